@@ -3,38 +3,35 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
+	"github.com/heathweaver/arn-protocol/pkg/bridge"
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/heathweaver/arn-protocol/pkg/metrics"
 	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/persistence"
 	"github.com/heathweaver/arn-protocol/pkg/protocol"
 )
 
 var (
-	tcpAddr = flag.String("tcp", ":7777", "TCP address to listen on")
-	udpAddr = flag.String("udp", ":7778", "UDP address to listen on")
+	tcpAddr     = flag.String("tcp", ":7777", "TCP address to listen on")
+	udpAddr     = flag.String("udp", ":7778", "UDP address to listen on")
+	wsAddr      = flag.String("ws", "", "WebSocket address to listen on")
 	healthCheck = flag.Bool("health", false, "Run health check and exit")
+	healthAddr  = flag.String("health-addr", "", "HTTP address to serve /healthz and /readyz on")
+	debug       = flag.Bool("debug", false, "Log every handled message")
+	metricsAddr = flag.String("metrics", "", "HTTP address to serve Prometheus metrics on")
+	mdnsName    = flag.String("mdns", "", "Advertise the node over mDNS under this instance name")
+	multicast   = flag.String("multicast", "", "Announce registered capabilities to this UDP multicast group")
+	statePath   = flag.String("state", "", "File to persist registered capabilities and bridges to")
+	bridgePing  = flag.Duration("bridge-ping", 0, "Monitor advertised MCP bridge endpoints at this interval, 0 to disable")
 )
 
-// MCPBridgeManager handles MCP data source integration
-type MCPBridgeManager struct {
-	bridges sync.Map // thread-safe map of MCP bridges
-}
-
-func newMCPBridgeManager() *MCPBridgeManager {
-	return &MCPBridgeManager{}
-}
-
-func (m *MCPBridgeManager) handleMCPBridge(bridge *protocol.MCPBridge) error {
-	log.Printf("Registering MCP bridge: %s (%s)", bridge.ID, bridge.Endpoint)
-	m.bridges.Store(bridge.ID, bridge)
-	return nil
-}
-
 func main() {
 	flag.Parse()
 
@@ -43,27 +40,86 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Create context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Configure logging
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	var handlerOpts []protocol.Option
+	var serverOpts []network.Option
+
+	// Serve metrics if requested
+	if *metricsAddr != "" {
+		handlerOpts = append(handlerOpts, protocol.WithMetrics(metrics.Registry))
+		serverOpts = append(serverOpts, network.WithMetrics(metrics.Registry))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, metrics.HTTPHandler()); err != nil {
+				slog.Error("Metrics server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Keep the registry across restarts if requested
+	if *statePath != "" {
+		handlerOpts = append(handlerOpts, protocol.WithStore(persistence.NewFileStore(*statePath)))
+	}
+
+	// Answer orchestrator probes if requested
+	if *healthAddr != "" {
+		serverOpts = append(serverOpts, network.WithHTTPHealth(*healthAddr))
+	}
+
+	// Serve browser and serverless agents over WebSocket if requested
+	if *wsAddr != "" {
+		serverOpts = append(serverOpts, network.WithWebSocket(*wsAddr))
+	}
+
+	// Announce the node on the local network if requested
+	if *mdnsName != "" {
+		serverOpts = append(serverOpts, network.WithAdvertiser(*mdnsName))
+	}
 
-	// Initialize MCP bridge manager
-	mcpManager := newMCPBridgeManager()
+	// Announce capabilities to multicast listeners if requested
+	if *multicast != "" {
+		serverOpts = append(serverOpts, network.WithMulticastGroup(*multicast))
+	}
+
+	// Initialize MCP bridge manager, re-registering bridges that recover
+	var handler *protocol.Handler
+	mcpManager := bridge.NewMCPBridgeManager(bridge.WithAdvertiser(func(b *protocol.MCPBridge) error {
+		return handler.RegisterMCPBridge(b)
+	}))
+	monitorCtx, stopMonitors := context.WithCancel(context.Background())
+	defer stopMonitors()
+
+	// Subscribe before the handler exists so bridges restored from -state are seen too
+	bus := events.NewBus()
+	bus.Subscribe(events.MessageReceived, func(event interface{}) {
+		slog.Debug("Received message", "type", event.(*protocol.Message).Type)
+	})
+	bus.Subscribe(events.BridgeAdvertised, mcpManager.HandleBridgeAdvertised)
+
+	// Watch bridge endpoints if requested
+	if *bridgePing > 0 {
+		mcpManager.PingInterval = *bridgePing
+		bus.Subscribe(events.BridgeAdvertised, func(event interface{}) {
+			go mcpManager.Monitor(monitorCtx, event.(*protocol.MCPBridge), func(b *protocol.MCPBridge) {
+				slog.Warn("MCP bridge unreachable, reconnecting", "bridge", b.ID)
+			})
+		})
+	}
 
 	// Initialize protocol handler with MCP bridge support
-	handler := protocol.NewHandler(
-		func(msg *protocol.Message) error {
-			// Global message handler
-			log.Printf("Received message type: %v", msg.Type)
-			return nil
-		},
-		mcpManager.handleMCPBridge,
-	)
+	handler = protocol.NewHandler(append(handlerOpts, protocol.WithEventBus(bus))...)
+	defer handler.Close()
 
 	// Create and start server
-	server := network.NewServer(*tcpAddr, *udpAddr, handler)
+	server := network.NewServer(*tcpAddr, *udpAddr, handler, serverOpts...)
 	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
 	}
 
 	// Handle graceful shutdown
@@ -72,7 +128,7 @@ func main() {
 
 	// Wait for shutdown signal
 	<-sigChan
-	log.Println("Shutting down...")
+	slog.Info("Shutting down...")
 
 	// Create shutdown context with timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -80,16 +136,16 @@ func main() {
 
 	// Stop server
 	if err := server.Stop(); err != nil {
-		log.Printf("Error during shutdown: %v", err)
+		slog.Error("Error during shutdown", "error", err)
 	}
 
 	// Wait for context to be done
 	<-shutdownCtx.Done()
 	if err := shutdownCtx.Err(); err != context.DeadlineExceeded {
-		log.Printf("Error during shutdown: %v", err)
+		slog.Error("Error during shutdown", "error", err)
 	}
 
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
 }
 
 // Example capability registration
@@ -121,7 +177,7 @@ func registerExampleCapabilities(handler *protocol.Handler) {
 
 	for _, cap := range capabilities {
 		if err := handler.RegisterCapability(cap); err != nil {
-			log.Printf("Failed to register capability %s: %v", cap.ID, err)
+			slog.Error("Failed to register capability", "capability", cap.ID, "error", err)
 		}
 	}
 }
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/heathweaver/arn-protocol/pkg/client"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// DefaultMaxBodyBytes bounds the JSON message an HTTPBridge accepts
+const DefaultMaxBodyBytes = 1 << 20
+
+// HTTPBridge lets REST clients talk to an ARN server. Each POST carries one
+// JSON-encoded protocol.Message, which is sent to the server through an ARN
+// client; the server's response is written back as JSON.
+type HTTPBridge struct {
+	// MaxBodyBytes bounds the request body; larger requests are refused
+	MaxBodyBytes int64
+
+	client *client.Client
+	logger *slog.Logger
+}
+
+// NewHTTPBridge creates a bridge forwarding messages through c
+func NewHTTPBridge(c *client.Client) *HTTPBridge {
+	return &HTTPBridge{
+		MaxBodyBytes: DefaultMaxBodyBytes,
+		client:       c,
+		logger:       slog.Default(),
+	}
+}
+
+// ServeHTTP forwards the message in the request body and writes back the
+// response. Malformed messages get 400; failures reaching the server 502.
+// An Error message from the server is a response like any other, so it is
+// returned with 200.
+func (b *HTTPBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg protocol.Message
+	body := http.MaxBytesReader(w, r.Body, b.MaxBodyBytes)
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := b.client.Send(r.Context(), &msg)
+	if err != nil {
+		b.logger.Error("Failed to forward HTTP message", "type", msg.Type, "error", err)
+		http.Error(w, "failed to reach ARN server", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		b.logger.Error("Failed to write HTTP response", "error", err)
+	}
+}
@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/client"
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// startHTTPBridge serves an HTTPBridge in front of a fresh ARN server
+func startHTTPBridge(t *testing.T) (*HTTPBridge, *httptest.Server) {
+	t.Helper()
+
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "nlp-1", Type: "nlp", Version: "1.0.0", Interaction: protocol.Discover}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	c, err := client.Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	b := NewHTTPBridge(c)
+	ts := httptest.NewServer(b)
+	t.Cleanup(ts.Close)
+	return b, ts
+}
+
+func TestHTTPBridgeForwards(t *testing.T) {
+	_, ts := startHTTPBridge(t)
+
+	payload, _ := json.Marshal(protocol.QueryPayload{CapabilityType: "nlp"})
+	body, err := json.Marshal(&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var response protocol.Message
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	var caps []*protocol.Capability
+	if err := response.DecodePayload(&caps); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if response.Type != protocol.Response || len(caps) != 1 || caps[0].ID != "nlp-1" {
+		t.Errorf("Got %v with %v, want a Response listing nlp-1", response.Type, caps)
+	}
+}
+
+func TestHTTPBridgeRejects(t *testing.T) {
+	b, ts := startHTTPBridge(t)
+	b.MaxBodyBytes = 256
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"GET", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed JSON", http.MethodPost, "{", http.StatusBadRequest},
+		{"unknown type", http.MethodPost, `{"version":"V1","type":"Gossip","timestamp":"2024-05-01T12:30:00Z"}`, http.StatusBadRequest},
+		{"too large", http.MethodPost, `{"version":"V1","type":"Query","timestamp":"2024-05-01T12:30:00Z","payload":"` + strings.Repeat("A", 512) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Package bridge tracks MCP bridges and watches their endpoints
+package bridge
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Defaults for monitoring bridge endpoints
+const (
+	DefaultPingInterval = 30 * time.Second
+	DefaultMaxRetries   = 5
+	DefaultBaseDelay    = time.Second
+	DefaultMaxDelay     = time.Minute
+)
+
+// MCPBridgeManager keeps the MCP bridges a node has seen advertised and
+// monitors their endpoints, re-advertising a bridge once its endpoint
+// comes back after going down
+type MCPBridgeManager struct {
+	// PingInterval is how often a monitored endpoint is probed
+	PingInterval time.Duration
+
+	// MaxRetries is how many reconnection attempts are made after an
+	// endpoint goes down before monitoring gives up on it
+	MaxRetries int
+
+	// BaseDelay is the wait before the first reconnection attempt. It
+	// doubles for every attempt after that, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	advertise func(*protocol.MCPBridge) error
+	probe     func(ctx context.Context, endpoint string) error
+	logger    *slog.Logger
+
+	bridges   sync.Map // bridge ID -> *protocol.MCPBridge
+	monitored sync.Map // bridge ID -> struct{}, bridges with a running Monitor
+}
+
+// Option configures optional MCPBridgeManager behaviour
+type Option func(*MCPBridgeManager)
+
+// WithAdvertiser re-advertises recovered bridges through fn, typically
+// Client.AdvertiseMCPBridge or Handler.RegisterMCPBridge
+func WithAdvertiser(fn func(*protocol.MCPBridge) error) Option {
+	return func(m *MCPBridgeManager) {
+		m.advertise = fn
+	}
+}
+
+// WithProbe replaces protocol.ProbeEndpoint as the check that an endpoint
+// is up
+func WithProbe(fn func(ctx context.Context, endpoint string) error) Option {
+	return func(m *MCPBridgeManager) {
+		m.probe = fn
+	}
+}
+
+// WithLogger sends manager logs to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *MCPBridgeManager) {
+		m.logger = logger
+	}
+}
+
+// NewMCPBridgeManager creates a manager with the default monitoring settings
+func NewMCPBridgeManager(opts ...Option) *MCPBridgeManager {
+	m := &MCPBridgeManager{
+		PingInterval: DefaultPingInterval,
+		MaxRetries:   DefaultMaxRetries,
+		BaseDelay:    DefaultBaseDelay,
+		MaxDelay:     DefaultMaxDelay,
+		probe:        protocol.ProbeEndpoint,
+		logger:       slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// HandleBridgeAdvertised stores the bridge carried by an
+// events.BridgeAdvertised event
+func (m *MCPBridgeManager) HandleBridgeAdvertised(event interface{}) {
+	bridge, ok := event.(*protocol.MCPBridge)
+	if !ok {
+		return
+	}
+	m.logger.Info("Registering MCP bridge", "bridge", bridge.ID, "endpoint", bridge.Endpoint)
+	m.bridges.Store(bridge.ID, bridge)
+}
+
+// Bridge returns the bridge stored under id
+func (m *MCPBridgeManager) Bridge(id string) (*protocol.MCPBridge, bool) {
+	bridge, ok := m.bridges.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return bridge.(*protocol.MCPBridge), true
+}
+
+// Bridges returns every stored bridge
+func (m *MCPBridgeManager) Bridges() []*protocol.MCPBridge {
+	var bridges []*protocol.MCPBridge
+	m.bridges.Range(func(_, bridge any) bool {
+		bridges = append(bridges, bridge.(*protocol.MCPBridge))
+		return true
+	})
+	return bridges
+}
+
+// Monitor probes the bridge endpoint every PingInterval until ctx is done.
+// When a probe fails onDown is called and the endpoint is retried with
+// exponential backoff, up to MaxRetries times. If it comes back the bridge
+// is re-advertised and monitoring carries on; if not, the bridge is dropped
+// from the manager and Monitor returns. Monitor blocks, so run it in its own
+// goroutine. It returns at once if the bridge is already being monitored.
+func (m *MCPBridgeManager) Monitor(ctx context.Context, bridge *protocol.MCPBridge, onDown func(*protocol.MCPBridge)) {
+	if _, running := m.monitored.LoadOrStore(bridge.ID, struct{}{}); running {
+		return
+	}
+	defer m.monitored.Delete(bridge.ID)
+
+	ticker := time.NewTicker(m.PingInterval)
+	defer ticker.Stop()
+
+	log := m.logger.With("bridge", bridge.ID, "endpoint", bridge.Endpoint)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := m.ping(ctx, bridge)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		log.Warn("MCP bridge endpoint down", "error", err)
+		if onDown != nil {
+			onDown(bridge)
+		}
+
+		if !m.reconnect(ctx, bridge, log) {
+			if ctx.Err() == nil {
+				log.Error("Giving up on MCP bridge", "retries", m.MaxRetries)
+				m.bridges.Delete(bridge.ID)
+			}
+			return
+		}
+
+		log.Info("MCP bridge endpoint back, re-advertising")
+		m.bridges.Store(bridge.ID, bridge)
+		if m.advertise != nil {
+			if err := m.advertise(bridge); err != nil {
+				log.Error("Failed to re-advertise MCP bridge", "error", err)
+			}
+		}
+	}
+}
+
+// reconnect retries the endpoint with exponential backoff and reports
+// whether it came back
+func (m *MCPBridgeManager) reconnect(ctx context.Context, bridge *protocol.MCPBridge, log *slog.Logger) bool {
+	for attempt := 1; attempt <= m.MaxRetries; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(m.backoff(attempt)):
+		}
+
+		err := m.ping(ctx, bridge)
+		if err == nil {
+			return true
+		}
+		log.Debug("MCP bridge reconnection failed", "attempt", attempt, "error", err)
+	}
+	return false
+}
+
+// backoff returns the wait before the given reconnection attempt, counting
+// from 1
+func (m *MCPBridgeManager) backoff(attempt int) time.Duration {
+	delay := m.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if m.MaxDelay > 0 && delay >= m.MaxDelay {
+			return m.MaxDelay
+		}
+	}
+	return delay
+}
+
+// ping probes the bridge endpoint once, bounded by PingInterval
+func (m *MCPBridgeManager) ping(ctx context.Context, bridge *protocol.MCPBridge) error {
+	pingCtx, cancel := context.WithTimeout(ctx, m.PingInterval)
+	defer cancel()
+	return m.probe(pingCtx, bridge.Endpoint)
+}
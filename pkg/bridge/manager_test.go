@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// newTestManager returns a manager that monitors quickly
+func newTestManager(opts ...Option) *MCPBridgeManager {
+	m := NewMCPBridgeManager(opts...)
+	m.PingInterval = 10 * time.Millisecond
+	m.BaseDelay = 5 * time.Millisecond
+	m.MaxDelay = 20 * time.Millisecond
+	return m
+}
+
+func TestHandleBridgeAdvertised(t *testing.T) {
+	m := NewMCPBridgeManager()
+	m.HandleBridgeAdvertised(&protocol.MCPBridge{ID: "bridge-1", Endpoint: "mcp://bridge/v1"})
+	m.HandleBridgeAdvertised("not a bridge")
+
+	if bridge, ok := m.Bridge("bridge-1"); !ok || bridge.Endpoint != "mcp://bridge/v1" {
+		t.Errorf("Bridge() = %v, %v, want the advertised bridge", bridge, ok)
+	}
+	if bridges := m.Bridges(); len(bridges) != 1 {
+		t.Errorf("Expected 1 bridge, got %d", len(bridges))
+	}
+}
+
+func TestMonitorReadvertises(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	advertised := make(chan *protocol.MCPBridge, 1)
+	m := newTestManager(WithAdvertiser(func(bridge *protocol.MCPBridge) error {
+		advertised <- bridge
+		return nil
+	}))
+	m.MaxRetries = 100
+
+	down := make(chan struct{}, 1)
+	bridge := &protocol.MCPBridge{ID: "bridge-1", Endpoint: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Monitor(ctx, bridge, func(b *protocol.MCPBridge) {
+			down <- struct{}{}
+			up.Store(true) // Back for the next reconnection attempt
+		})
+		close(done)
+	}()
+
+	up.Store(false)
+	select {
+	case <-down:
+	case <-time.After(time.Second):
+		t.Fatal("Expected onDown to be called")
+	}
+
+	select {
+	case got := <-advertised:
+		if got.ID != bridge.ID {
+			t.Errorf("Re-advertised %s, want %s", got.ID, bridge.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the bridge to be re-advertised")
+	}
+	if _, ok := m.Bridge(bridge.ID); !ok {
+		t.Error("Expected the recovered bridge to be stored")
+	}
+
+	// A second Monitor for the same bridge returns at once
+	second := make(chan struct{})
+	go func() {
+		m.Monitor(ctx, bridge, nil)
+		close(second)
+	}()
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Error("Expected a duplicate Monitor to return")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Monitor to return once ctx is done")
+	}
+}
+
+func TestMonitorGivesUp(t *testing.T) {
+	var probes atomic.Int32
+	m := newTestManager(WithProbe(func(ctx context.Context, endpoint string) error {
+		probes.Add(1)
+		return errors.New("connection refused")
+	}))
+	m.MaxRetries = 3
+
+	bridge := &protocol.MCPBridge{ID: "bridge-1", Endpoint: "tcp://127.0.0.1:1"}
+	m.HandleBridgeAdvertised(bridge)
+
+	var downs int
+	done := make(chan struct{})
+	go func() {
+		m.Monitor(context.Background(), bridge, func(*protocol.MCPBridge) { downs++ })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Monitor to give up")
+	}
+
+	if downs != 1 {
+		t.Errorf("Expected onDown once, got %d", downs)
+	}
+	// The failed ping plus every reconnection attempt
+	if got := probes.Load(); got != 4 {
+		t.Errorf("Expected 4 probes, got %d", got)
+	}
+	if _, ok := m.Bridge(bridge.ID); ok {
+		t.Error("Expected the bridge to be dropped after giving up")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	m := &MCPBridgeManager{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := m.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package cli implements the arn command, which sends ARN messages to a
+// server from the terminal
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// Output formats selected with --output
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// options holds the flags every command shares
+type options struct {
+	addr    string
+	output  string
+	timeout time.Duration
+	peerID  string
+}
+
+// NewRootCommand returns the arn command with every subcommand attached
+func NewRootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:           "arn",
+		Short:         "Send ARN messages to a server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != OutputTable && opts.output != OutputJSON {
+				return fmt.Errorf("unknown output format %q, want %s or %s", opts.output, OutputTable, OutputJSON)
+			}
+			return nil
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.addr, "addr", ":7777", "TCP address of the ARN server")
+	flags.StringVarP(&opts.output, "output", "o", OutputTable, "Output format: table or json")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "How long to wait for each response")
+	flags.StringVar(&opts.peerID, "peer-id", "", "Peer ID to present during the handshake")
+
+	root.AddCommand(
+		newQueryCommand(opts),
+		newRegisterCommand(opts),
+		newBridgeAdvertiseCommand(opts),
+		newPingCommand(opts),
+	)
+	return root
+}
+
+// Execute runs the arn command with args, writing results to stdout and
+// errors to stderr
+func Execute(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	root := NewRootCommand()
+	root.SetArgs(args)
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+
+	err := root.ExecuteContext(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+	}
+	return err
+}
+
+// dial connects to the server named by --addr
+func (o *options) dial() (*client.Client, error) {
+	opts := []client.Option{client.WithMessageTimeout(o.timeout)}
+	if o.peerID != "" {
+		opts = append(opts, client.WithPeerID(o.peerID))
+	}
+	return client.Dial(o.addr, "", opts...)
+}
+
+// print writes v as indented JSON, or as a table of header and rows
+func (o *options) print(w io.Writer, v interface{}, header []string, rows [][]string) error {
+	if o.output == OutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
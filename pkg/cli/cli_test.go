@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func startServer(t *testing.T) (*protocol.Handler, string) {
+	t.Helper()
+
+	handler := protocol.NewHandler()
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return handler, server.TCPAddr().String()
+}
+
+// run executes the arn command and returns what it printed
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	err := Execute(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRegisterAndQuery(t *testing.T) {
+	handler, addr := startServer(t)
+
+	out, err := run(t, "register", "--addr", addr, "--id", "foo", "--name", "Foo", "--type", "DELEGATE", "--interaction", "delegate", "--metadata", "lang=en")
+	if err != nil {
+		t.Fatalf("register error = %v", err)
+	}
+	if !strings.Contains(out, "foo") || !strings.Contains(out, "registered") {
+		t.Errorf("Unexpected register output:\n%s", out)
+	}
+	if caps := handler.ListCapabilities(); len(caps) != 1 || caps[0].Interaction != protocol.Delegate || caps[0].Metadata["lang"] != "en" {
+		t.Fatalf("Expected foo to be registered as a delegate, got %v", caps)
+	}
+
+	out, err = run(t, "query", "--addr", addr, "--type", "DELEGATE")
+	if err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "Foo") || !strings.Contains(lines[1], "delegate") {
+		t.Errorf("Unexpected table output:\n%s", out)
+	}
+
+	out, err = run(t, "query", "--addr", addr, "--type", "DELEGATE", "--output", "json")
+	if err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	var caps []protocol.Capability
+	if err := json.Unmarshal([]byte(out), &caps); err != nil || len(caps) != 1 || caps[0].ID != "foo" {
+		t.Errorf("Unexpected JSON output %q: %v", out, err)
+	}
+
+	// No matches is an empty list, not null
+	out, err = run(t, "query", "--addr", addr, "--type", "NONE", "-o", "json")
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("Expected an empty JSON list, got %q, %v", out, err)
+	}
+}
+
+func TestBridgeAdvertise(t *testing.T) {
+	handler, addr := startServer(t)
+
+	_, err := run(t, "bridge-advertise", "--addr", addr, "--id", "b1", "--endpoint", "mcp://host/v1",
+		"--data-types", "records,logs", "--metadata", "auth_type=none,data_format=json")
+	if err != nil {
+		t.Fatalf("bridge-advertise error = %v", err)
+	}
+	bridges := handler.ListMCPBridges()
+	if len(bridges) != 1 || bridges[0].Protocol != "MCP/1.0" || len(bridges[0].DataTypes) != 2 {
+		t.Errorf("Expected b1 to be advertised, got %v", bridges)
+	}
+
+	// Server errors surface as command errors
+	if _, err := run(t, "bridge-advertise", "--addr", addr, "--id", "b2", "--endpoint", "mcp://host/v1"); err == nil {
+		t.Error("Expected advertising an MCP/1.0 bridge without its metadata to fail")
+	}
+}
+
+func TestPing(t *testing.T) {
+	_, addr := startServer(t)
+
+	out, err := run(t, "ping", "--addr", addr, "--count", "2", "--interval", "1ms", "-o", "json")
+	if err != nil {
+		t.Fatalf("ping error = %v", err)
+	}
+	var results []pingResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("Failed to decode ping output %q: %v", out, err)
+	}
+	if len(results) != 2 || results[1].Seq != 2 || results[0].Status != "Hello" {
+		t.Errorf("Unexpected ping results %+v", results)
+	}
+}
+
+func TestInvalidFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"missing type", []string{"query"}},
+		{"unknown output", []string{"query", "--type", "x", "--output", "yaml"}},
+		{"unknown interaction", []string{"register", "--id", "a", "--type", "x", "--interaction", "gossip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := run(t, tt.args...); err == nil {
+				t.Errorf("Expected %v to fail", tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+// Interaction names accepted by --interaction
+var interactions = map[string]protocol.InteractionType{
+	"discover":  protocol.Discover,
+	"negotiate": protocol.Negotiate,
+	"stream":    protocol.Stream,
+	"delegate":  protocol.Delegate,
+}
+
+// interactionName returns the --interaction name of t
+func interactionName(t protocol.InteractionType) string {
+	for name, it := range interactions {
+		if it == t {
+			return name
+		}
+	}
+	return strconv.Itoa(int(t))
+}
+
+func newQueryCommand(opts *options) *cobra.Command {
+	var capType string
+	var mcpEnabled bool
+
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "List the capabilities of a type registered with the server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			caps, err := c.QueryCapabilities(capType, mcpEnabled)
+			if err != nil {
+				return fmt.Errorf("query failed: %w", err)
+			}
+			sort.Slice(caps, func(i, j int) bool { return caps[i].ID < caps[j].ID })
+
+			rows := make([][]string, 0, len(caps))
+			for _, cap := range caps {
+				rows = append(rows, []string{cap.ID, cap.Name, cap.Type, cap.Version, interactionName(cap.Interaction), strconv.FormatBool(cap.MCPEnabled)})
+			}
+			if caps == nil {
+				caps = []*protocol.Capability{}
+			}
+			return opts.print(cmd.OutOrStdout(), caps, []string{"ID", "NAME", "TYPE", "VERSION", "INTERACTION", "MCP"}, rows)
+		},
+	}
+
+	cmd.Flags().StringVar(&capType, "type", "", "Capability type to look for")
+	cmd.Flags().BoolVar(&mcpEnabled, "mcp", false, "Only list MCP-enabled capabilities")
+	cmd.MarkFlagRequired("type")
+	return cmd
+}
+
+func newRegisterCommand(opts *options) *cobra.Command {
+	var cap protocol.Capability
+	var interaction string
+
+	cmd := &cobra.Command{
+		Use:   "register",
+		Short: "Register a capability with the server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			it, ok := interactions[strings.ToLower(interaction)]
+			if !ok {
+				return fmt.Errorf("unknown interaction %q", interaction)
+			}
+			cap.Interaction = it
+
+			c, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			if err := c.RegisterCapability(&cap); err != nil {
+				return fmt.Errorf("register failed: %w", err)
+			}
+			return opts.print(cmd.OutOrStdout(), &cap, []string{"ID", "TYPE", "STATUS"}, [][]string{{cap.ID, cap.Type, "registered"}})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&cap.ID, "id", "", "Capability ID")
+	flags.StringVar(&cap.Name, "name", "", "Human-readable name")
+	flags.StringVar(&cap.Type, "type", "", "Capability type")
+	flags.StringVar(&cap.Version, "version", "", "SemVer version")
+	flags.StringVar(&interaction, "interaction", "discover", "Interaction pattern: discover, negotiate, stream or delegate")
+	flags.StringToStringVar(&cap.Metadata, "metadata", nil, "Metadata as key=value pairs")
+	flags.BoolVar(&cap.MCPEnabled, "mcp", false, "Whether the capability can interact via MCP")
+	flags.DurationVar(&cap.TTL, "ttl", 0, "Lifetime after registration, 0 for the server default")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("type")
+	return cmd
+}
+
+func newBridgeAdvertiseCommand(opts *options) *cobra.Command {
+	var bridge protocol.MCPBridge
+
+	cmd := &cobra.Command{
+		Use:   "bridge-advertise",
+		Short: "Advertise an MCP bridge to the server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			bridge.LastUpdated = time.Now()
+			if err := c.AdvertiseMCPBridge(&bridge); err != nil {
+				return fmt.Errorf("advertise failed: %w", err)
+			}
+			return opts.print(cmd.OutOrStdout(), &bridge, []string{"ID", "ENDPOINT", "PROTOCOL", "STATUS"}, [][]string{{bridge.ID, bridge.Endpoint, bridge.Protocol, "advertised"}})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&bridge.ID, "id", "", "Bridge ID")
+	flags.StringVar(&bridge.Endpoint, "endpoint", "", "MCP endpoint URL, such as mcp://host/v1")
+	flags.StringVar(&bridge.Protocol, "protocol", "MCP/1.0", "MCP protocol version")
+	flags.StringSliceVar(&bridge.DataTypes, "data-types", nil, "Data types served, comma separated")
+	flags.StringToStringVar(&bridge.Metadata, "metadata", nil, "Metadata as key=value pairs")
+	flags.StringSliceVar(&bridge.AllowedClients, "allowed-clients", nil, "Peer IDs or CIDR blocks allowed to request the bridge")
+	flags.DurationVar(&bridge.Lease, "lease", 0, "Lease to renew the bridge within, 0 for none")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("endpoint")
+	return cmd
+}
+
+// pingResult is one round trip measured by ping
+type pingResult struct {
+	Seq    int     `json:"seq"`
+	RTTms  float64 `json:"rtt_ms"`
+	Status string  `json:"status"`
+}
+
+func newPingCommand(opts *options) *cobra.Command {
+	var count int
+	var interval time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "ping",
+		Short: "Measure round trips to the server with Hello messages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			results := make([]pingResult, 0, count)
+			rows := make([][]string, 0, count)
+			for seq := 1; seq <= count; seq++ {
+				if seq > 1 {
+					select {
+					case <-cmd.Context().Done():
+						return cmd.Context().Err()
+					case <-time.After(interval):
+					}
+				}
+
+				msg, err := protocol.NewMessage(protocol.Hello).Build()
+				if err != nil {
+					return err
+				}
+				start := time.Now()
+				response, err := c.Send(cmd.Context(), msg)
+				rtt := time.Since(start)
+				if err != nil {
+					return fmt.Errorf("ping %d failed: %w", seq, err)
+				}
+
+				result := pingResult{Seq: seq, RTTms: float64(rtt.Microseconds()) / 1000, Status: response.Type.String()}
+				results = append(results, result)
+				rows = append(rows, []string{strconv.Itoa(seq), rtt.Round(time.Microsecond).String(), result.Status})
+			}
+			return opts.print(cmd.OutOrStdout(), results, []string{"SEQ", "RTT", "STATUS"}, rows)
+		},
+	}
+
+	cmd.Flags().IntVarP(&count, "count", "c", 1, "Number of pings to send")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Wait between pings")
+	return cmd
+}
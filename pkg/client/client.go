@@ -0,0 +1,721 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// Reconnection defaults
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+	defaultUDPTimeout  = 2 * time.Second
+
+	// Longest a single request waits for its response
+	defaultMessageTimeout = 30 * time.Second
+
+	// Payloads at least this large are compressed when the server supports it
+	compressionThreshold = 1024
+)
+
+// Client talks to an ARN server over TCP, using UDP for lightweight queries
+type Client struct {
+	tcpAddr string
+	udpAddr string
+
+	conn    *link
+	udpConn net.Conn
+	udpFrag *protocol.Reassembler
+	session protocol.HandshakePayload
+	mu      sync.Mutex
+	udpMu   sync.Mutex
+	closed  bool
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	tlsConfig   *tls.Config
+	secret      []byte
+	onNotify    func(*protocol.Message)
+	peerID      string
+	tenantID    string
+	identityKey ed25519.PrivateKey
+
+	messageTimeout time.Duration
+}
+
+// Option configures optional Client behaviour
+type Option func(*Client)
+
+// WithTLS dials the TCP address over TLS using cfg
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithSharedSecret signs outgoing messages and verifies responses with secret
+func WithSharedSecret(secret []byte) Option {
+	return func(c *Client) {
+		c.secret = secret
+	}
+}
+
+// WithNotificationHandler receives messages the server pushes unprompted, such as
+// MCPBridgeDown or AICapabilityAdvertise. They are delivered in order with
+// responses by the connection's reader, so fn must not wait on the client.
+func WithNotificationHandler(fn func(*protocol.Message)) Option {
+	return func(c *Client) {
+		c.onNotify = fn
+	}
+}
+
+// WithMessageTimeout bounds each request/response exchange, including any
+// notifications read ahead of the response. Zero waits as long as the context allows.
+func WithMessageTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.messageTimeout = d
+	}
+}
+
+// WithPeerID identifies the client to the server during the handshake, for
+// use in MCP bridge access control lists
+func WithPeerID(id string) Option {
+	return func(c *Client) {
+		c.peerID = id
+	}
+}
+
+// WithTenantID joins the tenant id during the handshake, so the client's
+// capabilities are registered in that tenant's namespace and its queries
+// only see that namespace
+func WithTenantID(id string) Option {
+	return func(c *Client) {
+		c.tenantID = id
+	}
+}
+
+// WithIdentity identifies the client as id and proves it with key during the
+// handshake, to servers holding the matching public key. Servers without one
+// fall back to taking the ID on trust, as with WithPeerID.
+func WithIdentity(id string, key ed25519.PrivateKey) Option {
+	return func(c *Client) {
+		c.peerID = id
+		c.identityKey = key
+	}
+}
+
+// Dial connects to an ARN server and performs the opening handshake.
+// udpAddr may be empty, in which case all traffic goes over TCP.
+func Dial(tcpAddr, udpAddr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		tcpAddr:     tcpAddr,
+		udpAddr:     udpAddr,
+		maxAttempts: defaultMaxAttempts,
+		baseDelay:   defaultBaseDelay,
+		maxDelay:    defaultMaxDelay,
+
+		messageTimeout: defaultMessageTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if err := c.connect(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if udpAddr != "" {
+		udpConn, err := net.Dial("udp", udpAddr)
+		if err != nil {
+			c.conn.close()
+			return nil, fmt.Errorf("failed to dial UDP: %w", err)
+		}
+		c.udpConn = udpConn
+		c.udpFrag = protocol.NewReassembler(protocol.DefaultReassemblyTimeout)
+	}
+
+	return c, nil
+}
+
+// Send writes msg over TCP and waits for the server's response.
+// A broken connection is re-established once before giving up.
+func (c *Client) Send(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	response, err := c.roundTrip(ctx, msg, false)
+	if errors.Is(err, errConnectionLost) && ctx.Err() == nil {
+		// The server may have dropped the connection. The first attempt may
+		// have reached it, so the retry is sealed again with a fresh nonce
+		// but keeps its correlation ID for the server to deduplicate it by.
+		response, err = c.roundTrip(ctx, msg, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, c.verify(response)
+}
+
+// SendAsync writes msg over TCP and returns a channel that receives the
+// server's response and is then closed. Several requests can be in flight on
+// the connection at once; responses are matched to them by CorrelationID. The
+// channel is closed without a value if the connection fails first.
+func (c *Client) SendAsync(ctx context.Context, msg *protocol.Message) (<-chan *protocol.Message, error) {
+	_, w, err := c.sendAsync(ctx, msg, false)
+	if err != nil {
+		return nil, err
+	}
+	return w.response, nil
+}
+
+// roundTrip sends msg once and waits for its response, bounded by the
+// message timeout. resend marks a retry of msg, see sendAsync.
+func (c *Client) roundTrip(ctx context.Context, msg *protocol.Message, resend bool) (*protocol.Message, error) {
+	if c.messageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.messageTimeout)
+		defer cancel()
+	}
+
+	l, w, err := c.sendAsync(ctx, msg, resend)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response, ok := <-w.response:
+		if !ok {
+			return nil, errConnectionLost
+		}
+		return response, nil
+	case <-ctx.Done():
+		l.forget(w)
+		return nil, ctx.Err()
+	}
+}
+
+// sendAsync seals msg with a fresh correlation ID and writes it on the
+// current connection, reconnecting first if it has failed. A resent msg
+// keeps the correlation ID it was first sent with.
+func (c *Client) sendAsync(ctx context.Context, msg *protocol.Message, resend bool) (*link, *waiter, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, nil, fmt.Errorf("client closed")
+	}
+	if c.conn == nil || !c.conn.alive() {
+		if err := c.connect(ctx); err != nil {
+			c.mu.Unlock()
+			return nil, nil, err
+		}
+	}
+	l, session := c.conn, c.session
+	c.mu.Unlock()
+
+	if err := protocol.InjectTraceContext(ctx, msg); err != nil {
+		return nil, nil, err
+	}
+
+	// Correlation IDs need the V2 wire format. V1 servers answer in order.
+	if session.MaxVersion >= protocol.V2 {
+		if msg.Version < protocol.V2 {
+			msg.Version = protocol.V2
+		}
+		if !resend || !msg.HasCorrelationID() {
+			if err := msg.GenerateCorrelationID(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := c.seal(msg); err != nil {
+		return nil, nil, err
+	}
+
+	w, err := l.send(ctx, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, w, nil
+}
+
+// RegisterCapability registers cap with the server
+func (c *Client) RegisterCapability(cap *protocol.Capability) error {
+	msg, err := c.newMessage(protocol.Register, cap)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// UnregisterCapability removes a capability this client registered earlier
+func (c *Client) UnregisterCapability(id string) error {
+	msg, err := c.newMessage(protocol.Unregister, protocol.UnregisterPayload{CapabilityID: id})
+	if err != nil {
+		return err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// QueryCapabilities returns the capabilities of the given type known to the server
+func (c *Client) QueryCapabilities(capType string, mcpEnabled bool) ([]*protocol.Capability, error) {
+	page, err := c.QueryPage(protocol.QueryPayload{
+		CapabilityType: capType,
+		MCPEnabled:     mcpEnabled,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page.Capabilities, nil
+}
+
+// QueryPage runs query and returns the matching capabilities, limited to
+// the page selected by its Offset and Limit, with the total match count
+func (c *Client) QueryPage(query protocol.QueryPayload) (*protocol.QueryResponse, error) {
+	msg, err := c.newMessage(protocol.Query, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer UDP for lookups and fall back to TCP if the datagram is lost.
+	// Datagrams carry no session, so tenants always query over TCP.
+	var response *protocol.Message
+	if c.udpConn != nil && c.tenantID == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultUDPTimeout)
+		response, err = c.sendUDP(ctx, msg)
+		cancel()
+	}
+	if response == nil {
+		response, err = c.Send(context.Background(), msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+	return protocol.DecodeQueryResponse(response)
+}
+
+// AdvertiseCapability registers cap with the server, which pushes it to every connected peer
+func (c *Client) AdvertiseCapability(cap *protocol.Capability) error {
+	msg, err := c.newMessage(protocol.AICapabilityAdvertise, cap)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// RequestCapability fetches a single capability by ID
+func (c *Client) RequestCapability(id string) (*protocol.Capability, error) {
+	msg, err := c.newMessage(protocol.AICapabilityRequest, protocol.CapabilityRequestPayload{CapabilityID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	var cap protocol.Capability
+	if err := json.Unmarshal(response.Payload, &cap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal capability: %w", err)
+	}
+	return &cap, nil
+}
+
+// Negotiate asks the server to settle parameters with a negotiable capability of
+// the given type and returns what was agreed
+func (c *Client) Negotiate(capType string, parameters map[string]interface{}) (*protocol.NegotiateResult, error) {
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	request := protocol.NegotiatePayload{
+		Capability: protocol.Capability{Type: capType, Interaction: protocol.Negotiate},
+		Parameters: parameters,
+	}
+
+	msg, err := c.newMessage(protocol.Register, request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	var result protocol.NegotiateResult
+	if err := json.Unmarshal(response.Payload, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal negotiation result: %w", err)
+	}
+	return &result, nil
+}
+
+// AdvertiseMCPBridge announces an MCP data source bridge to the server
+func (c *Client) AdvertiseMCPBridge(bridge *protocol.MCPBridge) error {
+	msg, err := c.newMessage(protocol.MCPBridgeAdvertise, bridge)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// QueryMCPBridges returns the MCP bridges known to the server that pass
+// filter and that this client may use, most recently updated first
+func (c *Client) QueryMCPBridges(filter protocol.MCPBridgeFilter) ([]*protocol.MCPBridge, error) {
+	msg, err := c.newMessage(protocol.MCPBridgeQuery, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	var bridges []*protocol.MCPBridge
+	if err := json.Unmarshal(response.Payload, &bridges); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bridges: %w", err)
+	}
+	return bridges, nil
+}
+
+// Close shuts down all connections to the server
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	var errs []error
+	if c.conn != nil {
+		errs = append(errs, c.conn.close())
+		c.conn = nil
+	}
+	if c.udpConn != nil {
+		errs = append(errs, c.udpConn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// connect dials the TCP address with exponential backoff and performs the handshake
+func (c *Client) connect(ctx context.Context) error {
+	delay := c.baseDelay
+
+	var lastErr error
+	for attempt := 0; attempt < c.maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > c.maxDelay {
+				delay = c.maxDelay
+			}
+		}
+
+		conn, err := c.dial(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		session, err := handshake(ctx, conn, c.peerID, c.tenantID, c.identityKey)
+		if err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+
+		// Responses are read in the background from here on
+		conn.SetDeadline(time.Time{})
+		c.conn = newLink(conn)
+		c.session = *session
+		go c.readLoop(c.conn)
+		return nil
+	}
+
+	return fmt.Errorf("failed to connect to %s after %d attempts: %w", c.tcpAddr, c.maxAttempts, lastErr)
+}
+
+// dial opens a raw TCP or TLS connection to the server
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.tlsConfig != nil {
+		dialer := &tls.Dialer{Config: c.tlsConfig}
+		return dialer.DialContext(ctx, "tcp", c.tcpAddr)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", c.tcpAddr)
+}
+
+// sendUDP sends msg as datagrams, fragmented past protocol.DefaultMTU, and waits for the reply
+func (c *Client) sendUDP(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	if err := c.seal(msg); err != nil {
+		return nil, err
+	}
+
+	fragments, err := msg.Fragment(protocol.DefaultMTU)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	// Serialize datagram exchanges so replies are not mixed up
+	c.udpMu.Lock()
+	defer c.udpMu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.udpConn.SetDeadline(deadline)
+	}
+
+	for _, data := range fragments {
+		if _, err := c.udpConn.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to send UDP message: %w", err)
+		}
+	}
+
+	buffer := make([]byte, 65535)
+	for {
+		n, err := c.udpConn.Read(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read UDP response: %w", err)
+		}
+
+		data := buffer[:n]
+		if protocol.IsFragment(data) {
+			if data, err = c.udpFrag.Add(c.udpAddr, data); err != nil || data == nil {
+				continue
+			}
+		}
+
+		response, err := protocol.Deserialize(data)
+		if err != nil {
+			return nil, err
+		}
+		return response, c.verify(response)
+	}
+}
+
+// seal gives V2 messages a fresh nonce and attaches an HMAC when a shared
+// secret is configured
+func (c *Client) seal(msg *protocol.Message) error {
+	// Signatures need the V2 wire format
+	if len(c.secret) > 0 && msg.Version < protocol.V2 {
+		msg.Version = protocol.V2
+	}
+
+	if msg.Version >= protocol.V2 {
+		if err := msg.GenerateNonce(); err != nil {
+			return err
+		}
+	}
+
+	if len(c.secret) == 0 {
+		return nil
+	}
+	return msg.Sign(c.secret)
+}
+
+// verify checks the server's HMAC when a shared secret is configured
+func (c *Client) verify(msg *protocol.Message) error {
+	if len(c.secret) == 0 {
+		return nil
+	}
+	return msg.Verify(c.secret)
+}
+
+// newMessage builds a message at the negotiated protocol version with v as its JSON payload
+func (c *Client) newMessage(t protocol.MessageType, v interface{}) (*protocol.Message, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	c.mu.Lock()
+	session := c.session
+	c.mu.Unlock()
+
+	msg := &protocol.Message{
+		Version:   session.MaxVersion,
+		Type:      t,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
+
+	if len(payload) >= compressionThreshold && session.MaxVersion >= protocol.V2 && hasFeature(session, protocol.FeatureCompression) {
+		msg.Compressed = true
+		msg.CompressionCodec = protocol.CompressionGzip
+	}
+
+	return msg, nil
+}
+
+// hasFeature reports whether feature was agreed during the handshake
+func hasFeature(session protocol.HandshakePayload, feature string) bool {
+	for _, f := range session.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotification reports whether msg is an unsolicited server push
+func isNotification(msg *protocol.Message) bool {
+	return msg.Type == protocol.MCPBridgeDown || msg.Type == protocol.AICapabilityAdvertise
+}
+
+// handshake offers every version and feature this client supports. With a
+// key it also offers FeatureIdentity and answers the server's challenge.
+func handshake(ctx context.Context, conn net.Conn, peerID, tenantID string, key ed25519.PrivateKey) (*protocol.HandshakePayload, error) {
+	features := protocol.SupportedFeatures
+	if key != nil {
+		features = append(features[:len(features):len(features)], protocol.FeatureIdentity)
+	}
+
+	payload, err := json.Marshal(protocol.HandshakePayload{
+		MinVersion: protocol.MinSupportedVersion,
+		MaxVersion: protocol.MaxSupportedVersion,
+		Features:   features,
+		PeerID:     peerID,
+		TenantID:   tenantID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal handshake: %w", err)
+	}
+
+	response, err := exchange(ctx, conn, &protocol.Message{
+		Version:   protocol.MinSupportedVersion,
+		Type:      protocol.Handshake,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	if err := responseError(response); err != nil {
+		return nil, fmt.Errorf("handshake rejected: %w", err)
+	}
+
+	var session protocol.HandshakePayload
+	if err := json.Unmarshal(response.Payload, &session); err != nil {
+		return nil, fmt.Errorf("invalid handshake response: %w", err)
+	}
+
+	if len(session.Challenge) > 0 {
+		if key == nil {
+			return nil, fmt.Errorf("server challenged an identity the client cannot prove")
+		}
+		if err := proveIdentity(ctx, conn, response.Version, peerID, key, session.Challenge); err != nil {
+			return nil, err
+		}
+	}
+	return &session, nil
+}
+
+// proveIdentity answers the server's handshake challenge by signing it
+func proveIdentity(ctx context.Context, conn net.Conn, version protocol.Version, peerID string, key ed25519.PrivateKey, challenge []byte) error {
+	payload, err := json.Marshal(protocol.HandshakePayload{
+		PeerID: peerID,
+		Proof:  security.SignChallenge(key, peerID, challenge),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal identity proof: %w", err)
+	}
+
+	response, err := exchange(ctx, conn, &protocol.Message{
+		Version:   version,
+		Type:      protocol.Handshake,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("identity proof failed: %w", err)
+	}
+	if err := responseError(response); err != nil {
+		return fmt.Errorf("identity proof rejected: %w", err)
+	}
+	return nil
+}
+
+// exchange writes msg to conn and reads a single response, honouring ctx
+func exchange(ctx context.Context, conn net.Conn, msg *protocol.Message) (*protocol.Message, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+
+	// Unblock I/O if the context is cancelled mid-exchange
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := network.WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	return network.ReadMessage(conn)
+}
+
+// responseError converts an Error message into a Go error
+func responseError(msg *protocol.Message) error {
+	if msg.Type != protocol.Error {
+		return nil
+	}
+
+	var payload protocol.ErrorPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return fmt.Errorf("malformed error response: %w", err)
+	}
+	return fmt.Errorf("%w: %s", payload.Code, payload.Message)
+}
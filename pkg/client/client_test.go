@@ -0,0 +1,981 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func startServer(t *testing.T, opts ...network.Option) *network.Server {
+	t.Helper()
+
+	handler := protocol.NewHandler()
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler, opts...)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	cap := &protocol.Capability{
+		ID:          "test-cap",
+		Name:        "Test Capability",
+		Type:        "DISCOVER",
+		Version:     "1.0",
+		Interaction: protocol.Discover,
+		MCPEnabled:  true,
+	}
+	if err := c.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	matches, err := c.QueryCapabilities("DISCOVER", true)
+	if err != nil {
+		t.Fatalf("QueryCapabilities() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].ID != cap.ID {
+		t.Errorf("Expected [%s], got %v", cap.ID, matches)
+	}
+
+	if err := c.UnregisterCapability(cap.ID); err != nil {
+		t.Fatalf("UnregisterCapability() error = %v", err)
+	}
+	if _, err := c.RequestCapability(cap.ID); !errors.Is(err, protocol.ErrCapabilityNotFound) {
+		t.Errorf("Expected unregistered capability to be gone, got %v", err)
+	}
+
+	bridge := &protocol.MCPBridge{
+		ID:        "test-bridge",
+		Endpoint:  "mcp://test.endpoint/v1",
+		Protocol:  "MCP/1.0",
+		Metadata:  map[string]string{"auth_type": "none", "data_format": "json"},
+		DataTypes: []string{"test_data"},
+	}
+	if err := c.AdvertiseMCPBridge(bridge); err != nil {
+		t.Errorf("AdvertiseMCPBridge() error = %v", err)
+	}
+}
+
+func TestClientQueryPage(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	for _, id := range []string{"page-c", "page-a", "page-b"} {
+		if err := c.RegisterCapability(&protocol.Capability{ID: id, Type: "DISCOVER", Interaction: protocol.Discover}); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	page, err := c.QueryPage(protocol.QueryPayload{CapabilityType: "DISCOVER", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatalf("QueryPage() error = %v", err)
+	}
+	if page.Total != 3 || len(page.Capabilities) != 1 || page.Capabilities[0].ID != "page-b" {
+		t.Errorf("QueryPage() = %+v of %d, want page-b of 3", page.Capabilities, page.Total)
+	}
+
+	// Unpaginated queries still get every match
+	matches, err := c.QueryCapabilities("DISCOVER", false)
+	if err != nil {
+		t.Fatalf("QueryCapabilities() error = %v", err)
+	}
+	if len(matches) != 3 {
+		t.Errorf("QueryCapabilities() returned %d matches, want 3", len(matches))
+	}
+}
+
+func TestClientQueryMCPBridges(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	for _, region := range []string{"eu-west", "us-east"} {
+		bridge := &protocol.MCPBridge{
+			ID:        "bridge-" + region,
+			Endpoint:  "mcp://" + region + "/v1",
+			Protocol:  "MCP/1.0",
+			DataTypes: []string{"structured.json"},
+			Metadata:  map[string]string{"auth_type": "none", "data_format": "json"},
+			Location:  protocol.Location{Region: region},
+		}
+		if err := c.AdvertiseMCPBridge(bridge); err != nil {
+			t.Fatalf("AdvertiseMCPBridge() error = %v", err)
+		}
+	}
+
+	bridges, err := c.QueryMCPBridges(protocol.MCPBridgeFilter{Region: "us-east", DataTypes: []string{"structured.json"}})
+	if err != nil {
+		t.Fatalf("QueryMCPBridges() error = %v", err)
+	}
+	if len(bridges) != 1 || bridges[0].ID != "bridge-us-east" {
+		t.Errorf("QueryMCPBridges() = %v, want bridge-us-east", bridges)
+	}
+}
+
+func TestClientTenants(t *testing.T) {
+	server := startServer(t)
+
+	dial := func(tenant string) *Client {
+		c, err := Dial(server.TCPAddr().String(), server.UDPAddr().String(), WithTenantID(tenant))
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	acme, globex := dial("acme"), dial("globex")
+
+	for _, c := range []*Client{acme, globex} {
+		if err := c.RegisterCapability(&protocol.Capability{ID: "search-v1", Type: "DISCOVER", Interaction: protocol.Discover}); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	for tenant, c := range map[string]*Client{"acme": acme, "globex": globex} {
+		matches, err := c.QueryCapabilities("DISCOVER", false)
+		if err != nil {
+			t.Fatalf("QueryCapabilities() error = %v", err)
+		}
+		if len(matches) != 1 || matches[0].ID != tenant+"/search-v1" {
+			t.Errorf("%s sees %+v, want only %s/search-v1", tenant, matches, tenant)
+		}
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	// Large metadata pushes the payload well past the compression threshold
+	cap := &protocol.Capability{
+		ID:       "large-cap",
+		Type:     "STREAM",
+		Metadata: map[string]string{"description": strings.Repeat("x", 70*1024)},
+	}
+
+	msg, err := c.newMessage(protocol.Register, cap)
+	if err != nil {
+		t.Fatalf("newMessage() error = %v", err)
+	}
+	if !msg.Compressed {
+		t.Fatal("Expected large payload to be compressed")
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if response.Type != protocol.Response || !response.Compressed {
+		t.Errorf("Expected compressed Response, got %v (compressed=%v)", response.Type, response.Compressed)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	cert, err := security.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	server := startServer(t, network.WithTLS(&tls.Config{
+		Certificates: []tls.Certificate{*cert},
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+
+	c, err := Dial(server.TCPAddr().String(), "", WithTLS(&tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	}))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, ok := c.conn.Conn.(*tls.Conn); !ok {
+		t.Errorf("Expected TLS connection, got %T", c.conn.Conn)
+	}
+
+	if err := c.RegisterCapability(&protocol.Capability{ID: "tls-cap", Type: "DISCOVER"}); err != nil {
+		t.Errorf("RegisterCapability() error = %v", err)
+	}
+}
+
+func TestClientSharedSecret(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	handler := protocol.NewHandler()
+	handler.SetSharedSecret(secret)
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), server.UDPAddr().String(), WithSharedSecret(secret))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.RegisterCapability(&protocol.Capability{ID: "signed-cap", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	matches, err := c.QueryCapabilities("DISCOVER", false)
+	if err != nil {
+		t.Fatalf("QueryCapabilities() error = %v", err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("Expected 1 match, got %d", len(matches))
+	}
+
+	// A client with the wrong secret is turned away
+	intruder, err := Dial(server.TCPAddr().String(), "", WithSharedSecret([]byte("wrong")))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer intruder.Close()
+
+	err = intruder.RegisterCapability(&protocol.Capability{ID: "intruder", Type: "DISCOVER"})
+	if !errors.Is(err, protocol.ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	server := startServer(t)
+
+	notified := make(chan *protocol.Message, 1)
+	c, err := Dial(server.TCPAddr().String(), "", WithNotificationHandler(func(msg *protocol.Message) {
+		notified <- msg
+	}))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	// One exchange guarantees the server has registered the session
+	if err := c.RegisterCapability(&protocol.Capability{ID: "before-push", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	// Push a notification ahead of the next response
+	if err := server.Broadcast(&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.MCPBridgeDown,
+		Payload:   []byte(`{"id":"gone"}`),
+		Timestamp: time.Now(),
+	}); err != nil {
+		t.Fatalf("Broadcast() error = %v", err)
+	}
+
+	if err := c.RegisterCapability(&protocol.Capability{ID: "after-push", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	select {
+	case msg := <-notified:
+		if msg.Type != protocol.MCPBridgeDown {
+			t.Errorf("Expected MCPBridgeDown notification, got %v", msg.Type)
+		}
+	default:
+		t.Error("Expected notification to be delivered")
+	}
+}
+
+func TestClientAdvertiseCapability(t *testing.T) {
+	server := startServer(t)
+
+	notified := make(chan *protocol.Message, 1)
+	listener, err := Dial(server.TCPAddr().String(), "", WithNotificationHandler(func(msg *protocol.Message) {
+		notified <- msg
+	}))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer listener.Close()
+
+	advertiser, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer advertiser.Close()
+
+	// One exchange guarantees the server has registered the listening session
+	if _, err := listener.QueryCapabilities("DISCOVER", false); err != nil {
+		t.Fatalf("QueryCapabilities() error = %v", err)
+	}
+
+	if err := advertiser.AdvertiseCapability(&protocol.Capability{ID: "pushed", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("AdvertiseCapability() error = %v", err)
+	}
+
+	// The push is read ahead of the listener's next response
+	cap, err := listener.RequestCapability("pushed")
+	if err != nil {
+		t.Fatalf("RequestCapability() error = %v", err)
+	}
+	if cap.ID != "pushed" {
+		t.Errorf("Expected capability pushed, got %s", cap.ID)
+	}
+
+	select {
+	case msg := <-notified:
+		if msg.Type != protocol.AICapabilityAdvertise {
+			t.Errorf("Expected AICapabilityAdvertise notification, got %v", msg.Type)
+		}
+	default:
+		t.Error("Expected advertisement to be pushed to the other client")
+	}
+
+	if _, err := listener.RequestCapability("missing"); !errors.Is(err, protocol.ErrCapabilityNotFound) {
+		t.Errorf("Expected ErrCapabilityNotFound, got %v", err)
+	}
+}
+
+func TestClientNegotiate(t *testing.T) {
+	handler := protocol.NewHandler(protocol.WithNegotiateFunc(func(offered, required map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"model": offered["model"], "stream": required["stream"]}, nil
+	}))
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.RegisterCapability(&protocol.Capability{
+		ID:          "chat",
+		Type:        "GENERATE",
+		Interaction: protocol.Negotiate,
+		Metadata:    map[string]string{"model": "small"},
+	}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	result, err := c.Negotiate("GENERATE", map[string]interface{}{"stream": true})
+	if err != nil {
+		t.Fatalf("Negotiate() error = %v", err)
+	}
+	if result.CapabilityID != "chat" || result.Parameters["model"] != "small" || result.Parameters["stream"] != true {
+		t.Errorf("Unexpected negotiation result %+v", result)
+	}
+
+	if _, err := c.Negotiate("EMBED", nil); !errors.Is(err, protocol.ErrCapabilityNotFound) {
+		t.Errorf("Expected ErrCapabilityNotFound, got %v", err)
+	}
+}
+
+func TestClientServerError(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	// Capabilities without an ID are rejected by the server
+	err = c.RegisterCapability(&protocol.Capability{Name: "No ID"})
+	if !errors.Is(err, protocol.ErrInvalidCapabilityFormat) {
+		t.Errorf("Expected ErrInvalidCapabilityFormat, got %v", err)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	// Sever the connection behind the client's back
+	c.conn.Close()
+
+	msg, err := c.newMessage(protocol.Hello, nil)
+	if err != nil {
+		t.Fatalf("newMessage() error = %v", err)
+	}
+
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if response.Type != protocol.Hello {
+		t.Errorf("Expected Hello response, got %v", response.Type)
+	}
+}
+
+func TestClientResendKeepsCorrelationID(t *testing.T) {
+	seen := make(chan [16]byte, 2)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	handler := protocol.NewHandler()
+	handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		if msg.Type == protocol.Hello {
+			seen <- msg.CorrelationID
+			if calls.Add(1) == 1 {
+				<-release
+			}
+		}
+		return next(ctx, msg)
+	})
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	defer close(release)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	msg, err := c.newMessage(protocol.Hello, nil)
+	if err != nil {
+		t.Fatalf("newMessage() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Send(context.Background(), msg)
+		done <- err
+	}()
+
+	// Lose the connection once the server has the request, forcing a retry
+	first := <-seen
+	c.conn.Close()
+	select {
+	case retried := <-seen:
+		if retried != first {
+			t.Errorf("Retry CorrelationID = %x, want %x", retried, first)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the request to be retried")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	// Grab a free port and release it so nothing is listening
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	c := &Client{
+		tcpAddr:     addr,
+		maxAttempts: 3,
+		baseDelay:   10 * time.Millisecond,
+		maxDelay:    time.Second,
+	}
+
+	start := time.Now()
+	if err := c.connect(context.Background()); err == nil {
+		t.Fatal("Expected connect to fail")
+	}
+
+	// Two waits between three attempts: 10ms + 20ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected backoff of at least 30ms, took %v", elapsed)
+	}
+}
+
+func TestClientNonce(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	msg, err := c.newMessage(protocol.Hello, nil)
+	if err != nil {
+		t.Fatalf("newMessage() error = %v", err)
+	}
+	if _, err := c.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if !msg.HasNonce() {
+		t.Fatal("Expected V2 message to carry a nonce")
+	}
+	first := msg.Nonce
+
+	// Resending the same message draws a new nonce instead of tripping replay protection
+	response, err := c.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if response.Type != protocol.Hello {
+		t.Errorf("Expected Hello response, got %v", response.Type)
+	}
+	if msg.Nonce == first {
+		t.Error("Expected a fresh nonce on resend")
+	}
+}
+
+func TestClientPeerID(t *testing.T) {
+	handler := protocol.NewHandler()
+	if err := handler.RegisterMCPBridge(&protocol.MCPBridge{
+		ID:             "private",
+		Endpoint:       "mcp://private/v1",
+		DataTypes:      []string{"records"},
+		AllowedClients: []string{"trusted-agent"},
+	}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	request := func(c *Client) error {
+		msg, err := c.newMessage(protocol.MCPBridgeRequest, map[string]string{
+			"bridge_id": "private",
+			"data_type": "records",
+		})
+		if err != nil {
+			t.Fatalf("newMessage() error = %v", err)
+		}
+		response, err := c.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		return responseError(response)
+	}
+
+	trusted, err := Dial(server.TCPAddr().String(), "", WithPeerID("trusted-agent"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer trusted.Close()
+
+	if err := request(trusted); err != nil {
+		t.Errorf("Expected trusted peer to be allowed, got %v", err)
+	}
+
+	stranger, err := Dial(server.TCPAddr().String(), "", WithPeerID("stranger"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer stranger.Close()
+
+	if err := request(stranger); !errors.Is(err, protocol.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	keyring, err := security.NewKeyring(&security.PeerIdentity{ID: "agent-1", PublicKey: pub, Roles: []string{"reader"}})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	handler := protocol.NewHandler(protocol.WithKeyring(keyring))
+	if err := handler.RegisterMCPBridge(&protocol.MCPBridge{
+		ID:             "private",
+		Endpoint:       "mcp://private/v1",
+		DataTypes:      []string{"records"},
+		AllowedClients: []string{"role:reader"},
+	}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	request := func(c *Client) error {
+		msg, err := c.newMessage(protocol.MCPBridgeRequest, map[string]string{
+			"bridge_id": "private",
+			"data_type": "records",
+		})
+		if err != nil {
+			t.Fatalf("newMessage() error = %v", err)
+		}
+		response, err := c.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		return responseError(response)
+	}
+
+	proven, err := Dial(server.TCPAddr().String(), "", WithIdentity("agent-1", key))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer proven.Close()
+	if err := request(proven); err != nil {
+		t.Errorf("Expected the proven reader to be allowed, got %v", err)
+	}
+
+	// Claiming the ID without the key does not grant its roles
+	claimed, err := Dial(server.TCPAddr().String(), "", WithPeerID("agent-1"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer claimed.Close()
+	if err := request(claimed); !errors.Is(err, protocol.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for an unproven peer, got %v", err)
+	}
+
+	wrongKey := &Client{tcpAddr: server.TCPAddr().String(), maxAttempts: 1}
+	WithIdentity("agent-1", otherKey)(wrongKey)
+	if err := wrongKey.connect(context.Background()); !errors.Is(err, protocol.ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for the wrong key, got %v", err)
+	}
+}
+
+func TestClientMessageTimeout(t *testing.T) {
+	handler := protocol.NewHandler()
+	handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		if msg.Type == protocol.Query {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return next(ctx, msg)
+	})
+
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler, network.WithDrainTimeout(100*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "", WithMessageTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.QueryCapabilities("DISCOVER", false); err == nil {
+		t.Fatal("Expected QueryCapabilities to time out")
+	}
+
+	// A timed out request is abandoned rather than retried
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("QueryCapabilities took %v with a 100ms message timeout", elapsed)
+	}
+}
+
+func TestClientOpenStreamRefused(t *testing.T) {
+	server := startServer(t)
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.OpenStreamWith(context.Background(), protocol.StreamStartPayload{Codec: "unknown"}); err == nil {
+		t.Error("Expected a stream with an unknown codec to be refused")
+	}
+	stream, err := c.OpenStreamWith(context.Background(), protocol.StreamStartPayload{SessionID: "chosen", QueueDepth: 4})
+	if err != nil {
+		t.Fatalf("OpenStreamWith() error = %v", err)
+	}
+	if stream.ID() != "chosen" || stream.Credits() != 4 {
+		t.Errorf("Stream %q has %d credits, want chosen with 4", stream.ID(), stream.Credits())
+	}
+}
+
+func TestClientStreamFlowControl(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	stream, err := c.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	data, err := handler.SubscribeStream(stream.ID())
+	if err != nil {
+		t.Fatalf("SubscribeStream() error = %v", err)
+	}
+
+	// Send more than the receiver buffers; the sender has to wait for the reader
+	const chunks = 200
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < chunks; i++ {
+			if err := stream.Send(context.Background(), []byte{byte(i)}); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- stream.Close()
+	}()
+
+	var received int
+	for chunk := range data {
+		if chunk[0] != byte(received) {
+			t.Fatalf("Chunk %d arrived out of order as %d", received, chunk[0])
+		}
+		received++
+		if received%50 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	if err := <-sent; err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if received != chunks {
+		t.Errorf("Expected %d chunks, got %d", chunks, received)
+	}
+}
+
+func TestClientSendAsync(t *testing.T) {
+	// The first query holds up the server until released, so the others queue
+	// behind it and the high priority one overtakes
+	release := make(chan struct{})
+	handler := protocol.NewHandler()
+	handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		var query protocol.QueryPayload
+		if msg.Type == protocol.Query && msg.DecodePayload(&query) == nil && query.CapabilityType == "SLOW" {
+			<-release
+		}
+		return next(ctx, msg)
+	})
+	for _, capType := range []string{"SLOW", "NORMAL", "URGENT"} {
+		if err := handler.RegisterCapability(&protocol.Capability{ID: strings.ToLower(capType), Type: capType}); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	var responses []<-chan *protocol.Message
+	for _, capType := range []string{"SLOW", "NORMAL", "URGENT"} {
+		msg, err := c.newMessage(protocol.Query, protocol.QueryPayload{CapabilityType: capType})
+		if err != nil {
+			t.Fatalf("newMessage() error = %v", err)
+		}
+		if capType == "URGENT" {
+			msg.Priority = protocol.PriorityCritical
+		}
+
+		response, err := c.SendAsync(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("SendAsync() error = %v", err)
+		}
+		responses = append(responses, response)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i, want := range []string{"slow", "normal", "urgent"} {
+		select {
+		case response, ok := <-responses[i]:
+			if !ok {
+				t.Fatalf("Response channel for %s closed without a response", want)
+			}
+			var caps []*protocol.Capability
+			if err := json.Unmarshal(response.Payload, &caps); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(caps) != 1 || caps[0].ID != want {
+				t.Errorf("Request %d got %+v, want %s", i, caps, want)
+			}
+			if _, ok := <-responses[i]; ok {
+				t.Errorf("Response channel for %s not closed after the response", want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s response", want)
+		}
+	}
+}
+
+func TestClientSendAsyncConnectionLost(t *testing.T) {
+	handler := protocol.NewHandler()
+	handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler, network.WithDrainTimeout(100*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	msg, err := c.newMessage(protocol.Hello, nil)
+	if err != nil {
+		t.Fatalf("newMessage() error = %v", err)
+	}
+	response, err := c.SendAsync(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("SendAsync() error = %v", err)
+	}
+
+	c.conn.Close()
+	select {
+	case _, ok := <-response:
+		if ok {
+			t.Error("Expected no response after the connection was lost")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Response channel not closed after the connection was lost")
+	}
+}
+
+func TestClientBroadcastReachesEveryClient(t *testing.T) {
+	server := startServer(t)
+
+	var clients []*Client
+	var notified []chan *protocol.Message
+	for i := 0; i < 2; i++ {
+		ch := make(chan *protocol.Message, 1)
+		c, err := Dial(server.TCPAddr().String(), "", WithNotificationHandler(func(msg *protocol.Message) {
+			ch <- msg
+		}))
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer c.Close()
+
+		// One exchange guarantees the server has registered the session
+		if _, err := c.QueryCapabilities("DISCOVER", false); err != nil {
+			t.Fatalf("QueryCapabilities() error = %v", err)
+		}
+		clients = append(clients, c)
+		notified = append(notified, ch)
+	}
+
+	// The registering client hears about its own capability too
+	if err := clients[0].AdvertiseCapability(&protocol.Capability{ID: "shared", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("AdvertiseCapability() error = %v", err)
+	}
+
+	for i, ch := range notified {
+		select {
+		case msg := <-ch:
+			var cap protocol.Capability
+			if err := json.Unmarshal(msg.Payload, &cap); err != nil {
+				t.Fatalf("Client %d got undecodable broadcast: %v", i, err)
+			}
+			if msg.Type != protocol.AICapabilityAdvertise || cap.ID != "shared" {
+				t.Errorf("Client %d got %v for %q, want AICapabilityAdvertise for shared", i, msg.Type, cap.ID)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Client %d did not receive the broadcast", i)
+		}
+	}
+}
+
+func TestClientAnswersHeartbeat(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler, network.WithHeartbeat(30*time.Millisecond, 30*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := Dial(server.TCPAddr().String(), "")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.RegisterCapability(&protocol.Capability{ID: "beating", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	// Several heartbeats go by without the client sending anything itself
+	time.Sleep(200 * time.Millisecond)
+	if n := handler.CapabilityCount(); n != 1 {
+		t.Fatalf("Expected the capability to survive the heartbeats, %d registered", n)
+	}
+	caps, err := c.QueryCapabilities("DISCOVER", false)
+	if err != nil || len(caps) != 1 {
+		t.Errorf("QueryCapabilities() = %v, %v, want the registered capability", caps, err)
+	}
+}
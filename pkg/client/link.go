@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// errConnectionLost means a request may not have reached the server, or its
+// response never came back, because the connection failed
+var errConnectionLost = errors.New("connection lost")
+
+// Time a Pong has to reach the server before the link is given up on
+const pongWriteTimeout = 5 * time.Second
+
+// link is one established TCP session. Requests are written in turn and a
+// reader goroutine matches each response to the request waiting for it.
+type link struct {
+	net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	waiting []*waiter // in the order the requests were written
+	closed  bool
+}
+
+// waiter is a request awaiting its response
+type waiter struct {
+	id       [16]byte // zero when the request carries no correlation ID
+	response chan *protocol.Message
+}
+
+func newLink(conn net.Conn) *link {
+	return &link{Conn: conn}
+}
+
+// send writes msg and returns the waiter its response is delivered to
+func (l *link) send(ctx context.Context, msg *protocol.Message) (*waiter, error) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	// Registered under writeMu so uncorrelated requests queue in write order
+	w := &waiter{id: msg.CorrelationID, response: make(chan *protocol.Message, 1)}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, errConnectionLost
+	}
+	l.waiting = append(l.waiting, w)
+	l.mu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	l.SetWriteDeadline(deadline)
+
+	// Unblock the write if the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		l.SetWriteDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := network.WriteMessage(l.Conn, msg); err != nil {
+		l.close()
+		return nil, fmt.Errorf("%w: %v", errConnectionLost, err)
+	}
+	return w, nil
+}
+
+// deliver hands msg to the request it answers. Responses without a
+// correlation ID answer the oldest request, as servers that do not echo
+// correlation IDs reply in order. Responses nobody waits for are dropped.
+func (l *link) deliver(msg *protocol.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := 0
+	if msg.HasCorrelationID() {
+		for i < len(l.waiting) && l.waiting[i].id != msg.CorrelationID {
+			i++
+		}
+	}
+	if i >= len(l.waiting) {
+		return
+	}
+
+	w := l.waiting[i]
+	l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+	w.response <- msg
+	close(w.response)
+}
+
+// forget stops waiting for w's response. A request without a correlation
+// ID cannot be skipped when its late response arrives, so the connection is
+// closed instead.
+func (l *link) forget(w *waiter) {
+	if w.id == [16]byte{} {
+		l.close()
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, other := range l.waiting {
+		if other == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			break
+		}
+	}
+}
+
+// pong answers a server's heartbeat Ping. A failed write closes the link
+// like any other.
+func (l *link) pong(ping *protocol.Message) {
+	pong := &protocol.Message{
+		Version:       ping.Version,
+		Type:          protocol.Pong,
+		CorrelationID: ping.CorrelationID,
+		Timestamp:     time.Now(),
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.SetWriteDeadline(time.Now().Add(pongWriteTimeout))
+	if err := network.WriteMessage(l.Conn, pong); err != nil {
+		l.close()
+	}
+}
+
+// alive reports whether the link can still carry requests
+func (l *link) alive() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return !l.closed
+}
+
+// close shuts the connection and releases every waiting request
+func (l *link) close() error {
+	err := l.Conn.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return err
+	}
+	l.closed = true
+	for _, w := range l.waiting {
+		close(w.response)
+	}
+	l.waiting = nil
+	return err
+}
+
+// readLoop delivers responses and server pushes until the connection fails
+func (c *Client) readLoop(l *link) {
+	defer l.close()
+
+	for {
+		msg, err := network.ReadMessage(l.Conn)
+		if err != nil {
+			return
+		}
+
+		if msg.Type == protocol.Ping {
+			go l.pong(msg)
+			continue
+		}
+		if isNotification(msg) {
+			if verr := c.verify(msg); verr == nil && c.onNotify != nil {
+				c.onNotify(msg)
+			}
+			continue
+		}
+		l.deliver(msg)
+	}
+}
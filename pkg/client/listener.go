@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Announcement is a capability a server multicast when it was registered
+type Announcement struct {
+	Capability *protocol.Capability
+	From       *net.UDPAddr
+}
+
+// Listener receives capability announcements multicast by ARN servers,
+// without needing to know any server address up front
+type Listener struct {
+	conn  *net.UDPConn
+	buf   []byte
+	frags *protocol.Reassembler
+}
+
+// Listen joins the multicast group servers announce to, such as
+// network.DefaultMulticastGroup
+func Listen(group string) (*Listener, error) {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve multicast group: %w", err)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join multicast group: %w", err)
+	}
+
+	return &Listener{
+		conn:  conn,
+		buf:   make([]byte, 65535),
+		frags: protocol.NewReassembler(protocol.DefaultReassemblyTimeout),
+	}, nil
+}
+
+// Receive waits for the next capability announcement. Other traffic on the
+// group is skipped.
+func (l *Listener) Receive(ctx context.Context) (*Announcement, error) {
+	l.conn.SetReadDeadline(time.Time{})
+
+	// Unblock the read once the context is done, so ctx.Err is always set by then
+	stop := context.AfterFunc(ctx, func() {
+		l.conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		n, from, err := l.conn.ReadFromUDP(l.buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to read announcement: %w", err)
+		}
+
+		data := l.buf[:n]
+		if protocol.IsFragment(data) {
+			if data, err = l.frags.Add(from.String(), data); err != nil || data == nil {
+				continue
+			}
+		}
+
+		msg, err := protocol.Deserialize(data)
+		if err != nil || msg.Type != protocol.AICapabilityAdvertise {
+			continue
+		}
+
+		var cap protocol.Capability
+		if err := msg.DecodePayload(&cap); err != nil {
+			continue
+		}
+		return &Announcement{Capability: &cap, From: from}, nil
+	}
+}
+
+// Close leaves the multicast group
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
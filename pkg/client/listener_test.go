@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestListenerReceivesAnnouncements(t *testing.T) {
+	// Pick a free port for the group so the test does not clash with a real node
+	reserve, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	group := "224.0.0.251:" + strconv.Itoa(reserve.LocalAddr().(*net.UDPAddr).Port)
+	reserve.Close()
+
+	listener, err := Listen(group)
+	if err != nil {
+		t.Skipf("Multicast unavailable: %v", err)
+	}
+	defer listener.Close()
+
+	handler := protocol.NewHandler()
+	server := network.NewServer("127.0.0.1:0", "0.0.0.0:0", handler, network.WithMulticastGroup(group))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "announced", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	announcement, err := listener.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if announcement.Capability.ID != "announced" {
+		t.Errorf("Expected capability announced, got %s", announcement.Capability.ID)
+	}
+	if announcement.From.Port != server.UDPAddr().(*net.UDPAddr).Port {
+		t.Errorf("Expected announcement from UDP port %d, got %v", server.UDPAddr().(*net.UDPAddr).Port, announcement.From)
+	}
+}
+
+func TestListenerContextCancel(t *testing.T) {
+	listener, err := Listen("224.0.0.251:0")
+	if err != nil {
+		t.Skipf("Multicast unavailable: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := listener.Receive(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Default time a surplus connection may sit idle before the pool closes it
+const defaultIdleTimeout = time.Minute
+
+const (
+	// Default number of times Send repeats a request that failed with a
+	// retryable error
+	defaultMaxRetries = 2
+
+	// Wait before the first retry, doubled for each one after it
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Pool shares a set of TCP connections to one ARN server between goroutines,
+// so concurrent requests do not queue behind a single connection
+type Pool struct {
+	addr     string
+	minConns int
+	maxConns int
+	opts     []Option
+
+	slots chan struct{} // one token per connection that may be checked out
+
+	mu          sync.Mutex
+	idle        []*pooledClient // most recently used last
+	idleTimeout time.Duration
+	maxRetries  int
+	backoff     time.Duration
+	rearm       chan struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+type pooledClient struct {
+	client   *Client
+	lastUsed time.Time
+}
+
+// NewPool dials minConns connections to addr and allows up to maxConns.
+// opts are applied to every connection the pool opens.
+func NewPool(addr string, minConns, maxConns int, opts ...Option) (*Pool, error) {
+	if minConns < 0 || maxConns < 1 || minConns > maxConns {
+		return nil, fmt.Errorf("invalid pool size: min %d, max %d", minConns, maxConns)
+	}
+
+	p := &Pool{
+		addr:        addr,
+		minConns:    minConns,
+		maxConns:    maxConns,
+		opts:        opts,
+		idleTimeout: defaultIdleTimeout,
+		maxRetries:  defaultMaxRetries,
+		backoff:     defaultRetryBackoff,
+		slots:       make(chan struct{}, maxConns),
+		rearm:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	for i := 0; i < minConns; i++ {
+		c, err := Dial(addr, "", opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle = append(p.idle, &pooledClient{client: c, lastUsed: time.Now()})
+	}
+
+	go p.reap()
+	return p, nil
+}
+
+// Send checks out a connection, exchanges msg on it and returns it to the pool.
+// It blocks while maxConns requests are already in flight. A request the
+// server fails with a retryable error is sent again with backoff; permanent
+// errors are returned to the caller straight away.
+func (p *Pool) Send(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	p.mu.Lock()
+	maxRetries, backoff := p.maxRetries, p.backoff
+	p.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		response, err := p.send(ctx, msg)
+		if err != nil || attempt >= maxRetries || !retryable(response) {
+			return response, err
+		}
+
+		select {
+		case <-time.After(backoff << attempt):
+		case <-ctx.Done():
+			return response, nil
+		case <-p.done:
+			return response, nil
+		}
+	}
+}
+
+// SetMaxRetries sets how many times Send repeats a request that failed with a
+// retryable error. Zero disables retries.
+func (p *Pool) SetMaxRetries(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxRetries = max(n, 0)
+}
+
+// SetIdleTimeout closes connections beyond minConns once they have not been used for d
+func (p *Pool) SetIdleTimeout(d time.Duration) {
+	p.mu.Lock()
+	p.idleTimeout = d
+	p.mu.Unlock()
+
+	select {
+	case p.rearm <- struct{}{}:
+	default:
+	}
+}
+
+// Close closes idle connections; connections in use are closed as they are returned
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	for _, pc := range p.idle {
+		pc.client.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+// send exchanges msg once on a pooled connection
+func (p *Pool) send(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	c, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Send(ctx, msg)
+	p.put(c, err)
+	return response, err
+}
+
+// retryable reports whether response is an Error whose code is worth retrying
+func retryable(response *protocol.Message) bool {
+	var code protocol.ErrorCode
+	return errors.As(responseError(response), &code) && protocol.IsRetryable(code)
+}
+
+// get waits for a free slot and returns an idle connection or dials a new one
+func (p *Pool) get(ctx context.Context) (*Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+		return nil, fmt.Errorf("pool closed")
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, fmt.Errorf("pool closed")
+	}
+	if n := len(p.idle); n > 0 {
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return pc.client, nil
+	}
+	p.mu.Unlock()
+
+	c, err := Dial(p.addr, "", p.opts...)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns c to the pool, discarding it if the exchange failed
+func (p *Pool) put(c *Client, sendErr error) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || sendErr != nil {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, &pooledClient{client: c, lastUsed: time.Now()})
+}
+
+// reap periodically closes surplus connections that have been idle too long
+func (p *Pool) reap() {
+	for {
+		p.mu.Lock()
+		timeout := p.idleTimeout
+		p.mu.Unlock()
+
+		timer := time.NewTimer(timeout / 2)
+		select {
+		case <-p.done:
+			timer.Stop()
+			return
+		case <-p.rearm:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		p.mu.Lock()
+		cutoff := time.Now().Add(-p.idleTimeout)
+
+		// The oldest connections sit at the front of the idle list
+		for len(p.idle) > p.minConns && p.idle[0].lastUsed.Before(cutoff) {
+			p.idle[0].client.Close()
+			p.idle = p.idle[1:]
+		}
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/heathweaver/arn-protocol/pkg/network"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func idleCount(p *Pool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+func TestPoolSend(t *testing.T) {
+	server := startServer(t)
+
+	pool, err := NewPool(server.TCPAddr().String(), 2, 4)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	defer pool.Close()
+
+	if n := idleCount(pool); n != 2 {
+		t.Errorf("Expected 2 pre-dialed connections, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := pool.Send(context.Background(), &protocol.Message{
+				Version:   protocol.V1,
+				Type:      protocol.Hello,
+				Timestamp: time.Now(),
+			})
+			if err == nil && response.Type != protocol.Hello {
+				t.Errorf("Expected Hello response, got %v", response.Type)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Send() error = %v", err)
+		}
+	}
+
+	if n := idleCount(pool); n < 2 || n > 4 {
+		t.Errorf("Expected between 2 and 4 pooled connections, got %d", n)
+	}
+}
+
+func TestPoolMaxConns(t *testing.T) {
+	server := startServer(t)
+
+	pool, err := NewPool(server.TCPAddr().String(), 0, 1)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	defer pool.Close()
+
+	// Hold the only connection
+	c, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Send(ctx, &protocol.Message{Version: protocol.V1, Type: protocol.Hello}); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded while pool is exhausted, got %v", err)
+	}
+
+	pool.put(c, nil)
+	if _, err := pool.Send(context.Background(), &protocol.Message{Version: protocol.V1, Type: protocol.Hello}); err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	server := startServer(t)
+
+	pool, err := NewPool(server.TCPAddr().String(), 1, 3)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	defer pool.Close()
+
+	// Check out three connections at once so the pool grows to its maximum
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		c, err := pool.get(context.Background())
+		if err != nil {
+			t.Fatalf("get() error = %v", err)
+		}
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		pool.put(c, nil)
+	}
+	if n := idleCount(pool); n != 3 {
+		t.Fatalf("Expected 3 idle connections, got %d", n)
+	}
+
+	pool.SetIdleTimeout(20 * time.Millisecond)
+
+	// Surplus connections are closed, minConns are kept
+	deadline := time.Now().Add(time.Second)
+	for idleCount(pool) > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected idle connections to shrink to 1, got %d", idleCount(pool))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewPoolInvalidSize(t *testing.T) {
+	if _, err := NewPool("127.0.0.1:0", 3, 2); err == nil {
+		t.Error("Expected error when minConns exceeds maxConns")
+	}
+}
+
+func TestPoolRetry(t *testing.T) {
+	handler := protocol.NewHandler()
+	if err := handler.RegisterMCPBridge(&protocol.MCPBridge{
+		ID:        "records",
+		Endpoint:  "mcp://records/v1",
+		DataTypes: []string{"records"},
+	}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+	server := network.NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	var received atomic.Int32
+	handler.Events().Subscribe(events.MessageReceived, func(event interface{}) {
+		if event.(*protocol.Message).Type == protocol.MCPBridgeRequest {
+			received.Add(1)
+		}
+	})
+
+	pool, err := NewPool(server.TCPAddr().String(), 0, 1)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	defer pool.Close()
+	pool.backoff = time.Millisecond
+
+	tests := []struct {
+		name     string
+		payload  interface{}
+		wantCode protocol.ErrorCode
+		wantSent int32
+	}{
+		{"transient", map[string]string{"bridge_id": "missing"}, protocol.ErrMCPEndpointUnavailable, defaultMaxRetries + 1},
+		{"permanent", "not a request", protocol.ErrInvalidPayload, 1},
+		{"permanent bridge error", map[string]string{"bridge_id": "records", "data_type": "images"}, protocol.ErrMCPProtocolMismatch, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received.Store(0)
+			msg, err := protocol.NewMessage(protocol.MCPBridgeRequest).WithPayload(tt.payload).Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+
+			response, err := pool.Send(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if err := responseError(response); !errors.Is(err, tt.wantCode) {
+				t.Errorf("Send() response error = %v, want %v", err, tt.wantCode)
+			}
+			if got := received.Load(); got != tt.wantSent {
+				t.Errorf("Server received %d requests, want %d", got, tt.wantSent)
+			}
+		})
+	}
+
+	pool.SetMaxRetries(0)
+	received.Store(0)
+	msg, _ := protocol.NewMessage(protocol.MCPBridgeRequest).WithPayload(map[string]string{"bridge_id": "missing"}).Build()
+	if _, err := pool.Send(context.Background(), msg); err != nil || received.Load() != 1 {
+		t.Errorf("Expected a single attempt with retries disabled, got %d (%v)", received.Load(), err)
+	}
+}
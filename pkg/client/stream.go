@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Bounds on how often a stream out of credits asks the receiver for more
+const (
+	creditPollMin = 10 * time.Millisecond
+	creditPollMax = 500 * time.Millisecond
+)
+
+// Stream sends data to a stream session opened on the server. It follows the
+// receiver's flow control, pausing whenever it has no credits left.
+type Stream struct {
+	c  *Client
+	id string
+
+	mu      sync.Mutex
+	credits uint32
+}
+
+// OpenStream starts a stream session on the server with its defaults
+func (c *Client) OpenStream(ctx context.Context) (*Stream, error) {
+	return c.OpenStreamWith(ctx, protocol.StreamStartPayload{})
+}
+
+// OpenStreamWith starts a stream session negotiated from start, failing if
+// the server refuses it
+func (c *Client) OpenStreamWith(ctx context.Context, start protocol.StreamStartPayload) (*Stream, error) {
+	msg, err := c.newMessage(protocol.AIStreamStart, start)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Send(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	var session protocol.StreamStartResponse
+	if err := json.Unmarshal(response.Payload, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stream session: %w", err)
+	}
+	if !session.Accepted {
+		return nil, fmt.Errorf("stream refused: %s", session.Reason)
+	}
+	return &Stream{c: c, id: session.SessionID, credits: session.Credits}, nil
+}
+
+// ID returns the session ID the server assigned to the stream
+func (s *Stream) ID() string {
+	return s.id
+}
+
+// Credits returns how many more chunks may be sent before the stream pauses
+func (s *Stream) Credits() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.credits
+}
+
+// Send delivers one chunk, first waiting for credits if the receiver has
+// fallen behind. It returns ctx.Err() if ctx ends while paused.
+func (s *Stream) Send(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delay := creditPollMin
+	for s.credits == 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if err := s.exchange(ctx, protocol.AIStreamCredit, protocol.StreamCreditPayload{SessionID: s.id}); err != nil {
+			return err
+		}
+
+		delay *= 2
+		if delay > creditPollMax {
+			delay = creditPollMax
+		}
+	}
+
+	s.credits--
+	return s.exchange(ctx, protocol.AIStreamData, protocol.StreamDataPayload{SessionID: s.id, Data: data})
+}
+
+// Close ends the stream on the server
+func (s *Stream) Close() error {
+	msg, err := s.c.newMessage(protocol.AIStreamEnd, protocol.StreamSessionPayload{SessionID: s.id})
+	if err != nil {
+		return err
+	}
+
+	response, err := s.c.Send(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// exchange sends a stream message and adds the credits granted in reply
+func (s *Stream) exchange(ctx context.Context, t protocol.MessageType, v interface{}) error {
+	msg, err := s.c.newMessage(t, v)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.c.Send(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+
+	var grant protocol.StreamCreditPayload
+	if err := json.Unmarshal(response.Payload, &grant); err != nil {
+		return fmt.Errorf("failed to unmarshal stream credits: %w", err)
+	}
+	s.credits += grant.Credits
+	return nil
+}
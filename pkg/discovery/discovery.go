@@ -0,0 +1,465 @@
+// Package discovery advertises and finds ARN nodes on the local network
+// using multicast DNS service discovery (RFC 6762 / RFC 6763).
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// ServiceType is the DNS-SD service type ARN nodes advertise under
+const ServiceType = "_arn._tcp.local."
+
+const (
+	// Default interval between unsolicited announcements
+	defaultInterval = 30 * time.Second
+
+	// TTL attached to advertised records, in seconds
+	recordTTL = 120
+
+	// Largest mDNS packet we expect to receive
+	maxPacketSize = 9000
+)
+
+// mdnsGroup is the IPv4 multicast group and port reserved for mDNS
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type options struct {
+	group    *net.UDPAddr
+	iface    *net.Interface
+	interval time.Duration
+}
+
+// Option configures an Advertiser or Discoverer
+type Option func(*options)
+
+// WithGroup replaces the standard mDNS multicast group and port
+func WithGroup(group *net.UDPAddr) Option {
+	return func(o *options) {
+		o.group = group
+	}
+}
+
+// WithInterface joins the multicast group on iface instead of the system default
+func WithInterface(iface *net.Interface) Option {
+	return func(o *options) {
+		o.iface = iface
+	}
+}
+
+// WithInterval sets how often an Advertiser repeats its announcement
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		group:    mdnsGroup,
+		interval: defaultInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Advertiser announces a node's TCP and UDP addresses over mDNS and
+// answers queries for ServiceType
+type Advertiser struct {
+	instance string
+	tcpAddr  string
+	udpAddr  string
+	opts     options
+
+	conn *net.UDPConn
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewAdvertiser creates an advertiser for the node reachable at tcpAddr and udpAddr
+func NewAdvertiser(instance, tcpAddr, udpAddr string, opts ...Option) *Advertiser {
+	return &Advertiser{
+		instance: instance,
+		tcpAddr:  tcpAddr,
+		udpAddr:  udpAddr,
+		opts:     newOptions(opts),
+	}
+}
+
+// Start joins the multicast group, announces the node and keeps answering queries
+func (a *Advertiser) Start() error {
+	announcement, err := a.announcement(recordTTL)
+	if err != nil {
+		return err
+	}
+
+	conn, err := listenGroup(a.opts)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	a.done = make(chan struct{})
+
+	if _, err := conn.WriteToUDP(announcement, a.opts.group); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send mDNS announcement: %w", err)
+	}
+
+	a.wg.Add(2)
+	go a.announceLoop(announcement)
+	go a.answerQueries(announcement)
+	return nil
+}
+
+// Stop withdraws the advertisement and leaves the multicast group
+func (a *Advertiser) Stop() error {
+	if a.conn == nil {
+		return nil
+	}
+	close(a.done)
+
+	// A zero TTL tells listeners the service is going away
+	if goodbye, err := a.announcement(0); err == nil {
+		a.conn.WriteToUDP(goodbye, a.opts.group)
+	}
+
+	err := a.conn.Close()
+	a.wg.Wait()
+	a.conn = nil
+	return err
+}
+
+func (a *Advertiser) announceLoop(announcement []byte) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			a.conn.WriteToUDP(announcement, a.opts.group)
+		}
+	}
+}
+
+func (a *Advertiser) answerQueries(announcement []byte) {
+	defer a.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // Connection closed by Stop
+		}
+		if isServiceQuery(buf[:n]) {
+			a.conn.WriteToUDP(announcement, a.opts.group)
+		}
+	}
+}
+
+// announcement builds the PTR, SRV, TXT and A records describing this node
+func (a *Advertiser) announcement(ttl uint32) ([]byte, error) {
+	host, port, err := splitHostPort(a.tcpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP address: %w", err)
+	}
+
+	service, err := dnsmessage.NewName(ServiceType)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(escapeLabel(a.instance) + "." + ServiceType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance name: %w", err)
+	}
+	target, err := dnsmessage.NewName(hostName())
+	if err != nil {
+		return nil, err
+	}
+
+	txt := []string{"txtvers=1"}
+	if a.udpAddr != "" {
+		if _, udpPort, err := splitHostPort(a.udpAddr); err == nil {
+			txt = append(txt, "udp="+strconv.Itoa(int(udpPort)))
+		}
+	}
+
+	header := func(name dnsmessage.Name, t dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: t, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{
+				Header: header(service, dnsmessage.TypePTR),
+				Body:   &dnsmessage.PTRResource{PTR: instance},
+			},
+			{
+				Header: header(instance, dnsmessage.TypeSRV),
+				Body:   &dnsmessage.SRVResource{Target: target, Port: port},
+			},
+			{
+				Header: header(instance, dnsmessage.TypeTXT),
+				Body:   &dnsmessage.TXTResource{TXT: txt},
+			},
+		},
+	}
+
+	for _, ip := range advertisedIPs(host) {
+		var rec dnsmessage.AResource
+		copy(rec.A[:], ip)
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: header(target, dnsmessage.TypeA),
+			Body:   &rec,
+		})
+	}
+
+	return msg.Pack()
+}
+
+// Discoverer listens for ARN announcements and reports each node it finds once
+type Discoverer struct {
+	onFound func(addr string)
+	opts    options
+
+	conn *net.UDPConn
+	wg   sync.WaitGroup
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// NewDiscoverer creates a discoverer that calls onFound with the TCP address
+// of every node it hears from
+func NewDiscoverer(onFound func(addr string), opts ...Option) *Discoverer {
+	return &Discoverer{
+		onFound: onFound,
+		opts:    newOptions(opts),
+		seen:    make(map[string]bool),
+	}
+}
+
+// Start joins the multicast group and asks nodes already running to announce themselves
+func (d *Discoverer) Start() error {
+	conn, err := listenGroup(d.opts)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+
+	d.wg.Add(1)
+	go d.listen()
+
+	query, err := serviceQuery()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := conn.WriteToUDP(query, d.opts.group); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send mDNS query: %w", err)
+	}
+	return nil
+}
+
+// Stop leaves the multicast group
+func (d *Discoverer) Stop() error {
+	if d.conn == nil {
+		return nil
+	}
+
+	err := d.conn.Close()
+	d.wg.Wait()
+	d.conn = nil
+	return err
+}
+
+func (d *Discoverer) listen() {
+	defer d.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // Connection closed by Stop
+		}
+
+		for addr, alive := range parseAnnouncement(buf[:n], src.IP) {
+			d.mu.Lock()
+			known := d.seen[addr]
+			if alive {
+				d.seen[addr] = true
+			} else {
+				delete(d.seen, addr)
+			}
+			d.mu.Unlock()
+
+			if alive && !known && d.onFound != nil {
+				d.onFound(addr)
+			}
+		}
+	}
+}
+
+// listenGroup joins the multicast group with loopback enabled, so nodes on the
+// same host can find each other
+func listenGroup(o options) (*net.UDPConn, error) {
+	conn, err := net.ListenMulticastUDP("udp4", o.iface, o.group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+
+	// ListenMulticastUDP turns loopback off
+	if err := ipv4.NewPacketConn(conn).SetMulticastLoopback(true); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable multicast loopback: %w", err)
+	}
+	return conn, nil
+}
+
+// parseAnnouncement extracts the TCP addresses of ARN services in an mDNS
+// response, mapped to false for goodbye (zero TTL) records
+func parseAnnouncement(packet []byte, src net.IP) map[string]bool {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Header.Response {
+		return nil
+	}
+
+	records := append(msg.Answers, msg.Additionals...)
+
+	// Collect host addresses first so SRV targets can be resolved
+	hosts := make(map[string]net.IP)
+	for _, r := range records {
+		if a, ok := r.Body.(*dnsmessage.AResource); ok {
+			hosts[strings.ToLower(r.Header.Name.String())] = net.IP(a.A[:])
+		}
+	}
+
+	found := make(map[string]bool)
+	for _, r := range records {
+		srv, ok := r.Body.(*dnsmessage.SRVResource)
+		if !ok || !strings.HasSuffix(strings.ToLower(r.Header.Name.String()), ServiceType) {
+			continue
+		}
+
+		ip, ok := hosts[strings.ToLower(srv.Target.String())]
+		if !ok {
+			ip = src
+		}
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port)))
+		found[addr] = r.Header.TTL > 0
+	}
+	return found
+}
+
+// serviceQuery builds a PTR question for ServiceType
+func serviceQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(ServiceType)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  service,
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return msg.Pack()
+}
+
+// isServiceQuery reports whether packet asks for ARN services
+func isServiceQuery(packet []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || header.Response {
+		return false
+	}
+
+	for {
+		q, err := parser.Question()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return false
+		}
+		if err != nil {
+			return false
+		}
+		if (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) &&
+			strings.EqualFold(q.Name.String(), ServiceType) {
+			return true
+		}
+	}
+}
+
+// splitHostPort splits addr and parses its port
+func splitHostPort(addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, uint16(port), nil
+}
+
+// advertisedIPs returns host itself when it is a specific IPv4 address,
+// otherwise every non-loopback IPv4 address on the machine
+func advertisedIPs(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		if ip4 := ip.To4(); ip4 != nil {
+			return []net.IP{ip4}
+		}
+		return nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		}
+	}
+	return ips
+}
+
+// hostName returns this machine's name in the .local. domain
+func hostName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "arn-node"
+	}
+	name, _, _ = strings.Cut(name, ".")
+	return escapeLabel(name) + ".local."
+}
+
+// escapeLabel makes s safe to use as a single DNS label
+func escapeLabel(s string) string {
+	return strings.NewReplacer(".", "-", " ", "-").Replace(s)
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testGroup returns the mDNS multicast group on a free port so tests do not
+// collide with a real responder on 5353
+func testGroup(t *testing.T) *net.UDPAddr {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	group := &net.UDPAddr{IP: mdnsGroup.IP, Port: port}
+	probe, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		t.Skipf("Multicast unavailable: %v", err)
+	}
+	probe.Close()
+	return group
+}
+
+func TestAdvertiseAndDiscover(t *testing.T) {
+	group := testGroup(t)
+
+	found := make(chan string, 10)
+	discoverer := NewDiscoverer(func(addr string) {
+		found <- addr
+	}, WithGroup(group))
+	if err := discoverer.Start(); err != nil {
+		t.Fatalf("Discoverer.Start() error = %v", err)
+	}
+	defer discoverer.Stop()
+
+	advertiser := NewAdvertiser("test-node", "127.0.0.1:7777", "127.0.0.1:7778",
+		WithGroup(group), WithInterval(10*time.Millisecond))
+	if err := advertiser.Start(); err != nil {
+		t.Fatalf("Advertiser.Start() error = %v", err)
+	}
+	defer advertiser.Stop()
+
+	select {
+	case addr := <-found:
+		if addr != "127.0.0.1:7777" {
+			t.Errorf("Expected 127.0.0.1:7777, got %s", addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for discovery")
+	}
+
+	// Repeated announcements do not report the node again
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case addr := <-found:
+		t.Errorf("Expected node to be reported once, got %s again", addr)
+	default:
+	}
+}
+
+func TestAdvertiserAnswersQueries(t *testing.T) {
+	group := testGroup(t)
+
+	// A long interval means only a query can trigger the second announcement
+	advertiser := NewAdvertiser("early-node", "127.0.0.1:9000", "", WithGroup(group), WithInterval(time.Hour))
+	if err := advertiser.Start(); err != nil {
+		t.Fatalf("Advertiser.Start() error = %v", err)
+	}
+	defer advertiser.Stop()
+
+	found := make(chan string, 1)
+	discoverer := NewDiscoverer(func(addr string) {
+		found <- addr
+	}, WithGroup(group))
+	if err := discoverer.Start(); err != nil {
+		t.Fatalf("Discoverer.Start() error = %v", err)
+	}
+	defer discoverer.Stop()
+
+	select {
+	case addr := <-found:
+		if addr != "127.0.0.1:9000" {
+			t.Errorf("Expected 127.0.0.1:9000, got %s", addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for query response")
+	}
+}
+
+func TestParseAnnouncement(t *testing.T) {
+	advertiser := NewAdvertiser("node", "192.0.2.10:7777", "192.0.2.10:7778")
+
+	live, err := advertiser.announcement(recordTTL)
+	if err != nil {
+		t.Fatalf("announcement() error = %v", err)
+	}
+	goodbye, err := advertiser.announcement(0)
+	if err != nil {
+		t.Fatalf("announcement() error = %v", err)
+	}
+
+	src := net.IPv4(192, 0, 2, 99)
+	if got := parseAnnouncement(live, src); !got["192.0.2.10:7777"] {
+		t.Errorf("Expected live node at 192.0.2.10:7777, got %v", got)
+	}
+
+	got := parseAnnouncement(goodbye, src)
+	if alive, ok := got["192.0.2.10:7777"]; !ok || alive {
+		t.Errorf("Expected goodbye for 192.0.2.10:7777, got %v", got)
+	}
+
+	// Queries are not announcements
+	query, err := serviceQuery()
+	if err != nil {
+		t.Fatalf("serviceQuery() error = %v", err)
+	}
+	if got := parseAnnouncement(query, src); len(got) != 0 {
+		t.Errorf("Expected no services from a query, got %v", got)
+	}
+	if !isServiceQuery(query) {
+		t.Error("Expected serviceQuery to be recognised as a query")
+	}
+}
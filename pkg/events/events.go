@@ -0,0 +1,79 @@
+// Package events fans internal events out to any number of subscribers
+package events
+
+import "sync"
+
+// Topics published by protocol.Handler
+const (
+	// CapabilityRegistered carries a *protocol.Capability once it is registered
+	CapabilityRegistered = "capability.registered"
+
+	// BridgeAdvertised carries a *protocol.MCPBridge once it is registered
+	BridgeAdvertised = "bridge.advertised"
+
+	// MessageReceived carries every *protocol.Message that passed authentication
+	MessageReceived = "message.received"
+)
+
+// Bus delivers published events to the handlers subscribed to their topic
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[string][]*subscription
+	nextID uint64
+}
+
+type subscription struct {
+	id      uint64
+	handler func(interface{})
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[string][]*subscription)}
+}
+
+// Subscribe calls handler with every event published to topic until the
+// returned function is called
+func (b *Bus) Subscribe(topic string, handler func(interface{})) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	sub := &subscription{id: b.nextID, handler: handler}
+	b.subs[topic] = append(b.subs[topic], sub)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.unsubscribe(topic, sub.id) })
+	}
+}
+
+func (b *Bus) unsubscribe(topic string, id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.subs[topic]
+	for i, sub := range subs {
+		if sub.id == id {
+			// Copy so a Publish iterating the old slice is unaffected
+			b.subs[topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(b.subs[topic]) == 0 {
+		delete(b.subs, topic)
+	}
+}
+
+// Publish calls every handler subscribed to topic with event, in the order
+// they subscribed. Handlers run on the caller's goroutine, so they should
+// hand slow work off rather than block.
+func (b *Bus) Publish(topic string, event interface{}) {
+	b.mu.RLock()
+	subs := b.subs[topic]
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.handler(event)
+	}
+}
@@ -0,0 +1,44 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBusFanout(t *testing.T) {
+	bus := NewBus()
+
+	var got []string
+	bus.Subscribe("topic", func(event interface{}) { got = append(got, "first:"+event.(string)) })
+	unsubscribe := bus.Subscribe("topic", func(event interface{}) { got = append(got, "second:"+event.(string)) })
+	bus.Subscribe("other", func(event interface{}) { got = append(got, "other:"+event.(string)) })
+
+	bus.Publish("topic", "a")
+	unsubscribe()
+	unsubscribe() // Calling it again is harmless
+	bus.Publish("topic", "b")
+	bus.Publish("unknown", "c")
+
+	want := []string{"first:a", "second:a", "first:b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestBusUnsubscribeDuringPublish(t *testing.T) {
+	bus := NewBus()
+
+	calls := 0
+	var unsubscribe func()
+	unsubscribe = bus.Subscribe("topic", func(event interface{}) {
+		calls++
+		unsubscribe()
+	})
+	bus.Subscribe("topic", func(event interface{}) { calls++ })
+
+	bus.Publish("topic", nil)
+	bus.Publish("topic", nil)
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,110 @@
+// Package metrics exposes Prometheus instrumentation for ARN nodes.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the default registry served by HTTPHandler
+var Registry = prometheus.NewRegistry()
+
+// Metrics holds the ARN collectors registered on a single registry.
+// A nil *Metrics discards every observation.
+type Metrics struct {
+	messages    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	connections *prometheus.GaugeVec
+	bridges     prometheus.Gauge
+}
+
+var (
+	mu         sync.Mutex
+	byRegistry = make(map[*prometheus.Registry]*Metrics)
+)
+
+// For returns the collectors registered on reg, registering them on first use.
+// Handlers and servers sharing a registry share the same collectors.
+func For(reg *prometheus.Registry) *Metrics {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if m, ok := byRegistry[reg]; ok {
+		return m
+	}
+
+	m := &Metrics{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "arn_messages_total",
+			Help: "Messages received, by message type and transport.",
+		}, []string{"type", "transport"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "arn_message_duration_seconds",
+			Help:    "Time spent handling a message, by message type.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"type"}),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "arn_active_connections",
+			Help: "Open connections, by transport.",
+		}, []string{"transport"}),
+		bridges: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "arn_bridge_count",
+			Help: "Registered MCP bridges.",
+		}),
+	}
+	reg.MustRegister(m.messages, m.duration, m.connections, m.bridges)
+
+	byRegistry[reg] = m
+	return m
+}
+
+// MessageReceived counts one message of msgType arriving over transport
+func (m *Metrics) MessageReceived(msgType, transport string) {
+	if m == nil {
+		return
+	}
+	m.messages.WithLabelValues(msgType, transport).Inc()
+}
+
+// ObserveDuration records how long a message of msgType took to handle
+func (m *Metrics) ObserveDuration(msgType string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.duration.WithLabelValues(msgType).Observe(d.Seconds())
+}
+
+// ConnectionOpened increments the open connection gauge for transport
+func (m *Metrics) ConnectionOpened(transport string) {
+	if m == nil {
+		return
+	}
+	m.connections.WithLabelValues(transport).Inc()
+}
+
+// ConnectionClosed decrements the open connection gauge for transport
+func (m *Metrics) ConnectionClosed(transport string) {
+	if m == nil {
+		return
+	}
+	m.connections.WithLabelValues(transport).Dec()
+}
+
+// SetBridgeCount records the number of registered MCP bridges
+func (m *Metrics) SetBridgeCount(n int) {
+	if m == nil {
+		return
+	}
+	m.bridges.Set(float64(n))
+}
+
+// HTTPHandler serves Registry in the Prometheus text format at /metrics
+func HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	return mux
+}
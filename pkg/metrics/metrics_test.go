@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestForSharesCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// A second call must not try to register the collectors again
+	m := For(reg)
+	if For(reg) != m {
+		t.Error("Expected For to return the same Metrics for a registry")
+	}
+
+	m.MessageReceived("1", "tcp")
+	m.MessageReceived("1", "tcp")
+	m.ObserveDuration("1", 10*time.Millisecond)
+	m.ConnectionOpened("tcp")
+	m.SetBridgeCount(3)
+
+	if got := testutil.ToFloat64(m.messages.WithLabelValues("1", "tcp")); got != 2 {
+		t.Errorf("Expected 2 messages, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.connections.WithLabelValues("tcp")); got != 1 {
+		t.Errorf("Expected 1 connection, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.bridges); got != 3 {
+		t.Errorf("Expected 3 bridges, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.duration); got != 1 {
+		t.Errorf("Expected 1 duration series, got %d", got)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	// Every method is a no-op on nil
+	m.MessageReceived("1", "tcp")
+	m.ObserveDuration("1", time.Millisecond)
+	m.ConnectionOpened("tcp")
+	m.ConnectionClosed("tcp")
+	m.SetBridgeCount(1)
+}
+
+func TestHTTPHandler(t *testing.T) {
+	For(Registry).SetBridgeCount(2)
+
+	server := httptest.NewServer(HTTPHandler())
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if !strings.Contains(string(body), "arn_bridge_count 2") {
+		t.Errorf("Expected arn_bridge_count in output, got:\n%s", body)
+	}
+}
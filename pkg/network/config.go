@@ -0,0 +1,265 @@
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Prefix of the environment variables read by EnvConfig
+const envPrefix = "ARN_"
+
+// Config describes a server and the handler behind it. It is loaded from a
+// TOML file by LoadConfig, where TLSFiles is the [tls] table, or from
+// ARN_* environment variables by EnvConfig, named after the TOML keys: the
+// tcp_addr key is ARN_TCP_ADDR and cert_file in [tls] is
+// ARN_TLS_CERT_FILE. Durations are written like "30s". Empty and zero
+// fields leave the server's defaults in place.
+type Config struct {
+	TCPAddr        string `toml:"tcp_addr"`
+	UDPAddr        string `toml:"udp_addr"`
+	UnixSocket     string `toml:"unix_socket"`
+	WebSocketAddr  string `toml:"websocket_addr"`
+	SCTPAddr       string `toml:"sctp_addr"`
+	HealthAddr     string `toml:"health_addr"`
+	MulticastGroup string `toml:"multicast_group"`
+
+	TLS TLSFiles `toml:"tls"`
+
+	MaxConnections    int           `toml:"max_connections"`
+	Workers           int           `toml:"workers"`
+	MaxIdleTime       time.Duration `toml:"max_idle_time"`
+	MessageTimeout    time.Duration `toml:"message_timeout"`
+	DrainTimeout      time.Duration `toml:"drain_timeout"`
+	HeartbeatInterval time.Duration `toml:"heartbeat_interval"`
+	HeartbeatTimeout  time.Duration `toml:"heartbeat_timeout"`
+}
+
+// TLSFiles names the PEM files of the server's certificate and key. TLS
+// is enabled when both are set.
+type TLSFiles struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+}
+
+// DefaultConfig returns the configuration the server command uses when
+// given no flags
+func DefaultConfig() *Config {
+	return &Config{
+		TCPAddr: ":7777",
+		UDPAddr: ":7778",
+	}
+}
+
+// LoadConfig reads the TOML file at path over DefaultConfig
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	cfg := DefaultConfig()
+	if err := decodeConfig(string(data), cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// decodeConfig decodes the TOML document data into cfg. Keys without a
+// field are an error so that typos are not silently ignored, and so are
+// durations not written as strings, which would otherwise be read as
+// nanoseconds.
+func decodeConfig(data string, cfg *Config) error {
+	md, err := toml.Decode(data, cfg)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return fmt.Errorf("unknown key %q", undecoded[0].String())
+	}
+
+	walkConfig(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value) {
+		if err == nil && field.Type() == durationType && md.IsDefined(path...) && md.Type(path...) != "String" {
+			err = fmt.Errorf("%s: expected a duration such as \"30s\", got %s", strings.Join(path, "."), strings.ToLower(md.Type(path...)))
+		}
+	})
+	return err
+}
+
+// EnvConfig returns DefaultConfig overridden by the ARN_* environment
+// variables that are set
+func EnvConfig() (*Config, error) {
+	cfg := DefaultConfig()
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides c with the ARN_* environment variables that are set,
+// so that a loaded file can be adjusted per deployment
+func (c *Config) ApplyEnv() error {
+	var err error
+	walkConfig(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) {
+		name := envPrefix + strings.ToUpper(strings.Join(path, "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := setConfigField(field, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// walkConfig calls fn for every tagged leaf field of the struct v, with the
+// tags of the tables leading to it
+func walkConfig(v reflect.Value, path []string, fn func(path []string, field reflect.Value)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		tag := t.Field(i).Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := append(path[:len(path):len(path)], tag)
+		if field.Kind() == reflect.Struct {
+			walkConfig(field, fieldPath, fn)
+			continue
+		}
+		fn(fieldPath, field)
+	}
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// setConfigField parses s into field, which holds a string, integer,
+// boolean or time.Duration
+func setConfigField(field reflect.Value, s string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(s)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports settings that cannot be served
+func (c *Config) Validate() error {
+	if c.TCPAddr == "" {
+		return errors.New("tcp_addr is required")
+	}
+	if c.UDPAddr == "" {
+		return errors.New("udp_addr is required")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.MaxConnections < 0 {
+		return errors.New("max_connections must not be negative")
+	}
+	if c.Workers < 0 {
+		return errors.New("workers must not be negative")
+	}
+	for name, d := range map[string]time.Duration{
+		"max_idle_time":      c.MaxIdleTime,
+		"message_timeout":    c.MessageTimeout,
+		"drain_timeout":      c.DrainTimeout,
+		"heartbeat_interval": c.HeartbeatInterval,
+		"heartbeat_timeout":  c.HeartbeatTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// NewServerFromConfig validates cfg and creates a server for it together
+// with its handler. opts are applied after those derived from cfg. The
+// caller closes Handler once the server has stopped.
+func NewServerFromConfig(cfg *Config, opts ...Option) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	var serverOpts []Option
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		serverOpts = append(serverOpts, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	}
+	if cfg.UnixSocket != "" {
+		serverOpts = append(serverOpts, WithUnixSocket(cfg.UnixSocket))
+	}
+	if cfg.WebSocketAddr != "" {
+		serverOpts = append(serverOpts, WithWebSocket(cfg.WebSocketAddr))
+	}
+	if cfg.SCTPAddr != "" {
+		serverOpts = append(serverOpts, WithSCTP(cfg.SCTPAddr))
+	}
+	if cfg.HealthAddr != "" {
+		serverOpts = append(serverOpts, WithHTTPHealth(cfg.HealthAddr))
+	}
+	if cfg.MulticastGroup != "" {
+		serverOpts = append(serverOpts, WithMulticastGroup(cfg.MulticastGroup))
+	}
+	if cfg.MaxConnections > 0 {
+		serverOpts = append(serverOpts, WithMaxConnections(cfg.MaxConnections))
+	}
+	if cfg.MaxIdleTime > 0 {
+		serverOpts = append(serverOpts, WithMaxIdleTime(cfg.MaxIdleTime))
+	}
+	if cfg.MessageTimeout > 0 {
+		serverOpts = append(serverOpts, WithMessageTimeout(cfg.MessageTimeout))
+	}
+	if cfg.DrainTimeout > 0 {
+		serverOpts = append(serverOpts, WithDrainTimeout(cfg.DrainTimeout))
+	}
+	if cfg.HeartbeatInterval > 0 {
+		serverOpts = append(serverOpts, WithHeartbeat(cfg.HeartbeatInterval, cfg.HeartbeatTimeout))
+	}
+
+	var handlerOpts []protocol.Option
+	if cfg.Workers > 0 {
+		handlerOpts = append(handlerOpts, protocol.WithWorkerPool(cfg.Workers))
+	}
+	handler := protocol.NewHandler(handlerOpts...)
+
+	return NewServer(cfg.TCPAddr, cfg.UDPAddr, handler, append(serverOpts, opts...)...), nil
+}
+
+// Handler returns the handler the server passes messages to
+func (s *Server) Handler() *protocol.Handler {
+	return s.handler
+}
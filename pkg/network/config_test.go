@@ -0,0 +1,206 @@
+package network
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "arn.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name: "every field",
+			content: `# ARN node
+tcp_addr = "127.0.0.1:9000"
+udp_addr = '127.0.0.1:9001'
+unix_socket = "/run/arn.sock"
+websocket_addr = ":8080"  # browsers
+sctp_addr = ":9002"
+health_addr = ":8081"
+multicast_group = "239.0.0.1:7779"
+max_connections = 1_000
+workers = 8
+max_idle_time = "5m"
+message_timeout = "2s"
+drain_timeout = "30s"
+heartbeat_interval = "10s"
+heartbeat_timeout = "5s"
+
+[tls]
+cert_file = "/etc/arn/cert.pem"
+key_file = "/etc/arn/key.pem"
+`,
+			want: &Config{
+				TCPAddr:           "127.0.0.1:9000",
+				UDPAddr:           "127.0.0.1:9001",
+				UnixSocket:        "/run/arn.sock",
+				WebSocketAddr:     ":8080",
+				SCTPAddr:          ":9002",
+				HealthAddr:        ":8081",
+				MulticastGroup:    "239.0.0.1:7779",
+				TLS:               TLSFiles{CertFile: "/etc/arn/cert.pem", KeyFile: "/etc/arn/key.pem"},
+				MaxConnections:    1000,
+				Workers:           8,
+				MaxIdleTime:       5 * time.Minute,
+				MessageTimeout:    2 * time.Second,
+				DrainTimeout:      30 * time.Second,
+				HeartbeatInterval: 10 * time.Second,
+				HeartbeatTimeout:  5 * time.Second,
+			},
+		},
+		{
+			name:    "defaults kept",
+			content: "workers = 2\n",
+			want:    &Config{TCPAddr: ":7777", UDPAddr: ":7778", Workers: 2},
+		},
+		{name: "unknown key", content: "tcp_adr = \":1\"\n", wantErr: true},
+		{name: "unknown table", content: "[tsl]\ncert_file = \"a\"\n", wantErr: true},
+		{name: "string for integer", content: "workers = \"8\"\n", wantErr: true},
+		{name: "integer for string", content: "tcp_addr = 7777\n", wantErr: true},
+		{name: "invalid duration", content: "drain_timeout = \"soon\"\n", wantErr: true},
+		{name: "integer duration", content: "drain_timeout = 30\n", wantErr: true},
+		{name: "unknown key in table", content: "[tls]\ncert = \"a\"\n", wantErr: true},
+		{name: "unterminated string", content: "tcp_addr = \":7777\n", wantErr: true},
+		{name: "duplicate key", content: "workers = 1\nworkers = 2\n", wantErr: true},
+		{name: "missing value", content: "workers =\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadConfig(writeConfig(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+func TestEnvConfig(t *testing.T) {
+	t.Setenv("ARN_TCP_ADDR", "127.0.0.1:9000")
+	t.Setenv("ARN_TLS_CERT_FILE", "/etc/arn/cert.pem")
+	t.Setenv("ARN_WORKERS", "4")
+	t.Setenv("ARN_MESSAGE_TIMEOUT", "3s")
+
+	cfg, err := EnvConfig()
+	if err != nil {
+		t.Fatalf("EnvConfig() error = %v", err)
+	}
+	want := &Config{
+		TCPAddr:        "127.0.0.1:9000",
+		UDPAddr:        ":7778",
+		TLS:            TLSFiles{CertFile: "/etc/arn/cert.pem"},
+		Workers:        4,
+		MessageTimeout: 3 * time.Second,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("EnvConfig() = %+v, want %+v", cfg, want)
+	}
+
+	// The environment overrides a loaded file
+	cfg, err = LoadConfig(writeConfig(t, "workers = 16\nmax_connections = 10\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatalf("ApplyEnv() error = %v", err)
+	}
+	if cfg.Workers != 4 || cfg.MaxConnections != 10 {
+		t.Errorf("ApplyEnv() workers = %d, max_connections = %d, want 4 and 10", cfg.Workers, cfg.MaxConnections)
+	}
+
+	t.Setenv("ARN_MAX_CONNECTIONS", "many")
+	if _, err := EnvConfig(); err == nil {
+		t.Error("Expected an invalid ARN_MAX_CONNECTIONS to fail")
+	}
+}
+
+func TestNewServerFromConfig(t *testing.T) {
+	cert, err := security.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		TCPAddr:        "127.0.0.1:0",
+		UDPAddr:        "127.0.0.1:0",
+		TLS:            TLSFiles{CertFile: certFile, KeyFile: keyFile},
+		MaxConnections: 5,
+		Workers:        3,
+		DrainTimeout:   time.Second,
+		MessageTimeout: 2 * time.Second,
+	}
+	server, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewServerFromConfig() error = %v", err)
+	}
+	defer server.Handler().Close()
+
+	if server.TLSConfig == nil {
+		t.Error("Expected TLS to be enabled")
+	}
+	if server.maxConns != 5 || server.DrainTimeout != time.Second || server.MessageTimeout != 2*time.Second {
+		t.Errorf("Server limits = %d, %v, %v, want 5, 1s, 2s", server.maxConns, server.DrainTimeout, server.MessageTimeout)
+	}
+	if got := server.Handler().Workers(); got != 3 {
+		t.Errorf("Handler().Workers() = %d, want 3", got)
+	}
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := server.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	for name, bad := range map[string]*Config{
+		"no TCP address":   {UDPAddr: ":0"},
+		"certificate only": {TCPAddr: ":0", UDPAddr: ":0", TLS: TLSFiles{CertFile: certFile}},
+		"missing key file": {TCPAddr: ":0", UDPAddr: ":0", TLS: TLSFiles{CertFile: certFile, KeyFile: filepath.Join(dir, "none.pem")}},
+		"negative workers": {TCPAddr: ":0", UDPAddr: ":0", Workers: -1},
+		"negative timeout": {TCPAddr: ":0", UDPAddr: ":0", DrainTimeout: -time.Second},
+	} {
+		if _, err := NewServerFromConfig(bad); err == nil {
+			t.Errorf("NewServerFromConfig(%s) succeeded, want an error", name)
+		}
+	}
+}
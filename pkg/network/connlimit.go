@@ -0,0 +1,38 @@
+package network
+
+import (
+	"net"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Time a refused peer has to read why before its connection is closed
+const refuseWriteTimeout = time.Second
+
+// WithMaxConnections caps the stream connections open at once at n. Further
+// connections are accepted only to be sent an ErrUnauthorized Error and
+// closed. Zero, the default, sets no limit.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// overConnectionLimit reports whether a connection taking the server to n
+// open connections exceeds WithMaxConnections
+func (s *Server) overConnectionLimit(n int64) bool {
+	return s.maxConns > 0 && n > int64(s.maxConns)
+}
+
+// refuseConn tells a peer the server is full. The caller closes conn.
+func (s *Server) refuseConn(conn net.Conn, transport string) {
+	s.logger.Warn("Refused connection over limit", "transport", transport, "peer", conn.RemoteAddr(), "max_connections", s.maxConns)
+
+	response, err := protocol.NewErrorMessage(protocol.ErrUnauthorized, "too many connections")
+	if err != nil {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(refuseWriteTimeout))
+	WriteMessage(conn, response)
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestMaxConnections(t *testing.T) {
+	const limit = 3
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithMaxConnections(limit))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	dial := func() net.Conn {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	conns := make([]net.Conn, limit)
+	for i := range conns {
+		conns[i] = dial()
+		handshake(t, conns[i])
+	}
+
+	// The connection over the limit is accepted, told why and closed
+	extra := dial()
+	extra.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := ReadMessage(extra)
+	if err != nil {
+		t.Fatalf("Failed to read refusal: %v", err)
+	}
+	var payload protocol.ErrorPayload
+	if msg.Type != protocol.Error || json.Unmarshal(msg.Payload, &payload) != nil || payload.Code != protocol.ErrUnauthorized {
+		t.Fatalf("Expected an ErrUnauthorized Error, got %v: %s", msg.Type, msg.Payload)
+	}
+	if _, err := ReadMessage(extra); !isClosedError(err) {
+		t.Errorf("Expected the refused connection to be closed, got %v", err)
+	}
+
+	// Sessions within the limit are unaffected
+	query(t, conns[0], "DISCOVER")
+
+	// Closing a session makes room for another
+	conns[limit-1].Close()
+	for deadline := time.Now().Add(time.Second); server.ActiveConnections() >= limit && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	handshake(t, dial())
+}
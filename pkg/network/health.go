@@ -0,0 +1,74 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// healthServer answers orchestrator liveness and readiness probes
+type healthServer struct {
+	addr       string
+	server     *Server
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+// WithHTTPHealth serves /healthz and /readyz over plain HTTP on addr
+func WithHTTPHealth(addr string) Option {
+	return func(s *Server) {
+		s.health = &healthServer{addr: addr, server: s}
+	}
+}
+
+func (h *healthServer) start() error {
+	listener, err := net.Listen("tcp", h.addr)
+	if err != nil {
+		return fmt.Errorf("failed to start health listener: %w", err)
+	}
+	h.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+	h.httpServer = &http.Server{Handler: mux}
+
+	h.server.wg.Add(1)
+	go func() {
+		defer h.server.wg.Done()
+		if err := h.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			h.server.logger.Error("Health server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (h *healthServer) stop() error {
+	if h.httpServer == nil {
+		return nil
+	}
+	return h.httpServer.Close()
+}
+
+// healthz reports the process is alive and serving
+func (h *healthServer) healthz(w http.ResponseWriter, r *http.Request) {
+	if !h.server.accepting.Load() {
+		http.Error(w, "stopped", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether the node is worth routing traffic to: its listeners
+// are accepting and it has at least one capability to offer
+func (h *healthServer) readyz(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case !h.server.accepting.Load():
+		http.Error(w, "listeners not accepting", http.StatusServiceUnavailable)
+	case h.server.handler.CapabilityCount() == 0:
+		http.Error(w, "no capabilities registered", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ready")
+	}
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestHTTPHealth(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithHTTPHealth("127.0.0.1:0"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	base := "http://" + server.HealthAddr().String()
+	probe := func(path string) int {
+		t.Helper()
+
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz 200, got %d", code)
+	}
+
+	// Not ready until there is something to offer
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz 503 without capabilities, got %d", code)
+	}
+
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "ready", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz 200, got %d", code)
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Error("Expected health server to be stopped")
+	}
+}
@@ -0,0 +1,101 @@
+package network
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// WithHeartbeat sends a Ping on stream connections that have been quiet for
+// interval. A connection that then stays silent for timeout is treated as
+// dropped: it is closed and the capabilities registered over it are
+// deregistered. The idle read deadline is extended to cover interval plus
+// timeout, but a shorter WithMaxIdleTimeout still closes quiet connections.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.HeartbeatInterval = interval
+		s.HeartbeatTimeout = timeout
+	}
+}
+
+// heartbeat pings tc whenever it has been quiet for HeartbeatInterval until
+// done is closed. Any message proves the peer is alive, a Pong being the
+// cheapest. ctx is the session's context, which identifies the peer. A zero
+// HeartbeatTimeout waits one HeartbeatInterval for the answer.
+func (s *Server) heartbeat(ctx context.Context, tc *trackedConn, done <-chan struct{}, log *slog.Logger) {
+	timeout := s.HeartbeatTimeout
+	if timeout <= 0 {
+		timeout = s.HeartbeatInterval
+	}
+
+	timer := time.NewTimer(s.HeartbeatInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		if idle := time.Since(s.lastSeenAt(tc.Conn)); idle < s.HeartbeatInterval {
+			timer.Reset(s.HeartbeatInterval - idle)
+			continue
+		}
+
+		sent := time.Now()
+		if err := s.ping(tc); err != nil {
+			log.Error("Failed to send heartbeat", "error", err)
+			tc.Close()
+			return
+		}
+
+		timer.Reset(timeout)
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		if s.lastSeenAt(tc.Conn).After(sent) {
+			timer.Reset(s.HeartbeatInterval)
+			continue
+		}
+
+		removed := s.handler.DeregisterPeerCapabilities(ctx)
+		log.Warn("Closing connection after missed heartbeat", "timeout", timeout, "deregistered", removed)
+		tc.Close()
+		return
+	}
+}
+
+// ping sends a Ping to tc
+func (s *Server) ping(tc *trackedConn) error {
+	msg, err := protocol.NewMessage(protocol.Ping).Build()
+	if err != nil {
+		return err
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+	if err := tc.writeFrame(data); err != nil {
+		return err
+	}
+	if tc.batch != nil {
+		return tc.batch.Flush()
+	}
+	return nil
+}
+
+// lastSeenAt returns when conn last sent a message
+func (s *Server) lastSeenAt(conn net.Conn) time.Time {
+	seen, ok := s.lastSeen.Load(conn)
+	if !ok {
+		return time.Time{}
+	}
+	return seen.(time.Time)
+}
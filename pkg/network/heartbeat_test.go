@@ -0,0 +1,97 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestHeartbeat(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithHeartbeat(50*time.Millisecond, 50*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	// Both peers are anonymous and on the same host, so only their sessions
+	// tell their capabilities apart
+	connect := func(capID string) net.Conn {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		handshake(t, conn)
+		response := send(t, conn, &protocol.Message{
+			Version:   protocol.V1,
+			Type:      protocol.Register,
+			Payload:   mustMarshal(t, &protocol.Capability{ID: capID, Type: "DISCOVER"}),
+			Timestamp: time.Now(),
+		})
+		if response.Type != protocol.Response {
+			t.Fatalf("Register failed: %v %s", response.Type, response.Payload)
+		}
+		return conn
+	}
+
+	alive := connect("alive-cap")
+	silent := connect("silent-cap")
+
+	// The live peer answers every Ping; the silent one reads them but never replies
+	silentErr := make(chan error, 1)
+	go func() {
+		silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			msg, err := ReadMessage(silent)
+			if err == nil && msg.Type != protocol.Ping {
+				err = fmt.Errorf("unexpected %v", msg.Type)
+			}
+			if err != nil {
+				silentErr <- err
+				return
+			}
+		}
+	}()
+
+	var pings int
+	for deadline := time.Now().Add(400 * time.Millisecond); time.Now().Before(deadline); {
+		alive.SetReadDeadline(time.Now().Add(time.Second))
+		msg, err := ReadMessage(alive)
+		if err != nil {
+			t.Fatalf("Live connection failed: %v", err)
+		}
+		if msg.Type != protocol.Ping {
+			t.Fatalf("Expected a Ping, got %v", msg.Type)
+		}
+		pings++
+		if err := WriteMessage(alive, &protocol.Message{Version: msg.Version, Type: protocol.Pong, Timestamp: time.Now()}); err != nil {
+			t.Fatalf("Failed to send Pong: %v", err)
+		}
+	}
+	if pings < 3 {
+		t.Errorf("Expected a Ping every 50ms on a quiet connection, got %d", pings)
+	}
+
+	// The silent peer was dropped along with its capability
+	if err := <-silentErr; !errors.Is(err, io.EOF) {
+		t.Errorf("Expected the silent connection to be closed, got %v", err)
+	}
+	caps := handler.ListCapabilities()
+	if len(caps) != 1 || caps[0].ID != "alive-cap" {
+		t.Errorf("Expected only alive-cap to remain registered, got %v", caps)
+	}
+
+	// Pongs are not answered, so the live session is still in step
+	if ids := query(t, alive, "DISCOVER"); len(ids) != 1 || ids[0] != "alive-cap" {
+		t.Errorf("Query on the live connection = %v, want [alive-cap]", ids)
+	}
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+// WithMaxIdleTimeout runs a janitor that closes stream connections which
+// have not sent a message for d. Unlike WithMaxIdleTime it does not rely on
+// read deadlines, so it also catches sessions on every transport. A d longer
+// than the WithMaxIdleTime period replaces that period as the read deadline.
+func WithMaxIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.MaxIdleTimeout = d
+	}
+}
+
+// WithIdleCheckInterval sets how often the idle janitor walks the open
+// connections
+func WithIdleCheckInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.IdleCheckInterval = d
+	}
+}
+
+// touch records that conn was just heard from
+func (s *Server) touch(conn net.Conn) {
+	s.lastSeen.Store(conn, time.Now())
+}
+
+// reapIdle closes connections idle for longer than MaxIdleTimeout every
+// IdleCheckInterval until the server stops. A zero interval checks twice
+// per MaxIdleTimeout.
+func (s *Server) reapIdle() {
+	interval := s.IdleCheckInterval
+	if interval <= 0 {
+		interval = s.MaxIdleTimeout / 2
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.closeIdle(now)
+		}
+	}
+}
+
+// closeIdle closes every open connection last seen before now minus
+// MaxIdleTimeout. Closing unblocks its session, which then cleans up.
+func (s *Server) closeIdle(now time.Time) {
+	s.open.Range(func(key, _ any) bool {
+		seen, ok := s.lastSeen.Load(key)
+		if !ok {
+			return true
+		}
+
+		idle := now.Sub(seen.(time.Time))
+		if idle > s.MaxIdleTimeout {
+			conn := key.(net.Conn)
+			s.logger.Info("Closing idle connection", "peer", conn.RemoteAddr(), "idle", idle)
+			conn.Close()
+		}
+		return true
+	})
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestIdleJanitor(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(),
+		WithMaxIdleTimeout(150*time.Millisecond), WithIdleCheckInterval(20*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	busy, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer busy.Close()
+	handshake(t, busy)
+
+	quiet, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer quiet.Close()
+	handshake(t, quiet)
+
+	// Traffic keeps a session open well past the timeout
+	for i := 0; i < 6; i++ {
+		time.Sleep(50 * time.Millisecond)
+		query(t, busy, "DISCOVER")
+	}
+
+	quiet.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ReadMessage(quiet); !isClosedError(err) {
+		t.Errorf("Expected the quiet connection to be closed, got %v", err)
+	}
+
+	// The closed session stops being tracked once it has cleaned up
+	var open int
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		open = 0
+		server.lastSeen.Range(func(_, _ any) bool {
+			open++
+			return true
+		})
+		if open == 1 {
+			break
+		}
+	}
+	if open != 1 {
+		t.Errorf("Expected only the busy connection to be tracked, got %d", open)
+	}
+}
+
+func TestIdleLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want time.Duration
+	}{
+		{"default", nil, defaultMaxIdleTime},
+		{"max idle time", []Option{WithMaxIdleTime(time.Minute)}, time.Minute},
+		{"longer janitor", []Option{WithMaxIdleTimeout(5 * time.Minute)}, 5 * time.Minute},
+		{"shorter janitor", []Option{WithMaxIdleTimeout(time.Second)}, defaultMaxIdleTime},
+		{"heartbeat", []Option{WithHeartbeat(time.Minute, 10*time.Second)}, 70 * time.Second},
+		{"heartbeat without timeout", []Option{WithHeartbeat(time.Minute, 0)}, 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), tt.opts...)
+			if got := server.idleLimit(); got != tt.want {
+				t.Errorf("idleLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutExtendsReadDeadline(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(),
+		WithMaxIdleTime(50*time.Millisecond), WithMaxIdleTimeout(time.Second))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	// Quiet for longer than WithMaxIdleTime but not MaxIdleTimeout
+	time.Sleep(200 * time.Millisecond)
+	query(t, conn, "DISCOVER")
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ipMode selects the address families the server listens on
+type ipMode int
+
+const (
+	ipAny       ipMode = iota // whatever the addresses resolve to
+	ipV6Only                  // IPv6 sockets that refuse IPv4-mapped traffic
+	ipDualStack               // one IPv4 and one IPv6 socket per transport
+)
+
+// WithIPv6Only listens on IPv6 sockets only, so IPv4 peers cannot connect
+// even when the address is a wildcard such as ":7777"
+func WithIPv6Only() Option {
+	return func(s *Server) {
+		s.ipMode = ipV6Only
+	}
+}
+
+// WithDualStack listens on separate IPv4 and IPv6 sockets for TCP and UDP
+// rather than relying on the host to map IPv4 onto an IPv6 socket. The
+// address host must be empty, a wildcard, or loopback ("localhost",
+// "127.0.0.1" or "::1"); both sockets share its port.
+func WithDualStack() Option {
+	return func(s *Server) {
+		s.ipMode = ipDualStack
+	}
+}
+
+// listenTCP opens the TCP listeners for the configured address family
+func (s *Server) listenTCP() ([]net.Listener, error) {
+	switch s.ipMode {
+	case ipV6Only:
+		return s.listenTCPGroup("tcp6", s.tcpAddr)
+
+	case ipDualStack:
+		v4, v6, err := splitDualStack(s.tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+		l4, err := s.listenTCPGroup("tcp4", v4)
+		if err != nil {
+			return nil, err
+		}
+		l6, err := s.listenTCPGroup("tcp6", withBoundPort(v6, l4[0].Addr()))
+		if err != nil {
+			for _, l := range l4 {
+				l.Close()
+			}
+			return nil, err
+		}
+		return append(l4, l6...), nil
+
+	default:
+		return s.listenTCPGroup("tcp", s.tcpAddr)
+	}
+}
+
+// listenUDP opens the UDP sockets for the configured address family
+func (s *Server) listenUDP() ([]*net.UDPConn, error) {
+	switch s.ipMode {
+	case ipV6Only:
+		conn, err := listenUDP("udp6", s.udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+
+	case ipDualStack:
+		v4, v6, err := splitDualStack(s.udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		conn4, err := listenUDP("udp4", v4)
+		if err != nil {
+			return nil, err
+		}
+		conn6, err := listenUDP("udp6", withBoundPort(v6, conn4.LocalAddr()))
+		if err != nil {
+			conn4.Close()
+			return nil, err
+		}
+		return []*net.UDPConn{conn4, conn6}, nil
+
+	default:
+		conn, err := listenUDP("udp", s.udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+}
+
+func listenUDP(network, addr string) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve UDP address: %w", err)
+	}
+	return net.ListenUDP(network, udpAddr)
+}
+
+// splitDualStack returns the IPv4 and IPv6 addresses to bind for addr
+func splitDualStack(addr string) (v4, v6 string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch ip := net.ParseIP(host); {
+	case host == "" || ip != nil && ip.IsUnspecified():
+		return net.JoinHostPort("0.0.0.0", port), net.JoinHostPort("::", port), nil
+	case host == "localhost" || ip != nil && ip.IsLoopback():
+		return net.JoinHostPort("127.0.0.1", port), net.JoinHostPort("::1", port), nil
+	default:
+		return "", "", fmt.Errorf("dual-stack needs a wildcard or loopback host, got %q", host)
+	}
+}
+
+// withBoundPort replaces a zero port in addr with the port bound, so both
+// families of a dual-stack pair share the port picked for the first
+func withBoundPort(addr string, bound net.Addr) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != "0" {
+		return addr
+	}
+
+	switch a := bound.(type) {
+	case *net.TCPAddr:
+		port = strconv.Itoa(a.Port)
+	case *net.UDPAddr:
+		port = strconv.Itoa(a.Port)
+	}
+	return net.JoinHostPort(host, port)
+}
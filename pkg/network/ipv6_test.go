@@ -0,0 +1,120 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// roundTrip sends a Hello over TCP and a Query over UDP to the given addresses
+func roundTrip(t *testing.T, tcpAddr, udpAddr string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", tcpAddr, err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	if err := WriteMessage(conn, &protocol.Message{Version: protocol.V1, Type: protocol.Hello, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to send Hello: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if response, err := ReadMessage(conn); err != nil || response.Type != protocol.Hello {
+		t.Fatalf("Expected Hello over %s, got %v, %v", tcpAddr, response, err)
+	}
+
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", udpAddr, err)
+	}
+	defer udp.Close()
+
+	data, err := (&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+		Timestamp: time.Now(),
+	}).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if _, err := udp.Write(data); err != nil {
+		t.Fatalf("Failed to send UDP query: %v", err)
+	}
+
+	buffer := make([]byte, 65535)
+	udp.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := udp.Read(buffer)
+	if err != nil {
+		t.Fatalf("Failed to read UDP response from %s: %v", udpAddr, err)
+	}
+	if response, err := protocol.Deserialize(buffer[:n]); err != nil || response.Type != protocol.Response {
+		t.Fatalf("Expected Response over %s, got %v, %v", udpAddr, response, err)
+	}
+}
+
+func TestIPv6Only(t *testing.T) {
+	server := NewServer("[::1]:0", "[::1]:0", protocol.NewHandler(), WithIPv6Only())
+	if err := server.Start(); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer server.Stop()
+
+	roundTrip(t, server.TCPAddr().String(), server.UDPAddr().String())
+}
+
+func TestDualStack(t *testing.T) {
+	server := NewServer("localhost:0", "localhost:0", protocol.NewHandler(), WithDualStack())
+	if err := server.Start(); err != nil {
+		t.Skipf("Dual-stack loopback unavailable: %v", err)
+	}
+	defer server.Stop()
+
+	tcpAddrs, udpAddrs := server.TCPAddrs(), server.UDPAddrs()
+	if len(tcpAddrs) != 2 || len(udpAddrs) != 2 {
+		t.Fatalf("Expected two listeners per transport, got %v and %v", tcpAddrs, udpAddrs)
+	}
+
+	for i, family := range []string{"IPv4", "IPv6"} {
+		tcp, udp := tcpAddrs[i].(*net.TCPAddr), udpAddrs[i].(*net.UDPAddr)
+		if isV4 := tcp.IP.To4() != nil; isV4 != (family == "IPv4") {
+			t.Errorf("Expected %s TCP listener, got %s", family, tcp)
+		}
+		if tcp.Port != tcpAddrs[0].(*net.TCPAddr).Port || udp.Port != udpAddrs[0].(*net.UDPAddr).Port {
+			t.Errorf("Expected both families to share a port, got %v and %v", tcpAddrs, udpAddrs)
+		}
+
+		t.Run(family, func(t *testing.T) {
+			roundTrip(t, tcp.String(), udp.String())
+		})
+	}
+}
+
+func TestSplitDualStack(t *testing.T) {
+	tests := []struct {
+		addr    string
+		v4, v6  string
+		wantErr bool
+	}{
+		{":7777", "0.0.0.0:7777", "[::]:7777", false},
+		{"[::]:7777", "0.0.0.0:7777", "[::]:7777", false},
+		{"localhost:0", "127.0.0.1:0", "[::1]:0", false},
+		{"[::1]:7777", "127.0.0.1:7777", "[::1]:7777", false},
+		{"192.0.2.1:7777", "", "", true},
+	}
+
+	for _, tt := range tests {
+		v4, v6, err := splitDualStack(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitDualStack(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if v4 != tt.v4 || v6 != tt.v6 {
+			t.Errorf("splitDualStack(%q) = %q, %q, want %q, %q", tt.addr, v4, v6, tt.v4, tt.v6)
+		}
+	}
+}
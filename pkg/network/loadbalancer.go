@@ -0,0 +1,274 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// DefaultBackendBackoff is how long a failed server is skipped, growing
+// with each consecutive failure
+var DefaultBackendBackoff = RetryPolicy{
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Minute,
+	Multiplier: 2,
+}
+
+// LoadBalancer spreads requests across replicas of an ARN server. Servers
+// that fail are skipped until their backoff elapses.
+type LoadBalancer struct {
+	// Backoff sets how long a failed server is skipped after each
+	// consecutive failure. MaxAttempts is ignored.
+	Backoff RetryPolicy
+
+	mu       sync.Mutex
+	backends []*backend
+	next     int
+	now      func() time.Time
+}
+
+// backend is one server and its session
+type backend struct {
+	addr string
+
+	// Guarded by LoadBalancer.mu
+	failures int
+	retryAt  time.Time
+
+	// One exchange at a time on the session
+	connMu sync.Mutex
+	conn   net.Conn
+}
+
+// NewRoundRobinLB creates a LoadBalancer that sends each request to the next
+// healthy address in turn. Sessions are opened on first use.
+func NewRoundRobinLB(addrs []string) *LoadBalancer {
+	lb := &LoadBalancer{
+		Backoff: DefaultBackendBackoff,
+		now:     time.Now,
+	}
+	for _, addr := range addrs {
+		lb.backends = append(lb.backends, &backend{addr: addr})
+	}
+	return lb
+}
+
+// Send delivers msg to the next healthy server and returns its response. If
+// the server cannot be reached, or the connection fails mid-exchange, it is
+// marked unhealthy and the next one is tried, so msg may reach more than one
+// server. Error responses from a server are returned as they are.
+func (lb *LoadBalancer) Send(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	var lastErr error
+	for range lb.backends {
+		b := lb.pick()
+		if b == nil {
+			break
+		}
+
+		response, err := b.exchange(ctx, msg)
+		lb.record(b, err)
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("all ARN servers failed: %w", lastErr)
+	}
+	return nil, fmt.Errorf("no healthy ARN servers among %d", len(lb.backends))
+}
+
+// Healthy returns the addresses not currently backing off, in the order given
+func (lb *LoadBalancer) Healthy() []string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.now()
+	var healthy []string
+	for _, b := range lb.backends {
+		if !now.Before(b.retryAt) {
+			healthy = append(healthy, b.addr)
+		}
+	}
+	return healthy
+}
+
+// Close ends every open session
+func (lb *LoadBalancer) Close() error {
+	for _, b := range lb.backends {
+		b.connMu.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+			b.conn = nil
+		}
+		b.connMu.Unlock()
+	}
+	return nil
+}
+
+// pick returns the next backend in turn that is not backing off, or nil
+func (lb *LoadBalancer) pick() *backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.now()
+	for range lb.backends {
+		b := lb.backends[lb.next]
+		lb.next = (lb.next + 1) % len(lb.backends)
+		if !now.Before(b.retryAt) {
+			return b
+		}
+	}
+	return nil
+}
+
+// record resets b after a success, or backs it off after a failure
+func (lb *LoadBalancer) record(b *backend, err error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.retryAt = time.Time{}
+		return
+	}
+	b.failures++
+	b.retryAt = lb.now().Add(lb.Backoff.backoff(b.failures))
+}
+
+// exchange sends msg on the backend's session, opening one if needed. The
+// session is dropped on any error.
+func (b *backend) exchange(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.conn != nil {
+		response, err := exchange(ctx, b.conn, msg)
+		if err == nil {
+			return response, nil
+		}
+		b.conn.Close()
+		b.conn = nil
+		// The server may have dropped the session while it sat idle, so only
+		// a failure on a fresh one counts against the backend
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := dialSession(ctx, b.addr)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+
+	response, err := exchange(ctx, b.conn, msg)
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	return response, nil
+}
+
+// dialSession connects to addr and completes the opening handshake
+func dialSession(ctx context.Context, addr string) (net.Conn, error) {
+	payload, err := json.Marshal(protocol.HandshakePayload{
+		MinVersion: protocol.MinSupportedVersion,
+		MaxVersion: protocol.MaxSupportedVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal handshake: %w", err)
+	}
+
+	conn, response, err := dialUpstream(ctx, addr, nil, &protocol.Message{
+		Version:   protocol.MinSupportedVersion,
+		Type:      protocol.Handshake,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("handshake with %s rejected: %s", addr, response.Payload)
+	}
+	return conn, nil
+}
+
+// dialUpstream connects to addr, over TLS when cfg is set, sends handshake
+// and returns the server's answer. If the server rejects the handshake the
+// connection is closed and only the Error response is returned.
+func dialUpstream(ctx context.Context, addr string, cfg *tls.Config, handshake *protocol.Message) (net.Conn, *protocol.Message, error) {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if cfg != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := exchange(ctx, conn, handshake)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("handshake with %s failed: %w", addr, err)
+	}
+	if response.Type == protocol.Error {
+		conn.Close()
+		return nil, response, nil
+	}
+	return conn, response, nil
+}
+
+// exchange writes msg and reads its response, skipping any broadcasts the
+// server pushes in between and answering its heartbeat Pings
+func exchange(ctx context.Context, conn net.Conn, msg *protocol.Message) (*protocol.Message, error) {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// Unblock I/O if the context is cancelled mid-exchange
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	for {
+		response, err := ReadMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch response.Type {
+		case protocol.AICapabilityAdvertise, protocol.MCPBridgeDown:
+			continue
+		case protocol.Ping:
+			pong := &protocol.Message{
+				Version:       response.Version,
+				Type:          protocol.Pong,
+				CorrelationID: response.CorrelationID,
+				Timestamp:     time.Now(),
+			}
+			if err := WriteMessage(conn, pong); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return response, nil
+	}
+}
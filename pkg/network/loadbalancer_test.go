@@ -0,0 +1,219 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// countingServer starts a server that counts the Hello messages it handles
+func countingServer(t *testing.T) (*Server, *atomic.Int32) {
+	t.Helper()
+
+	var hellos atomic.Int32
+	handler := protocol.NewHandler()
+	handler.Events().Subscribe(events.MessageReceived, func(event interface{}) {
+		if event.(*protocol.Message).Type == protocol.Hello {
+			hellos.Add(1)
+		}
+	})
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server, &hellos
+}
+
+func hello() *protocol.Message {
+	return &protocol.Message{Version: protocol.V1, Type: protocol.Hello, Timestamp: time.Now()}
+}
+
+func TestLoadBalancerRoundRobin(t *testing.T) {
+	first, firstCount := countingServer(t)
+	second, secondCount := countingServer(t)
+
+	lb := NewRoundRobinLB([]string{first.TCPAddr().String(), second.TCPAddr().String()})
+	defer lb.Close()
+
+	for i := 0; i < 4; i++ {
+		response, err := lb.Send(context.Background(), hello())
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if response.Type != protocol.Hello {
+			t.Errorf("Expected Hello response, got %v", response.Type)
+		}
+	}
+
+	if firstCount.Load() != 2 || secondCount.Load() != 2 {
+		t.Errorf("Expected 2 requests per server, got %d and %d", firstCount.Load(), secondCount.Load())
+	}
+	if healthy := lb.Healthy(); len(healthy) != 2 {
+		t.Errorf("Healthy() = %v, want both servers", healthy)
+	}
+}
+
+func TestLoadBalancerSkipsFailedServers(t *testing.T) {
+	server, count := countingServer(t)
+
+	// Nothing listens here once the listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve address: %v", err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	lb := NewRoundRobinLB([]string{dead, server.TCPAddr().String()})
+	defer lb.Close()
+
+	now := time.Now()
+	lb.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := lb.Send(context.Background(), hello()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if count.Load() != 3 {
+		t.Errorf("Expected every request on the live server, got %d", count.Load())
+	}
+
+	healthy := lb.Healthy()
+	if len(healthy) != 1 || healthy[0] != server.TCPAddr().String() {
+		t.Errorf("Healthy() = %v, want only %s", healthy, server.TCPAddr())
+	}
+
+	// The dead server is retried once its backoff elapses, and backs off longer
+	now = now.Add(DefaultBackendBackoff.BaseDelay)
+	if len(lb.Healthy()) != 2 {
+		t.Errorf("Expected the dead server back in rotation after its backoff")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lb.Send(context.Background(), hello()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if lb.backends[0].failures != 2 {
+		t.Errorf("Expected 2 consecutive failures, got %d", lb.backends[0].failures)
+	}
+	now = now.Add(DefaultBackendBackoff.BaseDelay)
+	if len(lb.Healthy()) != 1 {
+		t.Errorf("Expected the second failure to back off for longer")
+	}
+}
+
+func TestLoadBalancerAllFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve address: %v", err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	lb := NewRoundRobinLB([]string{dead})
+	defer lb.Close()
+
+	if _, err := lb.Send(context.Background(), hello()); err == nil {
+		t.Fatal("Expected Send to fail with no servers reachable")
+	}
+	if _, err := lb.Send(context.Background(), hello()); err == nil {
+		t.Fatal("Expected Send to fail while the only server backs off")
+	}
+	if healthy := lb.Healthy(); len(healthy) != 0 {
+		t.Errorf("Healthy() = %v, want none", healthy)
+	}
+}
+
+func TestLoadBalancerReconnects(t *testing.T) {
+	server, count := countingServer(t)
+
+	lb := NewRoundRobinLB([]string{server.TCPAddr().String()})
+	defer lb.Close()
+
+	if _, err := lb.Send(context.Background(), hello()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	// Sever the session behind the balancer's back; the next Send redials
+	lb.backends[0].conn.Close()
+	if _, err := lb.Send(context.Background(), hello()); err != nil {
+		t.Fatalf("Send() on a severed session error = %v", err)
+	}
+	if count.Load() != 2 {
+		t.Errorf("Expected 2 requests, got %d", count.Load())
+	}
+	if healthy := lb.Healthy(); len(healthy) != 1 {
+		t.Errorf("Healthy() = %v, want the server", healthy)
+	}
+}
+
+func TestLoadBalancerIdleSession(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithMaxIdleTime(100*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	lb := NewRoundRobinLB([]string{server.TCPAddr().String()})
+	defer lb.Close()
+
+	if _, err := lb.Send(context.Background(), hello()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	// Let the server close the idle session
+	time.Sleep(300 * time.Millisecond)
+
+	response, err := lb.Send(context.Background(), hello())
+	if err != nil {
+		t.Fatalf("Send() after idle timeout error = %v", err)
+	}
+	if response.Type != protocol.Hello {
+		t.Errorf("Expected Hello response, got %v", response.Type)
+	}
+	if healthy := lb.Healthy(); len(healthy) != 1 {
+		t.Errorf("Healthy() = %v, want the server", healthy)
+	}
+}
+
+func TestLoadBalancerHeartbeat(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithHeartbeat(50*time.Millisecond, 2*time.Second))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	lb := NewRoundRobinLB([]string{server.TCPAddr().String()})
+	defer lb.Close()
+
+	if _, err := lb.Send(context.Background(), hello()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	// Let Pings queue up on the idle session
+	time.Sleep(300 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		response, err := lb.Send(context.Background(), &protocol.Message{
+			Version:   protocol.V1,
+			Type:      protocol.Query,
+			Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if response.Type != protocol.Response {
+			t.Errorf("Expected Response to query, got %v", response.Type)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Replacement for the values of redacted keys
+const redacted = "[REDACTED]"
+
+// LoggingTransport logs every message the server hands to its handler: the
+// peer, the message type, the payload and its size, the response and how
+// long the handler took. JSON payloads are logged with the values of
+// RedactKeys replaced, at any depth, so credentials in capability or bridge
+// metadata stay out of the logs. Other payloads are logged by size only.
+type LoggingTransport struct {
+	// Logger receives the records. If nil, WithLoggingTransport uses the
+	// server's logger and Middleware the default logger.
+	Logger *slog.Logger
+
+	// Level of successful messages; failures are logged at error level
+	Level slog.Level
+
+	// RedactKeys are JSON object keys, such as "api_key" or "token",
+	// matched regardless of case
+	RedactKeys []string
+}
+
+// WithLoggingTransport logs every message through t. Its middleware is
+// added to the handler's Router when the server is created, so it wraps
+// only the middleware added after that.
+func WithLoggingTransport(t *LoggingTransport) Option {
+	return func(s *Server) {
+		s.logging = t
+	}
+}
+
+// Middleware returns the middleware that does the logging
+func (t *LoggingTransport) Middleware() protocol.Middleware {
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	redact := make(map[string]bool, len(t.RedactKeys))
+	for _, key := range t.RedactKeys {
+		redact[strings.ToLower(key)] = true
+	}
+
+	return func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		start := time.Now()
+		response, err := next(ctx, msg)
+
+		// Skip redacting payloads for records the logger would drop.
+		// Failures are always logged.
+		if err == nil && !logger.Enabled(ctx, t.Level) {
+			return response, err
+		}
+
+		attrs := []any{"type", msg.Type, "payload_size", len(msg.Payload), "latency", time.Since(start)}
+		if addr, ok := protocol.PeerAddrFromContext(ctx); ok {
+			attrs = append(attrs, "peer", addr.String())
+		}
+		if id, ok := protocol.PeerIDFromContext(ctx); ok {
+			attrs = append(attrs, "peer_id", id)
+		}
+		if payload, ok := redactPayload(msg.Payload, redact); ok {
+			attrs = append(attrs, "payload", payload)
+		}
+		if response != nil {
+			attrs = append(attrs, "response", response.Type, "response_size", len(response.Payload))
+			if payload, ok := redactPayload(response.Payload, redact); ok {
+				attrs = append(attrs, "response_payload", payload)
+			}
+		}
+
+		if err != nil {
+			logger.Error("Failed to handle message", append(attrs, "error", err)...)
+		} else {
+			logger.Log(ctx, t.Level, "Handled message", attrs...)
+		}
+		return response, err
+	}
+}
+
+// redactPayload returns payload with the values of the redact keys
+// replaced, or false if it is empty or not JSON
+func redactPayload(payload []byte, redact map[string]bool) (string, bool) {
+	if len(payload) == 0 {
+		return "", false
+	}
+	var v any
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return "", false
+	}
+	data, err := json.Marshal(redactValue(v, redact))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// redactValue replaces the values of the redact keys in v and every object
+// nested in it
+func redactValue(v any, redact map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if redact[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value, redact)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value, redact)
+		}
+	}
+	return v
+}
@@ -0,0 +1,151 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestLoggingTransport(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithLogger(logger),
+		WithLoggingTransport(&LoggingTransport{RedactKeys: []string{"api_key", "Token"}}))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	defer server.Handler().Close()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	response := send(t, conn, &protocol.Message{
+		Version: protocol.V1,
+		Type:    protocol.AICapabilityAdvertise,
+		Payload: mustMarshal(t, &protocol.Capability{
+			ID:       "secret-cap",
+			Type:     "DISCOVER",
+			Version:  "1.0.0",
+			Metadata: map[string]string{"api_key": "sk-123", "token": "tok-456", "region": "eu-west"},
+		}),
+		Timestamp: time.Now(),
+	})
+	// The advertisement is broadcast to every session, this one included
+	for response.Type == protocol.AICapabilityAdvertise {
+		if response, err = ReadMessage(conn); err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+	}
+	if response.Type != protocol.Response {
+		t.Fatalf("Expected Response, got %v: %s", response.Type, response.Payload)
+	}
+	query(t, conn, "DISCOVER")
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if record["msg"] == "Handled message" {
+			records = append(records, record)
+		}
+	}
+
+	want := []string{"AICapabilityAdvertise", "Query"}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d traced messages, got %d: %s", len(want), len(records), buf.String())
+	}
+	for i, record := range records {
+		if record["type"] != want[i] || record["response"] != "Response" {
+			t.Errorf("Record %d type = %v, response = %v, want %s and Response", i, record["type"], record["response"], want[i])
+		}
+		if record["peer"] != conn.LocalAddr().String() {
+			t.Errorf("Record %d peer = %v, want %s", i, record["peer"], conn.LocalAddr())
+		}
+		for _, key := range []string{"payload_size", "latency", "response_size"} {
+			if _, ok := record[key]; !ok {
+				t.Errorf("Record %d has no %s", i, key)
+			}
+		}
+	}
+
+	// Neither the request nor the query response leaks the credentials
+	if out := buf.String(); strings.Contains(out, "sk-123") || strings.Contains(out, "tok-456") {
+		t.Errorf("Expected credentials to be redacted, got %s", out)
+	}
+	if !strings.Contains(fmt.Sprint(records[0]["payload"]), `"region":"eu-west"`) {
+		t.Errorf("Expected other metadata to be logged, got %v", records[0]["payload"])
+	}
+}
+
+func TestRedactPayload(t *testing.T) {
+	redact := map[string]bool{"api_key": true, "token": true}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		ok      bool
+	}{
+		{"top level", `{"token":"t","id":"a"}`, `{"id":"a","token":"[REDACTED]"}`, true},
+		{"metadata", `{"metadata":{"API_KEY":"k","region":"eu"}}`, `{"metadata":{"API_KEY":"[REDACTED]","region":"eu"}}`, true},
+		{"in array", `[{"token":{"nested":"t"}},"token"]`, `[{"token":"[REDACTED]"},"token"]`, true},
+		{"nothing to redact", `{"id":"a"}`, `{"id":"a"}`, true},
+		{"empty", ``, ``, false},
+		{"not JSON", "\x00\x01binary", ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := redactPayload([]byte(tt.payload), redact)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("redactPayload(%q) = %q, %v, want %q, %v", tt.payload, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoggingTransportLevel(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	middleware := (&LoggingTransport{Logger: logger, Level: slog.LevelInfo}).Middleware()
+
+	msg := &protocol.Message{Version: protocol.V1, Type: protocol.Query, Payload: []byte(`{"token":"t"}`), Timestamp: time.Now()}
+	handled := func(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+		return &protocol.Message{Version: protocol.V1, Type: protocol.Response, Timestamp: time.Now()}, nil
+	}
+	failed := func(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+		return nil, errors.New("boom")
+	}
+
+	// Successes below the logger's level are not recorded
+	if _, err := middleware(context.Background(), msg, handled); err != nil {
+		t.Fatalf("Middleware() error = %v", err)
+	}
+	if out := buf.String(); out != "" {
+		t.Errorf("Expected nothing logged below the logger's level, got %s", out)
+	}
+
+	// Failures always are
+	if _, err := middleware(context.Background(), msg, failed); err == nil {
+		t.Fatal("Expected the handler error returned")
+	}
+	if out := buf.String(); !strings.Contains(out, "Failed to handle message") || !strings.Contains(out, "boom") {
+		t.Errorf("Expected the failure logged, got %s", out)
+	}
+}
@@ -0,0 +1,90 @@
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultMulticastGroup shares the default UDP port, so a node on the
+// default ports also answers queries sent to the group
+const DefaultMulticastGroup = "224.0.0.251:7778"
+
+// WithMulticastGroup announces every registered capability to group as an
+// AICapabilityAdvertise datagram. An empty group selects DefaultMulticastGroup.
+func WithMulticastGroup(group string) Option {
+	return func(s *Server) {
+		if group == "" {
+			group = DefaultMulticastGroup
+		}
+		s.multicastGroup = group
+	}
+}
+
+// joinMulticastGroup resolves the configured group and, when it shares the
+// UDP listener's port, joins it so multicast queries reach the server
+func (s *Server) joinMulticastGroup() error {
+	group, err := net.ResolveUDPAddr("udp4", s.multicastGroup)
+	if err != nil {
+		return fmt.Errorf("failed to resolve multicast group: %w", err)
+	}
+	if !group.IP.IsMulticast() {
+		return fmt.Errorf("%s is not a multicast address", group.IP)
+	}
+	if s.ipMode == ipV6Only {
+		return fmt.Errorf("multicast announcements need an IPv4 UDP socket")
+	}
+	s.group = group
+
+	if group.Port != s.udpConn.LocalAddr().(*net.UDPAddr).Port {
+		return nil
+	}
+	if err := ipv4.NewPacketConn(s.udpConn).JoinGroup(nil, &net.UDPAddr{IP: group.IP}); err != nil {
+		s.logger.Warn("Failed to join multicast group, announcing only", "group", group, "error", err)
+	}
+	return nil
+}
+
+// Announce multicasts msg to the configured group
+func (s *Server) Announce(msg *protocol.Message) error {
+	if s.group == nil || s.udpConn == nil {
+		return fmt.Errorf("multicast not started")
+	}
+
+	if err := s.writeUDP(s.udpConn, msg, s.group); err != nil {
+		return fmt.Errorf("failed to send announcement: %w", err)
+	}
+	return nil
+}
+
+// isOwnAnnouncement reports whether a datagram from addr is one of our own
+// announcements looped back through the multicast group
+func (s *Server) isOwnAnnouncement(msg *protocol.Message, addr *net.UDPAddr) bool {
+	if msg.Type != protocol.AICapabilityAdvertise || s.group == nil {
+		return false
+	}
+	if addr.Port != s.udpConn.LocalAddr().(*net.UDPAddr).Port {
+		return false
+	}
+	return isLocalIP(addr.IP)
+}
+
+// isLocalIP reports whether ip belongs to this machine
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
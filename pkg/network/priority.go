@@ -0,0 +1,107 @@
+package network
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Number of messages a session buffers before it stops reading from the peer
+const maxQueuedMessages = 256
+
+// queuedMessage is a message waiting for the session worker
+type queuedMessage struct {
+	msg      *protocol.Message
+	priority protocol.Priority
+	seq      uint64 // arrival order, keeps equal priorities FIFO
+}
+
+// messageHeap orders queued messages by priority, then arrival
+type messageHeap []*queuedMessage
+
+func (h messageHeap) Len() int { return len(h) }
+
+func (h messageHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h messageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *messageHeap) Push(x any) { *h = append(*h, x.(*queuedMessage)) }
+
+func (h *messageHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// priorityQueue is a bounded, blocking queue that hands out the most urgent message first
+type priorityQueue struct {
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	items    messageHeap
+	capacity int
+	seq      uint64
+	closed   bool
+}
+
+func newPriorityQueue(capacity int) *priorityQueue {
+	q := &priorityQueue{capacity: capacity}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+	return q
+}
+
+// push queues msg, blocking while the queue is full. It reports false once the queue is closed.
+func (q *priorityQueue) push(msg *protocol.Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) >= q.capacity && !q.closed {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return false
+	}
+
+	q.seq++
+	heap.Push(&q.items, &queuedMessage{msg: msg, priority: msg.EffectivePriority(), seq: q.seq})
+	q.notEmpty.Signal()
+	return true
+}
+
+// pop removes the most urgent message, blocking while the queue is empty.
+// Messages still queued when the queue is closed are handed out before pop reports false.
+func (q *priorityQueue) pop() (*protocol.Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+
+	item := heap.Pop(&q.items).(*queuedMessage)
+	q.notFull.Signal()
+	return item.msg, true
+}
+
+// close wakes every waiter; pending messages can still be popped
+func (q *priorityQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
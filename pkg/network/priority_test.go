@@ -0,0 +1,114 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestPriorityQueueOrder(t *testing.T) {
+	q := newPriorityQueue(10)
+
+	push := func(msgType protocol.MessageType, priority protocol.Priority, payload string) {
+		q.push(&protocol.Message{Type: msgType, Priority: priority, Payload: []byte(payload)})
+	}
+
+	push(protocol.AIStreamData, protocol.PriorityNormal, "data-1")
+	push(protocol.AIStreamData, protocol.PriorityNormal, "data-2")
+	push(protocol.Query, protocol.PriorityHigh, "query")
+	push(protocol.MCPBridgeDown, protocol.PriorityNormal, "bridge-down")
+	push(protocol.AIStreamData, protocol.PriorityNormal, "data-3")
+	push(protocol.Error, protocol.PriorityNormal, "error")
+
+	want := []string{"bridge-down", "error", "query", "data-1", "data-2", "data-3"}
+	for _, w := range want {
+		msg, ok := q.pop()
+		if !ok {
+			t.Fatalf("pop() returned false, want %s", w)
+		}
+		if string(msg.Payload) != w {
+			t.Errorf("Expected %s, got %s", w, msg.Payload)
+		}
+	}
+}
+
+func TestPriorityQueueClose(t *testing.T) {
+	q := newPriorityQueue(1)
+	q.push(&protocol.Message{Type: protocol.Hello})
+
+	// A full queue blocks the producer until the queue closes
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.push(&protocol.Message{Type: protocol.Hello})
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Expected push to block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.close()
+	if <-pushed {
+		t.Error("Expected push to fail after close")
+	}
+
+	// Messages queued before close are still delivered
+	if _, ok := q.pop(); !ok {
+		t.Error("Expected pending message after close")
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("Expected empty closed queue to report false")
+	}
+}
+
+// backlog is the number of stream chunks queued ahead of the critical message
+const backlog = 200
+
+// Simulated cost of handling a single message
+const handleCost = 2 * time.Microsecond
+
+func simulateHandling() {
+	for start := time.Now(); time.Since(start) < handleCost; {
+	}
+}
+
+// BenchmarkCriticalLatencyFIFO measures how long an Error waits behind a
+// stream backlog when messages are processed in arrival order
+func BenchmarkCriticalLatencyFIFO(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		queue := make(chan *protocol.Message, backlog+1)
+		for j := 0; j < backlog; j++ {
+			queue <- &protocol.Message{Type: protocol.AIStreamData}
+		}
+		queue <- &protocol.Message{Type: protocol.Error}
+
+		for msg := range queue {
+			simulateHandling()
+			if msg.Type == protocol.Error {
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkCriticalLatencyPriority measures the same scenario with the
+// session priority queue
+func BenchmarkCriticalLatencyPriority(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		queue := newPriorityQueue(backlog + 1)
+		for j := 0; j < backlog; j++ {
+			queue.push(&protocol.Message{Type: protocol.AIStreamData})
+		}
+		queue.push(&protocol.Message{Type: protocol.Error})
+
+		for {
+			msg, _ := queue.pop()
+			simulateHandling()
+			if msg.Type == protocol.Error {
+				break
+			}
+		}
+	}
+}
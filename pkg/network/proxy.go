@@ -0,0 +1,439 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/routing"
+)
+
+// Default time the proxy waits for the upstream to answer one message
+const defaultUpstreamTimeout = 30 * time.Second
+
+// proxiedTypes are the messages ProxyServer relays upstream. All of them are
+// safe to resend, which lets the proxy retry them after reconnecting.
+var proxiedTypes = map[protocol.MessageType]bool{
+	protocol.Register:           true,
+	protocol.Query:              true,
+	protocol.MCPBridgeAdvertise: true,
+}
+
+// ProxyServer relays ARN sessions from one network to a Server on another.
+// Each peer gets its own upstream session, opened with the peer's own
+// handshake so the upstream sees the peer's ID and negotiates with it
+// directly. Register, Query and MCPBridgeAdvertise are forwarded and their
+// responses, CorrelationID included, passed back; other messages are
+// refused. Broadcasts pushed by the upstream are not relayed, and peers
+// cannot prove an identity through the proxy. With WithNodeRegistry the
+// proxy is also a relay node, forwarding messages along their Route.
+type ProxyServer struct {
+	addr         string
+	upstreamAddr string
+	upstreamTLS  *tls.Config
+	logger       *slog.Logger
+
+	// Relay node identity and where to find the other nodes, see WithNodeRegistry
+	nodeID   string
+	registry *routing.NodeRegistry
+
+	// Reconnect sets how often, and how patiently, a failed upstream session
+	// is reopened before the peer is told the upstream is unavailable
+	Reconnect RetryPolicy
+
+	// UpstreamTimeout bounds each exchange with the upstream
+	UpstreamTimeout time.Duration
+
+	// MaxHops bounds how many nodes a message may have passed through. The
+	// proxy counts itself as a hop and refuses messages past it, so proxies
+	// chained in a loop stop forwarding.
+	MaxHops uint8
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+	conns    sync.Map // net.Conn -> struct{}, open peer connections
+}
+
+// ProxyOption configures optional ProxyServer behaviour
+type ProxyOption func(*ProxyServer)
+
+// WithUpstreamTLS connects to the upstream server over TLS using cfg
+func WithUpstreamTLS(cfg *tls.Config) ProxyOption {
+	return func(p *ProxyServer) {
+		p.upstreamTLS = cfg
+	}
+}
+
+// WithProxyLogger sends proxy logs to logger instead of slog.Default()
+func WithProxyLogger(logger *slog.Logger) ProxyOption {
+	return func(p *ProxyServer) {
+		p.logger = logger
+	}
+}
+
+// WithNodeRegistry makes the proxy the relay node named nodeID. A message
+// whose Route starts with nodeID has it popped off and is forwarded to the
+// next node on the route, resolved through registry. Once the route is
+// used up the message goes to the proxy's own upstream.
+func WithNodeRegistry(nodeID string, registry *routing.NodeRegistry) ProxyOption {
+	return func(p *ProxyServer) {
+		p.nodeID = nodeID
+		p.registry = registry
+	}
+}
+
+// NewProxyServer creates a proxy that accepts peers on addr and relays them
+// to the server at upstreamAddr
+func NewProxyServer(addr, upstreamAddr string, opts ...ProxyOption) *ProxyServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &ProxyServer{
+		addr:            addr,
+		upstreamAddr:    upstreamAddr,
+		logger:          slog.Default(),
+		Reconnect:       DefaultRetryPolicy,
+		UpstreamTimeout: defaultUpstreamTimeout,
+		MaxHops:         protocol.DefaultMaxHops,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Start begins accepting peers. The upstream is dialed per peer, once its
+// handshake arrives.
+func (p *ProxyServer) Start() error {
+	listener, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return fmt.Errorf("failed to start proxy listener: %w", err)
+	}
+	p.listener = listener
+
+	p.wg.Add(1)
+	go p.accept()
+
+	p.logger.Info("ARN proxy listening", "addr", listener.Addr(), "upstream", p.upstreamAddr)
+	return nil
+}
+
+// Addr returns the address the proxy listens on
+func (p *ProxyServer) Addr() net.Addr {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Stop closes the listener and every relayed session
+func (p *ProxyServer) Stop() error {
+	p.cancel()
+
+	var err error
+	if p.listener != nil {
+		err = p.listener.Close()
+	}
+	p.conns.Range(func(conn, _ any) bool {
+		conn.(net.Conn).Close()
+		return true
+	})
+
+	p.wg.Wait()
+	return err
+}
+
+func (p *ProxyServer) accept() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return // Proxy is shutting down
+			}
+			p.logger.Error("Failed to accept connection", "error", err)
+			continue
+		}
+
+		p.wg.Add(1)
+		go p.serve(conn)
+	}
+}
+
+// serve relays one peer's session until either side hangs up
+func (p *ProxyServer) serve(conn net.Conn) {
+	defer p.wg.Done()
+	defer conn.Close()
+
+	p.conns.Store(conn, struct{}{})
+	defer p.conns.Delete(conn)
+	if p.ctx.Err() != nil {
+		return // Stop already closed every stored connection
+	}
+
+	log := p.logger.With("peer", conn.RemoteAddr())
+
+	// The peer's handshake opens its upstream session
+	offer, err := ReadMessage(conn)
+	if err != nil {
+		if !isClosedError(err) {
+			log.Error("Failed to read handshake", "error", err)
+		}
+		return
+	}
+	if offer.Type != protocol.Handshake {
+		if response, err := protocol.NewErrorMessage(protocol.ErrInvalidMessageType, "handshake required"); err == nil {
+			WriteMessage(conn, response)
+		}
+		return
+	}
+
+	if err := withoutIdentity(offer); err != nil {
+		log.Error("Invalid handshake", "error", err)
+		return
+	}
+	offer = offer.Forwarded()
+	if offer.HopCount > p.MaxHops {
+		log.Warn("Refused handshake past the hop limit", "hops", offer.HopCount)
+		if response, err := localError(offer, protocol.ErrInvalidPayload, "max hop count exceeded"); err == nil {
+			WriteMessage(conn, response)
+		}
+		return
+	}
+
+	upstream := &upstreamSession{proxy: p, addr: p.upstreamAddr, handshake: offer}
+	defer upstream.close()
+
+	// Sessions with the next nodes of routed messages, opened on first use
+	routes := make(map[string]*upstreamSession)
+	defer func() {
+		for _, session := range routes {
+			session.close()
+		}
+	}()
+
+	response, err := upstream.connect(p.ctx)
+	if err != nil {
+		log.Error("Failed to reach upstream", "upstream", p.upstreamAddr, "error", err)
+		response, err = protocol.NewErrorMessage(protocol.ErrCapabilityUnavailable, "upstream unavailable")
+		if err != nil {
+			return
+		}
+	}
+	if err := WriteMessage(conn, response); err != nil || response.Type == protocol.Error {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := protocol.DeserializeFrom(reader)
+		if err != nil {
+			if !isClosedError(err) && p.ctx.Err() == nil {
+				log.Error("Failed to read message", "error", err)
+			}
+			return
+		}
+
+		response, err := p.relay(upstream, routes, msg, log)
+		if err != nil {
+			log.Error("Failed to build response", "error", err)
+			return
+		}
+		if err := WriteMessage(conn, response); err != nil {
+			log.Error("Failed to write response", "error", err)
+			return
+		}
+	}
+}
+
+// relay forwards msg upstream, or to the next node on its route, if the
+// proxy carries its type and returns the response for the peer
+func (p *ProxyServer) relay(upstream *upstreamSession, routes map[string]*upstreamSession, msg *protocol.Message, log *slog.Logger) (*protocol.Message, error) {
+	if !proxiedTypes[msg.Type] {
+		return localError(msg, protocol.ErrInvalidMessageType, fmt.Sprintf("%v is not relayed by this proxy", msg.Type))
+	}
+	msg = msg.Forwarded()
+	if msg.HopCount > p.MaxHops {
+		return localError(msg, protocol.ErrInvalidPayload, "max hop count exceeded")
+	}
+
+	if len(msg.Route) > 0 {
+		next, err := p.route(msg, upstream, routes)
+		if err != nil {
+			log.Warn("Refused routed message", "route", msg.Route, "error", err)
+			code := protocol.ErrInvalidPayload
+			if errors.Is(err, routing.ErrUnknownNode) {
+				code = protocol.ErrCapabilityUnavailable
+			}
+			return localError(msg, code, err.Error())
+		}
+		upstream = next
+	}
+
+	response, err := upstream.forward(p.ctx, msg)
+	if err != nil {
+		log.Error("Failed to forward message", "type", msg.Type, "error", err)
+		return localError(msg, protocol.ErrCapabilityUnavailable, "upstream unavailable")
+	}
+	return response, nil
+}
+
+// route pops this node off the head of msg's route and returns the session
+// to the node named next, or upstream once the route is used up
+func (p *ProxyServer) route(msg *protocol.Message, upstream *upstreamSession, routes map[string]*upstreamSession) (*upstreamSession, error) {
+	if p.registry == nil || msg.Route[0] != p.nodeID {
+		return nil, errors.New("route does not pass through this node")
+	}
+
+	msg.Route = msg.Route[1:]
+	if len(msg.Route) == 0 {
+		return upstream, nil
+	}
+
+	addr, err := p.registry.Resolve(msg.Route[0])
+	if err != nil {
+		return nil, err
+	}
+	session, ok := routes[addr]
+	if !ok {
+		session = &upstreamSession{proxy: p, addr: addr, handshake: upstream.handshake}
+		routes[addr] = session
+	}
+	return session, nil
+}
+
+// withoutIdentity drops FeatureIdentity from a peer's handshake. The
+// upstream would challenge the peer, and the proxy reopens sessions without
+// it, so it cannot answer for the peer.
+func withoutIdentity(offer *protocol.Message) error {
+	var payload protocol.HandshakePayload
+	if err := offer.DecodePayload(&payload); err != nil {
+		return err
+	}
+
+	features := payload.Features[:0:0]
+	for _, f := range payload.Features {
+		if f != protocol.FeatureIdentity {
+			features = append(features, f)
+		}
+	}
+	if len(features) == len(payload.Features) {
+		return nil
+	}
+
+	payload.Features = features
+	offer.CodecID = 0
+	return offer.SetPayload(payload, protocol.EncodingJSON)
+}
+
+// localError builds an Error raised by the proxy itself, carrying the
+// correlation ID of msg so the peer can match it to its request
+func localError(msg *protocol.Message, code protocol.ErrorCode, text string) (*protocol.Message, error) {
+	response, err := protocol.NewErrorMessage(code, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.HasCorrelationID() {
+		response.CorrelationID = msg.CorrelationID
+		if response.Version < protocol.V2 {
+			response.Version = protocol.V2
+		}
+	}
+	return response, nil
+}
+
+// upstreamSession is a peer's session with the upstream server, or with the
+// next node on a route, reopened with the peer's handshake whenever it fails
+type upstreamSession struct {
+	proxy     *ProxyServer
+	addr      string
+	handshake *protocol.Message
+	conn      net.Conn
+}
+
+// connect opens the session, retrying as the proxy's Reconnect policy
+// allows, and returns the upstream's handshake response. If the upstream
+// rejects the handshake the Error response is returned and conn stays nil.
+func (u *upstreamSession) connect(ctx context.Context) (*protocol.Message, error) {
+	p := u.proxy
+	attempts := p.Reconnect.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(p.Reconnect.backoff(attempt - 1)):
+			}
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, p.UpstreamTimeout)
+		conn, response, err := dialUpstream(dialCtx, u.addr, p.upstreamTLS, u.handshake)
+		cancel()
+		if err == nil {
+			u.conn = conn
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to connect to %s after %d attempts: %w", u.addr, attempts, lastErr)
+}
+
+// forward sends msg upstream and returns the response. If the session has
+// failed it is reopened and msg sent again, so the peer never notices.
+func (u *upstreamSession) forward(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	if u.conn != nil {
+		response, err := u.exchange(ctx, msg)
+		if err == nil || ctx.Err() != nil {
+			return response, err
+		}
+		u.proxy.logger.Warn("Upstream session failed, reconnecting", "upstream", u.addr, "error", err)
+	}
+
+	if _, err := u.connect(ctx); err != nil {
+		return nil, err
+	}
+	if u.conn == nil {
+		return nil, errors.New("upstream rejected the handshake on reconnect")
+	}
+	return u.exchange(ctx, msg)
+}
+
+// exchange sends msg on the open session, dropping the session if it fails
+func (u *upstreamSession) exchange(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	exchangeCtx, cancel := context.WithTimeout(ctx, u.proxy.UpstreamTimeout)
+	defer cancel()
+
+	response, err := exchange(exchangeCtx, u.conn, msg)
+	if err != nil {
+		u.close()
+		return nil, err
+	}
+	return response, nil
+}
+
+func (u *upstreamSession) close() {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+}
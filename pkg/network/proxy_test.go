@@ -0,0 +1,398 @@
+package network
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/routing"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// startProxy starts a proxy in front of upstream and returns a session
+// through it that has completed the handshake
+func startProxy(t *testing.T, upstream string, opts ...ProxyOption) (*ProxyServer, net.Conn) {
+	t.Helper()
+
+	proxy := NewProxyServer("127.0.0.1:0", upstream, opts...)
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Stop() })
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if agreed := handshake(t, conn); agreed.MaxVersion != protocol.V2 {
+		t.Fatalf("Expected the upstream to negotiate V2 through the proxy, got %d", agreed.MaxVersion)
+	}
+	return proxy, conn
+}
+
+// send writes msg and reads the response
+func send(t *testing.T, conn net.Conn, msg *protocol.Message) *protocol.Message {
+	t.Helper()
+
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	response, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return response
+}
+
+// query asks for capabilities of capType over conn and returns their IDs
+func query(t *testing.T, conn net.Conn, capType string) []string {
+	t.Helper()
+
+	response := send(t, conn, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: capType}),
+		Timestamp: time.Now(),
+	})
+	if response.Type != protocol.Response {
+		t.Fatalf("Expected Response to query, got %v: %s", response.Type, response.Payload)
+	}
+
+	var caps []*protocol.Capability
+	if err := json.Unmarshal(response.Payload, &caps); err != nil {
+		t.Fatalf("Failed to unmarshal query response: %v", err)
+	}
+	ids := make([]string, 0, len(caps))
+	for _, cap := range caps {
+		ids = append(ids, cap.ID)
+	}
+	return ids
+}
+
+func TestProxyServer(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	_, conn := startProxy(t, server.TCPAddr().String())
+
+	register := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Register,
+		Payload:   mustMarshal(t, &protocol.Capability{ID: "proxied", Type: "DISCOVER"}),
+		Timestamp: time.Now(),
+	}
+	if response := send(t, conn, register); response.Type != protocol.Response {
+		t.Fatalf("Expected Response to register, got %v: %s", response.Type, response.Payload)
+	}
+	if handler.CapabilityCount() != 1 {
+		t.Errorf("Expected the upstream to hold 1 capability, got %d", handler.CapabilityCount())
+	}
+
+	bridge := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.MCPBridgeAdvertise,
+		Payload:   mustMarshal(t, &protocol.MCPBridge{ID: "proxied-bridge", Endpoint: "mcp://bridge/v1", Protocol: "MCP/1.0", Metadata: map[string]string{"auth_type": "none", "data_format": "json"}}),
+		Timestamp: time.Now(),
+	}
+	if response := send(t, conn, bridge); response.Type != protocol.Response {
+		t.Fatalf("Expected Response to advertise, got %v: %s", response.Type, response.Payload)
+	}
+	if bridges := handler.ListMCPBridges(); len(bridges) != 1 {
+		t.Errorf("Expected the upstream to hold 1 bridge, got %d", len(bridges))
+	}
+
+	// Correlated requests come back with their ID, from upstream or the proxy
+	correlated := func(msgType protocol.MessageType, payload []byte) *protocol.Message {
+		msg := &protocol.Message{Version: protocol.V2, Type: msgType, Payload: payload, Timestamp: time.Now()}
+		if err := msg.GenerateCorrelationID(); err != nil {
+			t.Fatalf("GenerateCorrelationID() error = %v", err)
+		}
+		response := send(t, conn, msg)
+		if response.CorrelationID != msg.CorrelationID {
+			t.Errorf("%v response correlation ID = %x, want %x", msgType, response.CorrelationID, msg.CorrelationID)
+		}
+		return response
+	}
+
+	if response := correlated(protocol.Query, mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"})); response.Type != protocol.Response {
+		t.Errorf("Expected Response to query, got %v", response.Type)
+	}
+
+	response := correlated(protocol.Hello, nil)
+	var errPayload protocol.ErrorPayload
+	if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+		t.Fatalf("Failed to unmarshal error: %v", err)
+	}
+	if response.Type != protocol.Error || errPayload.Code != protocol.ErrInvalidMessageType {
+		t.Errorf("Expected ErrInvalidMessageType for an unrelayed type, got %v %v", response.Type, errPayload.Code)
+	}
+}
+
+func TestProxyHopCount(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(protocol.WithMaxHops(2)))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	// A three node chain: each proxy counts one hop on the way upstream
+	third, _ := startProxy(t, server.TCPAddr().String())
+	second, viaTwo := startProxy(t, third.Addr().String())
+	_, viaThree := startProxy(t, second.Addr().String())
+
+	if ids := query(t, viaTwo, "DISCOVER"); len(ids) != 0 {
+		t.Errorf("Expected no capabilities, got %v", ids)
+	}
+
+	response := send(t, viaThree, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+		Timestamp: time.Now(),
+	})
+	var errPayload protocol.ErrorPayload
+	if response.Type != protocol.Error || json.Unmarshal(response.Payload, &errPayload) != nil ||
+		errPayload.Code != protocol.ErrInvalidPayload || errPayload.Message != "max hop count exceeded" {
+		t.Errorf("Expected max hop count exceeded past three hops, got %v: %s", response.Type, response.Payload)
+	}
+}
+
+func TestProxyRoute(t *testing.T) {
+	// Unrouted messages go to the decoy, routed ones to the destination
+	decoy := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler())
+	if err := decoy.Start(); err != nil {
+		t.Fatalf("Failed to start decoy server: %v", err)
+	}
+	defer decoy.Stop()
+
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "routed", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	destination := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := destination.Start(); err != nil {
+		t.Fatalf("Failed to start destination server: %v", err)
+	}
+	defer destination.Stop()
+
+	// Three nodes: relays a and b, then the destination server c
+	registry := routing.NewNodeRegistry()
+	relayB, _ := startProxy(t, decoy.TCPAddr().String(), WithNodeRegistry("b", registry))
+	_, conn := startProxy(t, decoy.TCPAddr().String(), WithNodeRegistry("a", registry))
+	registry.Register("b", relayB.Addr().String())
+	registry.Register("c", destination.TCPAddr().String())
+
+	routed := func(route ...string) *protocol.Message {
+		return &protocol.Message{
+			Version:   protocol.V2,
+			Type:      protocol.Query,
+			Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+			Timestamp: time.Now(),
+			Route:     route,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		msg     *protocol.Message
+		want    []string
+		wantErr protocol.ErrorCode
+	}{
+		{"unrouted", routed(), []string{}, 0},
+		{"through a and b to c", routed("a", "b", "c"), []string{"routed"}, 0},
+		{"a then its upstream", routed("a"), []string{}, 0},
+		{"a then b's upstream", routed("a", "b"), []string{}, 0},
+		{"not starting here", routed("b", "c"), nil, protocol.ErrInvalidPayload},
+		{"unknown next node", routed("a", "z"), nil, protocol.ErrCapabilityUnavailable},
+		{"unknown node past b", routed("a", "b", "z"), nil, protocol.ErrCapabilityUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := send(t, conn, tt.msg)
+
+			if tt.wantErr != 0 {
+				var errPayload protocol.ErrorPayload
+				if response.Type != protocol.Error || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != tt.wantErr {
+					t.Errorf("Expected error %v, got %v: %s", tt.wantErr, response.Type, response.Payload)
+				}
+				return
+			}
+
+			var caps []*protocol.Capability
+			if response.Type != protocol.Response || json.Unmarshal(response.Payload, &caps) != nil {
+				t.Fatalf("Expected Response to query, got %v: %s", response.Type, response.Payload)
+			}
+			ids := make([]string, 0, len(caps))
+			for _, cap := range caps {
+				ids = append(ids, cap.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Query returned %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyLoopDetected(t *testing.T) {
+	// Reserve an address for the first proxy so the last can point back at it
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve address: %v", err)
+	}
+	first := reserved.Addr().String()
+	reserved.Close()
+
+	start := func(addr, upstream string) *ProxyServer {
+		proxy := NewProxyServer(addr, upstream)
+		proxy.Reconnect = RetryPolicy{MaxAttempts: 1}
+		if err := proxy.Start(); err != nil {
+			t.Fatalf("Failed to start proxy: %v", err)
+		}
+		t.Cleanup(func() { proxy.Stop() })
+		return proxy
+	}
+
+	// first -> second -> third -> first
+	third := start("127.0.0.1:0", first)
+	second := start("127.0.0.1:0", third.Addr().String())
+	start(first, second.Addr().String())
+
+	conn, err := net.Dial("tcp", first)
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+
+	offer := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Handshake,
+		Payload:   mustMarshal(t, &protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2}),
+		Timestamp: time.Now(),
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	response := send(t, conn, offer)
+
+	var errPayload protocol.ErrorPayload
+	if response.Type != protocol.Error || json.Unmarshal(response.Payload, &errPayload) != nil ||
+		errPayload.Code != protocol.ErrInvalidPayload || errPayload.Message != "max hop count exceeded" {
+		t.Errorf("Expected the loop to be refused, got %v: %s", response.Type, response.Payload)
+	}
+}
+
+func TestProxyReconnects(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler())
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	addr := server.TCPAddr().String()
+
+	_, conn := startProxy(t, addr)
+	if ids := query(t, conn, "DISCOVER"); len(ids) != 0 {
+		t.Fatalf("Expected an empty registry, got %v", ids)
+	}
+
+	// Replace the upstream on the same address
+	server.Stop()
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "restarted", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	replacement := NewServer(addr, "127.0.0.1:0", handler)
+	if err := replacement.Start(); err != nil {
+		t.Fatalf("Failed to restart server: %v", err)
+	}
+	defer replacement.Stop()
+
+	if ids := query(t, conn, "DISCOVER"); len(ids) != 1 || ids[0] != "restarted" {
+		t.Errorf("Expected the query to reach the new upstream, got %v", ids)
+	}
+}
+
+func TestProxyUpstreamHeartbeat(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithHeartbeat(50*time.Millisecond, 2*time.Second))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	_, conn := startProxy(t, server.TCPAddr().String())
+
+	// Let Pings queue up on the idle upstream session
+	time.Sleep(300 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if ids := query(t, conn, "DISCOVER"); len(ids) != 0 {
+			t.Errorf("Expected an empty registry, got %v", ids)
+		}
+	}
+}
+
+func TestProxyUpstreamTLS(t *testing.T) {
+	cert, err := security.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "secured", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithTLS(&tls.Config{
+		Certificates: []tls.Certificate{*cert},
+	}))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	_, conn := startProxy(t, server.TCPAddr().String(), WithUpstreamTLS(&tls.Config{
+		InsecureSkipVerify: true,
+	}))
+	if ids := query(t, conn, "DISCOVER"); len(ids) != 1 || ids[0] != "secured" {
+		t.Errorf("Expected the TLS upstream to answer, got %v", ids)
+	}
+}
+
+func TestProxyUpstreamUnavailable(t *testing.T) {
+	// Reserve a port and release it so dials are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	deadAddr := listener.Addr().String()
+	listener.Close()
+
+	proxy := NewProxyServer("127.0.0.1:0", deadAddr)
+	proxy.Reconnect = RetryPolicy{MaxAttempts: 2, BaseDelay: 10 * time.Millisecond}
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+
+	response := send(t, conn, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Handshake,
+		Payload:   mustMarshal(t, &protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2}),
+		Timestamp: time.Now(),
+	})
+	if response.Type != protocol.Error {
+		t.Errorf("Expected Error when the upstream is down, got %v", response.Type)
+	}
+}
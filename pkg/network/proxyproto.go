@@ -0,0 +1,147 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Time a load balancer has to send the PROXY header once it connects
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature opens every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// errInvalidPROXYHeader is returned by reads on a connection that did not
+// open with a valid PROXY protocol v2 header
+var errInvalidPROXYHeader = errors.New("invalid PROXY protocol header")
+
+// WithPROXYProtocol expects every TCP connection to open with a PROXY
+// protocol v2 header, as sent by HAProxy or an AWS NLB, ahead of any TLS
+// or ARN handshake. The client address it carries becomes the connection's
+// RemoteAddr, so ACL checks and logs see the real peer rather than the load
+// balancer. Connections without a valid header are closed.
+func WithPROXYProtocol() Option {
+	return func(s *Server) {
+		s.proxyProtocol = true
+	}
+}
+
+// proxyListener hands out connections that read a PROXY header first
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn reads the PROXY header the first time it is read from or asked
+// for its addresses, so a slow load balancer never holds up Accept
+type proxyConn struct {
+	net.Conn
+
+	once     sync.Once
+	err      error
+	src, dst net.Addr // nil when the header carries no addresses
+}
+
+// init reads the header once. The server arms its own deadlines after it
+// first asks for the peer address, so clearing the deadline here is safe.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		c.src, c.dst, c.err = readPROXYHeader(c.Conn)
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address from the PROXY header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, from the PROXY header
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readPROXYHeader reads exactly one PROXY protocol v2 header from r and
+// returns the source and destination it names. LOCAL headers, sent by
+// load balancer health checks, and address families other than TCP over
+// IPv4 or IPv6 carry no addresses.
+func readPROXYHeader(r io.Reader) (src, dst net.Addr, err error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidPROXYHeader, err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, fmt.Errorf("%w: bad signature", errInvalidPROXYHeader)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("%w: version %d", errInvalidPROXYHeader, version)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidPROXYHeader, err)
+	}
+
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("%w: command %d", errInvalidPROXYHeader, command)
+	}
+
+	// Address family in the high nibble, transport in the low one
+	var size int
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: address block of %d bytes is too short", errInvalidPROXYHeader, len(body))
+	}
+	src = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[:size])),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[size : 2*size])),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return src, dst, nil
+}
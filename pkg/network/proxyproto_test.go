@@ -0,0 +1,149 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// proxyHeader builds a PROXY protocol v2 header
+func proxyHeader(command, family byte, addrs []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+// proxyAddrs encodes the address block for src and dst
+func proxyAddrs(src, dst *net.TCPAddr) []byte {
+	var block []byte
+	if ip := src.IP.To4(); ip != nil {
+		block = append(append(block, ip...), dst.IP.To4()...)
+	} else {
+		block = append(append(block, src.IP.To16()...), dst.IP.To16()...)
+	}
+	block = binary.BigEndian.AppendUint16(block, uint16(src.Port))
+	return binary.BigEndian.AppendUint16(block, uint16(dst.Port))
+}
+
+func TestReadPROXYHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 5555}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 7777}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 5555}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 7777}
+
+	tlv := []byte{0x04, 0x00, 0x01, 0xff} // a NOOP TLV after the addresses
+
+	tests := []struct {
+		name    string
+		header  []byte
+		wantSrc string
+		wantErr bool
+	}{
+		{"IPv4", proxyHeader(0x1, 0x11, proxyAddrs(src4, dst4)), "203.0.113.7:5555", false},
+		{"IPv6", proxyHeader(0x1, 0x21, proxyAddrs(src6, dst6)), "[2001:db8::7]:5555", false},
+		{"with TLVs", proxyHeader(0x1, 0x11, append(proxyAddrs(src4, dst4), tlv...)), "203.0.113.7:5555", false},
+		{"LOCAL", proxyHeader(0x0, 0x00, nil), "", false},
+		{"UNIX family", proxyHeader(0x1, 0x31, make([]byte, 216)), "", false},
+		{"bad signature", append([]byte("GET / HTTP/1.1\r\n"), 0, 0, 0, 0), "", true},
+		{"version 1", append(proxyHeader(0x1, 0x11, proxyAddrs(src4, dst4))[:12], 0x11, 0x11, 0, 0), "", true},
+		{"unknown command", proxyHeader(0x2, 0x11, proxyAddrs(src4, dst4)), "", true},
+		{"short addresses", proxyHeader(0x1, 0x21, proxyAddrs(src4, dst4)), "", true},
+		{"truncated", proxyHeader(0x1, 0x11, proxyAddrs(src4, dst4))[:20], "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The header must be consumed exactly, leaving what follows it
+			r := bytes.NewReader(append(tt.header, "next"...))
+			src, _, err := readPROXYHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPROXYHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, errInvalidPROXYHeader) {
+					t.Errorf("readPROXYHeader() error = %v, want %v", err, errInvalidPROXYHeader)
+				}
+				return
+			}
+
+			if tt.wantSrc == "" {
+				if src != nil {
+					t.Errorf("readPROXYHeader() source = %v, want none", src)
+				}
+			} else if src == nil || src.String() != tt.wantSrc {
+				t.Errorf("readPROXYHeader() source = %v, want %s", src, tt.wantSrc)
+			}
+			if r.Len() != len("next") {
+				t.Errorf("readPROXYHeader() left %d bytes, want 4", r.Len())
+			}
+		})
+	}
+}
+
+func TestPROXYProtocol(t *testing.T) {
+	handler := protocol.NewHandler()
+	peers := make(chan net.Addr, 1)
+	handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+		if addr, ok := protocol.PeerAddrFromContext(ctx); ok && msg.Type == protocol.Register {
+			peers <- addr
+		}
+		return next(ctx, msg)
+	})
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithPROXYProtocol())
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 5555}
+	if _, err := conn.Write(proxyHeader(0x1, 0x11, proxyAddrs(client, server.TCPAddr().(*net.TCPAddr)))); err != nil {
+		t.Fatalf("Failed to send PROXY header: %v", err)
+	}
+	handshake(t, conn)
+	response := send(t, conn, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Register,
+		Payload:   mustMarshal(t, &protocol.Capability{ID: "proxied", Type: "DISCOVER"}),
+		Timestamp: time.Now(),
+	})
+	if response.Type != protocol.Response {
+		t.Fatalf("Register failed: %v %s", response.Type, response.Payload)
+	}
+
+	// The message comes from the client behind the load balancer
+	if addr := <-peers; addr.String() != client.String() {
+		t.Errorf("Expected the message to come from %v, got %v", client, addr)
+	}
+
+	// Connections that skip the header are dropped
+	direct, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer direct.Close()
+	WriteMessage(direct, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Handshake,
+		Payload:   mustMarshal(t, &protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V1}),
+		Timestamp: time.Now(),
+	})
+	direct.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = ReadMessage(direct)
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Errorf("Expected a connection without a PROXY header to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QUICProtocol is the ALPN protocol QUIC peers negotiate when the TLS
+// config names none
+const QUICProtocol = "arn"
+
+// QUICServer serves the ARN wire format over QUIC, giving UDP peers
+// reliable, ordered delivery. Every stream a peer opens is a session with
+// the same framing as TCP, so one connection can carry several sessions
+// without head-of-line blocking between them.
+type QUICServer struct {
+	server     *Server
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	listener   *quic.Listener
+}
+
+// WithQUIC additionally serves QUIC on the UDP port matching the TCP
+// listener. QUIC always runs over TLS, so tlsConfig must hold a
+// certificate; quicConfig may be nil for the quic-go defaults.
+func WithQUIC(tlsConfig *tls.Config, quicConfig *quic.Config) Option {
+	return func(s *Server) {
+		s.quic = &QUICServer{server: s, tlsConfig: tlsConfig, quicConfig: quicConfig}
+	}
+}
+
+// quicStream is one QUIC stream standing in for a stream connection
+type quicStream struct {
+	*quic.Stream
+	local, remote net.Addr
+}
+
+func (s *quicStream) LocalAddr() net.Addr {
+	return s.local
+}
+
+func (s *quicStream) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+// Close ends both directions; closing a QUIC stream alone only ends ours
+func (s *quicStream) Close() error {
+	s.Stream.CancelRead(0)
+	return s.Stream.Close()
+}
+
+// Start begins accepting QUIC connections
+func (q *QUICServer) Start() error {
+	tlsConfig := q.tlsConfig.Clone()
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
+		return errors.New("QUIC requires a TLS certificate")
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{QUICProtocol}
+	}
+
+	listener, err := quic.ListenAddr(q.server.TCPAddr().String(), tlsConfig, q.quicConfig)
+	if err != nil {
+		return fmt.Errorf("failed to start QUIC listener: %w", err)
+	}
+	q.listener = listener
+
+	q.server.wg.Add(1)
+	go q.accept()
+	return nil
+}
+
+// Stop closes the listener. Established sessions end when the server
+// context is cancelled.
+func (q *QUICServer) Stop() error {
+	if q.listener == nil {
+		return nil
+	}
+	return q.listener.Close()
+}
+
+// Addr returns the address the QUIC listener is bound to
+func (q *QUICServer) Addr() net.Addr {
+	if q.listener == nil {
+		return nil
+	}
+	return q.listener.Addr()
+}
+
+// accept takes QUIC connections until the listener is closed
+func (q *QUICServer) accept() {
+	defer q.server.wg.Done()
+
+	for {
+		conn, err := q.listener.Accept(q.server.ctx)
+		if err != nil {
+			if q.server.ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				return // Server is shutting down
+			}
+			q.server.logger.Error("Failed to accept QUIC connection", "error", err)
+			continue
+		}
+
+		q.server.wg.Add(1)
+		go q.serveStreams(conn)
+	}
+}
+
+// serveStreams runs a session on each stream the peer opens
+func (q *QUICServer) serveStreams(conn *quic.Conn) {
+	defer q.server.wg.Done()
+	defer conn.CloseWithError(0, "")
+
+	for {
+		stream, err := conn.AcceptStream(q.server.ctx)
+		if err != nil {
+			return // Peer went away or the server is shutting down
+		}
+
+		q.server.wg.Add(1)
+		go q.server.serveConn(&quicStream{Stream: stream, local: conn.LocalAddr(), remote: conn.RemoteAddr()}, "quic")
+	}
+}
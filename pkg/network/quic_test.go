@@ -0,0 +1,65 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+	"github.com/quic-go/quic-go"
+)
+
+func TestQUICServer(t *testing.T) {
+	cert, err := security.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "over-quic", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithQUIC(&tls.Config{
+		Certificates: []tls.Certificate{*cert},
+	}, nil))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, server.QUICAddr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{QUICProtocol},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial QUIC: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	// Each stream is its own session over the one connection
+	for i := 0; i < 2; i++ {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		session := &quicStream{Stream: stream, local: conn.LocalAddr(), remote: conn.RemoteAddr()}
+		defer session.Close()
+
+		handshake(t, session)
+		if ids := query(t, session, "DISCOVER"); len(ids) != 1 || ids[0] != "over-quic" {
+			t.Errorf("Stream %d: expected the capability over QUIC, got %v", i, ids)
+		}
+	}
+}
+
+func TestQUICRequiresCertificate(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithQUIC(&tls.Config{}, nil))
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Expected Start to fail without a certificate")
+	}
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter throttles message processing
+type RateLimiter interface {
+	// Wait blocks until a message may proceed or ctx is done
+	Wait(ctx context.Context) error
+}
+
+// tokenBucket is a RateLimiter that refills at a fixed rate up to a burst size
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewTokenBucketLimiter allows rate messages per second with bursts of up to burst messages
+func NewTokenBucketLimiter(rate, burst int) RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return newTokenBucket(float64(rate), float64(burst))
+}
+
+// newTokenBucket returns a full bucket refilling at rate tokens per second
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	return b.waitN(ctx, 1)
+}
+
+// waitN takes n tokens, waiting until the bucket has refilled enough to
+// cover them
+func (b *tokenBucket) waitN(ctx context.Context, n float64) error {
+	b.mu.Lock()
+
+	// Refill for the time elapsed since the last call
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	// Reserve the tokens, possibly going into debt that later callers wait out
+	b.tokens -= n
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.rate <= 0 {
+		b.tokens += n
+		b.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Hand the reservation back
+		b.mu.Lock()
+		b.tokens += n
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
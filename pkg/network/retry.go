@@ -0,0 +1,88 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how DialWithRetry backs off between attempts
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts before giving up, at least one is always made
+	BaseDelay   time.Duration // Wait after the first failure
+	MaxDelay    time.Duration // Upper bound on any single wait, zero for none
+	Multiplier  float64       // Growth of the wait per attempt, below 1 means 2
+	Jitter      bool          // Randomize each wait between zero and its full length
+}
+
+// DefaultRetryPolicy matches the backoff the client package uses
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	Multiplier:  2,
+	Jitter:      true,
+}
+
+// backoff returns the wait before the given retry, counting from 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(p.BaseDelay)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// Full jitter spreads out clients that failed at the same moment
+	if p.Jitter && delay > 0 {
+		delay = rand.Float64() * delay
+	}
+	return time.Duration(delay)
+}
+
+// DialWithRetry dials addr over TCP, backing off between failed attempts as
+// policy describes. It gives up early when ctx is done.
+func DialWithRetry(ctx context.Context, addr string, policy RetryPolicy) (net.Conn, error) {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := policy.backoff(attempt - 1)
+			slog.Warn("Dial failed, retrying", "addr", addr, "attempt", attempt, "max_attempts", attempts, "delay", delay, "error", lastErr)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to dial %s after %d attempts: %w", addr, attempts, lastErr)
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 300 * time.Millisecond},
+		{3, 900 * time.Millisecond},
+		{4, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+
+	policy.Jitter = true
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(3); got < 0 || got > 900*time.Millisecond {
+			t.Fatalf("Jittered backoff %v outside [0, 900ms]", got)
+		}
+	}
+}
+
+func TestDialWithRetry(t *testing.T) {
+	// Reserve a port, then leave it closed until a few attempts have failed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer l.Close()
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	policy := RetryPolicy{MaxAttempts: 20, BaseDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2}
+	conn, err := DialWithRetry(context.Background(), addr, policy)
+	if err != nil {
+		t.Fatalf("DialWithRetry() error = %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialWithRetryGivesUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond}
+	if _, err := DialWithRetry(context.Background(), addr, policy); err == nil {
+		t.Error("Expected DialWithRetry to fail after 3 attempts")
+	}
+
+	// A cancelled context stops the backoff early
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	policy = RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second}
+	if _, err := DialWithRetry(ctx, addr, policy); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected to stop when the context expired, took %v", elapsed)
+	}
+}
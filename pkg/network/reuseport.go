@@ -0,0 +1,54 @@
+package network
+
+import (
+	"context"
+	"net"
+)
+
+// WithReusePort opens n TCP listeners on the same address with
+// SO_REUSEPORT, each accepted from by its own goroutine, so the kernel
+// spreads incoming connections across them. Other processes setting the
+// option on the same port share its connections too. Where the option is
+// not supported the server falls back to a single listener.
+func WithReusePort(n int) Option {
+	return func(s *Server) {
+		s.reusePort = n
+	}
+}
+
+// listenTCPGroup opens the TCP listeners for one address: one, or
+// s.reusePort sharing a port when WithReusePort is in effect
+func (s *Server) listenTCPGroup(network, addr string) ([]net.Listener, error) {
+	if s.reusePort <= 1 {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	if !reusePortSupported {
+		s.logger.Warn("SO_REUSEPORT is not supported, using a single TCP listener", "addr", addr)
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	lc := net.ListenConfig{Control: controlReusePort}
+	listeners := make([]net.Listener, 0, s.reusePort)
+	for len(listeners) < s.reusePort {
+		l, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		if len(listeners) == 0 {
+			addr = withBoundPort(addr, l.Addr())
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package network
+
+import "syscall"
+
+const reusePortSupported = false
+
+func controlReusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestReusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithReusePort(4))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	addrs := server.TCPAddrs()
+	if len(addrs) != 4 {
+		t.Fatalf("TCPAddrs() returned %d listeners, want 4", len(addrs))
+	}
+	for _, addr := range addrs[1:] {
+		if addr.String() != addrs[0].String() {
+			t.Errorf("Listener bound to %v, want the shared %v", addr, addrs[0])
+		}
+	}
+
+	// Whichever listener the kernel picks, the session works
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		handshake(t, conn)
+		conn.Close()
+	}
+
+	// Another server opting in can share the port
+	port := strconv.Itoa(server.TCPAddr().(*net.TCPAddr).Port)
+	other := NewServer(net.JoinHostPort("127.0.0.1", port), "127.0.0.1:0", protocol.NewHandler(), WithReusePort(2))
+	if err := other.Start(); err != nil {
+		t.Fatalf("Expected a second server to share the port: %v", err)
+	}
+	other.Stop()
+}
+
+// benchmarkAccept measures sessions opened per second, each a connect,
+// handshake and close, against a server with listeners TCP listeners
+func benchmarkAccept(b *testing.B, listeners int) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithReusePort(listeners))
+	if err := server.Start(); err != nil {
+		b.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	payload, _ := json.Marshal(&protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2})
+	addr := server.TCPAddr().String()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			err = WriteMessage(conn, &protocol.Message{
+				Version:   protocol.V1,
+				Type:      protocol.Handshake,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err == nil {
+				_, err = ReadMessage(conn)
+			}
+			conn.Close()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkAcceptSingleListener(b *testing.B) {
+	benchmarkAccept(b, 1)
+}
+
+func BenchmarkAcceptReusePort(b *testing.B) {
+	benchmarkAccept(b, runtime.NumCPU())
+}
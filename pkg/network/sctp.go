@@ -0,0 +1,450 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// ErrSCTPUnavailable is returned when the platform cannot open SCTP sockets
+var ErrSCTPUnavailable = errors.New("SCTP unavailable")
+
+// Streams requested in each direction of an SCTP association
+const sctpStreams = 64
+
+// SCTPServer serves ARN sessions over SCTP associations, each frame in one
+// SCTP message. The session itself runs on SCTP stream 0. A peer opens an
+// AI stream session by sending AIStreamStart on an unused SCTP stream; the
+// session's data, credits and AIStreamEnd then travel on that stream and
+// are handled independently of every other stream, and AIStreamEnd closes
+// it. Replies go out on the stream their request arrived on.
+//
+// SCTP sessions receive broadcasts on stream 0 but cannot prove an
+// identity.
+type SCTPServer struct {
+	addr     string
+	server   *Server
+	listener sctpListener
+}
+
+// WithSCTP additionally serves SCTP associations on addr. Where the
+// platform has no SCTP support the server starts without it.
+func WithSCTP(addr string) Option {
+	return func(s *Server) {
+		s.sctp = &SCTPServer{addr: addr, server: s}
+	}
+}
+
+// sctpListener accepts SCTP associations
+type sctpListener interface {
+	Accept() (sctpAssociation, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// sctpAssociation reads and writes whole frames tagged with the SCTP
+// stream they travel on. Its net.Conn deadlines apply to both.
+type sctpAssociation interface {
+	net.Conn
+
+	// ReadFrame reads the next message. onStart, if not nil, is called once
+	// part of a message has arrived and before the rest is read.
+	ReadFrame(onStart func()) ([]byte, uint16, error)
+	WriteFrame(data []byte, stream uint16) error
+}
+
+// sctpConn is an association as the server tracks connections, each write
+// sent as one SCTP message on stream 0
+type sctpConn struct {
+	sctpAssociation
+}
+
+func (c sctpConn) Write(p []byte) (int, error) {
+	if err := c.WriteFrame(p, 0); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// throttledAssociation holds an association to WithBandwidthLimit the way
+// a ThrottledConn does a stream connection, a whole frame at a time
+type throttledAssociation struct {
+	sctpAssociation
+
+	read  *tokenBucket
+	write *tokenBucket
+
+	ctx    context.Context // cancelled by Close to release throttled calls
+	cancel context.CancelFunc
+}
+
+// newThrottledAssociation limits assoc to bytesPerSec in each direction
+func newThrottledAssociation(assoc sctpAssociation, bytesPerSec int64) *throttledAssociation {
+	burst := max(bytesPerSec, minThrottleBurst)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &throttledAssociation{
+		sctpAssociation: assoc,
+		read:            newTokenBucket(float64(bytesPerSec), float64(burst)),
+		write:           newTokenBucket(float64(bytesPerSec), float64(burst)),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// ReadFrame reads a frame and then waits until it fits the limit
+func (a *throttledAssociation) ReadFrame(onStart func()) ([]byte, uint16, error) {
+	frame, stream, err := a.sctpAssociation.ReadFrame(onStart)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := a.read.waitN(a.ctx, float64(len(frame))); err != nil {
+		return nil, 0, net.ErrClosed
+	}
+	return frame, stream, nil
+}
+
+// WriteFrame waits until data fits the limit and then sends it
+func (a *throttledAssociation) WriteFrame(data []byte, stream uint16) error {
+	if err := a.write.waitN(a.ctx, float64(len(data))); err != nil {
+		return net.ErrClosed
+	}
+	return a.sctpAssociation.WriteFrame(data, stream)
+}
+
+// Close closes the association and releases any call waiting on the limit
+func (a *throttledAssociation) Close() error {
+	a.cancel()
+	return a.sctpAssociation.Close()
+}
+
+// start begins accepting associations
+func (a *SCTPServer) start() error {
+	listener, err := listenSCTP(a.addr)
+	if err != nil {
+		return err
+	}
+	a.listener = listener
+
+	a.server.wg.Add(1)
+	go a.accept()
+	return nil
+}
+
+// stop closes the listener. Established associations are drained by Stop
+// along with every other connection.
+func (a *SCTPServer) stop() error {
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Close()
+}
+
+// Addr returns the address the SCTP listener is bound to, or nil if it is
+// not listening
+func (a *SCTPServer) Addr() net.Addr {
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+func (a *SCTPServer) accept() {
+	defer a.server.wg.Done()
+
+	for {
+		assoc, err := a.listener.Accept()
+		if err != nil {
+			if a.server.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return // Server is shutting down
+			}
+			a.server.logger.Error("Failed to accept SCTP association", "error", err)
+			continue
+		}
+
+		a.server.wg.Add(1)
+		go a.serve(assoc)
+	}
+}
+
+// sctpSession is one association's ARN session, with a worker per open
+// SCTP stream
+type sctpSession struct {
+	server  *Server
+	assoc   sctpAssociation
+	ctx     context.Context
+	log     *slog.Logger
+	streams map[uint16]chan *protocol.Message // owned by the reading goroutine
+	workers sync.WaitGroup
+}
+
+// serve runs an ARN session over assoc until either side hangs up. The
+// session is held to the same limits as one on a stream connection.
+func (a *SCTPServer) serve(assoc sctpAssociation) {
+	s := a.server
+	defer s.wg.Done()
+	if s.bandwidthLimit > 0 {
+		assoc = newThrottledAssociation(assoc, s.bandwidthLimit)
+	}
+	defer assoc.Close()
+
+	// The association is tracked, drained and reaped like any connection
+	conn := sctpConn{assoc}
+
+	n := s.activeConns.Add(1)
+	defer s.activeConns.Add(-1)
+	if s.overConnectionLimit(n) {
+		s.refuseConn(conn, "sctp")
+		return
+	}
+
+	s.open.Store(conn, struct{}{})
+	defer s.open.Delete(conn)
+
+	s.touch(conn)
+	defer s.lastSeen.Delete(conn)
+
+	s.metrics.ConnectionOpened("sctp")
+	defer s.metrics.ConnectionClosed("sctp")
+
+	log := s.logger.With("transport", "sctp", "peer", assoc.RemoteAddr())
+
+	// Unblock pending reads when the server shuts down
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			assoc.Close()
+		case <-done:
+		}
+	}()
+
+	assoc.SetWriteDeadline(time.Now().Add(s.maxIdleTime))
+	if !s.armReadDeadline(conn) {
+		return
+	}
+	offer, err := a.handshake(assoc)
+	if err != nil {
+		if !isClosedError(err) && !s.isDraining() {
+			log.Error("Handshake failed", "error", err)
+		}
+		return
+	}
+	s.touch(conn)
+
+	// Broadcasts and Pings go out on stream 0
+	tc := &trackedConn{Conn: conn, writeTimeout: s.maxIdleTime}
+	s.conns.Store(conn, tc)
+	defer s.conns.Delete(conn)
+
+	ctx := protocol.ContextWithPeerAddr(s.ctx, assoc.RemoteAddr())
+	ctx = protocol.ContextWithSessionID(ctx, rand.Text())
+	if offer.PeerID != "" {
+		ctx = protocol.ContextWithPeerID(ctx, offer.PeerID)
+	}
+	if offer.TenantID != "" {
+		ctx = protocol.ContextWithTenantID(ctx, offer.TenantID)
+	}
+	if s.HeartbeatInterval > 0 {
+		go s.heartbeat(ctx, tc, done, log)
+	}
+
+	session := &sctpSession{
+		server:  s,
+		assoc:   assoc,
+		ctx:     ctx,
+		log:     log,
+		streams: make(map[uint16]chan *protocol.Message),
+	}
+	defer session.close()
+
+	// Read messages until the peer hangs up, goes idle or the server drains
+	for {
+		if !s.armReadDeadline(conn) {
+			return
+		}
+
+		frame, stream, err := assoc.ReadFrame(func() {
+			if s.MessageTimeout > 0 {
+				assoc.SetReadDeadline(time.Now().Add(s.MessageTimeout))
+			}
+		})
+		if err != nil {
+			if !isClosedError(err) && !s.isDraining() {
+				log.Error("Failed to read message", "error", err)
+			}
+			return
+		}
+
+		msg, err := protocol.Deserialize(frame)
+		if err != nil {
+			log.Error("Failed to decode message", "stream", stream, "error", err)
+			return
+		}
+		s.metrics.MessageReceived(fmt.Sprint(msg.Type), "sctp")
+		s.touch(conn)
+
+		if msg.Type == protocol.Pong {
+			continue
+		}
+		session.dispatch(stream, msg)
+	}
+}
+
+// handshake negotiates the session on the association's first message and
+// returns what the peer offered. Identity proofs are not offered to SCTP
+// peers, so the handler never challenges them.
+func (a *SCTPServer) handshake(assoc sctpAssociation) (*protocol.HandshakePayload, error) {
+	frame, stream, err := assoc.ReadFrame(nil)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := protocol.Deserialize(frame)
+	if err != nil {
+		return nil, err
+	}
+	a.server.metrics.MessageReceived(fmt.Sprint(msg.Type), "sctp")
+
+	var response *protocol.Message
+	if msg.Type != protocol.Handshake {
+		response, err = protocol.NewErrorMessage(protocol.ErrInvalidMessageType, "handshake required")
+	} else if err = withoutIdentity(msg); err == nil {
+		response, err = a.server.handler.HandleHandshake(msg)
+	} else {
+		response, err = protocol.NewErrorMessage(protocol.ErrInvalidPayload, "invalid handshake format")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := response.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if err := assoc.WriteFrame(data, stream); err != nil {
+		return nil, err
+	}
+	if response.Type == protocol.Error {
+		return nil, fmt.Errorf("peer sent %v before handshake completed", msg.Type)
+	}
+
+	var offer protocol.HandshakePayload
+	if err := json.Unmarshal(msg.Payload, &offer); err != nil {
+		return nil, fmt.Errorf("invalid handshake payload: %w", err)
+	}
+
+	// Without identity proofs a tenant can only be joined when none are
+	// required, and offer is rewritten to the tenant actually joined
+	if offer.TenantID, err = a.server.handler.SessionTenant(&offer, nil); err != nil {
+		if response, rerr := protocol.NewErrorMessage(protocol.ErrForbidden, err.Error()); rerr == nil {
+			if data, rerr := response.Serialize(); rerr == nil {
+				assoc.WriteFrame(data, stream)
+			}
+		}
+		return nil, err
+	}
+	return &offer, nil
+}
+
+// dispatch queues msg for the worker of the SCTP stream it arrived on, or
+// refuses it if that stream's queue is full. Streams other than 0 are
+// opened by AIStreamStart and closed by AIStreamEnd.
+func (a *sctpSession) dispatch(stream uint16, msg *protocol.Message) {
+	queue, open := a.streams[stream]
+	if !open {
+		if stream != 0 && msg.Type != protocol.AIStreamStart {
+			if response, err := protocol.NewErrorMessage(protocol.ErrInvalidMessageType, "SCTP stream not opened with AIStreamStart"); err == nil {
+				a.respond(stream, msg, response)
+			}
+			return
+		}
+		queue = make(chan *protocol.Message, maxQueuedMessages)
+		a.streams[stream] = queue
+		a.workers.Add(1)
+		go a.work(stream, queue)
+	}
+
+	// A peer that outpaces the stream's worker is told so rather than
+	// stalling every other stream behind it
+	select {
+	case queue <- msg:
+	default:
+		if response, err := protocol.NewErrorMessage(protocol.ErrCapabilityUnavailable, "SCTP stream queue full"); err == nil {
+			a.respond(stream, msg, response)
+		}
+		return
+	}
+	if stream != 0 && msg.Type == protocol.AIStreamEnd {
+		delete(a.streams, stream)
+		close(queue)
+	}
+}
+
+// work handles the messages of one SCTP stream in order
+func (a *sctpSession) work(stream uint16, queue <-chan *protocol.Message) {
+	defer a.workers.Done()
+
+	for msg := range queue {
+		if a.server.limiter != nil {
+			if err := a.server.limiter.Wait(a.ctx); err != nil {
+				a.log.Error("Rate limiter aborted message", "error", err)
+				a.assoc.Close()
+				continue
+			}
+		}
+
+		// Handler failures are logged by the handler itself
+		response, err := a.server.handler.HandleMessage(a.ctx, msg)
+		if err != nil || response == nil {
+			continue
+		}
+		if !a.respond(stream, msg, response) {
+			a.assoc.Close()
+		}
+	}
+}
+
+// respond writes the response to msg on stream, reporting false if the
+// association failed
+func (a *sctpSession) respond(stream uint16, msg, response *protocol.Message) bool {
+	mirrorCompression(msg, response)
+	data, err := response.Serialize()
+	if err != nil {
+		a.log.Error("Failed to serialize response", "error", err)
+		return true
+	}
+	a.assoc.SetWriteDeadline(time.Now().Add(a.server.maxIdleTime))
+	if err := a.assoc.WriteFrame(data, stream); err != nil {
+		if !isClosedError(err) {
+			a.log.Error("Failed to write response", "stream", stream, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// close stops every stream worker once its queue is handled
+func (a *sctpSession) close() {
+	for stream, queue := range a.streams {
+		delete(a.streams, stream)
+		close(queue)
+	}
+	a.workers.Wait()
+}
+
+// SCTPAddr returns the address the SCTP listener is bound to, or nil if
+// WithSCTP was not used or the platform has no SCTP support
+func (s *Server) SCTPAddr() net.Addr {
+	if s.sctp == nil {
+		return nil
+	}
+	return s.sctp.Addr()
+}
@@ -0,0 +1,209 @@
+//go:build linux
+
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket options and ancillary data types from linux/sctp.h
+const (
+	sctpInitMsg     = 2  // SCTP_INITMSG socket option
+	sctpRecvRcvInfo = 32 // SCTP_RECVRCVINFO socket option
+	sctpSndInfo     = 2  // SCTP_SNDINFO ancillary data
+	sctpRcvInfo     = 3  // SCTP_RCVINFO ancillary data
+
+	sctpSndInfoSize = 16 // sizeof(struct sctp_sndinfo)
+	sctpRcvInfoSize = 28 // sizeof(struct sctp_rcvinfo)
+)
+
+// Largest piece of an SCTP message read at once; longer messages are
+// read in several pieces and joined
+const sctpReadSize = 64 << 10
+
+// Largest frame the wire format can describe, and so the most a TCP peer
+// can send in one message: version and type, a five-byte payload size, the
+// payload, timestamp, V2 trailer, extensions and checksum
+const maxSCTPFrameSize int64 = 2 + 5 + 1<<32 - 1 + 8 + 3 + 1<<16 - 1 + 4
+
+// errFrameTooLarge is returned for SCTP messages longer than any ARN frame
+var errFrameTooLarge = errors.New("SCTP message exceeds the largest ARN frame")
+
+// listenSCTP opens a one-to-one style SCTP socket listening on addr. The
+// socket is handed to the runtime poller through net.FileListener, which
+// sees a stream socket and reports its addresses as TCP addresses.
+func listenSCTP(addr string) (sctpListener, error) {
+	sa, family, err := sctpSockaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		if errors.Is(err, unix.EPROTONOSUPPORT) || errors.Is(err, unix.ESOCKTNOSUPPORT) || errors.Is(err, unix.EAFNOSUPPORT) {
+			return nil, fmt.Errorf("%w: %v", ErrSCTPUnavailable, err)
+		}
+		return nil, fmt.Errorf("failed to open SCTP socket: %w", err)
+	}
+	file := os.NewFile(uintptr(fd), "sctp")
+	defer file.Close()
+
+	// Ask for sctpStreams streams each way; the peer may grant fewer
+	var initMsg [8]byte
+	binary.NativeEndian.PutUint16(initMsg[0:], sctpStreams)
+	binary.NativeEndian.PutUint16(initMsg[2:], sctpStreams)
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return nil, fmt.Errorf("failed to set SO_REUSEADDR: %w", err)
+	}
+	if err := unix.SetsockoptString(fd, unix.IPPROTO_SCTP, sctpInitMsg, string(initMsg[:])); err != nil {
+		return nil, fmt.Errorf("failed to set SCTP_INITMSG: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_SCTP, sctpRecvRcvInfo, 1); err != nil {
+		return nil, fmt.Errorf("failed to set SCTP_RECVRCVINFO: %w", err)
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return nil, fmt.Errorf("failed to bind SCTP socket: %w", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		return nil, fmt.Errorf("failed to listen on SCTP socket: %w", err)
+	}
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SCTP listener: %w", err)
+	}
+	return &sctpSocketListener{Listener: listener}, nil
+}
+
+// sctpSockaddr resolves addr to a socket address and its address family
+func sctpSockaddr(addr string) (unix.Sockaddr, int, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid SCTP address: %w", err)
+	}
+
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil || tcpAddr.IP == nil {
+		sa := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		return sa, unix.AF_INET, nil
+	}
+	sa := &unix.SockaddrInet6{Port: tcpAddr.Port}
+	copy(sa.Addr[:], tcpAddr.IP.To16())
+	return sa, unix.AF_INET6, nil
+}
+
+// sctpSocketListener accepts associations on an SCTP socket
+type sctpSocketListener struct {
+	net.Listener
+}
+
+func (l *sctpSocketListener) Accept() (sctpAssociation, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to access SCTP socket: %w", err)
+	}
+	return &sctpSocket{
+		Conn: conn,
+		raw:  raw,
+		buf:  make([]byte, sctpReadSize),
+		oob:  make([]byte, unix.CmsgSpace(sctpRcvInfoSize)),
+	}, nil
+}
+
+// sctpSocket is an accepted association, read by one goroutine and written
+// by any
+type sctpSocket struct {
+	net.Conn
+	raw     syscall.RawConn
+	buf     []byte
+	oob     []byte
+	writeMu sync.Mutex
+}
+
+// ReadFrame reads the next SCTP message and the stream it arrived on. The
+// message is read in pieces, since SCTP delivers one only as far as the
+// buffer allows and marks its last piece with MSG_EOR.
+func (c *sctpSocket) ReadFrame(onStart func()) ([]byte, uint16, error) {
+	var frame []byte
+	for {
+		var n, oobn, flags int
+		var err error
+		if readErr := c.raw.Read(func(fd uintptr) bool {
+			n, oobn, flags, _, err = unix.Recvmsg(int(fd), c.buf, c.oob, 0)
+			return !errors.Is(err, unix.EAGAIN)
+		}); readErr != nil {
+			return nil, 0, readErr
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if n == 0 && flags&unix.MSG_EOR == 0 {
+			return nil, 0, io.EOF
+		}
+
+		if int64(len(frame))+int64(n) > maxSCTPFrameSize {
+			return nil, 0, errFrameTooLarge
+		}
+		frame = append(frame, c.buf[:n]...)
+		if flags&unix.MSG_EOR != 0 {
+			return frame, receivedStream(c.oob[:oobn]), nil
+		}
+		if onStart != nil && len(frame) == n {
+			onStart()
+		}
+	}
+}
+
+// receivedStream returns the stream ID carried by SCTP_RCVINFO ancillary
+// data, or stream 0 if there is none
+func receivedStream(oob []byte) uint16 {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_SCTP && msg.Header.Type == sctpRcvInfo && len(msg.Data) >= 2 {
+			return binary.NativeEndian.Uint16(msg.Data)
+		}
+	}
+	return 0
+}
+
+// WriteFrame sends data as one SCTP message on stream
+func (c *sctpSocket) WriteFrame(data []byte, stream uint16) error {
+	oob := make([]byte, unix.CmsgSpace(sctpSndInfoSize))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_SCTP
+	h.Type = sctpSndInfo
+	h.SetLen(unix.CmsgLen(sctpSndInfoSize))
+	binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], stream)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var err error
+	if writeErr := c.raw.Write(func(fd uintptr) bool {
+		err = unix.Sendmsg(int(fd), data, oob, nil, 0)
+		return !errors.Is(err, unix.EAGAIN)
+	}); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
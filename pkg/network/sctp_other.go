@@ -0,0 +1,8 @@
+//go:build !linux
+
+package network
+
+// listenSCTP reports that SCTP is unavailable; it is only supported on Linux
+func listenSCTP(addr string) (sctpListener, error) {
+	return nil, ErrSCTPUnavailable
+}
@@ -0,0 +1,299 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// sctpFrame is a frame on one SCTP stream
+type sctpFrame struct {
+	data   []byte
+	stream uint16
+}
+
+// pipeAssociation stands in for an SCTP association, for platforms and
+// sandboxes without SCTP. Deadlines are ignored.
+type pipeAssociation struct {
+	net.Conn // nil; only the methods below are used
+
+	in     chan sctpFrame
+	out    chan sctpFrame
+	closed chan struct{}
+}
+
+func newPipeAssociation() *pipeAssociation {
+	return &pipeAssociation{
+		in:     make(chan sctpFrame, 16),
+		out:    make(chan sctpFrame, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *pipeAssociation) ReadFrame(func()) ([]byte, uint16, error) {
+	select {
+	case f, ok := <-p.in:
+		if !ok {
+			return nil, 0, io.EOF
+		}
+		return f.data, f.stream, nil
+	case <-p.closed:
+		return nil, 0, net.ErrClosed
+	}
+}
+
+func (p *pipeAssociation) WriteFrame(data []byte, stream uint16) error {
+	select {
+	case p.out <- sctpFrame{data, stream}:
+		return nil
+	case <-p.closed:
+		return net.ErrClosed
+	}
+}
+
+func (p *pipeAssociation) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}
+}
+
+func (p *pipeAssociation) SetDeadline(time.Time) error      { return nil }
+func (p *pipeAssociation) SetReadDeadline(time.Time) error  { return nil }
+func (p *pipeAssociation) SetWriteDeadline(time.Time) error { return nil }
+
+func (p *pipeAssociation) Close() error {
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+	return nil
+}
+
+// exchange sends msg on stream and returns the reply and the stream it came back on
+func (p *pipeAssociation) exchange(t *testing.T, stream uint16, msg *protocol.Message) (*protocol.Message, uint16) {
+	t.Helper()
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	p.in <- sctpFrame{data, stream}
+
+	select {
+	case f := <-p.out:
+		response, err := protocol.Deserialize(f.data)
+		if err != nil {
+			t.Fatalf("Deserialize() error = %v", err)
+		}
+		return response, f.stream
+	case <-time.After(time.Second):
+		t.Fatalf("No reply on stream %d", stream)
+		return nil, 0
+	}
+}
+
+func TestSCTPStreams(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithSCTP("127.0.0.1:0"))
+	assoc := newPipeAssociation()
+	server.wg.Add(1)
+	go server.sctp.serve(assoc)
+	defer server.wg.Wait()
+	defer close(assoc.in)
+
+	message := func(typ protocol.MessageType, payload any) *protocol.Message {
+		return &protocol.Message{Version: protocol.V1, Type: typ, Payload: mustMarshal(t, payload), Timestamp: time.Now()}
+	}
+
+	offer := message(protocol.Handshake, protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2})
+	if response, stream := assoc.exchange(t, 0, offer); response.Type != protocol.Handshake || stream != 0 {
+		t.Fatalf("Expected a Handshake reply on stream 0, got %v on %d", response.Type, stream)
+	}
+
+	// Stream sessions open on their own SCTP stream and answer on it
+	start := message(protocol.AIStreamStart, protocol.StreamStartPayload{SessionID: "sub-task"})
+	response, stream := assoc.exchange(t, 3, start)
+	var started protocol.StreamStartResponse
+	if stream != 3 || json.Unmarshal(response.Payload, &started) != nil || !started.Accepted {
+		t.Fatalf("Expected the stream accepted on SCTP stream 3, got %s on %d", response.Payload, stream)
+	}
+
+	data := message(protocol.AIStreamData, protocol.StreamDataPayload{SessionID: "sub-task", Data: []byte("chunk")})
+	if response, stream := assoc.exchange(t, 3, data); response.Type == protocol.Error || stream != 3 {
+		t.Errorf("Expected stream data accepted on SCTP stream 3, got %v %s on %d", response.Type, response.Payload, stream)
+	}
+
+	// The session itself carries on over stream 0 meanwhile
+	query := message(protocol.Query, protocol.QueryPayload{CapabilityType: "DISCOVER"})
+	if response, stream := assoc.exchange(t, 0, query); response.Type != protocol.Response || stream != 0 {
+		t.Errorf("Expected a query answered on stream 0, got %v on %d", response.Type, stream)
+	}
+
+	end := message(protocol.AIStreamEnd, protocol.StreamSessionPayload{SessionID: "sub-task"})
+	if response, stream := assoc.exchange(t, 3, end); response.Type != protocol.Response || stream != 3 {
+		t.Errorf("Expected the stream ended on SCTP stream 3, got %v %s on %d", response.Type, response.Payload, stream)
+	}
+
+	// AIStreamEnd closed SCTP stream 3, and stream 5 was never opened
+	for _, stream := range []uint16{3, 5} {
+		response, got := assoc.exchange(t, stream, data)
+		var errPayload protocol.ErrorPayload
+		if response.Type != protocol.Error || got != stream || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != protocol.ErrInvalidMessageType {
+			t.Errorf("Expected data on closed SCTP stream %d refused, got %v %s on %d", stream, response.Type, response.Payload, got)
+		}
+	}
+}
+
+func TestSCTPHandshakeRequired(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithSCTP("127.0.0.1:0"))
+	assoc := newPipeAssociation()
+	server.wg.Add(1)
+	go server.sctp.serve(assoc)
+
+	query := &protocol.Message{Version: protocol.V1, Type: protocol.Query, Payload: mustMarshal(t, protocol.QueryPayload{}), Timestamp: time.Now()}
+	if response, _ := assoc.exchange(t, 0, query); response.Type != protocol.Error {
+		t.Errorf("Expected Error before the handshake, got %v", response.Type)
+	}
+
+	server.wg.Wait()
+	select {
+	case <-assoc.closed:
+	default:
+		t.Error("Expected the association closed after a failed handshake")
+	}
+}
+
+func TestSCTPUnavailableFallback(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithSCTP("127.0.0.1:0"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer server.Stop()
+
+	// Either SCTP is served or the platform lacks it and the server carries on
+	listener, err := listenSCTP("127.0.0.1:0")
+	if listener != nil {
+		listener.Close()
+	}
+	if errors.Is(err, ErrSCTPUnavailable) {
+		if server.SCTPAddr() != nil {
+			t.Errorf("SCTPAddr() = %v without SCTP support, want nil", server.SCTPAddr())
+		}
+		return
+	}
+	if server.SCTPAddr() == nil {
+		t.Errorf("SCTPAddr() = nil, want the listener address (probe error %v)", err)
+	}
+}
+
+func TestSCTPSharedLimits(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithSCTP("127.0.0.1:0"), WithMaxConnections(1))
+	defer server.wg.Wait()
+
+	offer := &protocol.Message{Version: protocol.V1, Type: protocol.Handshake, Payload: mustMarshal(t, protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2}), Timestamp: time.Now()}
+
+	first := newPipeAssociation()
+	server.wg.Add(1)
+	go server.sctp.serve(first)
+	defer close(first.in)
+	if response, _ := first.exchange(t, 0, offer); response.Type != protocol.Handshake {
+		t.Fatalf("Expected a Handshake reply, got %v", response.Type)
+	}
+
+	// Associations count against WithMaxConnections like any connection
+	second := newPipeAssociation()
+	server.wg.Add(1)
+	go server.sctp.serve(second)
+	select {
+	case f := <-second.out:
+		response, err := protocol.Deserialize(f.data)
+		var errPayload protocol.ErrorPayload
+		if err != nil || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != protocol.ErrUnauthorized || f.stream != 0 {
+			t.Errorf("Expected ErrUnauthorized on stream 0 over the limit, got %v on %d", response, f.stream)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the association over the limit to be refused")
+	}
+	select {
+	case <-second.closed:
+	case <-time.After(time.Second):
+		t.Error("Expected the association over the limit to be closed")
+	}
+
+	// Established associations receive broadcasts on stream 0
+	ping, err := protocol.NewMessage(protocol.Ping).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Broadcast(ping); err != nil {
+		t.Fatalf("Broadcast() error = %v", err)
+	}
+	select {
+	case f := <-first.out:
+		if response, err := protocol.Deserialize(f.data); err != nil || response.Type != protocol.Ping || f.stream != 0 {
+			t.Errorf("Expected the broadcast Ping on stream 0, got %v on %d", response, f.stream)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the broadcast to reach the association")
+	}
+}
+
+// heldLimiter holds every message until release is closed
+type heldLimiter struct {
+	release chan struct{}
+}
+
+func (l heldLimiter) Wait(ctx context.Context) error {
+	select {
+	case <-l.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestSCTPQueueFull(t *testing.T) {
+	limiter := heldLimiter{release: make(chan struct{})}
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithSCTP("127.0.0.1:0"), WithRateLimiter(limiter))
+	assoc := newPipeAssociation()
+	server.wg.Add(1)
+	go server.sctp.serve(assoc)
+	defer server.wg.Wait()
+	defer close(assoc.in)
+
+	offer := &protocol.Message{Version: protocol.V1, Type: protocol.Handshake, Payload: mustMarshal(t, protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V2}), Timestamp: time.Now()}
+	if response, _ := assoc.exchange(t, 0, offer); response.Type != protocol.Handshake {
+		t.Fatalf("Expected a Handshake reply, got %v", response.Type)
+	}
+
+	// The worker holds the first query and the queue fills behind it, so
+	// the next query is refused instead of stalling the reader
+	query, err := (&protocol.Message{Version: protocol.V1, Type: protocol.Query, Payload: mustMarshal(t, protocol.QueryPayload{CapabilityType: "DISCOVER"}), Timestamp: time.Now()}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range maxQueuedMessages + 1 {
+		assoc.in <- sctpFrame{query, 0}
+	}
+	response, stream := assoc.exchange(t, 0, &protocol.Message{Version: protocol.V1, Type: protocol.Query, Payload: mustMarshal(t, protocol.QueryPayload{}), Timestamp: time.Now()})
+	var errPayload protocol.ErrorPayload
+	if response.Type != protocol.Error || stream != 0 || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != protocol.ErrCapabilityUnavailable {
+		t.Errorf("Expected ErrCapabilityUnavailable for a full queue, got %v %s on %d", response.Type, response.Payload, stream)
+	}
+
+	// Answer the queued queries so the session can wind down
+	close(limiter.release)
+	go func() {
+		for {
+			select {
+			case <-assoc.out:
+			case <-assoc.closed:
+				return
+			}
+		}
+	}()
+}
@@ -1,14 +1,25 @@
 package network
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/heathweaver/arn-protocol/pkg/discovery"
+	"github.com/heathweaver/arn-protocol/pkg/metrics"
 	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Server represents the ARN network server
@@ -21,72 +32,630 @@ type Server struct {
 	wg          sync.WaitGroup
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// Every listener and UDP socket, tcpListener and udpConn first. With
+	// WithReusePort each address has several TCP listeners, and with
+	// WithDualStack the IPv6 ones follow the IPv4 ones.
+	ipMode       ipMode
+	reusePort    int
+	tcpListeners []net.Listener
+	udpConns     []*net.UDPConn
+
+	// TLSConfig enables TLS on the TCP listener when non-nil
+	TLSConfig *tls.Config
+
+	// UnixAddr is the path of a UNIX domain socket to also serve sessions
+	// on, empty for none. See WithUnixSocket.
+	UnixAddr     string
+	unixListener net.Listener
+	unixCredAuth func(cred Ucred) error // see WithUnixCredentialAuth
+
+	// DrainTimeout bounds how long Stop waits for in-flight messages before
+	// closing the remaining connections
+	DrainTimeout time.Duration
+
+	// MessageTimeout bounds how long the rest of a message may take to arrive
+	// once its header has been read, so a stalled peer cannot hold a session
+	MessageTimeout time.Duration
+
+	// MaxIdleTimeout closes stream connections that send no message for
+	// this long, checked every IdleCheckInterval. Zero disables the janitor.
+	// A longer MaxIdleTimeout also extends the WithMaxIdleTime deadline.
+	MaxIdleTimeout    time.Duration
+	IdleCheckInterval time.Duration
+
+	// HeartbeatInterval and HeartbeatTimeout detect connections that drop
+	// silently, see WithHeartbeat. A zero HeartbeatInterval sends no Pings.
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+
+	// FlushDelay and FlushThreshold batch writes to stream connections,
+	// see WithWriteBatching. A zero FlushDelay writes each message at once.
+	FlushDelay     time.Duration
+	FlushThreshold int
+
+	limiter        RateLimiter
+	maxConns       int   // see WithMaxConnections
+	bandwidthLimit int64 // bytes per second per connection, see WithBandwidthLimit
+	proxyProtocol  bool  // see WithPROXYProtocol
+	maxIdleTime    time.Duration
+	logger         *slog.Logger
+	metrics        *metrics.Metrics
+
+	// Datagrams larger than udpMTU are fragmented, see protocol.Message.Fragment
+	udpMTU            int
+	reassemblyTimeout time.Duration
+	reassembler       *protocol.Reassembler
+
+	// Capability announcements, enabled by WithMulticastGroup
+	multicastGroup string
+	group          *net.UDPAddr
+
+	// WebSocket transport, enabled by WithWebSocket
+	ws *WSServer
+
+	// QUIC transport, enabled by WithQUIC
+	quic *QUICServer
+
+	// SCTP transport, enabled by WithSCTP
+	sctp *SCTPServer
+
+	// Message trace logging, enabled by WithLoggingTransport
+	logging *LoggingTransport
+
+	// Liveness and readiness probes, enabled by WithHTTPHealth
+	health    *healthServer
+	accepting atomic.Bool
+
+	// mDNS advertisement, enabled by WithAdvertiser
+	instance      string
+	discoveryOpts []discovery.Option
+	advertiser    *discovery.Advertiser
+
+	conns sync.Map // net.Conn -> *trackedConn, connections past the handshake
+	open  sync.Map // net.Conn -> struct{}, every open stream connection
+
+	lastSeen sync.Map // net.Conn -> time.Time, when each open connection last sent a message
+
+	// Set once Stop starts draining; guards read deadlines against being re-armed
+	drainMu  sync.RWMutex
+	draining bool
+
+	activeConns       atomic.Int64 // open stream connections and SCTP associations
+	activeUDPSessions atomic.Int64 // UDP datagrams being handled
+}
+
+// trackedConn is an open TCP connection the server can push messages to
+type trackedConn struct {
+	net.Conn
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+	batch        *WriteBatcher // nil without write batching
+}
+
+// writeFrame writes a serialized message without interleaving with other
+// writers, through the batcher when there is one
+func (c *trackedConn) writeFrame(data []byte) error {
+	if c.batch != nil {
+		_, err := c.batch.Write(data)
+		return err
+	}
+	return c.writeNow(data)
+}
+
+// writeNow writes data to the connection under a fresh write deadline
+func (c *trackedConn) writeNow(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.Write(data)
+	return err
+}
+
+// Default time a TCP connection may sit without traffic before it is closed
+const defaultMaxIdleTime = 30 * time.Second
+
+// Default time Stop waits for in-flight messages
+const defaultDrainTimeout = 10 * time.Second
+
+// Default time a peer has to finish sending a message it has started
+const defaultMessageTimeout = 10 * time.Second
+
+// Option configures optional Server behaviour
+type Option func(*Server)
+
+// WithTLS serves TCP connections over TLS using cfg
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.TLSConfig = cfg
+	}
+}
+
+// WithDrainTimeout sets how long Stop waits for in-flight messages
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.DrainTimeout = d
+	}
+}
+
+// WithMessageTimeout sets how long a peer has to finish sending a message
+// once its header has arrived
+func WithMessageTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.MessageTimeout = d
+	}
+}
+
+// WithUDPMTU fragments UDP responses and announcements larger than mtu bytes
+func WithUDPMTU(mtu int) Option {
+	return func(s *Server) {
+		s.udpMTU = mtu
+	}
+}
+
+// WithReassemblyTimeout sets how long fragments of an incomplete UDP message
+// are kept waiting for the rest
+func WithReassemblyTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.reassemblyTimeout = d
+	}
+}
+
+// WithRateLimiter throttles TCP messages through limiter before they are handled
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(s *Server) {
+		s.limiter = limiter
+	}
+}
+
+// WithMaxIdleTime closes TCP connections that send nothing for d. The
+// period is extended to MaxIdleTimeout and to the heartbeat round when those
+// are longer.
+func WithMaxIdleTime(d time.Duration) Option {
+	return func(s *Server) {
+		s.maxIdleTime = d
+	}
+}
+
+// WithLogger sends server logs to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithMetrics records message counts and open connections on reg
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(s *Server) {
+		s.metrics = metrics.For(reg)
+	}
+}
+
+// WithAdvertiser announces the server over mDNS as instance once it is listening
+func WithAdvertiser(instance string, opts ...discovery.Option) Option {
+	return func(s *Server) {
+		s.instance = instance
+		s.discoveryOpts = opts
+	}
 }
 
 // NewServer creates a new ARN server
-func NewServer(tcpAddr, udpAddr string, handler *protocol.Handler) *Server {
+func NewServer(tcpAddr, udpAddr string, handler *protocol.Handler, opts ...Option) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Server{
-		tcpAddr: tcpAddr,
-		udpAddr: udpAddr,
-		handler: handler,
-		ctx:     ctx,
-		cancel:  cancel,
+	s := &Server{
+		tcpAddr:     tcpAddr,
+		udpAddr:     udpAddr,
+		handler:     handler,
+		ctx:         ctx,
+		cancel:      cancel,
+		maxIdleTime: defaultMaxIdleTime,
+		logger:      slog.Default(),
+
+		udpMTU:            protocol.DefaultMTU,
+		reassemblyTimeout: protocol.DefaultReassemblyTimeout,
+
+		DrainTimeout:   defaultDrainTimeout,
+		MessageTimeout: defaultMessageTimeout,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.reassembler = protocol.NewReassembler(s.reassemblyTimeout)
+	if s.logging != nil {
+		logging := *s.logging
+		if logging.Logger == nil {
+			logging.Logger = s.logger
+		}
+		handler.Use(logging.Middleware())
+	}
+
+	handler.SetBroadcaster(s)
+	if s.multicastGroup != "" {
+		handler.SetAnnouncer(s)
+	}
+	return s
 }
 
 // Start begins listening for connections
 func (s *Server) Start() error {
-	// Start TCP listener
-	tcpListener, err := net.Listen("tcp", s.tcpAddr)
+	// Start TCP listeners
+	tcpListeners, err := s.listenTCP()
 	if err != nil {
 		return fmt.Errorf("failed to start TCP listener: %w", err)
 	}
-	s.tcpListener = tcpListener
-
-	// Start UDP listener
-	udpAddr, err := net.ResolveUDPAddr("udp", s.udpAddr)
-	if err != nil {
-		return fmt.Errorf("failed to resolve UDP address: %w", err)
+	// The PROXY header comes before the TLS handshake
+	if s.proxyProtocol {
+		for i, l := range tcpListeners {
+			tcpListeners[i] = &proxyListener{Listener: l}
+		}
+	}
+	if s.TLSConfig != nil {
+		for i, l := range tcpListeners {
+			tcpListeners[i] = tls.NewListener(l, s.TLSConfig)
+		}
 	}
+	s.tcpListeners = tcpListeners
+	s.tcpListener = tcpListeners[0]
 
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	// Start UDP listeners
+	udpConns, err := s.listenUDP()
 	if err != nil {
-		s.tcpListener.Close()
+		for _, l := range s.tcpListeners {
+			l.Close()
+		}
 		return fmt.Errorf("failed to start UDP listener: %w", err)
 	}
-	s.udpConn = udpConn
+	s.udpConns = udpConns
+	s.udpConn = udpConns[0]
+
+	// Start UNIX socket listener if configured
+	if s.UnixAddr != "" {
+		// Never serve the socket without the credential checks asked for
+		if s.unixCredAuth != nil && !unixCredSupported {
+			s.closeListeners()
+			return fmt.Errorf("failed to start UNIX socket listener: %w", errUnixCredUnsupported)
+		}
+		l, err := listenUnix(s.UnixAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to start UNIX socket listener: %w", err)
+		}
+		s.unixListener = l
+	}
+
+	// Join the multicast group if configured
+	if s.multicastGroup != "" {
+		if err := s.joinMulticastGroup(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	// Start WebSocket listener if configured
+	if s.ws != nil {
+		if err := s.ws.start(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	// Start QUIC listener if configured
+	if s.quic != nil {
+		if err := s.quic.Start(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	// Start SCTP listener if configured, carrying on without it where the
+	// platform has no SCTP support
+	if s.sctp != nil {
+		if err := s.sctp.start(); errors.Is(err, ErrSCTPUnavailable) {
+			s.logger.Warn("Serving without SCTP", "error", err)
+		} else if err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	// Start health probes if configured
+	if s.health != nil {
+		if err := s.health.start(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	// Announce the bound addresses so ephemeral ports are advertised correctly
+	if s.instance != "" {
+		s.advertiser = discovery.NewAdvertiser(s.instance, s.TCPAddr().String(), s.UDPAddr().String(), s.discoveryOpts...)
+		if err := s.advertiser.Start(); err != nil {
+			s.advertiser = nil
+			s.closeListeners()
+			return fmt.Errorf("failed to start mDNS advertiser: %w", err)
+		}
+	}
 
 	// Start handlers
-	s.wg.Add(2)
-	go s.handleTCP()
-	go s.handleUDP()
+	for _, l := range s.tcpListeners {
+		s.wg.Add(1)
+		go s.handleTCP(l)
+	}
+	for _, conn := range s.udpConns {
+		s.wg.Add(1)
+		go s.handleUDP(conn)
+	}
+	if s.unixListener != nil {
+		s.wg.Add(1)
+		go s.handleUnix(s.unixListener)
+	}
 
-	log.Printf("ARN server listening on TCP %s and UDP %s", s.tcpAddr, s.udpAddr)
+	// The janitor stops with s.ctx rather than s.wg, which Stop drains first
+	if s.MaxIdleTimeout > 0 {
+		go s.reapIdle()
+	}
+
+	attrs := []any{"tcp", s.TCPAddrs(), "udp", s.UDPAddrs()}
+	if s.unixListener != nil {
+		attrs = append(attrs, "unix", s.UnixAddr)
+	}
+	if s.ws != nil {
+		attrs = append(attrs, "ws", s.ws.Addr())
+	}
+	if s.quic != nil {
+		attrs = append(attrs, "quic", s.quic.Addr())
+	}
+	if addr := s.SCTPAddr(); addr != nil {
+		attrs = append(attrs, "sctp", addr)
+	}
+	if s.health != nil {
+		attrs = append(attrs, "health", s.health.listener.Addr())
+	}
+	s.accepting.Store(true)
+	s.logger.Info("ARN server listening", attrs...)
 	return nil
 }
 
-// Stop gracefully shuts down the server
+// closeListeners releases everything Start opened when a later step fails
+func (s *Server) closeListeners() {
+	for _, l := range s.tcpListeners {
+		l.Close()
+	}
+	for _, conn := range s.udpConns {
+		conn.Close()
+	}
+	if s.unixListener != nil {
+		s.unixListener.Close()
+	}
+	if s.ws != nil {
+		s.ws.stop()
+	}
+	if s.quic != nil {
+		s.quic.Stop()
+	}
+	if s.sctp != nil {
+		s.sctp.stop()
+	}
+	if s.health != nil {
+		s.health.stop()
+	}
+	s.wg.Wait()
+}
+
+// Stop gracefully shuts down the server. It stops accepting connections,
+// lets sessions finish the messages they have already received for up to
+// DrainTimeout, then closes whatever is still open.
 func (s *Server) Stop() error {
-	s.cancel()
+	s.accepting.Store(false)
+
+	if s.advertiser != nil {
+		if err := s.advertiser.Stop(); err != nil {
+			s.logger.Error("Failed to stop mDNS advertiser", "error", err)
+		}
+	}
 
-	if s.tcpListener != nil {
-		if err := s.tcpListener.Close(); err != nil {
+	for _, l := range s.tcpListeners {
+		if err := l.Close(); err != nil {
 			return fmt.Errorf("failed to close TCP listener: %w", err)
 		}
 	}
 
-	if s.udpConn != nil {
-		if err := s.udpConn.Close(); err != nil {
-			return fmt.Errorf("failed to close UDP connection: %w", err)
+	// Closing the UNIX listener also removes its socket file
+	if s.unixListener != nil {
+		if err := s.unixListener.Close(); err != nil {
+			return fmt.Errorf("failed to close UNIX socket listener: %w", err)
 		}
 	}
 
-	s.wg.Wait()
+	if s.ws != nil {
+		if err := s.ws.stop(); err != nil {
+			return fmt.Errorf("failed to close WebSocket listener: %w", err)
+		}
+	}
+
+	if s.quic != nil {
+		if err := s.quic.Stop(); err != nil {
+			return fmt.Errorf("failed to close QUIC listener: %w", err)
+		}
+	}
+
+	if s.sctp != nil {
+		if err := s.sctp.stop(); err != nil {
+			return fmt.Errorf("failed to close SCTP listener: %w", err)
+		}
+	}
+
+	if s.health != nil {
+		if err := s.health.stop(); err != nil {
+			return fmt.Errorf("failed to close health listener: %w", err)
+		}
+	}
+
+	s.drain()
+	s.cancel()
+
+	for _, conn := range s.udpConns {
+		if err := conn.Close(); err != nil {
+			return fmt.Errorf("failed to close UDP connection: %w", err)
+		}
+	}
 	return nil
 }
 
-func (s *Server) handleTCP() {
+// drain stops sessions reading new messages and waits for the ones already
+// received to be answered. Connections still open after DrainTimeout are closed.
+func (s *Server) drain() {
+	s.drainMu.Lock()
+	s.draining = true
+	s.drainMu.Unlock()
+
+	// Wake every blocked reader; armReadDeadline will not push these back out
+	now := time.Now()
+	s.open.Range(func(key, _ any) bool {
+		key.(net.Conn).SetReadDeadline(now)
+		return true
+	})
+	for _, conn := range s.udpConns {
+		conn.SetReadDeadline(now)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(s.DrainTimeout):
+	}
+
+	s.logger.Warn("Drain timed out, closing remaining connections")
+	s.cancel()
+	s.open.Range(func(key, _ any) bool {
+		key.(net.Conn).Close()
+		return true
+	})
+	<-done
+}
+
+// isDraining reports whether Stop has begun draining sessions
+func (s *Server) isDraining() bool {
+	s.drainMu.RLock()
+	defer s.drainMu.RUnlock()
+	return s.draining
+}
+
+// armReadDeadline gives conn another idle period to send its next message.
+// It reports false once the server is draining, in which case no more
+// messages should be read.
+func (s *Server) armReadDeadline(conn net.Conn) bool {
+	s.drainMu.RLock()
+	defer s.drainMu.RUnlock()
+
+	if s.draining {
+		return false
+	}
+	conn.SetReadDeadline(time.Now().Add(s.idleLimit()))
+	return true
+}
+
+// idleLimit returns how long a stream connection may send nothing before
+// its read deadline expires. The WithMaxIdleTime period is stretched to
+// MaxIdleTimeout and to a full heartbeat round, so that neither the janitor
+// nor a peer answering slow Pings is cut short by it.
+func (s *Server) idleLimit() time.Duration {
+	limit := max(s.maxIdleTime, s.MaxIdleTimeout)
+	if s.HeartbeatInterval > 0 {
+		timeout := s.HeartbeatTimeout
+		if timeout <= 0 {
+			timeout = s.HeartbeatInterval
+		}
+		limit = max(limit, s.HeartbeatInterval+timeout)
+	}
+	return limit
+}
+
+// TCPAddr returns the address the TCP listener is bound to. With
+// WithDualStack this is the IPv4 listener, see TCPAddrs for both.
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcpListener == nil {
+		return nil
+	}
+	return s.tcpListener.Addr()
+}
+
+// TCPAddrs returns the address of every TCP listener
+func (s *Server) TCPAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.tcpListeners))
+	for _, l := range s.tcpListeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// WSAddr returns the address the WebSocket listener is bound to, or nil if
+// WebSocket is not enabled
+func (s *Server) WSAddr() net.Addr {
+	if s.ws == nil {
+		return nil
+	}
+	return s.ws.Addr()
+}
+
+// QUICAddr returns the address the QUIC listener is bound to, or nil if
+// WithQUIC was not used
+func (s *Server) QUICAddr() net.Addr {
+	if s.quic == nil {
+		return nil
+	}
+	return s.quic.Addr()
+}
+
+// UDPAddr returns the address the UDP socket is bound to. With
+// WithDualStack this is the IPv4 socket, see UDPAddrs for both.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udpConn == nil {
+		return nil
+	}
+	return s.udpConn.LocalAddr()
+}
+
+// UDPAddrs returns the address of every UDP socket
+func (s *Server) UDPAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.udpConns))
+	for _, conn := range s.udpConns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	return addrs
+}
+
+// HealthAddr returns the address the health probe listener is bound to, or nil
+// if WithHTTPHealth is not set
+func (s *Server) HealthAddr() net.Addr {
+	if s.health == nil || s.health.listener == nil {
+		return nil
+	}
+	return s.health.listener.Addr()
+}
+
+// ActiveConnections returns the number of open TCP and WebSocket connections
+func (s *Server) ActiveConnections() int64 {
+	return s.activeConns.Load()
+}
+
+// ActiveUDPSessions returns the number of UDP datagrams currently being handled.
+// UDP has no connections, so each request counts as a session until it is answered.
+func (s *Server) ActiveUDPSessions() int64 {
+	return s.activeUDPSessions.Load()
+}
+
+func (s *Server) handleTCP(listener net.Listener) {
 	defer s.wg.Done()
 
 	for {
@@ -94,75 +663,452 @@ func (s *Server) handleTCP() {
 		case <-s.ctx.Done():
 			return
 		default:
-			conn, err := s.tcpListener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
-				if s.ctx.Err() != nil {
+				if s.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
 					return // Server is shutting down
 				}
-				log.Printf("Failed to accept TCP connection: %v", err)
+				s.logger.Error("Failed to accept TCP connection", "error", err)
 				continue
 			}
 
 			s.wg.Add(1)
-			go s.handleTCPConnection(conn)
+			go s.serveConn(conn, "tcp")
 		}
 	}
 }
 
-func (s *Server) handleTCPConnection(conn net.Conn) {
+// serveConn runs an ARN session over a stream connection accepted by any of
+// the server's transports
+func (s *Server) serveConn(conn net.Conn, transport string) {
 	defer s.wg.Done()
+	var cred *Ucred
+	if uc, ok := conn.(*unixConn); ok {
+		cred = uc.cred
+	}
+	if s.bandwidthLimit > 0 {
+		conn = NewThrottledConn(conn, s.bandwidthLimit)
+	}
 	defer conn.Close()
 
-	// Set reasonable timeouts
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
-
-	// Read message header (version + type + size = 6 bytes)
-	header := make([]byte, 6)
-	if _, err := conn.Read(header); err != nil {
-		log.Printf("Failed to read TCP header: %v", err)
+	n := s.activeConns.Add(1)
+	defer s.activeConns.Add(-1)
+	if s.overConnectionLimit(n) {
+		s.refuseConn(conn, transport)
 		return
 	}
 
-	// Parse message
-	msg, err := protocol.Deserialize(header)
+	s.open.Store(conn, struct{}{})
+	defer s.open.Delete(conn)
+
+	s.touch(conn)
+	defer s.lastSeen.Delete(conn)
+
+	s.metrics.ConnectionOpened(transport)
+	defer s.metrics.ConnectionClosed(transport)
+
+	log := s.logger.With("transport", transport, "peer", conn.RemoteAddr())
+
+	// Unblock pending reads when the server shuts down
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// Every session must open with a handshake
+	conn.SetWriteDeadline(time.Now().Add(s.maxIdleTime))
+	if !s.armReadDeadline(conn) {
+		return
+	}
+	offer, identity, err := s.handshake(conn, transport)
+	if err != nil {
+		if !s.isDraining() {
+			log.Error("Handshake failed", "error", err)
+		}
+		return
+	}
+	tenant, err := s.handler.SessionTenant(offer, identity)
 	if err != nil {
-		log.Printf("Failed to deserialize TCP message: %v", err)
+		log.Warn("Refused tenant", "error", err)
+		if response, err := protocol.NewErrorMessage(protocol.ErrForbidden, err.Error()); err == nil {
+			WriteMessage(conn, response)
+		}
 		return
 	}
+	s.touch(conn)
+
+	// Only established sessions receive broadcasts
+	tc := &trackedConn{Conn: conn, writeTimeout: s.maxIdleTime}
+	if s.FlushDelay > 0 {
+		tc.batch = NewWriteBatcher(writerFunc(tc.writeNow), s.FlushDelay, s.FlushThreshold)
+		defer tc.batch.Flush()
+	}
+	s.conns.Store(conn, tc)
+	defer s.conns.Delete(conn)
+
+	// The session ID scopes what the connection registers to it alone
+	ctx := protocol.ContextWithPeerAddr(s.ctx, conn.RemoteAddr())
+	ctx = protocol.ContextWithSessionID(ctx, rand.Text())
+	if offer.PeerID != "" {
+		ctx = protocol.ContextWithPeerID(ctx, offer.PeerID)
+	}
+	if tenant != "" {
+		ctx = protocol.ContextWithTenantID(ctx, tenant)
+	}
+	if identity != nil {
+		ctx = security.ContextWithPeerIdentity(ctx, identity)
+	}
+	if cred != nil {
+		ctx = ContextWithUcred(ctx, *cred)
+	}
+	if s.HeartbeatInterval > 0 {
+		go s.heartbeat(ctx, tc, done, log)
+	}
+
+	// Messages are handled by a worker in priority order, so urgent traffic
+	// is not stuck behind a backlog of stream data
+	queue := newPriorityQueue(maxQueuedMessages)
+	var worker sync.WaitGroup
+	worker.Add(1)
+	go func() {
+		defer worker.Done()
+		defer queue.close()
+		s.work(ctx, tc, queue, log)
+	}()
+	defer worker.Wait()
+	defer queue.close()
 
-	// Read payload if present
-	if msg.PayloadSize > 0 {
-		payload := make([]byte, msg.PayloadSize)
-		if _, err := conn.Read(payload); err != nil {
-			log.Printf("Failed to read TCP payload: %v", err)
+	// Read messages until the peer hangs up, goes idle or the server drains.
+	// The handshake was read unbuffered, so nothing is lost to this buffer.
+	reader := bufio.NewReader(conn)
+	for {
+		if !s.armReadDeadline(conn) {
 			return
 		}
-		msg.Payload = payload
+
+		msg, err := readMessage(reader, func() {
+			if s.MessageTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(s.MessageTimeout))
+			}
+		})
+		if err != nil {
+			if !isClosedError(err) && !s.isDraining() {
+				log.Error("Failed to read message", "error", err)
+			}
+			return
+		}
+
+		s.metrics.MessageReceived(fmt.Sprint(msg.Type), transport)
+		s.touch(conn)
+
+		// Pongs answer the server's heartbeat; being heard from is all they do
+		if msg.Type == protocol.Pong {
+			continue
+		}
+		if !queue.push(msg) {
+			return // Worker stopped
+		}
 	}
+}
 
-	// Handle message
-	response, err := s.handler.HandleMessage(s.ctx, msg)
-	if err != nil {
-		log.Printf("Failed to handle TCP message: %v", err)
-		return
+// work handles queued messages and writes their responses until the queue
+// closes or the connection fails
+func (s *Server) work(ctx context.Context, tc *trackedConn, queue *priorityQueue, log *slog.Logger) {
+	// With a worker pool, messages are handed to the pool and their responses
+	// written as they complete, so they may come back out of order
+	var replies chan protocol.Result
+	var pending sync.WaitGroup
+	if s.handler.Workers() > 0 {
+		replies = make(chan protocol.Result, maxQueuedMessages)
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			s.writeReplies(tc, replies, &pending, log)
+		}()
+		defer func() {
+			pending.Wait()
+			close(replies)
+			<-written
+		}()
 	}
 
-	// Send response if any
-	if response != nil {
-		data, err := response.Serialize()
-		if err != nil {
-			log.Printf("Failed to serialize TCP response: %v", err)
+	for {
+		msg, ok := queue.pop()
+		if !ok {
 			return
 		}
 
-		if _, err := conn.Write(data); err != nil {
-			log.Printf("Failed to write TCP response: %v", err)
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx); err != nil {
+				log.Error("Rate limiter aborted message", "error", err)
+				tc.Close()
+				return
+			}
+		}
+
+		if replies != nil && msg.Type != protocol.BatchMessage {
+			pending.Add(1)
+			if err := s.handler.Dispatch(ctx, msg, replies); err != nil {
+				pending.Done()
+				log.Error("Failed to dispatch message", "error", err)
+				tc.Close()
+				return
+			}
+			continue
+		}
+
+		// Handle message
+		// Handler failures are logged by the handler itself
+		var response *protocol.Message
+		var err error
+		if msg.Type == protocol.BatchMessage {
+			response, err = s.handleBatch(ctx, msg, log)
+		} else {
+			response, err = s.handler.HandleMessage(ctx, msg)
+		}
+		if err != nil || response == nil {
+			continue
+		}
+		if !s.respond(tc, msg, response, log) {
+			tc.Close()
 			return
 		}
 	}
 }
 
-func (s *Server) handleUDP() {
+// writeReplies writes the responses dispatched to the worker pool until
+// replies closes. After a failed write the rest are drained unwritten so
+// workers are never left blocked.
+func (s *Server) writeReplies(tc *trackedConn, replies <-chan protocol.Result, pending *sync.WaitGroup, log *slog.Logger) {
+	failed := false
+	for result := range replies {
+		// Handler failures are logged by the handler itself
+		if !failed && result.Err == nil && result.Response != nil {
+			if !s.respond(tc, result.Request, result.Response, log) {
+				tc.Close()
+				failed = true
+			}
+		}
+		pending.Done()
+	}
+}
+
+// respond writes the response to msg, reporting false if the connection failed
+func (s *Server) respond(tc *trackedConn, msg, response *protocol.Message, log *slog.Logger) bool {
+	mirrorCompression(msg, response)
+	data, err := response.Serialize()
+	if err != nil {
+		log.Error("Failed to serialize response", "error", err)
+		return true
+	}
+	if err := tc.writeFrame(data); err != nil {
+		log.Error("Failed to write response", "error", err)
+		return false
+	}
+	return true
+}
+
+// handleBatch handles each message packed into a BatchMessage and packs
+// their responses into a BatchResponse. Messages that fail or have no
+// response contribute nothing, so responses are in order but may be fewer.
+func (s *Server) handleBatch(ctx context.Context, batch *protocol.Message, log *slog.Logger) (*protocol.Message, error) {
+	var response *protocol.Message
+	msgs, err := protocol.Unbatch(batch)
+	if err != nil {
+		log.Error("Invalid batch", "error", err)
+		response, err = protocol.NewErrorMessage(protocol.ErrInvalidPayload, err.Error())
+	} else {
+		responses := make([]*protocol.Message, 0, len(msgs))
+		for _, msg := range msgs {
+			inner, err := s.handler.HandleMessage(ctx, msg)
+			if err != nil || inner == nil {
+				continue
+			}
+			mirrorCompression(msg, inner)
+			responses = append(responses, inner)
+		}
+		response, err = protocol.NewBatchResponse(responses)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if batch.HasCorrelationID() {
+		response.Version = protocol.V2
+		response.CorrelationID = batch.CorrelationID
+	}
+	return response, nil
+}
+
+// Broadcast pushes msg to every established TCP connection.
+// Connections that fail the write are closed and dropped.
+func (s *Server) Broadcast(msg *protocol.Message) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize broadcast: %w", err)
+	}
+
+	s.conns.Range(func(key, value any) bool {
+		tc := value.(*trackedConn)
+		if err := tc.writeFrame(data); err != nil {
+			s.logger.Error("Dropping TCP connection after failed broadcast", "peer", tc.RemoteAddr(), "error", err)
+			s.conns.Delete(key)
+			tc.Close()
+		}
+		return true
+	})
+	return nil
+}
+
+// isClosedError reports whether err means the connection ended normally or went idle
+func isClosedError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// handshake negotiates the protocol version and features before any other
+// traffic and returns what the peer offered
+func (s *Server) handshake(conn net.Conn, transport string) (*protocol.HandshakePayload, *security.PeerIdentity, error) {
+	msg, err := ReadMessage(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.metrics.MessageReceived(fmt.Sprint(msg.Type), transport)
+
+	var response *protocol.Message
+	if msg.Type != protocol.Handshake {
+		response, err = protocol.NewErrorMessage(protocol.ErrInvalidMessageType, "handshake required")
+	} else {
+		response, err = s.handler.HandleHandshake(msg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := WriteMessage(conn, response); err != nil {
+		return nil, nil, err
+	}
+
+	if response.Type == protocol.Error {
+		return nil, nil, fmt.Errorf("peer sent %v before handshake completed", msg.Type)
+	}
+
+	// HandleHandshake already validated the payload
+	var offer protocol.HandshakePayload
+	if err := json.Unmarshal(msg.Payload, &offer); err != nil {
+		return nil, nil, fmt.Errorf("invalid handshake payload: %w", err)
+	}
+	var agreed protocol.HandshakePayload
+	if err := json.Unmarshal(response.Payload, &agreed); err != nil {
+		return nil, nil, fmt.Errorf("invalid handshake response: %w", err)
+	}
+	if len(agreed.Challenge) == 0 {
+		return &offer, nil, nil
+	}
+
+	// The peer asked to prove its identity and was challenged
+	identity, err := s.proveIdentity(conn, transport, offer.PeerID, agreed.Challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &offer, identity, nil
+}
+
+// proveIdentity reads the peer's answer to its handshake challenge and
+// returns the identity it proved
+func (s *Server) proveIdentity(conn net.Conn, transport, peerID string, challenge []byte) (*security.PeerIdentity, error) {
+	msg, err := ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	s.metrics.MessageReceived(fmt.Sprint(msg.Type), transport)
+
+	identity, response, err := s.handler.VerifyHandshakeProof(peerID, challenge, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteMessage(conn, response); err != nil {
+		return nil, err
+	}
+
+	if identity == nil {
+		return nil, fmt.Errorf("peer %s failed to prove its identity", peerID)
+	}
+	return identity, nil
+}
+
+// mirrorCompression answers compressed requests with compressed responses,
+// since a peer only compresses once the feature has been negotiated
+func mirrorCompression(request, response *protocol.Message) {
+	if !request.Compressed {
+		return
+	}
+
+	if response.Version < request.Version {
+		response.Version = request.Version
+	}
+	response.Compressed = true
+	response.CompressionCodec = request.CompressionCodec
+}
+
+// ReadMessage reads one complete ARN frame from r
+func ReadMessage(r io.Reader) (*protocol.Message, error) {
+	return readMessage(r, nil)
+}
+
+// readMessage reads one frame, calling onHeader once the header has arrived
+// and before the rest of the frame is read
+func readMessage(r io.Reader, onHeader func()) (*protocol.Message, error) {
+	if onHeader != nil {
+		r = &headerNotifier{r: r, remaining: frameHeaderSize, onHeader: onHeader}
+	}
+	return protocol.DeserializeFrom(r)
+}
+
+// Size of the shortest frame header: version, type and a one-byte V2
+// payload size. Every frame has started arriving once this much is read.
+const frameHeaderSize = 3
+
+// headerNotifier calls onHeader once the first remaining bytes have been read
+type headerNotifier struct {
+	r         io.Reader
+	remaining int
+	onHeader  func()
+}
+
+func (h *headerNotifier) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if h.remaining > 0 {
+		h.remaining -= n
+		if h.remaining <= 0 {
+			h.onHeader()
+		}
+	}
+	return n, err
+}
+
+// WriteMessage serializes msg and writes it to w
+func WriteMessage(w io.Writer, msg *protocol.Message) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleUDP(conn *net.UDPConn) {
 	defer s.wg.Done()
 
 	buffer := make([]byte, 65535) // Maximum UDP packet size
@@ -172,50 +1118,84 @@ func (s *Server) handleUDP() {
 		case <-s.ctx.Done():
 			return
 		default:
-			n, addr, err := s.udpConn.ReadFromUDP(buffer)
+			n, addr, err := conn.ReadFromUDP(buffer)
 			if err != nil {
-				if s.ctx.Err() != nil {
+				if s.ctx.Err() != nil || s.isDraining() {
 					return // Server is shutting down
 				}
-				log.Printf("Failed to read UDP packet: %v", err)
+				s.logger.Error("Failed to read UDP packet", "error", err)
 				continue
 			}
 
-			// Handle packet in a goroutine
+			// Handle packet in a goroutine, on its own copy since the buffer is reused
+			packet := make([]byte, n)
+			copy(packet, buffer[:n])
+
+			// Hold fragments back until the whole message has arrived
+			if protocol.IsFragment(packet) {
+				packet, err = s.reassembler.Add(addr.String(), packet)
+				if err != nil {
+					s.logger.Warn("Dropped UDP fragment", "peer", addr, "error", err)
+					continue
+				}
+				if packet == nil {
+					continue
+				}
+			}
+
 			s.wg.Add(1)
-			go s.handleUDPPacket(buffer[:n], addr)
+			go s.handleUDPPacket(conn, packet, addr)
 		}
 	}
 }
 
-func (s *Server) handleUDPPacket(data []byte, addr *net.UDPAddr) {
+// handleUDPPacket answers a datagram on conn, the socket it arrived on
+func (s *Server) handleUDPPacket(conn *net.UDPConn, data []byte, addr *net.UDPAddr) {
 	defer s.wg.Done()
 
+	s.activeUDPSessions.Add(1)
+	defer s.activeUDPSessions.Add(-1)
+
 	// Parse message
 	msg, err := protocol.Deserialize(data)
 	if err != nil {
-		log.Printf("Failed to deserialize UDP message: %v", err)
+		s.logger.Error("Failed to deserialize UDP message", "peer", addr, "error", err)
 		return
 	}
+	if s.isOwnAnnouncement(msg, addr) {
+		return
+	}
+	s.metrics.MessageReceived(fmt.Sprint(msg.Type), "udp")
 
 	// Handle message
-	response, err := s.handler.HandleMessage(s.ctx, msg)
+	// Handler failures are logged by the handler itself
+	ctx := protocol.ContextWithPeerAddr(s.ctx, addr)
+	response, err := s.handler.HandleMessage(ctx, msg)
 	if err != nil {
-		log.Printf("Failed to handle UDP message: %v", err)
 		return
 	}
 
 	// Send response if any
 	if response != nil {
-		data, err := response.Serialize()
-		if err != nil {
-			log.Printf("Failed to serialize UDP response: %v", err)
+		mirrorCompression(msg, response)
+		if err := s.writeUDP(conn, response, addr); err != nil {
+			s.logger.Error("Failed to write UDP response", "peer", addr, "error", err)
 			return
 		}
+	}
+}
 
-		if _, err := s.udpConn.WriteToUDP(data, addr); err != nil {
-			log.Printf("Failed to write UDP response: %v", err)
-			return
+// writeUDP sends msg to addr over conn, fragmenting it when it exceeds the MTU
+func (s *Server) writeUDP(conn *net.UDPConn, msg *protocol.Message, addr *net.UDPAddr) error {
+	fragments, err := msg.Fragment(s.udpMTU)
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	for _, data := range fragments {
+		if _, err := conn.WriteToUDP(data, addr); err != nil {
+			return err
 		}
 	}
+	return nil
 }
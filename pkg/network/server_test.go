@@ -1,30 +1,34 @@
 package network
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/heathweaver/arn-protocol/pkg/discovery"
+	"github.com/heathweaver/arn-protocol/pkg/events"
 	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestTCPServer(t *testing.T) {
-	// Create handler with test callbacks
-	messageReceived := make(chan *protocol.Message, 1)
+	// Create handler with a test subscriber
 	bridgeReceived := make(chan *protocol.MCPBridge, 1)
 
-	handler := protocol.NewHandler(
-		func(msg *protocol.Message) error {
-			messageReceived <- msg
-			return nil
-		},
-		func(bridge *protocol.MCPBridge) error {
-			bridgeReceived <- bridge
-			return nil
-		},
-	)
+	handler := protocol.NewHandler()
+	handler.Events().Subscribe(events.BridgeAdvertised, func(event interface{}) {
+		bridgeReceived <- event.(*protocol.MCPBridge)
+	})
 
 	// Start server
 	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
@@ -72,9 +76,10 @@ func TestTCPServer(t *testing.T) {
 				Version: protocol.V1,
 				Type:    protocol.MCPBridgeAdvertise,
 				Payload: mustMarshal(t, &protocol.MCPBridge{
-					ID:       "test-bridge",
-					Endpoint: "mcp://test.endpoint",
-					Protocol: "MCP/1.0",
+					ID:        "test-bridge",
+					Endpoint:  "mcp://test.endpoint",
+					Protocol:  "MCP/1.0",
+					Metadata:  map[string]string{"auth_type": "none", "data_format": "json"},
 					DataTypes: []string{"test_data"},
 				}),
 				Timestamp: time.Now(),
@@ -92,38 +97,22 @@ func TestTCPServer(t *testing.T) {
 			}
 			defer conn.Close()
 
-			// Send message
-			data, err := tt.message.Serialize()
-			if err != nil {
-				t.Fatalf("Failed to serialize message: %v", err)
-			}
+			handshake(t, conn)
 
-			if _, err := conn.Write(data); err != nil {
+			// Send message
+			if err := WriteMessage(conn, tt.message); err != nil {
 				t.Fatalf("Failed to send message: %v", err)
 			}
 
 			// Read response
-			header := make([]byte, 6)
-			if _, err := conn.Read(header); err != nil {
+			response, err := ReadMessage(conn)
+			if err != nil {
 				if !tt.wantErr {
-					t.Errorf("Failed to read response header: %v", err)
+					t.Errorf("Failed to read response: %v", err)
 				}
 				return
 			}
 
-			response, err := protocol.Deserialize(header)
-			if err != nil {
-				t.Fatalf("Failed to deserialize response: %v", err)
-			}
-
-			if response.PayloadSize > 0 {
-				payload := make([]byte, response.PayloadSize)
-				if _, err := conn.Read(payload); err != nil {
-					t.Fatalf("Failed to read response payload: %v", err)
-				}
-				response.Payload = payload
-			}
-
 			// Verify response
 			switch tt.message.Type {
 			case protocol.Hello:
@@ -153,9 +142,193 @@ func TestTCPServer(t *testing.T) {
 	}
 }
 
+func TestTCPHandshakeRequired(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	// Skip the handshake and go straight to a hello
+	msg := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Hello,
+		Timestamp: time.Now(),
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	response, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	if response.Type != protocol.Error {
+		t.Errorf("Expected Error response, got %v", response.Type)
+	}
+}
+
+func TestTenantRequiresIdentity(t *testing.T) {
+	keyring, err := security.NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(protocol.WithKeyring(keyring)))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	defer server.Handler().Close()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	// Claiming a tenant without proving an identity granted it is refused
+	response := send(t, conn, &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Handshake,
+		Payload:   mustMarshal(t, &protocol.HandshakePayload{MinVersion: protocol.V1, MaxVersion: protocol.V1, TenantID: "acme"}),
+		Timestamp: time.Now(),
+	})
+	if response.Type != protocol.Handshake {
+		t.Fatalf("Expected Handshake response, got %v", response.Type)
+	}
+	response, err = ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read refusal: %v", err)
+	}
+	var payload protocol.ErrorPayload
+	if response.Type != protocol.Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != protocol.ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v: %s", response.Type, response.Payload)
+	}
+	if _, err := ReadMessage(conn); !isClosedError(err) {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	handshake(t, conn)
+
+	// Several exchanges share the same connection
+	for i := 0; i < 3; i++ {
+		msg := &protocol.Message{
+			Version:   protocol.V1,
+			Type:      protocol.Hello,
+			Timestamp: time.Now(),
+		}
+		if err := WriteMessage(conn, msg); err != nil {
+			t.Fatalf("Failed to send message %d: %v", i, err)
+		}
+
+		response, err := ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("Failed to read response %d: %v", i, err)
+		}
+		if response.Type != protocol.Hello {
+			t.Errorf("Expected Hello response, got %v", response.Type)
+		}
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithMaxIdleTime(50*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	handshake(t, conn)
+
+	// Stay quiet and expect the server to hang up
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF from idle connection, got %v", err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	// Two peers with completed handshakes
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		handshake(t, conn)
+		conns = append(conns, conn)
+	}
+
+	// Make sure both sessions are registered before broadcasting
+	deadline := time.Now().Add(time.Second)
+	for countConns(server) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	msg := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.MCPBridgeDown,
+		Payload:   []byte(`{"id":"gone"}`),
+		Timestamp: time.Now(),
+	}
+	if err := server.Broadcast(msg); err != nil {
+		t.Fatalf("Broadcast() error = %v", err)
+	}
+
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		got, err := ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("Peer %d failed to read broadcast: %v", i, err)
+		}
+		if got.Type != protocol.MCPBridgeDown {
+			t.Errorf("Peer %d expected MCPBridgeDown, got %v", i, got.Type)
+		}
+	}
+}
+
 func TestUDPServer(t *testing.T) {
 	// Create handler
-	handler := protocol.NewHandler(nil, nil)
+	handler := protocol.NewHandler()
 
 	// Start server
 	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
@@ -185,8 +358,8 @@ func TestUDPServer(t *testing.T) {
 
 	msg := &protocol.Message{
 		Version:   protocol.V1,
-		Type:     protocol.Query,
-		Payload:  mustMarshal(t, query),
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, query),
 		Timestamp: time.Now(),
 	}
 
@@ -203,7 +376,7 @@ func TestUDPServer(t *testing.T) {
 	// Read response
 	buffer := make([]byte, 65535)
 	conn.SetReadDeadline(time.Now().Add(time.Second))
-	
+
 	n, err := conn.Read(buffer)
 	if err != nil {
 		t.Fatalf("Failed to read UDP response: %v", err)
@@ -220,7 +393,7 @@ func TestUDPServer(t *testing.T) {
 }
 
 func TestGracefulShutdown(t *testing.T) {
-	handler := protocol.NewHandler(nil, nil)
+	handler := protocol.NewHandler()
 	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
 
 	if err := server.Start(); err != nil {
@@ -258,6 +431,49 @@ func TestGracefulShutdown(t *testing.T) {
 	}
 }
 
+// countConns returns the number of established TCP sessions
+func countConns(s *Server) int {
+	n := 0
+	s.conns.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// handshake performs the opening handshake on a fresh TCP connection
+func handshake(t *testing.T, conn net.Conn) *protocol.HandshakePayload {
+	t.Helper()
+
+	msg := &protocol.Message{
+		Version: protocol.V1,
+		Type:    protocol.Handshake,
+		Payload: mustMarshal(t, &protocol.HandshakePayload{
+			MinVersion: protocol.V1,
+			MaxVersion: protocol.V2,
+			Features:   protocol.SupportedFeatures,
+		}),
+		Timestamp: time.Now(),
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+
+	response, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if response.Type != protocol.Handshake {
+		t.Fatalf("Expected Handshake response, got %v", response.Type)
+	}
+
+	var agreed protocol.HandshakePayload
+	if err := json.Unmarshal(response.Payload, &agreed); err != nil {
+		t.Fatalf("Failed to unmarshal handshake response: %v", err)
+	}
+	return &agreed
+}
+
 func mustMarshal(t *testing.T, v interface{}) []byte {
 	data, err := json.Marshal(v)
 	if err != nil {
@@ -265,3 +481,586 @@ func mustMarshal(t *testing.T, v interface{}) []byte {
 	}
 	return data
 }
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMessageLogging(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	handler := protocol.NewHandler(protocol.WithLogger(logger))
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithLogger(logger))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	handshake(t, conn)
+
+	msg := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Hello,
+		Timestamp: time.Now(),
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, err := ReadMessage(conn); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	// The handler sees the TCP peer the message arrived from
+	want := fmt.Sprintf(`"peer":"%s"`, conn.LocalAddr())
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Expected log to contain %s, got %q", want, buf.String())
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	handler := protocol.NewHandler(protocol.WithMetrics(reg))
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithMetrics(reg))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	handshake(t, conn)
+
+	msg := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Hello,
+		Timestamp: time.Now(),
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, err := ReadMessage(conn); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	expected := `
+# HELP arn_active_connections Open connections, by transport.
+# TYPE arn_active_connections gauge
+arn_active_connections{transport="tcp"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "arn_active_connections"); err != nil {
+		t.Error(err)
+	}
+
+	// Handshake plus hello
+	count, err := testutil.GatherAndCount(reg, "arn_messages_total")
+	if err != nil {
+		t.Fatalf("GatherAndCount() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 message series, got %d", count)
+	}
+
+	if count, _ := testutil.GatherAndCount(reg, "arn_message_duration_seconds"); count == 0 {
+		t.Error("Expected handler to record message duration")
+	}
+}
+
+func TestServerAdvertiser(t *testing.T) {
+	// Use the mDNS group on a private port to stay clear of real responders
+	reserve, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: reserve.LocalAddr().(*net.UDPAddr).Port}
+	reserve.Close()
+
+	found := make(chan string, 1)
+	discoverer := discovery.NewDiscoverer(func(addr string) {
+		found <- addr
+	}, discovery.WithGroup(group))
+	if err := discoverer.Start(); err != nil {
+		t.Skipf("Multicast unavailable: %v", err)
+	}
+	defer discoverer.Stop()
+
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler,
+		WithAdvertiser("test-node", discovery.WithGroup(group)))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	select {
+	case addr := <-found:
+		if addr != server.TCPAddr().String() {
+			t.Errorf("Expected %s, got %s", server.TCPAddr(), addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for server advertisement")
+	}
+}
+
+func TestMulticastQuery(t *testing.T) {
+	// The group shares the server's UDP port, as with the defaults
+	reserve, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	port := reserve.LocalAddr().(*net.UDPAddr).Port
+	reserve.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: port}
+
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", fmt.Sprintf("0.0.0.0:%d", port), handler, WithMulticastGroup(group.String()))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "mc-cap", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		t.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	query := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+		Timestamp: time.Now(),
+	}
+	data, err := query.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if _, err := conn.WriteToUDP(data, group); err != nil {
+		t.Skipf("Multicast unavailable: %v", err)
+	}
+
+	// The server answers a multicast query with a unicast reply
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("Failed to read query response: %v", err)
+		}
+		response, err := protocol.Deserialize(buf[:n])
+		if err != nil {
+			t.Fatalf("Deserialize() error = %v", err)
+		}
+		if response.Type != protocol.Response {
+			continue
+		}
+
+		var caps []protocol.Capability
+		if err := json.Unmarshal(response.Payload, &caps); err != nil {
+			t.Fatalf("Failed to unmarshal capabilities: %v", err)
+		}
+		if len(caps) != 1 || caps[0].ID != "mc-cap" {
+			t.Errorf("Expected [mc-cap], got %v", caps)
+		}
+		return
+	}
+}
+
+func TestActiveConnections(t *testing.T) {
+	release := make(chan struct{})
+	handler := protocol.NewHandler()
+	handler.Events().Subscribe(events.MessageReceived, func(event interface{}) {
+		<-release
+	})
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitFor := func(name string, get func() int64, want int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(time.Second)
+		for get() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d %s, got %d", want, name, get())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitFor("connections", server.ActiveConnections, 2)
+
+	conns[0].Close()
+	waitFor("connections", server.ActiveConnections, 1)
+
+	// A UDP request counts as a session until the handler returns
+	udp, err := net.Dial("udp", server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial UDP: %v", err)
+	}
+	defer udp.Close()
+
+	data, err := (&protocol.Message{Version: protocol.V1, Type: protocol.Response, Timestamp: time.Now()}).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if _, err := udp.Write(data); err != nil {
+		t.Fatalf("Failed to send UDP message: %v", err)
+	}
+	waitFor("UDP sessions", server.ActiveUDPSessions, 1)
+
+	close(release)
+	waitFor("UDP sessions", server.ActiveUDPSessions, 0)
+}
+
+func TestGracefulDrain(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		handling     time.Duration
+		wantResponse bool
+	}{
+		{"in-flight message answered", time.Second, 100 * time.Millisecond, true},
+		{"slow message cut off", 50 * time.Millisecond, 10 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan struct{})
+			handler := protocol.NewHandler()
+			handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+				close(received)
+				select {
+				case <-time.After(tt.handling):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				return next(ctx, msg)
+			})
+
+			server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithDrainTimeout(tt.drainTimeout))
+			if err := server.Start(); err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+
+			conn, err := net.Dial("tcp", server.TCPAddr().String())
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+			handshake(t, conn)
+
+			query := &protocol.Message{
+				Version:   protocol.V1,
+				Type:      protocol.Query,
+				Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+				Timestamp: time.Now(),
+			}
+			if err := WriteMessage(conn, query); err != nil {
+				t.Fatalf("Failed to send query: %v", err)
+			}
+			<-received
+
+			start := time.Now()
+			stopped := make(chan error, 1)
+			go func() { stopped <- server.Stop() }()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			response, err := ReadMessage(conn)
+			if tt.wantResponse {
+				if err != nil {
+					t.Fatalf("Expected in-flight response, got error %v", err)
+				}
+				if response.Type != protocol.Response {
+					t.Errorf("Expected Response, got %v", response.Type)
+				}
+			} else if err == nil {
+				t.Errorf("Expected connection to be closed, got %v", response.Type)
+			}
+
+			if err := <-stopped; err != nil {
+				t.Errorf("Stop() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > tt.drainTimeout+time.Second {
+				t.Errorf("Stop took %v with drain timeout %v", elapsed, tt.drainTimeout)
+			}
+		})
+	}
+}
+
+func TestMessageTimeout(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithMessageTimeout(100*time.Millisecond))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	// Send a header promising a body that never arrives
+	data, err := (&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+		Timestamp: time.Now(),
+	}).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if _, err := conn.Write(data[:6]); err != nil {
+		t.Fatalf("Failed to send header: %v", err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ReadMessage(conn); err == nil {
+		t.Fatal("Expected the server to close the stalled connection")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Stalled connection closed after %v, want about 100ms", elapsed)
+	}
+}
+
+func TestUDPFragmentation(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithUDPMTU(512))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("udp", server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+	defer conn.Close()
+
+	frags := protocol.NewReassembler(time.Second)
+	exchange := func(msg *protocol.Message) *protocol.Message {
+		t.Helper()
+
+		fragments, err := msg.Fragment(512)
+		if err != nil {
+			t.Fatalf("Fragment() error = %v", err)
+		}
+		for _, data := range fragments {
+			if _, err := conn.Write(data); err != nil {
+				t.Fatalf("Failed to send UDP message: %v", err)
+			}
+		}
+
+		buffer := make([]byte, 65535)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				t.Fatalf("Failed to read UDP response: %v", err)
+			}
+			if n > 512 {
+				t.Errorf("Response datagram of %d bytes exceeds the MTU", n)
+			}
+
+			data := buffer[:n]
+			if protocol.IsFragment(data) {
+				if data, err = frags.Add("server", data); err != nil || data == nil {
+					continue
+				}
+			}
+			response, err := protocol.Deserialize(data)
+			if err != nil {
+				t.Fatalf("Failed to deserialize UDP response: %v", err)
+			}
+			return response
+		}
+	}
+
+	// A capability far larger than one datagram
+	cap := &protocol.Capability{
+		ID:       "large-cap",
+		Type:     "DISCOVER",
+		Version:  "1.0.0",
+		Metadata: map[string]string{"description": strings.Repeat("x", 4000)},
+	}
+	response := exchange(&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.AICapabilityAdvertise,
+		Payload:   mustMarshal(t, cap),
+		Timestamp: time.Now(),
+	})
+	if response.Type != protocol.Response {
+		t.Fatalf("Expected Response, got %v: %s", response.Type, response.Payload)
+	}
+
+	response = exchange(&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Query,
+		Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+		Timestamp: time.Now(),
+	})
+	var caps []protocol.Capability
+	if err := response.DecodePayload(&caps); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if len(caps) != 1 || len(caps[0].Metadata["description"]) != 4000 {
+		t.Errorf("Expected the large capability back whole, got %d capabilities", len(caps))
+	}
+}
+
+func TestTCPBatch(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler())
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	batch, err := protocol.Batch([]*protocol.Message{
+		{Version: protocol.V1, Type: protocol.Hello, Timestamp: time.Now()},
+		{
+			Version: protocol.V1,
+			Type:    protocol.Register,
+			Payload: mustMarshal(t, &protocol.Capability{
+				ID:          "batched-cap",
+				Type:        "DISCOVER",
+				Interaction: protocol.Discover,
+			}),
+			Timestamp: time.Now(),
+		},
+		{
+			Version:   protocol.V1,
+			Type:      protocol.Query,
+			Payload:   mustMarshal(t, &protocol.QueryPayload{CapabilityType: "DISCOVER"}),
+			Timestamp: time.Now(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if err := WriteMessage(conn, batch); err != nil {
+		t.Fatalf("Failed to send batch: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read batch response: %v", err)
+	}
+	if response.Type != protocol.BatchResponse {
+		t.Fatalf("Expected BatchResponse, got %v: %s", response.Type, response.Payload)
+	}
+
+	responses, err := protocol.Unbatch(response)
+	if err != nil {
+		t.Fatalf("Unbatch() error = %v", err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(responses))
+	}
+	if responses[0].Type != protocol.Hello {
+		t.Errorf("Expected Hello first, got %v", responses[0].Type)
+	}
+
+	// Inner messages are handled in order, so the query sees the registration
+	var caps []*protocol.Capability
+	if err := json.Unmarshal(responses[2].Payload, &caps); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if len(caps) != 1 || caps[0].ID != "batched-cap" {
+		t.Errorf("Expected batched-cap in query response, got %+v", caps)
+	}
+}
+
+func TestTCPWorkerPool(t *testing.T) {
+	handler := protocol.NewHandler(protocol.WithWorkerPool(2))
+	defer handler.Close()
+	release := make(chan struct{})
+	handler.HandleFunc(protocol.Hello, func(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+		<-release
+		return &protocol.Message{Version: msg.Version, Type: protocol.Hello, Timestamp: time.Now()}, nil
+	})
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	defer close(release)
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	// A slow message holds one worker while the query is served by another
+	slow := &protocol.Message{Version: protocol.V1, Type: protocol.Hello, Timestamp: time.Now()}
+	if err := WriteMessage(conn, slow); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if ids := query(t, conn, "DISCOVER"); len(ids) != 0 {
+		t.Errorf("Expected no capabilities, got %v", ids)
+	}
+
+	release <- struct{}{}
+	response, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if response.Type != protocol.Hello {
+		t.Errorf("Expected the slow Hello to be answered last, got %v", response.Type)
+	}
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"context"
+	"net"
+)
+
+// Smallest burst a throttled connection allows, so a low limit still lets a
+// typical message through in one piece
+const minThrottleBurst = 4 << 10
+
+// WithBandwidthLimit caps each stream connection at bytesPerSec in each
+// direction by wrapping it in a ThrottledConn. Zero, the default, sets no
+// limit.
+func WithBandwidthLimit(bytesPerSec int64) Option {
+	return func(s *Server) {
+		s.bandwidthLimit = bytesPerSec
+	}
+}
+
+// ThrottledConn is a net.Conn whose reads and writes each run at no more
+// than a fixed number of bytes per second, averaged by a token bucket that
+// allows bursts of up to one second's worth
+type ThrottledConn struct {
+	net.Conn
+
+	read  *tokenBucket
+	write *tokenBucket
+	burst int
+
+	ctx    context.Context // cancelled by Close to release throttled calls
+	cancel context.CancelFunc
+}
+
+// NewThrottledConn limits conn to bytesPerSec in each direction
+func NewThrottledConn(conn net.Conn, bytesPerSec int64) *ThrottledConn {
+	burst := max(bytesPerSec, minThrottleBurst)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ThrottledConn{
+		Conn:   conn,
+		read:   newTokenBucket(float64(bytesPerSec), float64(burst)),
+		write:  newTokenBucket(float64(bytesPerSec), float64(burst)),
+		burst:  int(burst),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Read reads at most a burst and then waits until the bytes read fit the limit
+func (c *ThrottledConn) Read(p []byte) (int, error) {
+	if len(p) > c.burst {
+		p = p[:c.burst]
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if werr := c.read.waitN(c.ctx, float64(n)); werr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+// Write sends p in bursts, waiting before each until it fits the limit
+func (c *ThrottledConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.burst)]
+		if err := c.write.waitN(c.ctx, float64(len(chunk))); err != nil {
+			return written, net.ErrClosed
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the connection and releases any call waiting on the limit
+func (c *ThrottledConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
@@ -0,0 +1,151 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t testing.TB) (client, server net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	client, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("Failed to accept connection")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestThrottledConn(t *testing.T) {
+	const rate = 256 << 10
+
+	// One burst goes through at once, the half burst after it has to wait
+	data := bytes.Repeat([]byte("x"), rate+rate/2)
+	wantWait := 400 * time.Millisecond
+
+	t.Run("write", func(t *testing.T) {
+		client, server := tcpPair(t)
+		throttled := NewThrottledConn(client, rate)
+
+		go io.Copy(io.Discard, server)
+		start := time.Now()
+		if n, err := throttled.Write(data); err != nil || n != len(data) {
+			t.Fatalf("Write() = %d, %v, want %d bytes", n, err, len(data))
+		}
+		if elapsed := time.Since(start); elapsed < wantWait {
+			t.Errorf("Write() took %v, want at least %v", elapsed, wantWait)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		client, server := tcpPair(t)
+		throttled := NewThrottledConn(server, rate)
+
+		go client.Write(data)
+		start := time.Now()
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(throttled, got); err != nil {
+			t.Fatalf("ReadFull() error = %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < wantWait {
+			t.Errorf("Reading took %v, want at least %v", elapsed, wantWait)
+		}
+		if !bytes.Equal(got, data) {
+			t.Error("Read data differs from what was written")
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		client, server := tcpPair(t)
+		throttled := NewThrottledConn(client, minThrottleBurst)
+
+		go io.Copy(io.Discard, server)
+		written := make(chan error, 1)
+		go func() {
+			// Several seconds' worth at this rate
+			_, err := throttled.Write(make([]byte, 8*minThrottleBurst))
+			written <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		throttled.Close()
+
+		select {
+		case err := <-written:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Write() error = %v, want %v", err, net.ErrClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Close to release a throttled Write")
+		}
+	})
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithBandwidthLimit(64<<10))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Sessions within the limit behave as usual
+	handshake(t, conn)
+	query(t, conn, "DISCOVER")
+}
+
+func benchmarkThrottle(b *testing.B, bytesPerSec int64) {
+	client, server := tcpPair(b)
+	var conn net.Conn = client
+	if bytesPerSec > 0 {
+		conn = NewThrottledConn(client, bytesPerSec)
+	}
+	go io.Copy(io.Discard, server)
+
+	chunk := make([]byte, 32<<10)
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(chunk); err != nil {
+			b.Fatalf("Write() error = %v", err)
+		}
+	}
+}
+
+func BenchmarkThroughputUnlimited(b *testing.B) {
+	benchmarkThrottle(b, 0)
+}
+
+func BenchmarkThroughputLimited(b *testing.B) {
+	benchmarkThrottle(b, 64<<20)
+}
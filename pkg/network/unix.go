@@ -0,0 +1,93 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// WithUnixSocket additionally serves sessions on a UNIX domain socket at
+// path, for peers on the same host. The framing is the same as over TCP.
+func WithUnixSocket(path string) Option {
+	return func(s *Server) {
+		s.UnixAddr = path
+	}
+}
+
+// unixConn names unnamed UNIX socket peers after the socket they connected
+// to, so they are not mistaken for local callers of the handler, and
+// carries the credentials WithUnixCredentialAuth accepted
+type unixConn struct {
+	net.Conn
+	remote net.Addr
+	cred   *Ucred
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// listenUnix listens on the socket at path. A socket file left behind by a
+// server that did not stop cleanly is replaced; one still in use is not.
+func listenUnix(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+		return l, err
+	}
+
+	if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return net.Listen("unix", path)
+}
+
+// UnixSocketAddr returns the address the UNIX socket listener is bound to,
+// or nil if WithUnixSocket was not used
+func (s *Server) UnixSocketAddr() net.Addr {
+	if s.unixListener == nil {
+		return nil
+	}
+	return s.unixListener.Addr()
+}
+
+// handleUnix accepts sessions on the UNIX socket until it is closed
+func (s *Server) handleUnix(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return // Server is shutting down
+			}
+			s.logger.Error("Failed to accept UNIX socket connection", "error", err)
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.serveUnix(conn, listener.Addr())
+	}
+}
+
+// serveUnix checks the credentials of a peer accepted on the UNIX socket at
+// addr, then runs its session. serveConn marks the session done in s.wg.
+func (s *Server) serveUnix(conn net.Conn, addr net.Addr) {
+	cred, ok := s.authenticateUnix(conn)
+	if !ok {
+		conn.Close()
+		s.wg.Done()
+		return
+	}
+
+	remote := conn.RemoteAddr()
+	if remote == nil || remote.String() == "" {
+		remote = addr
+	}
+	s.serveConn(&unixConn{Conn: conn, remote: remote, cred: cred}, "unix")
+}
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// startUnixServer starts a server that also listens on a socket in a temp dir
+func startUnixServer(t *testing.T, handler *protocol.Handler) (*Server, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "arn.sock")
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithUnixSocket(path))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	return server, path
+}
+
+func TestUnixSocket(t *testing.T) {
+	handler := protocol.NewHandler()
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "local", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	server, path := startUnixServer(t, handler)
+	if got := server.UnixSocketAddr().String(); got != path {
+		t.Errorf("UnixSocketAddr() = %s, want %s", got, path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to socket: %v", err)
+	}
+	defer conn.Close()
+
+	handshake(t, conn)
+
+	register := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Register,
+		Payload:   mustMarshal(t, &protocol.Capability{ID: "over-unix", Type: "DISCOVER"}),
+		Timestamp: time.Now(),
+	}
+	if response := send(t, conn, register); response.Type != protocol.Response {
+		t.Fatalf("Expected Response to register, got %v: %s", response.Type, response.Payload)
+	}
+	if ids := query(t, conn, "DISCOVER"); len(ids) != 2 {
+		t.Errorf("Expected both capabilities over the socket, got %v", ids)
+	}
+
+	// Socket peers are not the handler's own local callers
+	unregister := &protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.Unregister,
+		Payload:   mustMarshal(t, &protocol.UnregisterPayload{CapabilityID: "local"}),
+		Timestamp: time.Now(),
+	}
+	response := send(t, conn, unregister)
+	var errPayload protocol.ErrorPayload
+	if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+		t.Fatalf("Failed to unmarshal error: %v", err)
+	}
+	if response.Type != protocol.Error || errPayload.Code != protocol.ErrForbidden {
+		t.Errorf("Expected ErrForbidden unregistering a local capability, got %v %v", response.Type, errPayload.Code)
+	}
+
+	conn.Close()
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed on Stop, got %v", err)
+	}
+}
+
+func TestUnixSocketStaleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arn.sock")
+
+	// Leave a socket file behind as a crashed server would
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithUnixSocket(path))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Expected Start to replace a stale socket, got %v", err)
+	}
+	defer server.Stop()
+
+	// A socket that is still served is left alone
+	second := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithUnixSocket(path))
+	if err := second.Start(); err == nil {
+		second.Stop()
+		t.Fatal("Expected Start to fail on a socket in use")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Expected the first server to keep its socket, got %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// errUnixCredUnsupported is returned where the platform cannot report the
+// credentials of a UNIX socket peer
+var errUnixCredUnsupported = errors.New("UNIX socket peer credentials unsupported")
+
+// WithUnixCredentialAuth checks the kernel-reported credentials of every
+// peer accepted on the UNIX socket with validator before its session
+// starts. A peer the validator returns an error for is sent an
+// ErrUnauthorized Error and disconnected. Accepted credentials are stored
+// in the session context, see UcredFromContext.
+//
+// Credentials come from SO_PEERCRED, which only Linux provides. Elsewhere
+// Start fails rather than serve the socket without the check.
+func WithUnixCredentialAuth(validator func(cred Ucred) error) Option {
+	return func(s *Server) {
+		s.unixCredAuth = validator
+	}
+}
+
+type ucredKey struct{}
+
+// ContextWithUcred returns a copy of ctx carrying the credentials of a
+// UNIX socket peer
+func ContextWithUcred(ctx context.Context, cred Ucred) context.Context {
+	return context.WithValue(ctx, ucredKey{}, cred)
+}
+
+// UcredFromContext returns the UNIX socket peer credentials stored in ctx,
+// if any
+func UcredFromContext(ctx context.Context) (Ucred, bool) {
+	cred, ok := ctx.Value(ucredKey{}).(Ucred)
+	return cred, ok
+}
+
+// authenticateUnix checks the credentials of a peer accepted on the UNIX
+// socket, returning them if the peer may proceed. A refused peer has been
+// told why; the caller closes conn.
+func (s *Server) authenticateUnix(conn net.Conn) (*Ucred, bool) {
+	if s.unixCredAuth == nil {
+		return nil, true
+	}
+
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		s.logger.Error("Failed to read UNIX socket peer credentials", "error", err)
+		return nil, false
+	}
+
+	if err := s.unixCredAuth(*cred); err != nil {
+		s.logger.Warn("Refused UNIX socket peer", "pid", cred.Pid, "uid", cred.Uid, "gid", cred.Gid, "error", err)
+		response, err := protocol.NewErrorMessage(protocol.ErrUnauthorized, "peer credentials rejected")
+		if err == nil {
+			conn.SetWriteDeadline(time.Now().Add(refuseWriteTimeout))
+			WriteMessage(conn, response)
+		}
+		return nil, false
+	}
+	return cred, true
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Ucred holds the process, user and group IDs of a UNIX socket peer
+type Ucred = syscall.Ucred
+
+const unixCredSupported = true
+
+// peerCredentials asks the kernel for the credentials of the process at the
+// other end of conn
+func peerCredentials(conn net.Conn) (*Ucred, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a socket", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+	return cred, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package network
+
+import "net"
+
+// Ucred holds the process, user and group IDs of a UNIX socket peer. It
+// mirrors the Linux syscall.Ucred.
+type Ucred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+const unixCredSupported = false
+
+// peerCredentials reports that SO_PEERCRED is unavailable; it is only
+// supported on Linux
+func peerCredentials(conn net.Conn) (*Ucred, error) {
+	return nil, errUnixCredUnsupported
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestUnixCredentialAuth(t *testing.T) {
+	if !unixCredSupported {
+		t.Skip("SO_PEERCRED is not supported on this platform")
+	}
+
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"same user accepted", true},
+		{"rejected peer", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var checked, seen []Ucred
+			validator := func(cred Ucred) error {
+				mu.Lock()
+				defer mu.Unlock()
+				checked = append(checked, cred)
+				if !tt.allowed {
+					return errors.New("not on the allow list")
+				}
+				return nil
+			}
+
+			handler := protocol.NewHandler()
+			defer handler.Close()
+			handler.Use(func(ctx context.Context, msg *protocol.Message, next func(context.Context, *protocol.Message) (*protocol.Message, error)) (*protocol.Message, error) {
+				if cred, ok := UcredFromContext(ctx); ok {
+					mu.Lock()
+					seen = append(seen, cred)
+					mu.Unlock()
+				}
+				return next(ctx, msg)
+			})
+
+			path := filepath.Join(t.TempDir(), "arn.sock")
+			server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithUnixSocket(path), WithUnixCredentialAuth(validator))
+			if err := server.Start(); err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+			defer server.Stop()
+
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatalf("Failed to connect to socket: %v", err)
+			}
+			defer conn.Close()
+
+			want := Ucred{Pid: int32(os.Getpid()), Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+			if !tt.allowed {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				msg, err := ReadMessage(conn)
+				if err != nil {
+					t.Fatalf("Failed to read refusal: %v", err)
+				}
+				var payload protocol.ErrorPayload
+				if msg.Type != protocol.Error || json.Unmarshal(msg.Payload, &payload) != nil || payload.Code != protocol.ErrUnauthorized {
+					t.Fatalf("Expected an ErrUnauthorized Error, got %v: %s", msg.Type, msg.Payload)
+				}
+				if _, err := ReadMessage(conn); !isClosedError(err) {
+					t.Errorf("Expected the refused connection to be closed, got %v", err)
+				}
+			} else {
+				handshake(t, conn)
+				query(t, conn, "DISCOVER")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(checked) != 1 || checked[0] != want {
+				t.Errorf("Validator saw %+v, want %+v", checked, want)
+			}
+			for _, cred := range seen {
+				if cred != want {
+					t.Errorf("Session context has %+v, want %+v", cred, want)
+				}
+			}
+			if tt.allowed && len(seen) == 0 {
+				t.Error("Expected the credentials in the session context")
+			}
+			if !tt.allowed && len(seen) != 0 {
+				t.Errorf("Expected no messages from a rejected peer, got %d", len(seen))
+			}
+		})
+	}
+}
+
+func TestUnixCredentialAuthUnsupported(t *testing.T) {
+	if unixCredSupported {
+		t.Skip("SO_PEERCRED is supported on this platform")
+	}
+
+	path := filepath.Join(t.TempDir(), "arn.sock")
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithUnixSocket(path), WithUnixCredentialAuth(func(Ucred) error { return nil }))
+	if err := server.Start(); !errors.Is(err, errUnixCredUnsupported) {
+		server.Stop()
+		t.Fatalf("Start() error = %v, want %v", err, errUnixCredUnsupported)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no socket at %s, got %v", path, err)
+	}
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Write batching defaults, see WithWriteBatching
+const (
+	DefaultFlushDelay     = 200 * time.Microsecond
+	DefaultFlushThreshold = 16 * 1024
+)
+
+// WithWriteBatching coalesces the messages written to each stream
+// connection, holding them for up to delay or until threshold bytes are
+// waiting and then writing them in one call. Zero values use
+// DefaultFlushDelay and DefaultFlushThreshold.
+func WithWriteBatching(delay time.Duration, threshold int) Option {
+	return func(s *Server) {
+		if delay <= 0 {
+			delay = DefaultFlushDelay
+		}
+		if threshold <= 0 {
+			threshold = DefaultFlushThreshold
+		}
+		s.FlushDelay = delay
+		s.FlushThreshold = threshold
+	}
+}
+
+// WriteBatcher buffers writes to an underlying writer and passes them on
+// together, once the oldest has waited the flush delay or the buffer holds
+// the flush threshold. It is safe for concurrent use. A failed flush is
+// reported by the next Write or Flush, and every one after it.
+type WriteBatcher struct {
+	w         io.Writer
+	delay     time.Duration
+	threshold int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer // Pending delayed flush, nil when the buffer is empty
+	err   error
+}
+
+// NewWriteBatcher creates a batcher writing to w
+func NewWriteBatcher(w io.Writer, delay time.Duration, threshold int) *WriteBatcher {
+	return &WriteBatcher{w: w, delay: delay, threshold: threshold}
+}
+
+// Write buffers p, flushing at once if the buffer reaches the threshold.
+// p is copied, so the caller may reuse it.
+func (b *WriteBatcher) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= b.threshold {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.delay, b.flushLater)
+	}
+	return len(p), nil
+}
+
+// Flush writes everything buffered now
+func (b *WriteBatcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// Buffered returns the number of bytes waiting to be written
+func (b *WriteBatcher) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buf)
+}
+
+// flushLater is the delayed flush armed by Write
+func (b *WriteBatcher) flushLater() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flush()
+}
+
+// flush writes the buffer and disarms the delayed flush. Callers must hold
+// b.mu.
+func (b *WriteBatcher) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	_, err := b.w.Write(b.buf)
+	b.buf = b.buf[:0]
+	if err != nil {
+		b.err = err
+	}
+	return err
+}
+
+// writerFunc adapts a function writing a whole buffer to io.Writer
+type writerFunc func([]byte) error
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
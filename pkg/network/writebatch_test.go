@@ -0,0 +1,139 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// countingWriter records each Write call it receives
+type countingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (w *countingWriter) calls() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestWriteBatcherCoalesces(t *testing.T) {
+	w := &countingWriter{}
+	b := NewWriteBatcher(w, 20*time.Millisecond, 1024)
+
+	for _, s := range []string{"one", "two", "three"} {
+		if _, err := b.Write([]byte(s)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if got := len(w.calls()); got != 0 {
+		t.Fatalf("Expected writes to wait for the flush delay, got %d", got)
+	}
+	if b.Buffered() != len("onetwothree") {
+		t.Errorf("Buffered() = %d, want %d", b.Buffered(), len("onetwothree"))
+	}
+
+	// The delayed flush sends everything in one write
+	deadline := time.Now().Add(time.Second)
+	for len(w.calls()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	calls := w.calls()
+	if len(calls) != 1 || string(calls[0]) != "onetwothree" {
+		t.Fatalf("Expected one write of every message, got %q", calls)
+	}
+}
+
+func TestWriteBatcherThreshold(t *testing.T) {
+	w := &countingWriter{}
+	b := NewWriteBatcher(w, time.Hour, 8)
+
+	b.Write([]byte("abcd"))
+	if len(w.calls()) != 0 {
+		t.Fatal("Expected a write below the threshold to be buffered")
+	}
+	b.Write([]byte("efgh"))
+	if calls := w.calls(); len(calls) != 1 || string(calls[0]) != "abcdefgh" {
+		t.Fatalf("Expected reaching the threshold to flush, got %q", calls)
+	}
+
+	b.Write([]byte("ij"))
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if calls := w.calls(); len(calls) != 2 || string(calls[1]) != "ij" {
+		t.Errorf("Expected Flush to write the rest, got %q", calls)
+	}
+	if err := b.Flush(); err != nil || len(w.calls()) != 2 {
+		t.Errorf("Expected flushing an empty buffer to write nothing, got %v", err)
+	}
+}
+
+func TestWriteBatcherError(t *testing.T) {
+	failure := errors.New("broken pipe")
+	w := &countingWriter{err: failure}
+	b := NewWriteBatcher(w, time.Hour, 1024)
+
+	b.Write([]byte("lost"))
+	if err := b.Flush(); !errors.Is(err, failure) {
+		t.Fatalf("Flush() error = %v, want %v", err, failure)
+	}
+	if _, err := b.Write([]byte("more")); !errors.Is(err, failure) {
+		t.Errorf("Write() after a failed flush error = %v, want %v", err, failure)
+	}
+}
+
+func TestTCPWriteBatching(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler(), WithWriteBatching(0, 0))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	if server.FlushDelay != DefaultFlushDelay || server.FlushThreshold != DefaultFlushThreshold {
+		t.Errorf("Expected zero values to use the defaults, got %v and %d", server.FlushDelay, server.FlushThreshold)
+	}
+
+	conn, err := net.Dial("tcp", server.TCPAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	handshake(t, conn)
+
+	// Pipeline several requests in one write; every response still arrives
+	const requests = 20
+	var pipelined bytes.Buffer
+	for i := 0; i < requests; i++ {
+		WriteMessage(&pipelined, &protocol.Message{Version: protocol.V1, Type: protocol.Hello, Timestamp: time.Now()})
+	}
+	if _, err := conn.Write(pipelined.Bytes()); err != nil {
+		t.Fatalf("Failed to send requests: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < requests; i++ {
+		if _, err := ReadMessage(reader); err != nil {
+			t.Fatalf("Failed to read response %d: %v", i, err)
+		}
+	}
+}
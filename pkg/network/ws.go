@@ -0,0 +1,95 @@
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// WSServer serves the ARN wire format over WebSocket for peers that cannot
+// open raw TCP connections. Each message travels in one binary frame with
+// the same framing as TCP, and sessions behave exactly like TCP sessions.
+type WSServer struct {
+	addr       string
+	server     *Server
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+// WithWebSocket additionally serves WebSocket connections on addr
+func WithWebSocket(addr string) Option {
+	return func(s *Server) {
+		s.ws = &WSServer{addr: addr, server: s}
+	}
+}
+
+// wsConn reports the real peer address instead of the WebSocket origin
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// start begins accepting WebSocket upgrades
+func (w *WSServer) start() error {
+	listener, err := net.Listen("tcp", w.addr)
+	if err != nil {
+		return fmt.Errorf("failed to start WebSocket listener: %w", err)
+	}
+	if w.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, w.server.TLSConfig)
+	}
+	w.listener = listener
+
+	w.httpServer = &http.Server{Handler: w}
+
+	w.server.wg.Add(1)
+	go func() {
+		defer w.server.wg.Done()
+		if err := w.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.server.logger.Error("WebSocket server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// stop closes the listener. Established sessions end when the server context is cancelled.
+func (w *WSServer) stop() error {
+	if w.httpServer == nil {
+		return nil
+	}
+	return w.httpServer.Close()
+}
+
+// Addr returns the address the WebSocket listener is bound to
+func (w *WSServer) Addr() net.Addr {
+	if w.listener == nil {
+		return nil
+	}
+	return w.listener.Addr()
+}
+
+// ServeHTTP upgrades the request and runs an ARN session over it
+func (w *WSServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	websocket.Server{Handler: w.serveWebSocket}.ServeHTTP(rw, req)
+}
+
+func (w *WSServer) serveWebSocket(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	var remote net.Addr = ws.RemoteAddr()
+	if addr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+		remote = addr
+	}
+
+	// The upgrade is torn down when this handler returns, so serve inline
+	w.server.wg.Add(1)
+	w.server.serveConn(&wsConn{Conn: ws, remote: remote}, "ws")
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+	"golang.org/x/net/websocket"
+)
+
+func dialWS(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+
+	ws, err := websocket.Dial("ws://"+s.WSAddr().String()+"/", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Failed to dial WebSocket: %v", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws
+}
+
+func TestWebSocketServer(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithWebSocket("127.0.0.1:0"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	ws := dialWS(t, server)
+	defer ws.Close()
+
+	handshake(t, ws)
+
+	msg := &protocol.Message{
+		Version: protocol.V1,
+		Type:    protocol.Register,
+		Payload: mustMarshal(t, &protocol.Capability{
+			ID:   "ws-cap",
+			Type: "DISCOVER",
+		}),
+		Timestamp: time.Now(),
+	}
+	if err := WriteMessage(ws, msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	response, err := ReadMessage(ws)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if response.Type != protocol.Response {
+		t.Errorf("Expected Response, got %v", response.Type)
+	}
+}
+
+func TestWebSocketBroadcast(t *testing.T) {
+	handler := protocol.NewHandler()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", handler, WithWebSocket("127.0.0.1:0"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	ws := dialWS(t, server)
+	defer ws.Close()
+
+	handshake(t, ws)
+
+	// Wait for the session to be tracked
+	deadline := time.Now().Add(time.Second)
+	for countConns(server) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for WebSocket session")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := server.Broadcast(&protocol.Message{
+		Version:   protocol.V1,
+		Type:      protocol.MCPBridgeDown,
+		Payload:   []byte(`{"id":"gone"}`),
+		Timestamp: time.Now(),
+	}); err != nil {
+		t.Fatalf("Broadcast() error = %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := ReadMessage(ws)
+	if err != nil {
+		t.Fatalf("Failed to read broadcast: %v", err)
+	}
+	if msg.Type != protocol.MCPBridgeDown {
+		t.Errorf("Expected MCPBridgeDown, got %v", msg.Type)
+	}
+}
+
+func TestWebSocketDisabled(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", protocol.NewHandler())
+	if addr := server.WSAddr(); addr != nil {
+		t.Errorf("Expected nil WSAddr without WithWebSocket, got %v", addr)
+	}
+}
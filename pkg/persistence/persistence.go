@@ -0,0 +1,91 @@
+// Package persistence saves a node's registered capabilities and MCP bridges
+// so they survive a restart
+package persistence
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+// Store saves and restores a handler's registry, see protocol.WithStore
+type Store = protocol.Store
+
+// snapshot is the on-disk form of the registry
+type snapshot struct {
+	Capabilities []*protocol.Capability
+	Bridges      []*protocol.MCPBridge
+}
+
+// FileStore keeps the registry in a single gob-encoded file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a store that reads and writes path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save replaces the file with the given registry. The new snapshot is written
+// to a temporary file first so a crash never leaves a truncated file behind.
+func (s *FileStore) Save(capabilities []*protocol.Capability, bridges []*protocol.MCPBridge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(snapshot{Capabilities: capabilities, Bridges: bridges}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load reads the registry back. A missing file yields an empty registry.
+func (s *FileStore) Load() ([]*protocol.Capability, []*protocol.MCPBridge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return snap.Capabilities, snap.Bridges, nil
+}
@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/heathweaver/arn-protocol/pkg/protocol"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "state", "registry.gob"))
+
+	// Nothing saved yet
+	caps, bridges, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(caps) != 0 || len(bridges) != 0 {
+		t.Fatalf("Expected empty registry, got %d capabilities and %d bridges", len(caps), len(bridges))
+	}
+
+	wantCaps := []*protocol.Capability{
+		{ID: "cap-1", Type: "DISCOVER", Version: "1.0.0", Metadata: map[string]string{"region": "eu"}, TTL: time.Minute},
+	}
+	wantBridges := []*protocol.MCPBridge{
+		{ID: "bridge-1", Endpoint: "mcp://data/v1", Protocol: "MCP/1.0", DataTypes: []string{"records"}, AllowedClients: []string{"agent-1"}},
+	}
+	if err := store.Save(wantCaps, wantBridges); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	caps, bridges, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(caps) != 1 || caps[0].ID != "cap-1" || caps[0].Metadata["region"] != "eu" || caps[0].TTL != time.Minute {
+		t.Errorf("Expected %+v, got %+v", wantCaps[0], caps)
+	}
+	if len(bridges) != 1 || bridges[0].ID != "bridge-1" || bridges[0].AllowedClients[0] != "agent-1" {
+		t.Errorf("Expected bridge-1, got %+v", bridges)
+	}
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.gob")
+	if err := os.WriteFile(path, []byte("not gob"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := NewFileStore(path).Load(); err == nil {
+		t.Error("Expected error loading corrupt snapshot")
+	}
+}
+
+func TestHandlerRestoresFromStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "registry.gob"))
+
+	handler := protocol.NewHandler(protocol.WithStore(store))
+	if err := handler.RegisterCapability(&protocol.Capability{ID: "persisted", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.RegisterMCPBridge(&protocol.MCPBridge{ID: "persisted-bridge", Endpoint: "mcp://data/v1", DataTypes: []string{"records"}}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	// Saves are asynchronous; Close flushes the pending one
+	handler.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		caps, bridges, err := store.Load()
+		if err == nil && len(caps) == 1 && len(bridges) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Registry was not saved: %d capabilities, %d bridges, err %v", len(caps), len(bridges), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Restored bridges are published while the handler is created, so subscribe up front
+	var restored []string
+	bus := events.NewBus()
+	bus.Subscribe(events.BridgeAdvertised, func(event interface{}) {
+		restored = append(restored, event.(*protocol.MCPBridge).ID)
+	})
+	restarted := protocol.NewHandler(protocol.WithEventBus(bus), protocol.WithStore(store))
+	defer restarted.Close()
+
+	if err := restarted.ValidateCapabilityPayload("persisted", []byte("{}")); err != nil {
+		t.Errorf("Expected restored capability, got %v", err)
+	}
+	if len(restored) != 1 || restored[0] != "persisted-bridge" {
+		t.Errorf("Expected persisted-bridge to be restored, got %v", restored)
+	}
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// AllowedClients entries with this prefix name a role of proven identities
+const rolePrefix = "role:"
+
+// AddAllowedClient permits a peer ID or CIDR block to request the bridge
+func (b *MCPBridge) AddAllowedClient(id string) {
+	b.aclMu.Lock()
+	defer b.aclMu.Unlock()
+
+	for _, existing := range b.AllowedClients {
+		if existing == id {
+			return
+		}
+	}
+	b.AllowedClients = append(b.AllowedClients, id)
+}
+
+// RemoveAllowedClient revokes a peer ID or CIDR block added earlier
+func (b *MCPBridge) RemoveAllowedClient(id string) {
+	b.aclMu.Lock()
+	defer b.aclMu.Unlock()
+
+	kept := b.AllowedClients[:0]
+	for _, existing := range b.AllowedClients {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+	b.AllowedClients = kept
+}
+
+// allows reports whether the peer behind ctx may use the bridge. Entries
+// match peerID, the trusted ID of the peer, exactly, the peer's IP address
+// by CIDR, or with a role: prefix a role of the identity the peer proved.
+func (b *MCPBridge) allows(ctx context.Context, peerID string) bool {
+	b.aclMu.RLock()
+	defer b.aclMu.RUnlock()
+
+	if len(b.AllowedClients) == 0 {
+		return true
+	}
+
+	identity, proven := security.PeerIdentityFromContext(ctx)
+	ip := peerIP(ctx)
+
+	for _, entry := range b.AllowedClients {
+		if role, ok := strings.CutPrefix(entry, rolePrefix); ok {
+			if proven && identity.HasRole(role) {
+				return true
+			}
+			continue
+		}
+		if peerID != "" && entry == peerID {
+			return true
+		}
+		if ip == nil || !strings.Contains(entry, "/") {
+			continue
+		}
+		if _, block, err := net.ParseCIDR(entry); err == nil && block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the IP address of the peer behind ctx, if known
+func peerIP(ctx context.Context) net.IP {
+	addr, ok := PeerAddrFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
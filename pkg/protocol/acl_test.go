@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMCPBridgeACL(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	bridge := &MCPBridge{
+		ID:             "restricted",
+		Endpoint:       "mcp://restricted/v1",
+		Protocol:       "MCP/1.0",
+		Metadata:       map[string]string{"auth_type": "none", "data_format": "json"},
+		DataTypes:      []string{"records"},
+		AllowedClients: []string{"agent-1", "10.0.0.0/8"},
+	}
+	if err := handler.RegisterMCPBridge(bridge); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	request := func(ctx context.Context) *Message {
+		t.Helper()
+
+		payload, _ := json.Marshal(map[string]string{"bridge_id": "restricted", "data_type": "records"})
+		response, err := handler.HandleMessage(ctx, &Message{
+			Version:   V1,
+			Type:      MCPBridgeRequest,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	peer := func(ip string, id string) context.Context {
+		ctx := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000})
+		if id != "" {
+			ctx = ContextWithPeerID(ctx, id)
+		}
+		return ctx
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		allowed bool
+	}{
+		{"listed peer ID", peer("192.0.2.1", "agent-1"), true},
+		{"address in CIDR block", peer("10.1.2.3", ""), true},
+		{"unlisted peer", peer("192.0.2.1", "agent-2"), false},
+		{"anonymous local call", context.Background(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := request(tt.ctx)
+			if tt.allowed {
+				if response.Type != MCPBridgeResponse {
+					t.Errorf("Expected MCPBridgeResponse, got %v", response.Type)
+				}
+				return
+			}
+
+			var errPayload ErrorPayload
+			if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+				t.Fatalf("Failed to unmarshal error: %v", err)
+			}
+			if response.Type != Error || errPayload.Code != ErrForbidden {
+				t.Errorf("Expected ErrForbidden, got %v %v", response.Type, errPayload.Code)
+			}
+		})
+	}
+
+	// Granting access at runtime takes effect immediately
+	bridge.AddAllowedClient("agent-2")
+	if response := request(peer("192.0.2.1", "agent-2")); response.Type != MCPBridgeResponse {
+		t.Errorf("Expected agent-2 to be allowed after AddAllowedClient, got %v", response.Type)
+	}
+
+	bridge.RemoveAllowedClient("agent-2")
+	if response := request(peer("192.0.2.1", "agent-2")); response.Type != Error {
+		t.Errorf("Expected agent-2 to be forbidden after RemoveAllowedClient, got %v", response.Type)
+	}
+}
+
+func TestAllowedClientHelpers(t *testing.T) {
+	bridge := &MCPBridge{ID: "b"}
+
+	bridge.AddAllowedClient("a")
+	bridge.AddAllowedClient("a")
+	bridge.AddAllowedClient("b")
+	if len(bridge.AllowedClients) != 2 {
+		t.Errorf("Expected duplicates to be ignored, got %v", bridge.AllowedClients)
+	}
+
+	bridge.RemoveAllowedClient("a")
+	if len(bridge.AllowedClients) != 1 || bridge.AllowedClients[0] != "b" {
+		t.Errorf("Expected [b], got %v", bridge.AllowedClients)
+	}
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Batch packs msgs into a single BatchMessage whose payload is each
+// serialized message prefixed with its length as a big-endian uint32
+func Batch(msgs []*Message) (*Message, error) {
+	return pack(BatchMessage, msgs)
+}
+
+// Unbatch returns the messages packed into a BatchMessage or BatchResponse
+func Unbatch(msg *Message) ([]*Message, error) {
+	if msg.Type != BatchMessage && msg.Type != BatchResponse {
+		return nil, fmt.Errorf("%w: cannot unbatch message type %d", ErrInvalidMessageType, msg.Type)
+	}
+
+	var msgs []*Message
+	for data := msg.Payload; len(data) > 0; {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: truncated batch entry length", ErrInvalidPayload)
+		}
+		size := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: batch entry of %d bytes exceeds remaining %d", ErrInvalidPayload, size, len(data))
+		}
+
+		inner, err := Deserialize(data[:size])
+		if err != nil {
+			return nil, fmt.Errorf("%w: batch entry %d: %v", ErrInvalidPayload, len(msgs), err)
+		}
+		if inner.Type == BatchMessage || inner.Type == BatchResponse {
+			return nil, fmt.Errorf("%w: nested batch at entry %d", ErrInvalidPayload, len(msgs))
+		}
+		msgs = append(msgs, inner)
+		data = data[size:]
+	}
+	return msgs, nil
+}
+
+// NewBatchResponse packs the responses to a BatchMessage
+func NewBatchResponse(responses []*Message) (*Message, error) {
+	return pack(BatchResponse, responses)
+}
+
+func pack(t MessageType, msgs []*Message) (*Message, error) {
+	var payload []byte
+	for i, msg := range msgs {
+		if msg.Type == BatchMessage || msg.Type == BatchResponse {
+			return nil, fmt.Errorf("cannot nest batch at entry %d", i)
+		}
+		data, err := msg.Serialize()
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize batch entry %d: %w", i, err)
+		}
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(data)))
+		payload = append(payload, data...)
+	}
+
+	return &Message{
+		Version:     V1,
+		Type:        t,
+		PayloadSize: uint32(len(payload)),
+		Payload:     payload,
+		Timestamp:   time.Now(),
+	}, nil
+}
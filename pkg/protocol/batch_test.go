@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	msgs := []*Message{
+		{Version: V1, Type: Hello, Timestamp: time.Unix(1700000000, 0)},
+		{Version: V1, Type: Query, Payload: []byte(`{"capability_type":"DISCOVER"}`), Timestamp: time.Unix(1700000001, 0)},
+		{Version: V2, Type: Register, Payload: []byte(`{"id":"cap"}`), Priority: PriorityHigh, Timestamp: time.Unix(1700000002, 0)},
+	}
+
+	batch, err := Batch(msgs)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if batch.Type != BatchMessage {
+		t.Errorf("Batch() type = %v, want %v", batch.Type, BatchMessage)
+	}
+
+	// The batch survives the wire like any other message
+	data, err := batch.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+
+	got, err := Unbatch(decoded)
+	if err != nil {
+		t.Fatalf("Unbatch() error = %v", err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("Unbatch() returned %d messages, want %d", len(got), len(msgs))
+	}
+	for i, msg := range got {
+		want := msgs[i]
+		if msg.Type != want.Type || msg.Version != want.Version || !bytes.Equal(msg.Payload, want.Payload) ||
+			!msg.Timestamp.Equal(want.Timestamp) || msg.Priority != want.Priority {
+			t.Errorf("Entry %d = %+v, want %+v", i, msg, want)
+		}
+	}
+}
+
+func TestBatchEmpty(t *testing.T) {
+	batch, err := Batch(nil)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	msgs, err := Unbatch(batch)
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("Unbatch() = %v, %v, want no messages", msgs, err)
+	}
+}
+
+func TestBatchRejectsNesting(t *testing.T) {
+	inner, err := Batch([]*Message{{Version: V1, Type: Hello, Timestamp: time.Now()}})
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if _, err := Batch([]*Message{inner}); err == nil {
+		t.Error("Batch() accepted a nested batch")
+	}
+
+	// A nested batch built by hand is rejected on the way in
+	data, err := inner.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	outer := &Message{Version: V1, Type: BatchMessage, Payload: binary.BigEndian.AppendUint32(nil, uint32(len(data)))}
+	outer.Payload = append(outer.Payload, data...)
+	if _, err := Unbatch(outer); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("Unbatch() error = %v, want %v", err, ErrInvalidPayload)
+	}
+}
+
+func TestUnbatchErrors(t *testing.T) {
+	hello, err := (&Message{Version: V1, Type: Hello, Timestamp: time.Now()}).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	entry := binary.BigEndian.AppendUint32(nil, uint32(len(hello)))
+	entry = append(entry, hello...)
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want error
+	}{
+		{
+			name: "not a batch",
+			msg:  &Message{Version: V1, Type: Hello},
+			want: ErrInvalidMessageType,
+		},
+		{
+			name: "truncated length",
+			msg:  &Message{Version: V1, Type: BatchMessage, Payload: []byte{0, 0}},
+			want: ErrInvalidPayload,
+		},
+		{
+			name: "length past end",
+			msg:  &Message{Version: V1, Type: BatchMessage, Payload: entry[:len(entry)-1]},
+			want: ErrInvalidPayload,
+		},
+		{
+			name: "corrupt entry",
+			msg:  &Message{Version: V1, Type: BatchResponse, Payload: []byte{0, 0, 0, 2, 1, 1}},
+			want: ErrInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unbatch(tt.msg); !errors.Is(err, tt.want) {
+				t.Errorf("Unbatch() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a bridge's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests until ResetTimeout has passed
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial request through to test the bridge
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig controls when a bridge's circuit opens and recovers
+type CircuitBreakerConfig struct {
+	MaxFailures  int           // consecutive failures that open the circuit
+	Window       time.Duration // failures further apart than this start a new count
+	ResetTimeout time.Duration // time an open circuit waits before going half-open
+}
+
+// DefaultCircuitBreakerConfig is used unless WithCircuitBreaker is given
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	MaxFailures:  5,
+	Window:       time.Minute,
+	ResetTimeout: 30 * time.Second,
+}
+
+// WithCircuitBreaker sets the circuit breaker configuration used for every MCP bridge
+func WithCircuitBreaker(cfg CircuitBreakerConfig) Option {
+	return func(h *Handler) {
+		h.breakerConfig = cfg
+	}
+}
+
+// circuitBreaker tracks the health of a single bridge
+type circuitBreaker struct {
+	cfg CircuitBreakerConfig
+	now func() time.Time
+
+	mu           sync.Mutex
+	state        CircuitState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	trialAt      time.Time
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// allow reports whether a request may go through. An open circuit turns
+// half-open after ResetTimeout and then admits one trial per ResetTimeout.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) < b.cfg.ResetTimeout {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.trialAt = now
+		return true
+	case CircuitHalfOpen:
+		if now.Sub(b.trialAt) < b.cfg.ResetTimeout {
+			return false
+		}
+		b.trialAt = now
+		return true
+	default:
+		return true
+	}
+}
+
+// record feeds the outcome of a request into the state machine
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if err == nil {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	switch b.state {
+	case CircuitHalfOpen:
+		// The trial failed, back off for another ResetTimeout
+		b.state = CircuitOpen
+		b.openedAt = now
+	case CircuitClosed:
+		if b.failures == 0 || now.Sub(b.firstFailure) > b.cfg.Window {
+			b.failures = 0
+			b.firstFailure = now
+		}
+		b.failures++
+		if b.failures >= b.cfg.MaxFailures {
+			b.state = CircuitOpen
+			b.openedAt = now
+			b.failures = 0
+		}
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RecordBridgeResult reports the outcome of talking to a bridge endpoint.
+// Whatever forwards requests to the bridge should call this after every
+// attempt so that failing bridges are cut off by their circuit breaker.
+func (h *Handler) RecordBridgeResult(id string, err error) {
+	h.mu.RLock()
+	breaker := h.breakers[id]
+	h.mu.RUnlock()
+
+	if breaker == nil {
+		return
+	}
+
+	before := breaker.currentState()
+	breaker.record(err)
+	after := breaker.currentState()
+
+	if before != after {
+		h.logger.Warn("MCP bridge circuit changed state", "bridge", id, "from", before, "to", after)
+	}
+}
+
+// BridgeCircuitState returns the circuit state of a registered bridge
+func (h *Handler) BridgeCircuitState(id string) (CircuitState, bool) {
+	h.mu.RLock()
+	breaker := h.breakers[id]
+	h.mu.RUnlock()
+
+	if breaker == nil {
+		return CircuitClosed, false
+	}
+	return breaker.currentState(), true
+}
@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(CircuitBreakerConfig{MaxFailures: 3, Window: time.Minute, ResetTimeout: 10 * time.Second})
+	b.now = func() time.Time { return now }
+	fail := errors.New("endpoint unreachable")
+
+	// Failures spread wider than the window never open the circuit
+	for i := 0; i < 3; i++ {
+		b.record(fail)
+		now = now.Add(2 * time.Minute)
+	}
+	if b.currentState() != CircuitClosed {
+		t.Fatalf("Expected closed after sparse failures, got %v", b.currentState())
+	}
+
+	// A success resets the consecutive count
+	b.record(fail)
+	b.record(fail)
+	b.record(nil)
+	b.record(fail)
+	if b.currentState() != CircuitClosed {
+		t.Fatalf("Expected closed after success, got %v", b.currentState())
+	}
+
+	b.record(fail)
+	b.record(fail)
+	if b.currentState() != CircuitOpen {
+		t.Fatalf("Expected open after %d failures, got %v", 3, b.currentState())
+	}
+	if b.allow() {
+		t.Error("Expected open circuit to reject requests")
+	}
+
+	// After the reset timeout a single trial is let through
+	now = now.Add(10 * time.Second)
+	if !b.allow() {
+		t.Fatal("Expected half-open circuit to admit a trial")
+	}
+	if b.currentState() != CircuitHalfOpen {
+		t.Errorf("Expected half-open, got %v", b.currentState())
+	}
+	if b.allow() {
+		t.Error("Expected only one trial while half-open")
+	}
+
+	// A failed trial reopens the circuit
+	b.record(fail)
+	if b.currentState() != CircuitOpen || b.allow() {
+		t.Fatalf("Expected failed trial to reopen the circuit, got %v", b.currentState())
+	}
+
+	// A successful trial closes it
+	now = now.Add(10 * time.Second)
+	if !b.allow() {
+		t.Fatal("Expected half-open circuit to admit a trial")
+	}
+	b.record(nil)
+	if b.currentState() != CircuitClosed || !b.allow() {
+		t.Errorf("Expected successful trial to close the circuit, got %v", b.currentState())
+	}
+}
+
+func TestBridgeCircuitBreaker(t *testing.T) {
+	handler := NewHandler(WithCircuitBreaker(CircuitBreakerConfig{
+		MaxFailures:  2,
+		Window:       time.Minute,
+		ResetTimeout: time.Hour,
+	}))
+	defer handler.Close()
+
+	if err := handler.RegisterMCPBridge(&MCPBridge{
+		ID:        "flaky",
+		Endpoint:  "mcp://flaky/v1",
+		Protocol:  "MCP/1.0",
+		Metadata:  map[string]string{"auth_type": "none", "data_format": "json"},
+		DataTypes: []string{"records"},
+	}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	request := func() *Message {
+		t.Helper()
+
+		payload, _ := json.Marshal(map[string]string{"bridge_id": "flaky", "data_type": "records"})
+		response, err := handler.HandleMessage(context.Background(), &Message{
+			Version:   V1,
+			Type:      MCPBridgeRequest,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	if response := request(); response.Type != MCPBridgeResponse {
+		t.Fatalf("Expected MCPBridgeResponse, got %v", response.Type)
+	}
+
+	for i := 0; i < 2; i++ {
+		handler.RecordBridgeResult("flaky", errors.New("connection refused"))
+	}
+	if state, ok := handler.BridgeCircuitState("flaky"); !ok || state != CircuitOpen {
+		t.Fatalf("Expected open circuit, got %v (registered=%v)", state, ok)
+	}
+
+	response := request()
+	if response.Type != Error {
+		t.Fatalf("Expected Error, got %v", response.Type)
+	}
+	var payload ErrorPayload
+	if err := json.Unmarshal(response.Payload, &payload); err != nil {
+		t.Fatalf("Failed to unmarshal error payload: %v", err)
+	}
+	if payload.Code != ErrMCPEndpointUnavailable {
+		t.Errorf("Expected ErrMCPEndpointUnavailable, got %v", payload.Code)
+	}
+
+	// Deregistering drops the breaker with the bridge
+	handler.DeregisterMCPBridge("flaky")
+	if _, ok := handler.BridgeCircuitState("flaky"); ok {
+		t.Error("Expected circuit state to be removed with the bridge")
+	}
+}
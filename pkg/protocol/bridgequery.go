@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// MCPBridgeFilter selects MCP bridges by what they serve rather than by ID.
+// It is also the payload of an MCPBridgeQuery. Zero fields match any bridge.
+type MCPBridgeFilter struct {
+	// Protocol is the MCP protocol version the bridge must speak
+	Protocol string `json:"protocol,omitempty"`
+
+	// DataTypes must all be supported by the bridge, matching its DataTypes
+	// entries the same way an MCPBridgeRequest does
+	DataTypes []string `json:"data_types,omitempty"`
+
+	// Region is the region the bridge must run in
+	Region string `json:"region,omitempty"`
+
+	// MaxAge excludes bridges last updated longer ago than this
+	MaxAge time.Duration `json:"max_age,omitempty"`
+}
+
+// matches reports whether bridge passes every set field of f, as of now.
+// Callers must hold bridge.aclMu or own the bridge.
+func (f *MCPBridgeFilter) matches(bridge *MCPBridge, now time.Time) bool {
+	if f.Protocol != "" && bridge.Protocol != f.Protocol {
+		return false
+	}
+	if f.Region != "" && bridge.Location.Region != f.Region {
+		return false
+	}
+	if f.MaxAge > 0 && now.Sub(bridge.LastUpdated) > f.MaxAge {
+		return false
+	}
+	for _, dataType := range f.DataTypes {
+		if _, ok := matchDataType(bridge.DataTypes, dataType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryMCPBridges returns copies of the registered MCP bridges that pass
+// filter, most recently updated first
+func (h *Handler) QueryMCPBridges(filter MCPBridgeFilter) ([]*MCPBridge, error) {
+	return h.queryMCPBridges(filter, nil)
+}
+
+// queryMCPBridges is QueryMCPBridges limited to the bridges allow accepts.
+// A nil allow accepts every bridge.
+func (h *Handler) queryMCPBridges(filter MCPBridgeFilter, allow func(*MCPBridge) bool) ([]*MCPBridge, error) {
+	if filter.MaxAge < 0 {
+		return nil, errors.New("max age must not be negative")
+	}
+
+	now := time.Now()
+	h.mu.RLock()
+	bridges := make([]*MCPBridge, 0, len(h.mcpBridges))
+	for _, bridge := range h.mcpBridges {
+		if allow != nil && !allow(bridge) {
+			continue
+		}
+		if snapshot := bridge.snapshot(); filter.matches(snapshot, now) {
+			bridges = append(bridges, snapshot)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(bridges, func(i, j int) bool {
+		a, b := bridges[i], bridges[j]
+		if !a.LastUpdated.Equal(b.LastUpdated) {
+			return a.LastUpdated.After(b.LastUpdated)
+		}
+		return a.ID < b.ID
+	})
+	return bridges, nil
+}
+
+// handleMCPBridgeQuery answers an MCPBridgeQuery with the bridges passing
+// its filter that the requester may use
+func (h *Handler) handleMCPBridgeQuery(ctx context.Context, msg *Message) (*Message, error) {
+	var filter MCPBridgeFilter
+	if len(msg.Payload) > 0 {
+		if err := msg.DecodePayload(&filter); err != nil {
+			return NewErrorMessage(ErrInvalidPayload, "invalid bridge query format")
+		}
+	}
+
+	peerID, _ := h.trustedPeerID(ctx)
+	bridges, err := h.queryMCPBridges(filter, func(bridge *MCPBridge) bool {
+		return bridge.allows(ctx, peerID)
+	})
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, err.Error())
+	}
+
+	payload, err := json.Marshal(bridges)
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal bridges")
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
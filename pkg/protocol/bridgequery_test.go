@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQueryMCPBridges(t *testing.T) {
+	now := time.Now()
+	bridges := []*MCPBridge{
+		{ID: "eu-json", DataTypes: []string{"structured.*", "text"}, Location: Location{Region: "eu-west"}, LastUpdated: now.Add(-time.Minute)},
+		{ID: "us-json", DataTypes: []string{"structured.json"}, Location: Location{Region: "us-east"}, LastUpdated: now},
+		{ID: "stale", DataTypes: []string{"structured.json"}, Location: Location{Region: "eu-west"}, LastUpdated: now.Add(-2 * time.Hour)},
+		{ID: "restricted", DataTypes: []string{"images"}, LastUpdated: now.Add(-time.Second), AllowedClients: []string{"agent-9"}},
+	}
+
+	handler := NewHandler()
+	defer handler.Close()
+	for _, b := range bridges {
+		b.Endpoint = "mcp://" + b.ID + "/v1"
+		b.Protocol = "MCP/1.0"
+		b.Metadata = map[string]string{"auth_type": "none", "data_format": "json"}
+		if err := handler.RegisterMCPBridge(b); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", b.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter MCPBridgeFilter
+		want   []string
+	}{
+		{"zero filter, newest first", MCPBridgeFilter{}, []string{"us-json", "restricted", "eu-json", "stale"}},
+		{"protocol", MCPBridgeFilter{Protocol: "MCP/1.0"}, []string{"us-json", "restricted", "eu-json", "stale"}},
+		{"other protocol", MCPBridgeFilter{Protocol: "MCP/2.0"}, []string{}},
+		{"data type glob", MCPBridgeFilter{DataTypes: []string{"structured.json"}}, []string{"us-json", "eu-json", "stale"}},
+		{"every data type", MCPBridgeFilter{DataTypes: []string{"structured.json", "text"}}, []string{"eu-json"}},
+		{"region", MCPBridgeFilter{Region: "eu-west"}, []string{"eu-json", "stale"}},
+		{"max age", MCPBridgeFilter{MaxAge: time.Hour}, []string{"us-json", "restricted", "eu-json"}},
+		{"combined", MCPBridgeFilter{Region: "eu-west", MaxAge: time.Hour, DataTypes: []string{"structured.xml"}}, []string{"eu-json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := handler.QueryMCPBridges(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryMCPBridges() error = %v", err)
+			}
+			ids := make([]string, 0, len(got))
+			for _, b := range got {
+				ids = append(ids, b.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("QueryMCPBridges() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if _, err := handler.QueryMCPBridges(MCPBridgeFilter{MaxAge: -time.Second}); err == nil {
+		t.Error("Expected a negative MaxAge to be rejected")
+	}
+}
+
+func TestHandleMCPBridgeQuery(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+	for _, b := range []*MCPBridge{
+		{ID: "open", DataTypes: []string{"images"}},
+		{ID: "restricted", DataTypes: []string{"images"}, AllowedClients: []string{"agent-9"}},
+	} {
+		b.Endpoint = "mcp://" + b.ID + "/v1"
+		b.Protocol = "MCP/1.0"
+		b.Metadata = map[string]string{"auth_type": "none", "data_format": "json"}
+		if err := handler.RegisterMCPBridge(b); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", b.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		msg     *Message
+		want    []string
+		wantErr ErrorCode
+	}{
+		{"peer sees bridges it may use", ContextWithPeerID(context.Background(), "agent-1"), streamMessage(t, MCPBridgeQuery, MCPBridgeFilter{DataTypes: []string{"images"}}), []string{"open"}, 0},
+		{"allowed peer sees all", ContextWithPeerID(context.Background(), "agent-9"), streamMessage(t, MCPBridgeQuery, MCPBridgeFilter{}), []string{"open", "restricted"}, 0},
+		{"empty payload", ContextWithPeerID(context.Background(), "agent-1"), &Message{Version: V1, Type: MCPBridgeQuery, Timestamp: time.Now()}, []string{"open"}, 0},
+		{"negative max age", context.Background(), streamMessage(t, MCPBridgeQuery, MCPBridgeFilter{MaxAge: -1}), nil, ErrInvalidPayload},
+		{"malformed", context.Background(), &Message{Version: V1, Type: MCPBridgeQuery, Payload: []byte("{"), Timestamp: time.Now()}, nil, ErrInvalidPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(tt.ctx, tt.msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if tt.wantErr != 0 {
+				var payload ErrorPayload
+				if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != tt.wantErr {
+					t.Errorf("HandleMessage() = %s, want %v", response.Payload, tt.wantErr)
+				}
+				return
+			}
+
+			var bridges []*MCPBridge
+			if response.Type != Response || json.Unmarshal(response.Payload, &bridges) != nil {
+				t.Fatalf("HandleMessage() = %v %s, want a bridge list", response.Type, response.Payload)
+			}
+			ids := make([]string, 0, len(bridges))
+			for _, b := range bridges {
+				ids = append(ids, b.ID)
+			}
+			slices.Sort(ids)
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("MCPBridgeQuery returned %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
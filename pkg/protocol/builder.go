@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MessageBuilder assembles a Message step by step. Errors are collected
+// along the way and reported together by Build.
+type MessageBuilder struct {
+	msg  Message
+	errs []error
+}
+
+// NewMessage starts a message of type t, timestamped now. The version
+// defaults to V1, or V2 when a field only V2 can carry is set.
+func NewMessage(t MessageType) *MessageBuilder {
+	return &MessageBuilder{msg: Message{Type: t, Timestamp: time.Now()}}
+}
+
+// WithPayload encodes v as the JSON payload
+func (b *MessageBuilder) WithPayload(v interface{}) *MessageBuilder {
+	if err := b.msg.SetPayload(v, EncodingJSON); err != nil {
+		b.errs = append(b.errs, fmt.Errorf("payload: %w", err))
+	}
+	return b
+}
+
+// WithVersion sets the protocol version instead of choosing one
+func (b *MessageBuilder) WithVersion(v Version) *MessageBuilder {
+	b.msg.Version = v
+	return b
+}
+
+// WithCorrelationID sets the ID the response will echo
+func (b *MessageBuilder) WithCorrelationID(id [16]byte) *MessageBuilder {
+	b.msg.CorrelationID = id
+	return b
+}
+
+// Build validates the message and returns it. Each call returns a new
+// Message, so a builder can be reused as a template.
+func (b *MessageBuilder) Build() (*Message, error) {
+	errs := append([]error(nil), b.errs...)
+	msg := b.msg
+
+	if msg.Type == 0 {
+		errs = append(errs, fmt.Errorf("%w: message type required", ErrInvalidMessageType))
+	}
+
+	switch {
+	case msg.Version == 0 && msg.usesV2Fields():
+		msg.Version = V2
+	case msg.Version == 0:
+		msg.Version = V1
+	case msg.Version < MinSupportedVersion || msg.Version > MaxSupportedVersion:
+		errs = append(errs, fmt.Errorf("%w: %d is outside supported versions %d to %d",
+			ErrInvalidVersion, msg.Version, MinSupportedVersion, MaxSupportedVersion))
+	case msg.Version < V2 && msg.usesV2Fields():
+		errs = append(errs, fmt.Errorf("%w: correlation IDs and other extensions require version %d, got %d",
+			ErrInvalidVersion, V2, msg.Version))
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid message: %w", errors.Join(errs...))
+	}
+	return &msg, nil
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	msg, err := NewMessage(Query).WithPayload(&QueryPayload{CapabilityType: "DISCOVER"}).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if msg.Version != V1 || msg.Type != Query || msg.Timestamp.IsZero() {
+		t.Errorf("Build() = %+v", msg)
+	}
+	if int(msg.PayloadSize) != len(msg.Payload) {
+		t.Errorf("PayloadSize = %d, payload is %d bytes", msg.PayloadSize, len(msg.Payload))
+	}
+
+	var query QueryPayload
+	if err := msg.DecodePayload(&query); err != nil || query.CapabilityType != "DISCOVER" {
+		t.Errorf("DecodePayload() = %+v, %v", query, err)
+	}
+
+	// Built messages go on the wire as they are
+	if _, err := msg.Serialize(); err != nil {
+		t.Errorf("Serialize() error = %v", err)
+	}
+}
+
+func TestMessageBuilderCorrelationID(t *testing.T) {
+	id := [16]byte{1, 2, 3}
+
+	msg, err := NewMessage(Hello).WithCorrelationID(id).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if msg.Version != V2 || msg.CorrelationID != id {
+		t.Errorf("Build() version %d, correlation ID %x", msg.Version, msg.CorrelationID)
+	}
+
+	// An explicit V1 cannot carry it
+	if _, err := NewMessage(Hello).WithVersion(V1).WithCorrelationID(id).Build(); !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("Build() error = %v, want %v", err, ErrInvalidVersion)
+	}
+}
+
+func TestMessageBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *MessageBuilder
+		want    error
+	}{
+		{"missing type", NewMessage(0), ErrInvalidMessageType},
+		{"unsupported version", NewMessage(Hello).WithVersion(9), ErrInvalidVersion},
+		{"unencodable payload", NewMessage(Register).WithPayload(make(chan int)), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.builder.Build()
+			if err == nil {
+				t.Fatalf("Build() = %+v, want error", msg)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Build() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	_, err := NewMessage(0).WithVersion(9).Build()
+	if !errors.Is(err, ErrInvalidMessageType) || !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("Build() error = %v, want both type and version errors", err)
+	}
+}
+
+func TestMessageBuilderReuse(t *testing.T) {
+	b := NewMessage(Hello)
+	first, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	second, err := b.WithCorrelationID([16]byte{9}).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if first == second || first.HasCorrelationID() {
+		t.Error("Build() returned a message shared with a later Build")
+	}
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"slices"
+	"time"
+)
+
+// Default time a bridge slot stays taken by a request that is never
+// reported complete
+const defaultBridgeRequestTimeout = 30 * time.Second
+
+// WithBridgeRequestTimeout sets how long a request handed a bridge with
+// MaxConcurrentRequests holds its slot when CompleteBridgeRequest is not
+// called for it first
+func WithBridgeRequestTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.bridgeRequestTimeout = d
+	}
+}
+
+// setBridgeSlots sizes the semaphore bounding concurrent requests for
+// bridge. A bridge re-registered with the same limit keeps its semaphore,
+// so requests in flight still count against it.
+// Callers must hold h.mu for writing.
+func (h *Handler) setBridgeSlots(bridge *MCPBridge) {
+	if bridge.MaxConcurrentRequests <= 0 {
+		delete(h.bridgeSlots, bridge.ID)
+		return
+	}
+	if slots, ok := h.bridgeSlots[bridge.ID]; ok && cap(slots) == bridge.MaxConcurrentRequests {
+		return
+	}
+	h.bridgeSlots[bridge.ID] = make(chan struct{}, bridge.MaxConcurrentRequests)
+}
+
+// acquireSlot takes a slot from slots without waiting, reporting false if
+// all are taken. A nil semaphore never runs out.
+func acquireSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot returns a slot taken by acquireSlot
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// slotHold is a bridge slot taken by a request in flight
+type slotHold struct {
+	slots chan struct{}
+	timer *time.Timer
+}
+
+// grantBridge answers an MCPBridgeRequest with bridge, which matched the
+// requested data type with pattern, and keeps the slot taken from slots
+// for the request until CompleteBridgeRequest or the request timeout
+// frees it
+func (h *Handler) grantBridge(bridge *MCPBridge, pattern string, slots chan struct{}) (*Message, error) {
+	response, err := bridgeResponse(bridge, pattern)
+	if err != nil || response.Type == Error || slots == nil {
+		releaseSlot(slots)
+		return response, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hold := &slotHold{slots: slots}
+	hold.timer = time.AfterFunc(h.bridgeRequestTimeout, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.releaseHold(bridge.ID, hold)
+	})
+	h.bridgeHolds[bridge.ID] = append(h.bridgeHolds[bridge.ID], hold)
+	return response, nil
+}
+
+// CompleteBridgeRequest reports that a request handed bridge id by an
+// MCPBridgeRequest has finished with err. It frees the oldest slot held
+// against the bridge's MaxConcurrentRequests and records err with its
+// circuit breaker like RecordBridgeResult. Whatever forwards requests to
+// the bridge should call it once for every MCPBridgeResponse it acts on.
+func (h *Handler) CompleteBridgeRequest(id string, err error) {
+	h.mu.Lock()
+	if holds := h.bridgeHolds[id]; len(holds) > 0 {
+		h.releaseHold(id, holds[0])
+	}
+	h.mu.Unlock()
+
+	h.RecordBridgeResult(id, err)
+}
+
+// releaseHold frees the slot of hold unless it was already freed.
+// Callers must hold h.mu for writing.
+func (h *Handler) releaseHold(id string, hold *slotHold) {
+	holds := h.bridgeHolds[id]
+	i := slices.Index(holds, hold)
+	if i < 0 {
+		return
+	}
+	if holds = slices.Delete(holds, i, i+1); len(holds) == 0 {
+		delete(h.bridgeHolds, id)
+	} else {
+		h.bridgeHolds[id] = holds
+	}
+	hold.timer.Stop()
+	releaseSlot(hold.slots)
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMCPBridgeMaxConcurrentRequests(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	for _, b := range []*MCPBridge{
+		{ID: "limited", MaxConcurrentRequests: 2},
+		{ID: "unlimited"},
+	} {
+		b.Endpoint = "mcp://" + b.ID + "/v1"
+		b.Protocol = "MCP/1.0"
+		b.Metadata = map[string]string{"auth_type": "none", "data_format": "json"}
+		b.DataTypes = []string{"images"}
+		if err := handler.RegisterMCPBridge(b); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", b.ID, err)
+		}
+	}
+
+	request := func(bridgeID string) (string, string) {
+		t.Helper()
+		response, err := handler.HandleMessage(context.Background(), streamMessage(t, MCPBridgeRequest, MCPBridgeRequestPayload{BridgeID: bridgeID, DataType: "images"}))
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		if response.Type == Error {
+			var payload ErrorPayload
+			json.Unmarshal(response.Payload, &payload)
+			return "", payload.Message
+		}
+		var details MCPBridgeResponsePayload
+		if err := json.Unmarshal(response.Payload, &details); err != nil {
+			t.Fatalf("Failed to decode bridge response: %v", err)
+		}
+		return details.ID, ""
+	}
+
+	// N+1 concurrent requests for a bridge limited to N: each granted one
+	// holds its slot, so exactly one is turned away
+	const limit = 2
+	results := make(chan string, limit+1)
+	var wg sync.WaitGroup
+	for range limit + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errText := request("limited")
+			results <- errText
+		}()
+	}
+	wg.Wait()
+	close(results)
+	busy := 0
+	for errText := range results {
+		switch errText {
+		case "":
+		case "bridge at capacity":
+			busy++
+		default:
+			t.Errorf("Unexpected error %q", errText)
+		}
+	}
+	if busy != 1 {
+		t.Errorf("Expected 1 of %d concurrent requests at capacity, got %d", limit+1, busy)
+	}
+
+	if id, _ := request("unlimited"); id != "unlimited" {
+		t.Errorf("Expected the unlimited bridge unaffected, got %q", id)
+	}
+
+	// Selection passes over a bridge at capacity
+	for i := 0; i < 3; i++ {
+		if id, errText := request(""); id != "unlimited" {
+			t.Errorf("Expected selection to skip the full bridge, got %q %s", id, errText)
+		}
+	}
+
+	// Re-registering with the same limit keeps the slots in use
+	if err := handler.RegisterMCPBridge(handler.ListMCPBridges()[0]); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+	if _, errText := request("limited"); errText != "bridge at capacity" {
+		t.Errorf("Expected re-registration to keep the bridge at capacity, got %q", errText)
+	}
+
+	// A completed request frees its slot for the next
+	handler.CompleteBridgeRequest("limited", nil)
+	if id, errText := request("limited"); id != "limited" {
+		t.Errorf("Expected the released slot to be usable, got %s", errText)
+	}
+	if _, errText := request("limited"); errText != "bridge at capacity" {
+		t.Errorf("Expected the slot taken again, got %q", errText)
+	}
+	negative := &MCPBridge{ID: "negative", Endpoint: "mcp://negative/v1", Protocol: "MCP/1.0", Metadata: map[string]string{"auth_type": "none", "data_format": "json"}, MaxConcurrentRequests: -1}
+	if err := handler.RegisterMCPBridge(negative); err == nil {
+		t.Error("Expected a negative MaxConcurrentRequests to be rejected")
+	}
+}
+
+func TestBridgeRequestTimeout(t *testing.T) {
+	handler := NewHandler(WithBridgeRequestTimeout(20 * time.Millisecond))
+	defer handler.Close()
+
+	bridge := &MCPBridge{ID: "limited", Endpoint: "mcp://limited/v1", Protocol: "MCP/1.0", Metadata: map[string]string{"auth_type": "none", "data_format": "json"}, DataTypes: []string{"images"}, MaxConcurrentRequests: 1}
+	if err := handler.RegisterMCPBridge(bridge); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+	request := func() MessageType {
+		t.Helper()
+		response, err := handler.HandleMessage(context.Background(), streamMessage(t, MCPBridgeRequest, MCPBridgeRequestPayload{BridgeID: "limited", DataType: "images"}))
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response.Type
+	}
+
+	if got := request(); got != MCPBridgeResponse {
+		t.Fatalf("Expected the bridge granted, got %v", got)
+	}
+	if got := request(); got != Error {
+		t.Fatalf("Expected the bridge at capacity, got %v", got)
+	}
+
+	// A request never reported complete gives its slot back after the timeout
+	deadline := time.Now().Add(time.Second)
+	for request() != MCPBridgeResponse {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the slot freed after the request timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hexCodec is JSON written as hex, distinct enough that decoding it as plain
+// JSON fails
+type hexCodec struct{}
+
+const hexCodecID = 42
+
+func (hexCodec) ID() uint8 { return hexCodecID }
+
+func (hexCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(data)), nil
+}
+
+func (hexCodec) Unmarshal(data []byte, v interface{}) error {
+	raw, err := hex.DecodeString(string(data))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+var registerHexCodec sync.Once
+
+func useHexCodec(t *testing.T) {
+	t.Helper()
+	registerHexCodec.Do(func() {
+		if err := CodecRegistry.Register(hexCodec{}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	})
+}
+
+func TestCodecsRegister(t *testing.T) {
+	codecs := NewCodecs()
+	if _, err := codecs.Get(hexCodecID); err == nil {
+		t.Error("Get() found a codec in an empty registry")
+	}
+
+	if err := codecs.Register(hexCodec{}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := codecs.Register(hexCodec{}); err == nil {
+		t.Error("Register() accepted a duplicate ID")
+	}
+
+	c, err := codecs.Get(hexCodecID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if _, ok := c.(hexCodec); !ok {
+		t.Errorf("Get() = %T, want hexCodec", c)
+	}
+}
+
+func TestBuiltinCodecs(t *testing.T) {
+	for _, enc := range []Encoding{EncodingJSON, EncodingGob, EncodingProtobuf} {
+		c, err := CodecRegistry.Get(uint8(enc))
+		if err != nil {
+			t.Errorf("Get(%d) error = %v", enc, err)
+			continue
+		}
+		if c.ID() != uint8(enc) {
+			t.Errorf("Codec for encoding %d has ID %d", enc, c.ID())
+		}
+	}
+}
+
+func TestSetPayloadCodec(t *testing.T) {
+	useHexCodec(t)
+
+	msg := &Message{Version: V1, Type: Query, Timestamp: time.Now()}
+	if err := msg.SetPayloadCodec(&QueryPayload{CapabilityType: "DISCOVER"}, hexCodecID); err != nil {
+		t.Fatalf("SetPayloadCodec() error = %v", err)
+	}
+	if msg.Version != V2 || msg.CodecID != hexCodecID {
+		t.Errorf("SetPayloadCodec() left version %d, codec %d", msg.Version, msg.CodecID)
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if decoded.CodecID != hexCodecID {
+		t.Fatalf("CodecID = %d after round trip, want %d", decoded.CodecID, hexCodecID)
+	}
+
+	var query QueryPayload
+	if err := decoded.DecodePayload(&query); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if query.CapabilityType != "DISCOVER" {
+		t.Errorf("Decoded %+v", query)
+	}
+
+	// Built-in IDs go through the encoding bits rather than CodecID
+	if err := msg.SetPayloadCodec(&QueryPayload{CapabilityType: "DISCOVER"}, uint8(EncodingGob)); err != nil {
+		t.Fatalf("SetPayloadCodec() error = %v", err)
+	}
+	if msg.CodecID != 0 || msg.Encoding != EncodingGob {
+		t.Errorf("SetPayloadCodec(gob) left codec %d, encoding %d", msg.CodecID, msg.Encoding)
+	}
+
+	if err := msg.SetPayloadCodec(nil, 200); err == nil {
+		t.Error("SetPayloadCodec() accepted an unregistered codec")
+	}
+}
+
+func TestHandlerCustomCodec(t *testing.T) {
+	useHexCodec(t)
+
+	handler := NewHandler()
+	defer handler.Close()
+
+	register := &Message{Version: V2, Type: Register, Timestamp: time.Now()}
+	if err := register.SetPayloadCodec(&Capability{ID: "hex-cap", Type: "DISCOVER", Version: "1.0.0"}, hexCodecID); err != nil {
+		t.Fatalf("SetPayloadCodec() error = %v", err)
+	}
+	response, err := handler.HandleMessage(context.Background(), register)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type == Error {
+		t.Fatalf("Register failed: %s", response.Payload)
+	}
+
+	// Without the codec ID the payload is not valid JSON
+	register.CodecID = 0
+	response, err = handler.HandleMessage(context.Background(), register)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Error {
+		t.Errorf("Expected Error decoding hex payload as JSON, got %v", response.Type)
+	}
+
+	if _, ok := handler.capabilities["hex-cap"]; !ok {
+		t.Error("Capability registered with custom codec is missing")
+	}
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionCodec selects the algorithm used for compressed payloads
+type CompressionCodec uint8
+
+const (
+	CompressionGzip CompressionCodec = iota
+	CompressionZstd
+)
+
+// Upper bound on a decompressed payload, guards against decompression bombs
+const maxDecompressedSize = 64 << 20
+
+func compress(codec CompressionCodec, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch codec {
+	case CompressionGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("gzip compression failed: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("gzip compression failed: %w", err)
+		}
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("zstd compression failed: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("zstd compression failed: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("zstd compression failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(codec CompressionCodec, data []byte) ([]byte, error) {
+	var r io.Reader
+
+	switch codec {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decompression failed: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zstd decompression failed: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	}
+	if len(out) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+	}
+	return out, nil
+}
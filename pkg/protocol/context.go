@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"context"
+	"net"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+type peerAddrKey struct{}
+
+type peerIDKey struct{}
+
+type tenantIDKey struct{}
+
+type sessionIDKey struct{}
+
+// ContextWithPeerAddr returns a copy of ctx carrying the address of the remote peer
+func ContextWithPeerAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, peerAddrKey{}, addr)
+}
+
+// PeerAddrFromContext returns the remote peer address stored in ctx, if any
+func PeerAddrFromContext(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(peerAddrKey{}).(net.Addr)
+	return addr, ok
+}
+
+// ContextWithPeerID returns a copy of ctx carrying the ID the peer gave during the handshake
+func ContextWithPeerID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, peerIDKey{}, id)
+}
+
+// PeerIDFromContext returns the peer ID stored in ctx, if any
+func PeerIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(peerIDKey{}).(string)
+	return id, ok
+}
+
+// ContextWithTenantID returns a copy of ctx carrying the tenant the peer
+// joined during the handshake
+func ContextWithTenantID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantIDKey{}, id)
+}
+
+// TenantIDFromContext returns the tenant stored in ctx, if any
+func TenantIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// ContextWithSessionID returns a copy of ctx carrying the ID the server gave
+// the connection, unique to it for the server's lifetime
+func ContextWithSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, id)
+}
+
+// SessionIDFromContext returns the session ID stored in ctx, if any
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// trustedPeerID returns the peer ID that access control and capability
+// ownership may rely on. With a keyring that is only the identity the peer
+// proved, so a declared ID cannot impersonate another peer. Without one no
+// identity can be proven and the ID given during the handshake is used.
+func (h *Handler) trustedPeerID(ctx context.Context) (string, bool) {
+	if identity, ok := security.PeerIdentityFromContext(ctx); ok {
+		return identity.ID, true
+	}
+	if h.keyring != nil {
+		return "", false
+	}
+	return PeerIDFromContext(ctx)
+}
+
+// ownerID identifies the peer behind ctx for capability ownership, preferring
+// its trusted peer ID. Anonymous peers own what their session registered, so
+// that peers sharing a host cannot unregister each other's capabilities.
+// Local calls have no owner.
+func (h *Handler) ownerID(ctx context.Context) string {
+	if id, ok := h.trustedPeerID(ctx); ok && id != "" {
+		return "id:" + id
+	}
+	if session, ok := SessionIDFromContext(ctx); ok {
+		return "session:" + session
+	}
+	return senderID(ctx)
+}
+
+// registrant records who registered a capability: owner may unregister it,
+// and it is deregistered along with session when that connection drops
+type registrant struct {
+	owner   string
+	session string
+}
+
+// registrantOf returns the registrant of capabilities registered through ctx
+func (h *Handler) registrantOf(ctx context.Context) registrant {
+	session, _ := SessionIDFromContext(ctx)
+	return registrant{owner: h.ownerID(ctx), session: session}
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// GenerateCorrelationID fills m.CorrelationID from crypto/rand
+func (m *Message) GenerateCorrelationID() error {
+	if _, err := rand.Read(m.CorrelationID[:]); err != nil {
+		return fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return nil
+}
+
+// HasCorrelationID reports whether m carries a correlation ID
+func (m *Message) HasCorrelationID() bool {
+	return m.CorrelationID != [16]byte{}
+}
+
+// correlate copies the request's correlation ID onto its response so the
+// sender can match them when responses arrive out of order
+func correlate(request, response *Message) {
+	if !request.HasCorrelationID() {
+		return
+	}
+	if response.Version < V2 {
+		response.Version = V2
+	}
+	response.CorrelationID = request.CorrelationID
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCorrelationIDRoundTrip(t *testing.T) {
+	msg := &Message{Version: V2, Type: Hello, Timestamp: time.Now()}
+	if msg.HasCorrelationID() {
+		t.Fatal("New message has a correlation ID")
+	}
+	if err := msg.GenerateCorrelationID(); err != nil {
+		t.Fatalf("GenerateCorrelationID() error = %v", err)
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if decoded.CorrelationID != msg.CorrelationID {
+		t.Errorf("CorrelationID = %x, want %x", decoded.CorrelationID, msg.CorrelationID)
+	}
+
+	v1 := &Message{Version: V1, Type: Hello, Timestamp: time.Now(), CorrelationID: msg.CorrelationID}
+	if _, err := v1.Serialize(); err == nil {
+		t.Error("Expected error serializing a correlation ID as V1")
+	}
+}
+
+func TestHandlerEchoesCorrelationID(t *testing.T) {
+	secret := []byte("correlation-secret")
+	handler := NewHandler()
+	defer handler.Close()
+	handler.SetSharedSecret(secret)
+
+	msg := &Message{Version: V2, Type: Hello, Timestamp: time.Now()}
+	if err := msg.GenerateCorrelationID(); err != nil {
+		t.Fatalf("GenerateCorrelationID() error = %v", err)
+	}
+	if err := msg.Sign(secret); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	response, err := handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.CorrelationID != msg.CorrelationID {
+		t.Errorf("Response CorrelationID = %x, want %x", response.CorrelationID, msg.CorrelationID)
+	}
+
+	// The correlation ID is covered by the response signature
+	if err := response.Verify(secret); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
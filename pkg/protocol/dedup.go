@@ -0,0 +1,241 @@
+package protocol
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// DefaultDeduplicationTTL is how long responses are remembered when
+// WithDeduplication is given no TTL
+const DefaultDeduplicationTTL = time.Minute
+
+// Default number of responses WithDeduplication remembers at once
+const defaultDedupCacheSize = 10000
+
+// WithDeduplication answers a message whose type and correlation ID were
+// already handled for the same sender within ttl with the response it got
+// then, rather than handling it again. This makes retries after a lost
+// response safe for requests that are not idempotent. The sender is the
+// identity the peer proved or, failing that, its session, so peers that
+// prove no identity are only deduplicated within one connection. A retry
+// must carry a fresh nonce, as Client.Send gives it, since duplicates are
+// looked up only once the message has passed the replay and role checks.
+func WithDeduplication(ttl time.Duration) Option {
+	return func(h *Handler) {
+		if ttl <= 0 {
+			ttl = DefaultDeduplicationTTL
+		}
+		h.dedupTTL = ttl
+	}
+}
+
+// WithDeduplicationCacheSize bounds the number of responses
+// WithDeduplication remembers at once
+func WithDeduplicationCacheSize(size int) Option {
+	return func(h *Handler) {
+		h.dedupCacheSize = size
+	}
+}
+
+// Deduplication returns the cache of WithDeduplication, or nil if the
+// handler does not deduplicate
+func (h *Handler) Deduplication() *DeduplicationCache {
+	return h.dedup
+}
+
+// DeduplicationCache is a bounded LRU of serialized responses, each kept
+// for a TTL. Expired entries are evicted by a background goroutine until
+// Close.
+type DeduplicationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries *list.List // of *dedupEntry, most recent at the front
+	index   map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type dedupEntry struct {
+	key      string
+	response []byte
+	expires  time.Time
+}
+
+// NewDeduplicationCache creates a cache whose entries live for ttl and
+// which holds at most size of them
+func NewDeduplicationCache(ttl time.Duration, size int) *DeduplicationCache {
+	c := &DeduplicationCache{
+		ttl:     ttl,
+		size:    size,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+		done:    make(chan struct{}),
+	}
+	go c.evictLoop()
+	return c
+}
+
+// Get returns the response stored under key, counting a hit or a miss
+func (c *DeduplicationCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		if time.Now().Before(entry.expires) {
+			c.entries.MoveToFront(el)
+			c.hits.Add(1)
+			return entry.response, true
+		}
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put stores response under key for the cache's TTL, evicting the least
+// recently used entries beyond its size
+func (c *DeduplicationCache) Put(key string, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.index[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		entry.response, entry.expires = response, expires
+		c.entries.MoveToFront(el)
+		return
+	}
+	c.index[key] = c.entries.PushFront(&dedupEntry{key: key, response: response, expires: expires})
+
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Len returns the number of responses held, expired or not
+func (c *DeduplicationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// remove drops el from the cache. Callers must hold c.mu.
+func (c *DeduplicationCache) remove(el *list.Element) {
+	c.entries.Remove(el)
+	delete(c.index, el.Value.(*dedupEntry).key)
+}
+
+// Hits returns how many lookups found a response
+func (c *DeduplicationCache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns how many lookups found none
+func (c *DeduplicationCache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+// Close stops the eviction goroutine
+func (c *DeduplicationCache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// evictLoop drops expired entries every TTL until Close
+func (c *DeduplicationCache) evictLoop() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.evict(now)
+		}
+	}
+}
+
+// evict drops the entries expired at now
+func (c *DeduplicationCache) evict(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.entries.Front(); el != nil; {
+		next := el.Next()
+		if !now.Before(el.Value.(*dedupEntry).expires) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// dedupKey identifies msg by its type, its correlation ID and the identity
+// its sender proved, or the session it came over if none, so that no other
+// peer is ever answered from the entry. It returns false if msg has no
+// correlation ID to be deduplicated by.
+func dedupKey(ctx context.Context, msg *Message) (string, bool) {
+	if !msg.HasCorrelationID() {
+		return "", false
+	}
+
+	sender := senderID(ctx)
+	if identity, ok := security.PeerIdentityFromContext(ctx); ok {
+		sender = "id:" + identity.ID
+	} else if session, ok := SessionIDFromContext(ctx); ok {
+		sender = "session:" + session
+	}
+	// The type and correlation ID have a fixed length, so keys cannot collide
+	return sender + string([]byte{byte(msg.Type)}) + string(msg.CorrelationID[:]), true
+}
+
+// deduplicated returns the response already sent for msg, if any
+func (h *Handler) deduplicated(ctx context.Context, msg *Message) (*Message, bool) {
+	if h.dedup == nil {
+		return nil, false
+	}
+	key, ok := dedupKey(ctx, msg)
+	if !ok {
+		return nil, false
+	}
+
+	data, ok := h.dedup.Get(key)
+	if !ok {
+		return nil, false
+	}
+	response, err := Deserialize(data)
+	if err != nil {
+		h.logger.Error("Failed to decode deduplicated response", "error", err)
+		return nil, false
+	}
+	return response, true
+}
+
+// remember stores the response to msg for answering duplicates of it
+func (h *Handler) remember(ctx context.Context, msg, response *Message) {
+	if h.dedup == nil {
+		return
+	}
+	key, ok := dedupKey(ctx, msg)
+	if !ok {
+		return
+	}
+
+	data, err := response.Serialize()
+	if err != nil {
+		h.logger.Error("Failed to serialize response for deduplication", "error", err)
+		return
+	}
+	h.dedup.Put(key, data)
+}
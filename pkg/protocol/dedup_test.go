@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func TestDeduplication(t *testing.T) {
+	handler := NewHandler(WithDeduplication(time.Minute))
+	defer handler.Close()
+	handler.SetSharedSecret([]byte("secret"))
+
+	var handled atomic.Int32
+	const counted, other MessageType = 200, 201
+	for _, typ := range []MessageType{counted, other} {
+		handler.HandleFunc(typ, func(ctx context.Context, msg *Message) (*Message, error) {
+			n := handled.Add(1)
+			return &Message{Version: V1, Type: Response, Payload: []byte{byte(n)}, Timestamp: time.Now()}, nil
+		})
+	}
+
+	// Two sessions from one host, and one identity proven over two sessions
+	session := func(id string) context.Context {
+		ctx := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000})
+		return ContextWithSessionID(ctx, id)
+	}
+	agent := &security.PeerIdentity{ID: "agent", Roles: []string{security.RoleAgent}}
+	proven := func(id string) context.Context {
+		return security.ContextWithPeerIdentity(session(id), agent)
+	}
+	request := func(typ MessageType, correlated bool) *Message {
+		msg := &Message{Version: V2, Type: typ, Timestamp: time.Now()}
+		if correlated {
+			msg.CorrelationID = [16]byte{1, 2, 3}
+		}
+		if err := msg.GenerateNonce(); err != nil {
+			t.Fatalf("GenerateNonce() error = %v", err)
+		}
+		if err := msg.Sign([]byte("secret")); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return msg
+	}
+
+	// A retry resends the request sealed with a fresh nonce
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		msg         *Message
+		wantPayload byte
+	}{
+		{"first request", session("a"), request(counted, true), 1},
+		{"retry answered from cache", session("a"), request(counted, true), 1},
+		{"same ID from another session", session("b"), request(counted, true), 2},
+		{"same ID for another type", session("a"), request(other, true), 3},
+		{"no correlation ID", session("a"), request(counted, false), 4},
+		{"no correlation ID again", session("a"), request(counted, false), 5},
+		{"identity", proven("c"), request(counted, true), 6},
+		{"identity retried over a new session", proven("d"), request(counted, true), 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(tt.ctx, tt.msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if response.Type != Response || len(response.Payload) != 1 || response.Payload[0] != tt.wantPayload {
+				t.Fatalf("HandleMessage() = %v %v, want response %d", response.Type, response.Payload, tt.wantPayload)
+			}
+			if response.CorrelationID != tt.msg.CorrelationID {
+				t.Errorf("CorrelationID = %x, want %x", response.CorrelationID, tt.msg.CorrelationID)
+			}
+			if err := response.Verify([]byte("secret")); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+
+	// Only correlated requests are looked up
+	cache := handler.Deduplication()
+	if cache.Hits() != 2 || cache.Misses() != 4 {
+		t.Errorf("Hits() = %d, Misses() = %d, want 2 and 4", cache.Hits(), cache.Misses())
+	}
+
+	// Replaying the exact same message is refused for its nonce, correlated or not
+	for _, correlated := range []bool{false, true} {
+		replay := request(counted, correlated)
+		handler.HandleMessage(session("a"), replay)
+		if response, _ := handler.HandleMessage(session("a"), replay); response.Type != Error {
+			t.Errorf("Expected a replayed message (correlated %v) refused, got %v", correlated, response.Type)
+		}
+	}
+
+	// A cached response is not handed to a peer that may no longer send the request
+	handler.RequireRole(counted, security.RoleAdmin)
+	if response, _ := handler.HandleMessage(proven("e"), request(counted, true)); response.Type != Error {
+		t.Errorf("Expected a retry without the required role refused, got %v %v", response.Type, response.Payload)
+	}
+
+	if NewHandler().Deduplication() != nil {
+		t.Error("Expected no cache without WithDeduplication")
+	}
+}
+
+func TestDeduplicationCacheSize(t *testing.T) {
+	cache := NewDeduplicationCache(time.Minute, 2)
+	defer cache.Close()
+
+	cache.Put("a", []byte("1"))
+	cache.Put("b", []byte("2"))
+	cache.Get("a") // "b" is now the least recently used
+	cache.Put("c", []byte("3"))
+
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestDeduplicationCacheExpiry(t *testing.T) {
+	cache := NewDeduplicationCache(20*time.Millisecond, defaultDedupCacheSize)
+	defer cache.Close()
+
+	cache.Put("kept", []byte("response"))
+	if got, ok := cache.Get("kept"); !ok || string(got) != "response" {
+		t.Fatalf("Get() = %q, %v, want the stored response", got, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get("kept"); ok {
+		t.Error("Expected an expired entry to miss")
+	}
+
+	// The eviction goroutine drops expired entries
+	deadline := time.Now().Add(time.Second)
+	for {
+		if cache.Len() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expired entry to be evicted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if cache.Hits() != 1 || cache.Misses() != 1 {
+		t.Errorf("Hits() = %d, Misses() = %d, want 1 and 1", cache.Hits(), cache.Misses())
+	}
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errDependenciesPending is returned by storeCapability when it holds a
+// capability back until its dependencies are registered
+var errDependenciesPending = errors.New("dependencies not registered")
+
+// pendingCapability is a registration waiting for its dependencies
+type pendingCapability struct {
+	cap *Capability
+	by  registrant
+}
+
+// validateDependencies rejects dependency lists that can never be satisfied
+func validateDependencies(cap *Capability) error {
+	for _, dep := range cap.Dependencies {
+		switch dep {
+		case "":
+			return fmt.Errorf("%w: capability %s has an empty dependency", ErrInvalidCapabilityFormat, cap.ID)
+		case cap.ID:
+			return fmt.Errorf("%w: capability %s depends on itself", ErrInvalidCapabilityFormat, cap.ID)
+		}
+	}
+	return nil
+}
+
+// missingDependencies returns the dependencies of cap not yet registered.
+// Callers must hold h.mu.
+func (h *Handler) missingDependencies(cap *Capability) []string {
+	var missing []string
+	for _, dep := range cap.Dependencies {
+		if _, ok := h.capabilities[dep]; !ok {
+			missing = append(missing, dep)
+		}
+	}
+	return missing
+}
+
+// dependsOnItself reports whether registering cap would close a cycle, with
+// one of its dependencies already depending on it. Callers must hold h.mu.
+func (h *Handler) dependsOnItself(cap *Capability) bool {
+	for _, dep := range cap.Dependencies {
+		for _, transitive := range h.graph.TransitiveDeps(dep) {
+			if transitive.ID == cap.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dependents returns the IDs of the registered capabilities that depend on
+// id, ordered by ID
+func (h *Handler) Dependents(id string) []string {
+	var ids []string
+	for _, cap := range h.graph.DependentsOf(id) {
+		ids = append(ids, cap.ID)
+	}
+	return ids
+}
+
+// takeReadyPending removes and returns the pending capabilities whose
+// dependencies are now all registered, ordered by ID
+func (h *Handler) takeReadyPending() []*pendingCapability {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ready []*pendingCapability
+	for id, p := range h.pending {
+		if len(h.missingDependencies(p.cap)) == 0 {
+			ready = append(ready, p)
+			delete(h.pending, id)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].cap.ID < ready[j].cap.ID })
+	return ready
+}
+
+// resolvePending registers every pending capability whose dependencies have
+// arrived. Each registration resolves again, so chains complete in one call.
+func (h *Handler) resolvePending() {
+	for _, p := range h.takeReadyPending() {
+		if err := h.registerCapability(p.cap, p.by); err != nil {
+			h.logger.Error("Failed to register capability after its dependencies", "capability", p.cap.ID, "error", err)
+		}
+	}
+}
+
+// CapabilityReady returns a channel that is closed once the capability id is
+// registered. A capability with dependencies is only registered after all of
+// them are, so the channel also means its dependencies are in place.
+func (h *Handler) CapabilityReady(id string) <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ready := make(chan struct{})
+	if _, ok := h.capabilities[id]; ok {
+		close(ready)
+		return ready
+	}
+	h.readyWaiters[id] = append(h.readyWaiters[id], ready)
+	return ready
+}
+
+// signalReady releases everyone waiting on id. Callers must hold h.mu for writing.
+func (h *Handler) signalReady(id string) {
+	for _, ready := range h.readyWaiters[id] {
+		close(ready)
+	}
+	delete(h.readyWaiters, id)
+}
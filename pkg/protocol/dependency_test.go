@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+)
+
+func isReady(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestCapabilityDependencies(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	var registered []string
+	handler.Events().Subscribe(events.CapabilityRegistered, func(event interface{}) {
+		registered = append(registered, event.(*Capability).ID)
+	})
+
+	bridge := &Capability{ID: "bridge", Type: "DELEGATE", Dependencies: []string{"discovery"}}
+	ready := handler.CapabilityReady("bridge")
+
+	if err := handler.RegisterCapability(bridge); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if handler.CapabilityCount() != 0 {
+		t.Errorf("Capability registered before its dependency")
+	}
+	if isReady(ready) {
+		t.Error("CapabilityReady closed before the dependency was registered")
+	}
+
+	if err := handler.RegisterCapability(&Capability{ID: "discovery", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if !isReady(ready) {
+		t.Error("CapabilityReady still open after the dependency was registered")
+	}
+	if handler.CapabilityCount() != 2 {
+		t.Errorf("Expected 2 capabilities, got %d", handler.CapabilityCount())
+	}
+	if len(registered) != 2 || registered[0] != "discovery" || registered[1] != "bridge" {
+		t.Errorf("Expected discovery then bridge to be published, got %v", registered)
+	}
+
+	// Once registered, readiness is immediate
+	if !isReady(handler.CapabilityReady("bridge")) {
+		t.Error("CapabilityReady not closed for a registered capability")
+	}
+}
+
+func TestCapabilityDependencyChain(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	// c needs b needs a, registered in reverse
+	for _, cap := range []*Capability{
+		{ID: "c", Type: "STREAM", Dependencies: []string{"b"}},
+		{ID: "b", Type: "STREAM", Dependencies: []string{"a"}},
+	} {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+	ready := handler.CapabilityReady("c")
+
+	if err := handler.RegisterCapability(&Capability{ID: "a", Type: "STREAM"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("Chain did not resolve")
+	}
+	if handler.CapabilityCount() != 3 {
+		t.Errorf("Expected 3 capabilities, got %d", handler.CapabilityCount())
+	}
+}
+
+func TestCapabilityDependencyValidation(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	tests := []struct {
+		name string
+		cap  *Capability
+	}{
+		{"self dependency", &Capability{ID: "loop", Dependencies: []string{"loop"}}},
+		{"empty dependency", &Capability{ID: "blank", Dependencies: []string{""}}},
+		{"bad version", &Capability{ID: "bad", Version: "one", Dependencies: []string{"missing"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := handler.RegisterCapability(tt.cap); err == nil {
+				t.Error("RegisterCapability() accepted an invalid capability")
+			}
+		})
+	}
+}
+
+func TestDeregisterPendingCapability(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	if err := handler.RegisterCapability(&Capability{ID: "waiting", Dependencies: []string{"base"}}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.DeregisterCapability("waiting"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if err := handler.DeregisterCapability("waiting"); !errors.Is(err, ErrCapabilityNotFound) {
+		t.Errorf("DeregisterCapability() error = %v, want %v", err, ErrCapabilityNotFound)
+	}
+
+	// The cancelled registration does not come back with its dependency
+	if err := handler.RegisterCapability(&Capability{ID: "base"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if handler.CapabilityCount() != 1 {
+		t.Errorf("Expected only base registered, got %d capabilities", handler.CapabilityCount())
+	}
+}
+
+func TestRegisterMessageWithDependencies(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	register := func(cap *Capability) {
+		t.Helper()
+		payload, _ := json.Marshal(cap)
+		response, err := handler.HandleMessage(context.Background(), &Message{
+			Version:   V1,
+			Type:      Register,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		if response.Type == Error {
+			t.Fatalf("Register %s failed: %s", cap.ID, response.Payload)
+		}
+	}
+
+	register(&Capability{ID: "dependent", Type: "DISCOVER", Dependencies: []string{"base"}})
+	ready := handler.CapabilityReady("dependent")
+	register(&Capability{ID: "base", Type: "DISCOVER"})
+
+	if !isReady(ready) {
+		t.Error("Dependent capability not registered after its dependency")
+	}
+}
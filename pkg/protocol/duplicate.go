@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DuplicatePolicy decides what happens when a capability is registered under
+// an ID that is already taken by a different version
+type DuplicatePolicy int
+
+const (
+	// Overwrite replaces the registered capability, the default
+	Overwrite DuplicatePolicy = iota
+	// Reject refuses the registration with ErrInvalidCapabilityFormat
+	Reject
+	// KeepLatestVersion keeps whichever capability has the higher SemVer version
+	KeepLatestVersion
+)
+
+// errOlderVersion is returned by storeCapability when KeepLatestVersion keeps
+// the registered capability instead of cap
+var errOlderVersion = errors.New("newer version already registered")
+
+// errConflictLost is returned by storeCapability when the conflict resolver
+// keeps the registered capability instead of cap
+var errConflictLost = errors.New("registered capability kept by conflict resolver")
+
+// ConflictResolver picks which of two capabilities registered under the same
+// ID stays registered, returning either existing or incoming. It runs before
+// the duplicate policy, which only sees registrations the resolver lets
+// through.
+type ConflictResolver func(existing, incoming *Capability) *Capability
+
+// LastWriteWins keeps the latest registration, the default
+func LastWriteWins(existing, incoming *Capability) *Capability {
+	return incoming
+}
+
+// FirstWriteWins keeps the capability registered first until it is
+// unregistered or expires. Re-registering it does not refresh its TTL.
+func FirstWriteWins(existing, incoming *Capability) *Capability {
+	return existing
+}
+
+// HighestVersionWins keeps whichever capability has the higher SemVer
+// version, or the incoming one if neither is higher
+func HighestVersionWins(existing, incoming *Capability) *Capability {
+	if olderVersion(incoming.Version, existing.Version) {
+		return existing
+	}
+	return incoming
+}
+
+// WithConflictResolver sets how registrations reusing a registered ID are
+// settled. A nil resolver means LastWriteWins.
+func WithConflictResolver(r ConflictResolver) Option {
+	return func(h *Handler) {
+		if r == nil {
+			r = LastWriteWins
+		}
+		h.conflictResolver = r
+	}
+}
+
+// SetDuplicatePolicy sets how registrations that reuse an ID with a different
+// version are handled. Re-registering the same version always refreshes it.
+func (h *Handler) SetDuplicatePolicy(p DuplicatePolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.duplicatePolicy = p
+}
+
+// checkDuplicate applies the conflict resolver and duplicate policy to cap.
+// Callers must hold h.mu.
+func (h *Handler) checkDuplicate(cap *Capability) error {
+	existing, ok := h.capabilities[cap.ID]
+	if !ok {
+		return nil
+	}
+	if existing != cap && h.conflictResolver(existing, cap) == existing {
+		return fmt.Errorf("%w: %s", errConflictLost, cap.ID)
+	}
+	if existing.Version == cap.Version {
+		return nil
+	}
+
+	switch h.duplicatePolicy {
+	case Reject:
+		return fmt.Errorf("%w: capability %s already registered at version %q", ErrInvalidCapabilityFormat, cap.ID, existing.Version)
+	case KeepLatestVersion:
+		if olderVersion(cap.Version, existing.Version) {
+			return fmt.Errorf("%w: %s %q is older than %q", errOlderVersion, cap.ID, cap.Version, existing.Version)
+		}
+	}
+	return nil
+}
+
+// keepsExisting reports whether the conflict resolver or duplicate policy
+// would keep the registered capability over cap
+func (h *Handler) keepsExisting(cap *Capability) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return keptExisting(h.checkDuplicate(cap))
+}
+
+// keptExisting reports whether err means a registration was dropped in
+// favour of the capability already registered
+func keptExisting(err error) bool {
+	return errors.Is(err, errOlderVersion) || errors.Is(err, errConflictLost)
+}
+
+// olderVersion reports whether a is a lower version than b. An empty version
+// is older than any other.
+func olderVersion(a, b string) bool {
+	if a == "" || b == "" {
+		return a == "" && b != ""
+	}
+	cmp, err := CompareVersions(a, b)
+	return err == nil && cmp < 0
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      DuplicatePolicy
+		first       string
+		second      string
+		wantErr     error
+		wantVersion string
+	}{
+		{"overwrite with older", Overwrite, "2.0.0", "1.0.0", nil, "1.0.0"},
+		{"overwrite with newer", Overwrite, "1.0.0", "2.0.0", nil, "2.0.0"},
+		{"reject different version", Reject, "1.0.0", "2.0.0", ErrInvalidCapabilityFormat, "1.0.0"},
+		{"reject allows same version", Reject, "1.0.0", "1.0.0", nil, "1.0.0"},
+		{"keep latest upgrades", KeepLatestVersion, "1.0.0", "1.10.0", nil, "1.10.0"},
+		{"keep latest ignores older", KeepLatestVersion, "1.10.0", "1.9.0", nil, "1.10.0"},
+		{"keep latest prefers release", KeepLatestVersion, "2.0.0-beta", "2.0.0", nil, "2.0.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler()
+			defer handler.Close()
+			handler.SetDuplicatePolicy(tt.policy)
+
+			if err := handler.RegisterCapability(&Capability{ID: "search", Type: "DISCOVER", Version: tt.first}); err != nil {
+				t.Fatalf("RegisterCapability(%s) error = %v", tt.first, err)
+			}
+			err := handler.RegisterCapability(&Capability{ID: "search", Type: "DISCOVER", Version: tt.second})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RegisterCapability(%s) error = %v, want %v", tt.second, err, tt.wantErr)
+			}
+
+			caps := handler.ListCapabilities()
+			if len(caps) != 1 {
+				t.Fatalf("Expected 1 capability, got %d", len(caps))
+			}
+			if caps[0].Version != tt.wantVersion {
+				t.Errorf("Expected version %s to be registered, got %s", tt.wantVersion, caps[0].Version)
+			}
+		})
+	}
+}
+
+func TestOlderVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.0.0", "1.0.1", true},
+		{"1.0.1", "1.0.0", false},
+		{"1.0.0", "1.0.0", false},
+		{"", "1.0.0", true},
+		{"1.0.0", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := olderVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("olderVersion(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestConflictResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver ConflictResolver
+		// Version expected to stay registered after the concurrent pair, and
+		// after a later 1.5.0 registration. Empty means whichever came first.
+		wantRace  string
+		wantLater string
+	}{
+		{"last write wins", LastWriteWins, "", "1.5.0"},
+		{"first write wins", FirstWriteWins, "", ""},
+		{"highest version wins", HighestVersionWins, "2.0.0", "2.0.0"},
+		{"nil is last write wins", nil, "", "1.5.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(WithConflictResolver(tt.resolver))
+			defer handler.Close()
+
+			// Two peers race to register search-v1
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i, version := range []string{"1.0.0", "2.0.0"} {
+				msg := streamMessage(t, Register, &Capability{ID: "search-v1", Type: "DISCOVER", Version: version})
+				ctx := ContextWithPeerID(context.Background(), []string{"peer-a", "peer-b"}[i])
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					response, err := handler.HandleMessage(ctx, msg)
+					if err != nil || response.Type != Response {
+						t.Errorf("Register %s = %v, %v, want a Response", version, response, err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			caps := handler.ListCapabilities()
+			if len(caps) != 1 {
+				t.Fatalf("Expected one search-v1, got %d", len(caps))
+			}
+			raced := caps[0].Version
+			if tt.wantRace != "" && raced != tt.wantRace {
+				t.Errorf("After concurrent registration Version = %s, want %s", raced, tt.wantRace)
+			}
+
+			if err := handler.RegisterCapability(&Capability{ID: "search-v1", Type: "DISCOVER", Version: "1.5.0"}); err != nil {
+				t.Fatalf("RegisterCapability() error = %v", err)
+			}
+			want := tt.wantLater
+			if want == "" {
+				want = raced
+			}
+			if got := handler.ListCapabilities()[0].Version; got != want {
+				t.Errorf("After a later registration Version = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package protocol
+
+// Encoding selects how a message payload is serialized. Each value is also
+// the ID of the built-in Codec that implements it.
+type Encoding uint8
+
+const (
+	EncodingJSON Encoding = iota
+	EncodingGob
+	EncodingProtobuf
+)
+
+// SetPayload encodes v with enc and stores it as the message payload.
+// Binary encodings are carried in the V2 flags byte, so the message is
+// upgraded to V2 when enc is not JSON.
+func (m *Message) SetPayload(v interface{}, enc Encoding) error {
+	payload, err := encodePayload(enc, v)
+	if err != nil {
+		return err
+	}
+
+	if enc != EncodingJSON && m.Version < V2 {
+		m.Version = V2
+	}
+	m.Payload = payload
+	m.PayloadSize = uint32(len(payload))
+	m.Encoding = enc
+	return nil
+}
+
+// DecodePayload decodes the payload into v with the codec named by CodecID,
+// or by Encoding when CodecID is zero
+func (m *Message) DecodePayload(v interface{}) error {
+	c, err := CodecRegistry.Get(m.codecID())
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(m.Payload, v)
+}
+
+func encodePayload(enc Encoding, v interface{}) ([]byte, error) {
+	c, err := CodecRegistry.Get(uint8(enc))
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(v)
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetPayload(t *testing.T) {
+	cap := &Capability{
+		ID:       "gob-cap",
+		Type:     "DISCOVER",
+		Version:  "1.0.0",
+		Metadata: map[string]string{"region": "eu"},
+	}
+
+	tests := []struct {
+		name        string
+		version     Version
+		enc         Encoding
+		wantVersion Version
+	}{
+		{"json keeps v1", V1, EncodingJSON, V1},
+		{"gob upgrades to v2", V1, EncodingGob, V2},
+		{"gob on v2", V2, EncodingGob, V2},
+		{"protobuf upgrades to v2", V1, EncodingProtobuf, V2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Version: tt.version, Type: Register, Timestamp: time.Now()}
+			if err := msg.SetPayload(cap, tt.enc); err != nil {
+				t.Fatalf("SetPayload() error = %v", err)
+			}
+			if msg.Version != tt.wantVersion {
+				t.Errorf("Expected version %d, got %d", tt.wantVersion, msg.Version)
+			}
+
+			data, err := msg.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			decoded, err := Deserialize(data)
+			if err != nil {
+				t.Fatalf("Deserialize() error = %v", err)
+			}
+			if decoded.Encoding != tt.enc {
+				t.Errorf("Expected encoding %d, got %d", tt.enc, decoded.Encoding)
+			}
+
+			var got Capability
+			if err := decoded.DecodePayload(&got); err != nil {
+				t.Fatalf("DecodePayload() error = %v", err)
+			}
+			if got.ID != cap.ID || got.Metadata["region"] != "eu" {
+				t.Errorf("Expected %+v, got %+v", cap, got)
+			}
+		})
+	}
+}
+
+func TestEncodingRequiresV2(t *testing.T) {
+	msg := &Message{Version: V1, Type: Register, Timestamp: time.Now(), Encoding: EncodingGob}
+	if _, err := msg.Serialize(); err == nil {
+		t.Error("Expected error serializing gob payload as V1")
+	}
+}
+
+func TestHandlerGobPayloads(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	register := &Message{Version: V2, Type: Register, Timestamp: time.Now()}
+	if err := register.SetPayload(&Capability{ID: "gob-cap", Type: "DISCOVER", Version: "1.0.0"}, EncodingGob); err != nil {
+		t.Fatalf("SetPayload() error = %v", err)
+	}
+	response, err := handler.HandleMessage(context.Background(), register)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type == Error {
+		t.Fatalf("Register failed: %s", response.Payload)
+	}
+
+	query := &Message{Version: V2, Type: Query, Timestamp: time.Now()}
+	if err := query.SetPayload(&QueryPayload{CapabilityType: "DISCOVER"}, EncodingGob); err != nil {
+		t.Fatalf("SetPayload() error = %v", err)
+	}
+	response, err = handler.HandleMessage(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	var caps []Capability
+	if err := response.DecodePayload(&caps); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if len(caps) != 1 || caps[0].ID != "gob-cap" {
+		t.Errorf("Expected [gob-cap], got %+v", caps)
+	}
+}
+
+func TestDecodePayloadUnknownEncoding(t *testing.T) {
+	msg := &Message{Payload: []byte("{}"), Encoding: Encoding(3)}
+	var v map[string]any
+	if err := msg.DecodePayload(&v); err == nil {
+		t.Error("Expected error for unknown encoding")
+	}
+}
@@ -0,0 +1,34 @@
+package protocol
+
+// IsProtocolError reports whether code is a 1xx protocol error
+func IsProtocolError(code ErrorCode) bool {
+	return code >= 100 && code < 200
+}
+
+// IsAuthError reports whether code is a 2xx authentication or authorization error
+func IsAuthError(code ErrorCode) bool {
+	return code >= 200 && code < 300
+}
+
+// IsCapabilityError reports whether code is a 3xx capability error
+func IsCapabilityError(code ErrorCode) bool {
+	return code >= 300 && code < 400
+}
+
+// IsMCPBridgeError reports whether code is a 4xx MCP bridge error
+func IsMCPBridgeError(code ErrorCode) bool {
+	return code >= 400 && code < 500
+}
+
+// IsRetryable reports whether a request that failed with code may succeed if
+// sent again. Only an unavailable MCP endpoint, whose circuit may close or
+// whose capacity may free up, is transient; every other code, including a
+// protocol mismatch or failed authentication, fails the same way every time.
+func IsRetryable(code ErrorCode) bool {
+	switch code {
+	case ErrMCPEndpointUnavailable:
+		return true
+	default:
+		return false
+	}
+}
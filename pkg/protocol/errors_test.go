@@ -0,0 +1,42 @@
+package protocol
+
+import "testing"
+
+func TestErrorCodeCategories(t *testing.T) {
+	tests := []struct {
+		code                                          ErrorCode
+		protocol, auth, capability, bridge, retryable bool
+	}{
+		{ErrInvalidVersion, true, false, false, false, false},
+		{ErrInvalidPayload, true, false, false, false, false},
+		{ErrUnauthorized, false, true, false, false, false},
+		{ErrInvalidCredentials, false, true, false, false, false},
+		{ErrCapabilityNotFound, false, false, true, false, false},
+		{ErrCapabilityUnavailable, false, false, true, false, false},
+		{ErrMCPEndpointUnavailable, false, false, false, true, true},
+		{ErrMCPProtocolMismatch, false, false, false, true, false},
+		{ErrMCPAuthenticationFailed, false, false, false, true, false},
+		{0, false, false, false, false, false},
+		{ErrorCode(500), false, false, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			if got := IsProtocolError(tt.code); got != tt.protocol {
+				t.Errorf("IsProtocolError() = %v, want %v", got, tt.protocol)
+			}
+			if got := IsAuthError(tt.code); got != tt.auth {
+				t.Errorf("IsAuthError() = %v, want %v", got, tt.auth)
+			}
+			if got := IsCapabilityError(tt.code); got != tt.capability {
+				t.Errorf("IsCapabilityError() = %v, want %v", got, tt.capability)
+			}
+			if got := IsRetryable(tt.code); got != tt.retryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.retryable)
+			}
+			if got := IsMCPBridgeError(tt.code); got != tt.bridge {
+				t.Errorf("IsMCPBridgeError() = %v, want %v", got, tt.retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import "time"
+
+// SetDefaultTTL sets the lifetime applied to capabilities registered without a TTL.
+// Zero disables expiry for such capabilities.
+func (h *Handler) SetDefaultTTL(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.defaultTTL = d
+}
+
+// scheduleExpiry records when cap expires and starts the expiry loop on first use.
+// Callers must hold h.mu for writing.
+func (h *Handler) scheduleExpiry(cap *Capability) {
+	ttl := cap.TTL
+	if ttl == 0 {
+		ttl = h.defaultTTL
+	}
+
+	if ttl <= 0 {
+		delete(h.expiries, cap.ID)
+		return
+	}
+	h.expiries[cap.ID] = time.Now().Add(ttl)
+	h.wakeExpiry()
+}
+
+// wakeExpiry starts the expiry loop on first use and nudges it to recompute
+// its next deadline
+func (h *Handler) wakeExpiry() {
+	h.expiryOnce.Do(func() {
+		go h.expireLoop()
+	})
+
+	// Wake the loop in case this expiry is sooner than the one it is waiting for
+	select {
+	case h.expiryWake <- struct{}{}:
+	default:
+	}
+}
+
+// expireLoop removes capabilities as their TTLs elapse and bridges as their
+// leases lapse
+func (h *Handler) expireLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		timer.Reset(h.removeExpired())
+
+		select {
+		case <-h.done:
+			return
+		case <-h.expiryWake:
+		case <-timer.C:
+		}
+	}
+}
+
+// removeExpired drops every expired capability and lapsed bridge lease and
+// returns how long to wait until the next one is due
+func (h *Handler) removeExpired() time.Duration {
+	now := time.Now()
+	next := time.Hour
+
+	var expired []*Capability
+	h.mu.Lock()
+	for id, deadline := range h.expiries {
+		if wait := deadline.Sub(now); wait > 0 {
+			if wait < next {
+				next = wait
+			}
+			continue
+		}
+
+		expired = append(expired, h.capabilities[id])
+		h.removeCapability(id)
+	}
+
+	var lapsed []*MCPBridge
+	for id, expiry := range h.leases {
+		if wait := expiry.Add(h.leaseGrace).Sub(now); wait > 0 {
+			if wait < next {
+				next = wait
+			}
+			continue
+		}
+
+		lapsed = append(lapsed, h.mcpBridges[id])
+		h.removeMCPBridge(id)
+	}
+	callback := h.onCapabilityExpired
+	h.mu.Unlock()
+
+	if len(expired) > 0 || len(lapsed) > 0 {
+		h.persist()
+	}
+
+	for _, bridge := range lapsed {
+		h.logger.Info("MCP bridge lease expired", "bridge", bridge.ID)
+		if err := h.announceBridgeDown(bridge); err != nil {
+			h.logger.Warn("Failed to announce expired MCP bridge", "bridge", bridge.ID, "error", err)
+		}
+	}
+
+	if callback != nil {
+		for _, cap := range expired {
+			callback(cap)
+		}
+	}
+	return next
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapabilityTTL(t *testing.T) {
+	expired := make(chan *Capability, 1)
+	handler := NewHandler(WithCapabilityExpired(func(cap *Capability) {
+		expired <- cap
+	}))
+	defer handler.Close()
+
+	if err := handler.RegisterCapability(&Capability{ID: "short-lived", Type: "DISCOVER", TTL: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.RegisterCapability(&Capability{ID: "permanent", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	select {
+	case cap := <-expired:
+		if cap.ID != "short-lived" {
+			t.Errorf("Expected short-lived to expire, got %s", cap.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for capability expiry")
+	}
+
+	handler.mu.RLock()
+	_, shortLived := handler.capabilities["short-lived"]
+	_, permanent := handler.capabilities["permanent"]
+	handler.mu.RUnlock()
+
+	if shortLived {
+		t.Error("Expected short-lived capability to be removed")
+	}
+	if !permanent {
+		t.Error("Expected capability without TTL to remain")
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	expired := make(chan *Capability, 1)
+	handler := NewHandler(WithCapabilityExpired(func(cap *Capability) {
+		expired <- cap
+	}))
+	defer handler.Close()
+
+	handler.SetDefaultTTL(30 * time.Millisecond)
+	if err := handler.RegisterCapability(&Capability{ID: "defaulted", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	select {
+	case cap := <-expired:
+		if cap.ID != "defaulted" {
+			t.Errorf("Expected defaulted to expire, got %s", cap.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for default TTL expiry")
+	}
+}
+
+func TestReregisterExtendsTTL(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	cap := &Capability{ID: "refreshed", Type: "DISCOVER", TTL: 80 * time.Millisecond}
+	if err := handler.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	// Refresh before the first TTL elapses
+	time.Sleep(50 * time.Millisecond)
+	if err := handler.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	handler.mu.RLock()
+	_, ok := handler.capabilities["refreshed"]
+	handler.mu.RUnlock()
+	if !ok {
+		t.Error("Expected refreshed capability to still be registered")
+	}
+}
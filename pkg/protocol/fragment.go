@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMTU is the largest datagram sent without fragmenting, small enough
+// to cross Ethernet paths without IP fragmentation
+const DefaultMTU = 1400
+
+// Fragments start with a marker byte no protocol version uses, so receivers
+// can tell them apart from whole messages
+const fragmentMarker byte = 0xF7
+
+// FragmentHeaderSize is the marker, message ID, fragment index and fragment count
+const FragmentHeaderSize = 1 + 4 + 2 + 2
+
+// Default time a partially received message is kept before it is dropped
+const DefaultReassemblyTimeout = 5 * time.Second
+
+// Source of fragment message IDs, unique per sender
+var fragmentIDs atomic.Uint32
+
+// Fragment serializes the message and splits it into datagrams of at most mtu
+// bytes. A message that fits is returned whole, without a fragment header.
+func (m *Message) Fragment(mtu int) ([][]byte, error) {
+	if mtu <= FragmentHeaderSize {
+		return nil, fmt.Errorf("mtu %d too small for fragment header", mtu)
+	}
+
+	data, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= mtu {
+		return [][]byte{data}, nil
+	}
+
+	chunkSize := mtu - FragmentHeaderSize
+	total := (len(data) + chunkSize - 1) / chunkSize
+	if total > 0xFFFF {
+		return nil, fmt.Errorf("message needs %d fragments, more than %d", total, 0xFFFF)
+	}
+
+	id := fragmentIDs.Add(1)
+	fragments := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		chunk := data[i*chunkSize : min((i+1)*chunkSize, len(data))]
+
+		frag := make([]byte, FragmentHeaderSize+len(chunk))
+		frag[0] = fragmentMarker
+		binary.BigEndian.PutUint32(frag[1:5], id)
+		binary.BigEndian.PutUint16(frag[5:7], uint16(i))
+		binary.BigEndian.PutUint16(frag[7:9], uint16(total))
+		copy(frag[FragmentHeaderSize:], chunk)
+		fragments = append(fragments, frag)
+	}
+	return fragments, nil
+}
+
+// IsFragment reports whether a datagram is one fragment of a larger message
+func IsFragment(data []byte) bool {
+	return len(data) >= FragmentHeaderSize && data[0] == fragmentMarker
+}
+
+// Reassembler collects fragments by sender and message ID until every
+// fragment of a message has arrived
+type Reassembler struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	partial map[fragmentKey]*partialMessage
+	now     func() time.Time
+}
+
+type fragmentKey struct {
+	from string
+	id   uint32
+}
+
+type partialMessage struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// NewReassembler creates a Reassembler that drops incomplete messages after timeout
+func NewReassembler(timeout time.Duration) *Reassembler {
+	if timeout <= 0 {
+		timeout = DefaultReassemblyTimeout
+	}
+	return &Reassembler{
+		timeout: timeout,
+		partial: make(map[fragmentKey]*partialMessage),
+		now:     time.Now,
+	}
+}
+
+// Add records a fragment received from the sender identified by from. Once the
+// last fragment arrives it returns the whole serialized message, until then nil.
+func (r *Reassembler) Add(from string, data []byte) ([]byte, error) {
+	if !IsFragment(data) {
+		return nil, fmt.Errorf("not a fragment")
+	}
+
+	id := binary.BigEndian.Uint32(data[1:5])
+	index := int(binary.BigEndian.Uint16(data[5:7]))
+	total := int(binary.BigEndian.Uint16(data[7:9]))
+	if total == 0 || index >= total {
+		return nil, fmt.Errorf("invalid fragment %d of %d", index, total)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.expire(now)
+
+	key := fragmentKey{from: from, id: id}
+	p, ok := r.partial[key]
+	if !ok {
+		p = &partialMessage{chunks: make([][]byte, total), started: now}
+		r.partial[key] = p
+	}
+	if len(p.chunks) != total {
+		delete(r.partial, key)
+		return nil, fmt.Errorf("fragment count changed from %d to %d", len(p.chunks), total)
+	}
+
+	// Duplicated datagrams are ignored
+	if p.chunks[index] != nil {
+		return nil, nil
+	}
+	p.chunks[index] = append([]byte(nil), data[FragmentHeaderSize:]...)
+	p.received++
+	if p.received < total {
+		return nil, nil
+	}
+
+	delete(r.partial, key)
+	var size int
+	for _, chunk := range p.chunks {
+		size += len(chunk)
+	}
+	message := make([]byte, 0, size)
+	for _, chunk := range p.chunks {
+		message = append(message, chunk...)
+	}
+	return message, nil
+}
+
+// Pending returns the number of messages still missing fragments
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(r.now())
+	return len(r.partial)
+}
+
+// expire drops messages that have waited longer than the timeout
+func (r *Reassembler) expire(now time.Time) {
+	for key, p := range r.partial {
+		if now.Sub(p.started) > r.timeout {
+			delete(r.partial, key)
+		}
+	}
+}
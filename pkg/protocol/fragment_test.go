@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFragmentReassemble(t *testing.T) {
+	msg := &Message{
+		Version:   V1,
+		Type:      AICapabilityAdvertise,
+		Payload:   []byte(strings.Repeat("capability ", 500)),
+		Timestamp: time.Unix(1700000000, 0),
+	}
+	want, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	fragments, err := msg.Fragment(512)
+	if err != nil {
+		t.Fatalf("Fragment() error = %v", err)
+	}
+	if len(fragments) < 2 {
+		t.Fatalf("Expected several fragments, got %d", len(fragments))
+	}
+	for i, frag := range fragments {
+		if len(frag) > 512 {
+			t.Errorf("Fragment %d is %d bytes, over the MTU", i, len(frag))
+		}
+		if !IsFragment(frag) {
+			t.Errorf("Fragment %d not recognised as a fragment", i)
+		}
+	}
+
+	// Fragments may arrive out of order and more than once
+	shuffled := append([][]byte(nil), fragments...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	shuffled = append(shuffled[:1], append([][]byte{shuffled[0]}, shuffled[1:]...)...)
+
+	r := NewReassembler(time.Second)
+	var got []byte
+	for i, frag := range shuffled {
+		data, err := r.Add("peer", frag)
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		if data != nil {
+			if i != len(shuffled)-1 {
+				t.Errorf("Message completed after %d of %d datagrams", i+1, len(shuffled))
+			}
+			got = data
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("Reassembled message differs from the original")
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Expected no pending messages, got %d", r.Pending())
+	}
+}
+
+func TestFragmentSmallMessage(t *testing.T) {
+	msg := &Message{Version: V1, Type: Hello, Timestamp: time.Now()}
+
+	fragments, err := msg.Fragment(DefaultMTU)
+	if err != nil {
+		t.Fatalf("Fragment() error = %v", err)
+	}
+	if len(fragments) != 1 || IsFragment(fragments[0]) {
+		t.Fatalf("Expected one whole datagram, got %d", len(fragments))
+	}
+	if _, err := Deserialize(fragments[0]); err != nil {
+		t.Errorf("Deserialize() error = %v", err)
+	}
+
+	if _, err := msg.Fragment(FragmentHeaderSize); err == nil {
+		t.Error("Expected error for an MTU with no room for data")
+	}
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	msg := &Message{Version: V1, Type: Response, Payload: make([]byte, 300), Timestamp: time.Now()}
+	fragments, err := msg.Fragment(100)
+	if err != nil {
+		t.Fatalf("Fragment() error = %v", err)
+	}
+
+	now := time.Now()
+	r := NewReassembler(time.Second)
+	r.now = func() time.Time { return now }
+
+	for _, frag := range fragments[:len(fragments)-1] {
+		if _, err := r.Add("peer", frag); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if r.Pending() != 1 {
+		t.Fatalf("Expected 1 pending message, got %d", r.Pending())
+	}
+
+	// The last fragment arrives too late to complete the message
+	now = now.Add(2 * time.Second)
+	data, err := r.Add("peer", fragments[len(fragments)-1])
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if data != nil {
+		t.Error("Expected the expired message not to complete")
+	}
+
+	// Fragments from another sender are kept apart
+	if data, _ := r.Add("other", fragments[0]); data != nil {
+		t.Error("Expected a single fragment not to complete a message")
+	}
+}
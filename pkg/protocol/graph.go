@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"sort"
+	"sync"
+)
+
+// CapabilityGraph tracks which capabilities depend on which, as a directed
+// graph with an edge from each capability to every ID in its Dependencies.
+// Dependencies need not be in the graph. It is safe for concurrent use.
+type CapabilityGraph struct {
+	mu         sync.RWMutex
+	caps       map[string]*Capability
+	dependents map[string]map[string]struct{} // Dependency ID -> IDs depending on it
+}
+
+// NewCapabilityGraph creates an empty graph
+func NewCapabilityGraph() *CapabilityGraph {
+	return &CapabilityGraph{
+		caps:       make(map[string]*Capability),
+		dependents: make(map[string]map[string]struct{}),
+	}
+}
+
+// Add puts cap in the graph, replacing any capability with the same ID
+func (g *CapabilityGraph) Add(cap *Capability) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.remove(cap.ID)
+	g.caps[cap.ID] = cap
+	for _, dep := range cap.Dependencies {
+		if g.dependents[dep] == nil {
+			g.dependents[dep] = make(map[string]struct{})
+		}
+		g.dependents[dep][cap.ID] = struct{}{}
+	}
+}
+
+// Remove takes the capability id and its dependency edges out of the graph.
+// Capabilities depending on id keep their edges to it.
+func (g *CapabilityGraph) Remove(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.remove(id)
+}
+
+// remove drops id and its outgoing edges. Callers must hold g.mu.
+func (g *CapabilityGraph) remove(id string) {
+	cap, ok := g.caps[id]
+	if !ok {
+		return
+	}
+	for _, dep := range cap.Dependencies {
+		delete(g.dependents[dep], id)
+		if len(g.dependents[dep]) == 0 {
+			delete(g.dependents, dep)
+		}
+	}
+	delete(g.caps, id)
+}
+
+// DependentsOf returns the capabilities that list id as a dependency,
+// ordered by ID
+func (g *CapabilityGraph) DependentsOf(id string) []*Capability {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var dependents []*Capability
+	for dependent := range g.dependents[id] {
+		dependents = append(dependents, g.caps[dependent])
+	}
+	sort.Slice(dependents, func(i, j int) bool { return dependents[i].ID < dependents[j].ID })
+	return dependents
+}
+
+// TransitiveDeps returns every capability id depends on, directly or through
+// others, once each. They are ordered so each comes after its own
+// dependencies. Dependencies missing from the graph are left out.
+func (g *CapabilityGraph) TransitiveDeps(id string) []*Capability {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var deps []*Capability
+	visited := map[string]bool{id: true}
+	var visit func(id string)
+	visit = func(id string) {
+		cap, ok := g.caps[id]
+		if !ok {
+			return
+		}
+		for _, dep := range cap.Dependencies {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			visit(dep)
+			if depCap, ok := g.caps[dep]; ok {
+				deps = append(deps, depCap)
+			}
+		}
+	}
+	visit(id)
+	return deps
+}
+
+// DetectCycle returns the IDs along a dependency cycle, starting and ending
+// with the same ID, or nil if the graph is acyclic
+func (g *CapabilityGraph) DetectCycle() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	ids := make([]string, 0, len(g.caps))
+	for id := range g.caps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(ids))
+	var path []string
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = onPath
+		path = append(path, id)
+		if cap, ok := g.caps[id]; ok {
+			for _, dep := range cap.Dependencies {
+				switch state[dep] {
+				case onPath:
+					for i, p := range path {
+						if p == dep {
+							return append(append([]string(nil), path[i:]...), dep)
+						}
+					}
+				case unvisited:
+					if cycle := visit(dep); cycle != nil {
+						return cycle
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+
+	for _, id := range ids {
+		if state[id] == unvisited {
+			if cycle := visit(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
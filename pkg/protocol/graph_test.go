@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// diamond builds app -> {left, right} -> base
+func diamond() []*Capability {
+	return []*Capability{
+		{ID: "base", Type: "DISCOVER"},
+		{ID: "left", Type: "DISCOVER", Dependencies: []string{"base"}},
+		{ID: "right", Type: "DISCOVER", Dependencies: []string{"base"}},
+		{ID: "app", Type: "DISCOVER", Dependencies: []string{"left", "right"}},
+	}
+}
+
+func capabilityIDs(caps []*Capability) []string {
+	ids := make([]string, 0, len(caps))
+	for _, cap := range caps {
+		ids = append(ids, cap.ID)
+	}
+	return ids
+}
+
+func TestCapabilityGraphDiamond(t *testing.T) {
+	g := NewCapabilityGraph()
+	for _, cap := range diamond() {
+		g.Add(cap)
+	}
+
+	tests := []struct {
+		name string
+		got  []*Capability
+		want []string
+	}{
+		{"dependents of base", g.DependentsOf("base"), []string{"left", "right"}},
+		{"dependents of left", g.DependentsOf("left"), []string{"app"}},
+		{"dependents of app", g.DependentsOf("app"), []string{}},
+		// base is shared by both sides but listed once, ahead of them
+		{"transitive deps of app", g.TransitiveDeps("app"), []string{"base", "left", "right"}},
+		{"transitive deps of left", g.TransitiveDeps("left"), []string{"base"}},
+		{"transitive deps of base", g.TransitiveDeps("base"), []string{}},
+		{"unknown ID", g.TransitiveDeps("missing"), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capabilityIDs(tt.got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if cycle := g.DetectCycle(); cycle != nil {
+		t.Errorf("DetectCycle() = %v on a diamond, want nil", cycle)
+	}
+
+	// Removing one side leaves the other's edge to base in place
+	g.Remove("left")
+	if got := capabilityIDs(g.DependentsOf("base")); !reflect.DeepEqual(got, []string{"right"}) {
+		t.Errorf("DependentsOf(base) after Remove(left) = %v, want [right]", got)
+	}
+	if got := capabilityIDs(g.TransitiveDeps("app")); !reflect.DeepEqual(got, []string{"base", "right"}) {
+		t.Errorf("TransitiveDeps(app) after Remove(left) = %v, want [base right]", got)
+	}
+}
+
+func TestCapabilityGraphDetectCycle(t *testing.T) {
+	g := NewCapabilityGraph()
+	for _, cap := range diamond() {
+		g.Add(cap)
+	}
+
+	// Re-adding base to depend on app closes a loop through the diamond
+	g.Add(&Capability{ID: "base", Type: "DISCOVER", Dependencies: []string{"app"}})
+	cycle := g.DetectCycle()
+	if len(cycle) < 3 || cycle[0] != cycle[len(cycle)-1] {
+		t.Fatalf("DetectCycle() = %v, want a closed cycle", cycle)
+	}
+	for i := 0; i+1 < len(cycle); i++ {
+		if !slices.Contains(g.caps[cycle[i]].Dependencies, cycle[i+1]) {
+			t.Errorf("DetectCycle() = %v, but %s does not depend on %s", cycle, cycle[i], cycle[i+1])
+		}
+	}
+}
+
+func TestHandlerDependencyGraph(t *testing.T) {
+	var logs bytes.Buffer
+	handler := NewHandler(WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	defer handler.Close()
+
+	for _, cap := range diamond() {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+	if got := handler.Dependents("base"); !reflect.DeepEqual(got, []string{"left", "right"}) {
+		t.Errorf("Dependents(base) = %v, want [left right]", got)
+	}
+
+	// A re-registration that would close a cycle is refused
+	err := handler.RegisterCapability(&Capability{ID: "base", Type: "DISCOVER", Dependencies: []string{"app"}})
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("RegisterCapability() closing a cycle error = %v, want a cycle error", err)
+	}
+
+	// Deregistering a capability others depend on is allowed but warned about
+	if err := handler.DeregisterCapability("base"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "dependents=\"[left right]\"") {
+		t.Errorf("Expected a warning naming the dependents, got %q", logs.String())
+	}
+
+	logs.Reset()
+	if err := handler.DeregisterCapability("app"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if strings.Contains(logs.String(), "depend on") {
+		t.Errorf("Expected no warning for a capability nothing depends on, got %q", logs.String())
+	}
+	if got := handler.Dependents("left"); len(got) != 0 {
+		t.Errorf("Dependents(left) after removing app = %v, want none", got)
+	}
+}
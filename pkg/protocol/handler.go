@@ -3,94 +3,773 @@ package protocol
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/heathweaver/arn-protocol/pkg/metrics"
+	"github.com/heathweaver/arn-protocol/pkg/security"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Handler manages protocol communication
+// Handler manages protocol communication. Its embedded Router carries the
+// built-in message handlers; HandleFunc adds new types or replaces them.
 type Handler struct {
-	capabilities     map[string]*Capability
-	mcpBridges      map[string]*MCPBridge
-	mu              sync.RWMutex
-	onMessage       func(*Message) error
-	onMCPBridge     func(*MCPBridge) error
+	Router
+
+	capabilities map[string]*Capability
+	graph        *CapabilityGraph    // dependency edges between registered capabilities
+	hashes       map[[32]byte]string // capability ID by Capability.Hash
+	watchers     map[uint64]*capabilityWatcher
+	nextWatcher  uint64
+	mcpBridges   map[string]*MCPBridge
+	schemas      map[string]*jsonschema.Schema // compiled Metadata["schema"] by capability ID
+	owners       map[string]string             // capability ID -> peer that registered it
+	tenants      map[string]bool               // namespaces tenant sessions registered in
+	sessions     map[string]string             // capability ID -> session it was registered on
+	pending      map[string]*pendingCapability // registrations waiting for dependencies, by ID
+	readyWaiters map[string][]chan struct{}    // CapabilityReady channels by capability ID
+	mu           sync.RWMutex
+	events       *events.Bus
+	sharedSecret []byte
+	keyring      *security.Keyring
+	roles        map[MessageType][]string // roles required by message type, see RequireRole
+	defaultSort  SortKey                  // order of query responses, see SetDefaultSort
+	logger       *slog.Logger
+	metrics      *metrics.Metrics
+
+	tracerProvider trace.TracerProvider // nil uses the global provider
+
+	// Capability expiry
+	expiries            map[string]time.Time
+	defaultTTL          time.Duration
+	duplicatePolicy     DuplicatePolicy
+	conflictResolver    ConflictResolver
+	onCapabilityExpired func(*Capability)
+	onCapabilityRemoved func(*Capability)
+	onMCPBridge         func(*MCPBridge)
+	expiryOnce          sync.Once
+	expiryWake          chan struct{}
+
+	streams map[string]*StreamSession
+
+	// Replay protection
+	replayWindow   time.Duration
+	nonceCacheSize int
+	nonces         *nonceCache
+
+	// Responses remembered for duplicate requests, see WithDeduplication
+	dedupTTL       time.Duration
+	dedupCacheSize int
+	dedup          *DeduplicationCache
+
+	maxHops uint8
+
+	workerCount int
+	workerPool  *WorkerPool
+
+	healthChecker *BridgeHealthChecker
+
+	// Bridge leases: expiry and owning peer by bridge ID
+	leases       map[string]time.Time
+	bridgeOwners map[string]string
+	leaseGrace   time.Duration
+
+	// Per-bridge circuit breakers, keyed by bridge ID
+	breakers      map[string]*circuitBreaker
+	bridgeSlots   map[string]chan struct{} // see MCPBridge.MaxConcurrentRequests
+	breakerConfig CircuitBreakerConfig
+	broadcaster   Broadcaster
+	announcer     Announcer
+
+	// Bridge slots taken by requests in flight, oldest first, and how long
+	// they are held at most; see CompleteBridgeRequest
+	bridgeHolds          map[string][]*slotHold
+	bridgeRequestTimeout time.Duration
+
+	store    Store
+	saveWake chan struct{}
+	saved    chan struct{} // closed once the final save on Close is written
+
+	negotiate NegotiateFunc
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Broadcaster pushes unsolicited messages to every connected peer
+type Broadcaster interface {
+	Broadcast(msg *Message) error
+}
+
+// Announcer publishes capability announcements beyond connected peers,
+// for example to a multicast group
+type Announcer interface {
+	Announce(msg *Message) error
+}
+
+// Option configures optional Handler behaviour
+type Option func(*Handler)
+
+// WithCapabilityExpired registers a callback invoked when a capability's TTL elapses
+func WithCapabilityExpired(fn func(*Capability)) Option {
+	return func(h *Handler) {
+		h.onCapabilityExpired = fn
+	}
+}
+
+// WithCapabilityRemoved registers a callback invoked when a capability is deregistered
+func WithCapabilityRemoved(fn func(*Capability)) Option {
+	return func(h *Handler) {
+		h.onCapabilityRemoved = fn
+	}
+}
+
+// WithEventBus publishes the handler's events to bus instead of a private one,
+// so several handlers or subsystems can share it
+func WithEventBus(bus *events.Bus) Option {
+	return func(h *Handler) {
+		h.events = bus
+	}
+}
+
+// WithLogger sends handler logs to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
+
+// WithMetrics records handling latency and bridge counts on reg
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(h *Handler) {
+		h.metrics = metrics.For(reg)
+	}
 }
 
 // MCPBridge represents a bridge to an MCP data source
 type MCPBridge struct {
-	ID          string            `json:"id"`
-	Endpoint    string            `json:"endpoint"`
-	Protocol    string            `json:"protocol"` // MCP protocol version
+	ID          string            `json:"id" arn:"required,max=128"`
+	Endpoint    string            `json:"endpoint" arn:"required,url"`
+	Protocol    string            `json:"protocol" arn:"mcpprotocol"` // MCP protocol version, see RegisterMCPVersion
 	DataTypes   []string          `json:"data_types"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
 	LastUpdated time.Time         `json:"last_updated"`
+
+	// Location is where the bridge runs, so requests naming no bridge are
+	// sent to one nearby
+	Location Location `json:"location,omitzero"`
+
+	// Lease is how long the bridge stays registered without a renewal.
+	// Zero registers it until it is deregistered.
+	Lease time.Duration `json:"lease,omitempty"`
+
+	// AllowedClients restricts who may request the bridge to these peer IDs,
+	// CIDR blocks or role:<name> entries, which admit peers that proved an
+	// identity holding the role. With a keyring, peer IDs match proven
+	// identities only. An empty list allows everyone.
+	AllowedClients []string `json:"allowed_clients,omitempty"`
+	aclMu          sync.RWMutex
+
+	// MaxConcurrentRequests bounds how many requests the bridge is handed
+	// out for at once. Each holds a slot from its MCPBridgeRequest until
+	// Handler.CompleteBridgeRequest reports it finished or
+	// WithBridgeRequestTimeout passes. Zero means no limit.
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+}
+
+// MCPBridgeResponsePayload is the body of the MCPBridgeResponse to an
+// MCPBridgeRequest: the bridge's fields, plus the entry of its DataTypes
+// the requested data type matched
+type MCPBridgeResponsePayload struct {
+	*MCPBridge
+	MatchedPattern string `json:"matched_pattern"`
+}
+
+// MCPBridgeRequestPayload is the body of an MCPBridgeRequest. Without a
+// BridgeID the server picks a bridge supporting DataType, preferring one in
+// PreferredRegion, then one in PreferredZone.
+type MCPBridgeRequestPayload struct {
+	BridgeID        string `json:"bridge_id,omitempty"`
+	DataType        string `json:"data_type"`
+	PreferredRegion string `json:"preferred_region,omitempty"`
+	PreferredZone   string `json:"preferred_zone,omitempty"`
+}
+
+// ErrorPayload is the body of an Error message
+type ErrorPayload struct {
+	Code    ErrorCode `json:"code"`
+	Name    string    `json:"name,omitempty"`
+	Message string    `json:"message"`
 }
 
 // NewHandler creates a new protocol handler
-func NewHandler(onMessage func(*Message) error, onMCPBridge func(*MCPBridge) error) *Handler {
-	return &Handler{
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{
 		capabilities: make(map[string]*Capability),
-		mcpBridges:  make(map[string]*MCPBridge),
-		onMessage:   onMessage,
-		onMCPBridge: onMCPBridge,
+		graph:        NewCapabilityGraph(),
+		hashes:       make(map[[32]byte]string),
+		watchers:     make(map[uint64]*capabilityWatcher),
+		mcpBridges:   make(map[string]*MCPBridge),
+		schemas:      make(map[string]*jsonschema.Schema),
+		owners:       make(map[string]string),
+		tenants:      make(map[string]bool),
+		sessions:     make(map[string]string),
+		pending:      make(map[string]*pendingCapability),
+		readyWaiters: make(map[string][]chan struct{}),
+		events:       events.NewBus(),
+		expiries:     make(map[string]time.Time),
+		streams:      make(map[string]*StreamSession),
+		expiryWake:   make(chan struct{}, 1),
+		saveWake:     make(chan struct{}, 1),
+		saved:        make(chan struct{}),
+		done:         make(chan struct{}),
+		logger:       slog.Default(),
+		breakers:     make(map[string]*circuitBreaker),
+		bridgeSlots:  make(map[string]chan struct{}),
+		bridgeHolds:  make(map[string][]*slotHold),
+		leases:       make(map[string]time.Time),
+		bridgeOwners: make(map[string]string),
+		leaseGrace:   defaultLeaseRenewalGrace,
+
+		bridgeRequestTimeout: defaultBridgeRequestTimeout,
+
+		breakerConfig: DefaultCircuitBreakerConfig,
+
+		replayWindow:   defaultReplayWindow,
+		nonceCacheSize: defaultNonceCacheSize,
+		dedupCacheSize: defaultDedupCacheSize,
+		maxHops:        DefaultMaxHops,
+
+		conflictResolver: LastWriteWins,
+	}
+	h.routeBuiltins()
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.nonces = newNonceCache(h.replayWindow, h.nonceCacheSize)
+	if h.dedupTTL > 0 {
+		h.dedup = NewDeduplicationCache(h.dedupTTL, h.dedupCacheSize)
+	}
+	if h.workerCount > 0 {
+		h.workerPool = NewWorkerPool(h.workerCount, h.HandleMessage)
+	}
+
+	if h.store != nil {
+		h.restore()
+		go h.saveLoop()
+	}
+
+	if h.healthChecker != nil {
+		go h.healthChecker.run(h.done)
+	}
+	return h
+}
+
+// Events returns the bus the handler publishes to. Subscribe to the topics in
+// pkg/events to react to registrations and incoming messages.
+func (h *Handler) Events() *events.Bus {
+	return h.events
+}
+
+// SetBroadcaster sets where the handler pushes announcements such as MCPBridgeDown
+func (h *Handler) SetBroadcaster(b Broadcaster) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.broadcaster = b
+}
+
+// SetAnnouncer sets where the handler announces newly registered capabilities
+func (h *Handler) SetAnnouncer(a Announcer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.announcer = a
+}
+
+// Close stops the handler's background goroutines, waiting for any pending
+// save to reach the store
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+	if h.workerPool != nil {
+		h.workerPool.Close()
+	}
+	if h.dedup != nil {
+		h.dedup.Close()
+	}
+	if h.store != nil {
+		<-h.saved
 	}
+	return nil
 }
 
 // RegisterCapability registers an AI capability
 func (h *Handler) RegisterCapability(cap *Capability) error {
+	return h.registerCapability(cap, registrant{})
+}
+
+// registerCapability stores cap on behalf of by, then saves and announces it. A capability whose dependencies
+// are not all registered yet is held back and registered once they are.
+func (h *Handler) registerCapability(cap *Capability, by registrant) error {
+	if err := h.storeCapability(cap, by); err != nil {
+		if errors.Is(err, errDependenciesPending) {
+			h.logger.Debug("Holding capability until its dependencies register", "capability", cap.ID, "error", err)
+			return nil
+		}
+		if keptExisting(err) {
+			h.logger.Debug("Keeping registered capability", "capability", cap.ID, "error", err)
+			return nil
+		}
+		return err
+	}
+	h.persist()
+	h.events.Publish(events.CapabilityRegistered, cap.clone())
+
+	if err := h.announceCapability(cap); err != nil {
+		h.logger.Error("Failed to announce capability", "capability", cap.ID, "error", err)
+	}
+
+	h.resolvePending()
+	return nil
+}
+
+// CapabilityCount returns the number of registered capabilities
+func (h *Handler) CapabilityCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.capabilities)
+}
+
+// ListCapabilities returns copies of every registered capability, ordered by ID
+func (h *Handler) ListCapabilities() []*Capability {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	caps := make([]*Capability, 0, len(h.capabilities))
+	for _, cap := range h.capabilities {
+		caps = append(caps, cap.clone())
+	}
+	sort.Slice(caps, func(i, j int) bool { return caps[i].ID < caps[j].ID })
+	return caps
+}
+
+// ListMCPBridges returns copies of every registered MCP bridge, ordered by ID
+func (h *Handler) ListMCPBridges() []*MCPBridge {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	bridges := make([]*MCPBridge, 0, len(h.mcpBridges))
+	for _, bridge := range h.mcpBridges {
+		bridges = append(bridges, bridge.snapshot())
+	}
+	sort.Slice(bridges, func(i, j int) bool { return bridges[i].ID < bridges[j].ID })
+	return bridges
+}
+
+// DeregisterCapability removes a capability from the registry
+func (h *Handler) DeregisterCapability(id string) error {
+	h.mu.Lock()
+	cap, exists := h.capabilities[id]
+	h.removeCapability(id)
+	_, pending := h.pending[id]
+	delete(h.pending, id)
+	callback := h.onCapabilityRemoved
+	h.mu.Unlock()
+
+	// A capability still waiting for its dependencies was never registered
+	if !exists && pending {
+		return nil
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrCapabilityNotFound, id)
+	}
+	h.persist()
+
+	if dependents := h.Dependents(id); len(dependents) > 0 {
+		h.logger.Warn("Deregistered capability others depend on", "capability", id, "dependents", dependents)
+	}
+
+	if callback != nil {
+		callback(cap)
+	}
+	return nil
+}
+
+// DeregisterPeerCapabilities removes every capability registered through
+// ctx, including any still waiting for dependencies, and returns their IDs.
+// A ctx with a session ID covers only what that session registered, so other
+// connections of the same peer or host keep theirs. Without one it covers
+// everything the peer owns.
+func (h *Handler) DeregisterPeerCapabilities(ctx context.Context) []string {
+	registered := func(by registrant) bool { return false }
+	if session, ok := SessionIDFromContext(ctx); ok {
+		registered = func(by registrant) bool { return by.session == session }
+	} else if owner := h.ownerID(ctx); owner != "" {
+		registered = func(by registrant) bool { return by.owner == owner }
+	}
+
+	h.mu.Lock()
+	var ids []string
+	var removed []*Capability
+	for id, cap := range h.capabilities {
+		if !registered(registrant{owner: h.owners[id], session: h.sessions[id]}) {
+			continue
+		}
+		removed = append(removed, cap)
+		h.removeCapability(id)
+		ids = append(ids, id)
+	}
+	for id, p := range h.pending {
+		if registered(p.by) {
+			delete(h.pending, id)
+			ids = append(ids, id)
+		}
+	}
+	callback := h.onCapabilityRemoved
+	h.mu.Unlock()
+
+	if len(removed) > 0 {
+		h.persist()
+	}
+	if callback != nil {
+		for _, cap := range removed {
+			callback(cap)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// removeCapability drops id and everything kept alongside it.
+// Callers must hold h.mu for writing.
+func (h *Handler) removeCapability(id string) {
+	if cap, ok := h.capabilities[id]; ok {
+		delete(h.hashes, cap.Hash())
+		h.notifyWatchers(Removed, cap)
+	}
+	delete(h.capabilities, id)
+	h.graph.Remove(id)
+	delete(h.schemas, id)
+	delete(h.expiries, id)
+	delete(h.owners, id)
+	delete(h.sessions, id)
+}
+
+// storeCapability validates cap and adds it to the registry. by is the peer
+// and session that registered it, empty for local registrations. If any dependency
+// is missing, cap is held in h.pending and errDependenciesPending is returned.
+// errConflictLost or errOlderVersion is returned when the conflict resolver or
+// duplicate policy keeps the registered one.
+func (h *Handler) storeCapability(cap *Capability, by registrant) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if cap.ID == "" {
-		return fmt.Errorf("capability ID required")
+	if err := Validate(cap); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCapabilityFormat, err)
+	}
+
+	schema, err := compileSchema(cap)
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkDuplicate(cap); err != nil {
+		return err
 	}
 
-	h.capabilities[cap.ID] = cap
+	if err := validateDependencies(cap); err != nil {
+		return err
+	}
+	if missing := h.missingDependencies(cap); len(missing) > 0 {
+		h.pending[cap.ID] = &pendingCapability{cap: cap, by: by}
+		return fmt.Errorf("%w: %s waits for %v", errDependenciesPending, cap.ID, missing)
+	}
+	if h.dependsOnItself(cap) {
+		return fmt.Errorf("%w: capability %s would complete a dependency cycle", ErrInvalidCapabilityFormat, cap.ID)
+	}
+	delete(h.pending, cap.ID)
+
+	if old, ok := h.capabilities[cap.ID]; ok {
+		cap.RegisteredAt = old.RegisteredAt
+	} else {
+		cap.RegisteredAt = time.Now().UTC()
+	}
+	h.putCapability(cap)
+	setOwner(h.owners, cap.ID, by.owner)
+	setOwner(h.sessions, cap.ID, by.session)
+	if schema != nil {
+		h.schemas[cap.ID] = schema
+	} else {
+		delete(h.schemas, cap.ID)
+	}
+	h.scheduleExpiry(cap)
+	h.signalReady(cap.ID)
 	return nil
 }
 
-// RegisterMCPBridge registers an MCP data source bridge
+// RegisterMCPBridge registers an MCP data source bridge. A bridge with a
+// Lease must be renewed with RenewMCPBridge before the lease runs out.
 func (h *Handler) RegisterMCPBridge(bridge *MCPBridge) error {
+	return h.registerMCPBridge(bridge, "")
+}
+
+// registerMCPBridge stores bridge on behalf of owner, the peer allowed to
+// renew its lease, then publishes it
+func (h *Handler) registerMCPBridge(bridge *MCPBridge, owner string) error {
+	if err := h.storeMCPBridge(bridge, owner); err != nil {
+		return err
+	}
+
+	// Published outside the lock so subscribers may call back into the handler
+	h.events.Publish(events.BridgeAdvertised, bridge)
+
+	h.mu.RLock()
+	callback := h.onMCPBridge
+	h.mu.RUnlock()
+	if callback != nil {
+		callback(bridge)
+	}
+	return nil
+}
+
+func (h *Handler) storeMCPBridge(bridge *MCPBridge, owner string) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if bridge.ID == "" {
-		return fmt.Errorf("bridge ID required")
+	if err := Validate(bridge); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	if err := checkMCPVersion(bridge); err != nil {
+		return err
+	}
+	if bridge.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("%w: max concurrent requests must not be negative", ErrInvalidPayload)
 	}
 
 	h.mcpBridges[bridge.ID] = bridge
-	
-	// Notify about new MCP bridge if handler exists
-	if h.onMCPBridge != nil {
-		if err := h.onMCPBridge(bridge); err != nil {
-			return fmt.Errorf("mcp bridge notification failed: %w", err)
-		}
-	}
+	h.setBridgeSlots(bridge)
+	h.metrics.SetBridgeCount(len(h.mcpBridges))
+	setOwner(h.bridgeOwners, bridge.ID, owner)
+	h.scheduleLease(bridge)
 
+	// Re-registering a bridge keeps its circuit, so a failing endpoint cannot reset it
+	if _, ok := h.breakers[bridge.ID]; !ok {
+		h.breakers[bridge.ID] = newCircuitBreaker(h.breakerConfig)
+	}
+	h.persist()
 	return nil
 }
 
+// SetSharedSecret enables HMAC authentication. Once set, every inbound message
+// must carry a valid signature and every outbound message is signed.
+func (h *Handler) SetSharedSecret(secret []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sharedSecret = append([]byte(nil), secret...)
+}
+
+// DeregisterMCPBridge removes a bridge and tells connected peers it is gone
+func (h *Handler) DeregisterMCPBridge(id string) error {
+	h.mu.Lock()
+	bridge, exists := h.mcpBridges[id]
+	h.removeMCPBridge(id)
+	h.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("%w: bridge %s not found", ErrMCPEndpointUnavailable, id)
+	}
+	h.persist()
+	return h.announceBridgeDown(bridge)
+}
+
+// removeMCPBridge drops id and everything kept alongside it.
+// Callers must hold h.mu for writing.
+func (h *Handler) removeMCPBridge(id string) {
+	delete(h.mcpBridges, id)
+	delete(h.breakers, id)
+	delete(h.bridgeSlots, id)
+	for _, hold := range h.bridgeHolds[id] {
+		hold.timer.Stop()
+	}
+	delete(h.bridgeHolds, id)
+	delete(h.leases, id)
+	delete(h.bridgeOwners, id)
+	h.metrics.SetBridgeCount(len(h.mcpBridges))
+}
+
+// announceBridgeDown tells connected peers bridge is gone
+func (h *Handler) announceBridgeDown(bridge *MCPBridge) error {
+	payload, err := json.Marshal(bridge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bridge: %w", err)
+	}
+
+	return h.broadcast(&Message{
+		Version:   V1,
+		Type:      MCPBridgeDown,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+}
+
+// broadcast signs msg if authentication is enabled and pushes it to all peers
+func (h *Handler) broadcast(msg *Message) error {
+	h.mu.RLock()
+	broadcaster := h.broadcaster
+	secret := h.sharedSecret
+	h.mu.RUnlock()
+
+	if broadcaster == nil {
+		return nil
+	}
+
+	if err := signOutbound(msg, secret); err != nil {
+		return fmt.Errorf("failed to sign broadcast: %w", err)
+	}
+	return broadcaster.Broadcast(msg)
+}
+
+// announceCapability tells the announcer, if any, that cap was registered
+func (h *Handler) announceCapability(cap *Capability) error {
+	h.mu.RLock()
+	announcer := h.announcer
+	secret := h.sharedSecret
+	h.mu.RUnlock()
+
+	if announcer == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(cap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capability: %w", err)
+	}
+
+	msg := &Message{
+		Version:   V1,
+		Type:      AICapabilityAdvertise,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
+	if err := signOutbound(msg, secret); err != nil {
+		return fmt.Errorf("failed to sign announcement: %w", err)
+	}
+	return announcer.Announce(msg)
+}
+
+// signOutbound signs an unsolicited message when a shared secret is configured
+func signOutbound(msg *Message, secret []byte) error {
+	if len(secret) == 0 {
+		return nil
+	}
+
+	msg.Version = V2
+	return msg.Sign(secret)
+}
+
 // HandleMessage processes an incoming message
 func (h *Handler) HandleMessage(ctx context.Context, msg *Message) (*Message, error) {
-	switch msg.Type {
-	case Hello:
-		return h.handleHello(msg)
-	case Register:
-		return h.handleRegister(msg)
-	case Query:
-		return h.handleQuery(msg)
-	case MCPBridgeAdvertise:
-		return h.handleMCPBridgeAdvertise(msg)
-	case MCPBridgeRequest:
-		return h.handleMCPBridgeRequest(msg)
-	default:
-		if h.onMessage != nil {
-			if err := h.onMessage(msg); err != nil {
-				return nil, fmt.Errorf("message handler error: %w", err)
-			}
+	start := time.Now()
+	ctx, span := h.startSpan(ctx, msg)
+	response, err := LoggingMiddleware(h.logger)(ctx, msg, h.handleMessage)
+	endSpan(span, response, err)
+	h.metrics.ObserveDuration(fmt.Sprint(msg.Type), time.Since(start))
+	return response, err
+}
+
+// handleMessage verifies msg, runs it through the middleware chain and signs the response
+func (h *Handler) handleMessage(ctx context.Context, msg *Message) (*Message, error) {
+	h.mu.RLock()
+	secret := h.sharedSecret
+	h.mu.RUnlock()
+
+	// Authenticate before recording the nonce so forged messages cannot poison the cache
+	var verr error
+	if len(secret) > 0 {
+		verr = msg.Verify(secret)
+	}
+
+	var response *Message
+	var err error
+	dispatched := false
+	if verr != nil {
+		response, err = NewErrorMessage(ErrInvalidCredentials, verr.Error())
+	} else if rerr := h.checkReplay(ctx, msg); rerr != nil {
+		response, err = NewErrorMessage(ErrInvalidPayload, rerr.Error())
+	} else if msg.HopCount > h.maxHops {
+		response, err = NewErrorMessage(ErrInvalidPayload, "max hop count exceeded")
+	} else if rerr := h.checkRole(ctx, msg.Type); rerr != nil {
+		response, err = NewErrorMessage(ErrForbidden, rerr.Error())
+	} else if cached, ok := h.deduplicated(ctx, msg); ok {
+		// Only a message that would itself be handled gets the earlier response
+		return cached, nil
+	} else {
+		// Subscribers get their own copy so they cannot change what is handled
+		h.events.Publish(events.MessageReceived, msg.Clone())
+		response, err = h.ServeMessage(ctx, msg)
+		dispatched = true
+	}
+	if err != nil || response == nil {
+		return response, err
+	}
+	correlate(msg, response)
+
+	if len(secret) > 0 {
+		// Signatures need the V2 wire format
+		if response.Version < V2 {
+			response.Version = V2
 		}
-		return nil, nil
+		if err := response.Sign(secret); err != nil {
+			return nil, fmt.Errorf("failed to sign response: %w", err)
+		}
+	}
+	if dispatched {
+		h.remember(ctx, msg, response)
+	}
+	return response, nil
+}
+
+// routeBuiltins registers the handlers for the message types defined by the
+// protocol. Other types are only seen by MessageReceived subscribers unless
+// routes are added for them.
+func (h *Handler) routeBuiltins() {
+	h.HandleFunc(Hello, withoutContext(h.handleHello))
+	h.HandleFunc(Ping, withoutContext(h.handlePing))
+	h.HandleFunc(Handshake, withoutContext(h.HandleHandshake))
+	h.HandleFunc(Register, h.handleRegister)
+	h.HandleFunc(Query, h.handleQuery)
+	h.HandleFunc(Unregister, h.handleUnregister)
+	h.HandleFunc(AICapabilityAdvertise, h.handleAICapabilityAdvertise)
+	h.HandleFunc(AICapabilityRequest, h.handleAICapabilityRequest)
+	h.HandleFunc(MCPBridgeAdvertise, h.handleMCPBridgeAdvertise)
+	h.HandleFunc(MCPBridgeRequest, h.handleMCPBridgeRequest)
+	h.HandleFunc(MCPBridgeRenew, h.handleMCPBridgeRenew)
+	h.HandleFunc(MCPBridgeQuery, h.handleMCPBridgeQuery)
+	h.HandleFunc(AIStreamStart, withoutContext(h.handleAIStreamStart))
+	h.HandleFunc(AIStreamData, withoutContext(h.handleAIStreamData))
+	h.HandleFunc(AIStreamEnd, withoutContext(h.handleAIStreamEnd))
+	h.HandleFunc(AIStreamCredit, withoutContext(h.handleAIStreamCredit))
+}
+
+// withoutContext adapts a handler that has no use for the context
+func withoutContext(fn func(*Message) (*Message, error)) HandlerFunc {
+	return func(_ context.Context, msg *Message) (*Message, error) {
+		return fn(msg)
 	}
 }
 
@@ -104,14 +783,97 @@ func (h *Handler) handleHello(msg *Message) (*Message, error) {
 	return response, nil
 }
 
-func (h *Handler) handleRegister(msg *Message) (*Message, error) {
-	var cap Capability
-	if err := json.Unmarshal(msg.Payload, &cap); err != nil {
-		return createErrorMessage(ErrInvalidPayload, "invalid capability format")
+// handlePing answers a peer checking the connection is still alive
+func (h *Handler) handlePing(msg *Message) (*Message, error) {
+	return &Message{
+		Version:   msg.Version,
+		Type:      Pong,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// HandleHandshake negotiates a common protocol version and feature set with a peer.
+// The agreement is advisory: the session is not held to it, so later messages
+// are accepted at any supported version and with any supported feature, and
+// responses follow each request rather than the negotiated values.
+func (h *Handler) HandleHandshake(msg *Message) (*Message, error) {
+	var offer HandshakePayload
+	if err := msg.DecodePayload(&offer); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid handshake format")
+	}
+	if err := validateTenantID(offer.TenantID); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, err.Error())
+	}
+
+	// Settle on the highest version both sides support
+	low, high := offer.MinVersion, offer.MaxVersion
+	if low < MinSupportedVersion {
+		low = MinSupportedVersion
+	}
+	if high > MaxSupportedVersion {
+		high = MaxSupportedVersion
+	}
+	if low > high {
+		return NewErrorMessage(ErrInvalidVersion, "no common protocol version")
+	}
+
+	// Keep only the features both sides support
+	features := make([]string, 0, len(offer.Features))
+	var challenge []byte
+	for _, f := range offer.Features {
+		if f == FeatureIdentity && h.keyring != nil {
+			if offer.PeerID == "" {
+				return NewErrorMessage(ErrInvalidPayload, "identity requires a peer ID")
+			}
+			var err error
+			if challenge, err = security.NewChallenge(); err != nil {
+				return nil, err
+			}
+			features = append(features, f)
+			continue
+		}
+		for _, supported := range SupportedFeatures {
+			if f == supported {
+				features = append(features, f)
+				break
+			}
+		}
+	}
+
+	payload, err := json.Marshal(HandshakePayload{
+		MinVersion: high,
+		MaxVersion: high,
+		Features:   features,
+		Challenge:  challenge,
+	})
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal handshake")
+	}
+
+	return &Message{
+		Version:   high,
+		Type:      Handshake,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (h *Handler) handleRegister(ctx context.Context, msg *Message) (*Message, error) {
+	var request NegotiatePayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid capability format")
 	}
 
-	if err := h.RegisterCapability(&cap); err != nil {
-		return createErrorMessage(ErrInvalidCapabilityFormat, err.Error())
+	if request.isNegotiation() {
+		return h.handleNegotiate(&request)
+	}
+
+	cap := request.Capability
+	if _, err := h.placeCapability(ctx, &cap); err != nil {
+		return NewErrorMessage(ErrForbidden, err.Error())
+	}
+	if err := h.registerCapability(&cap, h.registrantOf(ctx)); err != nil {
+		return NewErrorMessage(ErrInvalidCapabilityFormat, err.Error())
 	}
 
 	response := &Message{
@@ -122,31 +884,202 @@ func (h *Handler) handleRegister(msg *Message) (*Message, error) {
 	return response, nil
 }
 
-func (h *Handler) handleQuery(msg *Message) (*Message, error) {
-	var query struct {
-		CapabilityType string `json:"capability_type"`
-		MCPEnabled     bool   `json:"mcp_enabled,omitempty"`
+// handleAICapabilityAdvertise registers a capability like handleRegister and pushes
+// it to every connected peer so they learn of it without querying
+func (h *Handler) handleAICapabilityAdvertise(ctx context.Context, msg *Message) (*Message, error) {
+	var cap Capability
+	if err := msg.DecodePayload(&cap); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid capability format")
+	}
+	tenant, err := h.placeCapability(ctx, &cap)
+	if err != nil {
+		return NewErrorMessage(ErrForbidden, err.Error())
 	}
 
-	if err := json.Unmarshal(msg.Payload, &query); err != nil {
-		return createErrorMessage(ErrInvalidPayload, "invalid query format")
+	// Re-advertising an unchanged capability only refreshes its TTL. Without this,
+	// nodes sharing a multicast group would echo each other's announcements forever.
+	h.mu.RLock()
+	existing := h.capabilities[cap.ID]
+	h.mu.RUnlock()
+
+	unchanged := false
+	if existing != nil {
+		incoming := cap
+		incoming.RegisteredAt = existing.RegisteredAt
+		unchanged = reflect.DeepEqual(existing, &incoming)
+	}
+
+	// An older version the duplicate policy discards is not forwarded either
+	if existing != nil && (unchanged || h.keepsExisting(&cap)) {
+		if err := h.storeCapability(&cap, h.registrantOf(ctx)); err != nil && !errors.Is(err, errDependenciesPending) && !keptExisting(err) {
+			return NewErrorMessage(ErrInvalidCapabilityFormat, err.Error())
+		}
+	} else {
+		if err := h.registerCapability(&cap, h.registrantOf(ctx)); err != nil {
+			return NewErrorMessage(ErrInvalidCapabilityFormat, err.Error())
+		}
+		// Broadcasts reach every peer, so a tenant's capabilities stay local
+		if tenant == nil {
+			// Forward the payload as encoded, at a version that can carry its codec
+			if err := h.broadcast(&Message{
+				Version:   msg.Version,
+				Type:      AICapabilityAdvertise,
+				Payload:   msg.Payload,
+				Timestamp: time.Now(),
+				Encoding:  msg.Encoding,
+				CodecID:   msg.CodecID,
+			}); err != nil {
+				h.logger.Error("Failed to broadcast capability", "capability", cap.ID, "error", err)
+			}
+		}
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// handleAICapabilityRequest returns a single capability by ID
+func (h *Handler) handleAICapabilityRequest(ctx context.Context, msg *Message) (*Message, error) {
+	var request CapabilityRequestPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid capability request format")
+	}
+	tenant := h.tenantNamespace(ctx)
+	if tenant != nil {
+		request.CapabilityID = tenant.ID(request.CapabilityID)
+	}
+
+	h.mu.RLock()
+	cap, exists := h.capabilities[request.CapabilityID]
+	visible := exists && h.visibleTo(tenant, cap)
+	h.mu.RUnlock()
+
+	// Tenants only see their own namespace, whatever the ID looks like, and
+	// other peers see no tenant's
+	if !visible {
+		return NewErrorMessage(ErrCapabilityNotFound, fmt.Sprintf("capability %s not found", request.CapabilityID))
+	}
+
+	payload, err := json.Marshal(cap)
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal capability")
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// handleUnregister removes a capability on behalf of the peer that registered it
+func (h *Handler) handleUnregister(ctx context.Context, msg *Message) (*Message, error) {
+	var request UnregisterPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid unregister format")
+	}
+	if tenant := h.tenantNamespace(ctx); tenant != nil {
+		request.CapabilityID = tenant.ID(request.CapabilityID)
 	}
 
+	h.mu.RLock()
+	_, exists := h.capabilities[request.CapabilityID]
+	owner := h.owners[request.CapabilityID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return NewErrorMessage(ErrCapabilityNotFound, fmt.Sprintf("capability %s not found", request.CapabilityID))
+	}
+	if owner != h.ownerID(ctx) {
+		return NewErrorMessage(ErrForbidden, "capability registered by another peer")
+	}
+
+	if err := h.DeregisterCapability(request.CapabilityID); err != nil {
+		return NewErrorMessage(ErrCapabilityNotFound, err.Error())
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (h *Handler) handleQuery(ctx context.Context, msg *Message) (*Message, error) {
+	var query QueryPayload
+	if err := msg.DecodePayload(&query); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid query format")
+	}
+	tenant := h.tenantNamespace(ctx)
+	if tenant != nil {
+		query.Namespace = tenant.Name()
+	}
+
+	// Validate version bounds up front
+	for _, bound := range []string{query.MinVersion, query.MaxVersion} {
+		if bound == "" {
+			continue
+		}
+		if _, err := canonicalVersion(bound); err != nil {
+			return NewErrorMessage(ErrInvalidCapabilityFormat, err.Error())
+		}
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return NewErrorMessage(ErrInvalidPayload, "offset and limit must not be negative")
+	}
+	versioned := query.MinVersion != "" || query.MaxVersion != ""
+	filter := lowerFilter(query.MetadataFilter)
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	// Filter capabilities based on query
 	matches := make([]*Capability, 0)
 	for _, cap := range h.capabilities {
-		if cap.Type == query.CapabilityType && (!query.MCPEnabled || cap.MCPEnabled) {
-			matches = append(matches, cap)
+		if cap.Type != query.CapabilityType || (query.MCPEnabled && !cap.MCPEnabled) {
+			continue
+		}
+		if query.Interaction != 0 && cap.Interaction != query.Interaction {
+			continue
 		}
+		if query.Namespace != "" && cap.Namespace != query.Namespace {
+			continue
+		}
+		if !h.visibleTo(tenant, cap) {
+			continue
+		}
+		if !metadataMatches(cap.Metadata, filter) {
+			continue
+		}
+
+		if versioned {
+			if cap.Version == "" {
+				continue
+			}
+			if ok, err := versionInRange(cap.Version, query.MinVersion, query.MaxVersion); err != nil || !ok {
+				continue
+			}
+		}
+
+		matches = append(matches, cap)
 	}
+	SortCapabilities(matches, h.defaultSort)
 
 	// Prepare response
-	payload, err := json.Marshal(matches)
+	var body any = matches
+	if query.paginated() {
+		body = &QueryResponse{
+			Capabilities: paginate(matches, query.Offset, query.Limit),
+			Total:        len(matches),
+		}
+	}
+	payload, err := json.Marshal(body)
 	if err != nil {
-		return createErrorMessage(ErrInvalidPayload, "failed to marshal response")
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal response")
 	}
 
 	return &Message{
@@ -157,14 +1090,43 @@ func (h *Handler) handleQuery(msg *Message) (*Message, error) {
 	}, nil
 }
 
-func (h *Handler) handleMCPBridgeAdvertise(msg *Message) (*Message, error) {
+// lowerFilter lowercases the values of a metadata filter once per query
+func lowerFilter(filter map[string]string) map[string]string {
+	lowered := make(map[string]string, len(filter))
+	for k, v := range filter {
+		lowered[k] = strings.ToLower(v)
+	}
+	return lowered
+}
+
+// metadataMatches reports whether metadata has every key in filter with a
+// value containing the filter value. Filter values must be lowercase.
+func metadataMatches(metadata, filter map[string]string) bool {
+	for k, want := range filter {
+		got, ok := metadata[k]
+		if !ok || !strings.Contains(strings.ToLower(got), want) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) handleMCPBridgeAdvertise(ctx context.Context, msg *Message) (*Message, error) {
 	var bridge MCPBridge
-	if err := json.Unmarshal(msg.Payload, &bridge); err != nil {
-		return createErrorMessage(ErrInvalidPayload, "invalid MCP bridge format")
+	if err := msg.DecodePayload(&bridge); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid MCP bridge format")
 	}
 
-	if err := h.RegisterMCPBridge(&bridge); err != nil {
-		return createErrorMessage(ErrMCPEndpointUnavailable, err.Error())
+	if err := h.registerMCPBridge(&bridge, h.ownerID(ctx)); err != nil {
+		if errors.Is(err, ErrMCPProtocolMismatch) {
+			return NewErrorMessage(ErrMCPProtocolMismatch, err.Error())
+		}
+		return NewErrorMessage(ErrMCPEndpointUnavailable, err.Error())
+	}
+
+	// Leased bridges learn when they must renew
+	if expiry, ok := h.BridgeLeaseExpiry(bridge.ID); ok {
+		return newLeaseResponse(bridge.ID, expiry)
 	}
 
 	response := &Message{
@@ -175,41 +1137,56 @@ func (h *Handler) handleMCPBridgeAdvertise(msg *Message) (*Message, error) {
 	return response, nil
 }
 
-func (h *Handler) handleMCPBridgeRequest(msg *Message) (*Message, error) {
-	var request struct {
-		BridgeID  string `json:"bridge_id"`
-		DataType  string `json:"data_type"`
+func (h *Handler) handleMCPBridgeRequest(ctx context.Context, msg *Message) (*Message, error) {
+	var request MCPBridgeRequestPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid bridge request format")
 	}
-
-	if err := json.Unmarshal(msg.Payload, &request); err != nil {
-		return createErrorMessage(ErrInvalidPayload, "invalid bridge request format")
+	if request.BridgeID == "" {
+		return h.selectMCPBridge(ctx, &request)
 	}
 
 	h.mu.RLock()
 	bridge, exists := h.mcpBridges[request.BridgeID]
+	breaker := h.breakers[request.BridgeID]
+	slots := h.bridgeSlots[request.BridgeID]
 	h.mu.RUnlock()
 
 	if !exists {
-		return createErrorMessage(ErrMCPEndpointUnavailable, "bridge not found")
+		return NewErrorMessage(ErrMCPEndpointUnavailable, "bridge not found")
 	}
 
-	// Check if requested data type is supported
-	supported := false
-	for _, dt := range bridge.DataTypes {
-		if dt == request.DataType {
-			supported = true
-			break
-		}
+	// Check the requester against the bridge ACL
+	peerID, _ := h.trustedPeerID(ctx)
+	if !bridge.allows(ctx, peerID) {
+		return NewErrorMessage(ErrForbidden, "client not allowed to use bridge")
 	}
 
+	// Check if requested data type is supported
+	pattern, supported := matchDataType(bridge.DataTypes, request.DataType)
 	if !supported {
-		return createErrorMessage(ErrMCPProtocolMismatch, "unsupported data type")
+		return NewErrorMessage(ErrMCPProtocolMismatch, "unsupported data type")
 	}
 
-	// Return bridge details
-	payload, err := json.Marshal(bridge)
+	// Fail fast while the bridge's circuit is open
+	if breaker != nil && !breaker.allow() {
+		return NewErrorMessage(ErrMCPEndpointUnavailable, "bridge circuit open")
+	}
+
+	if !acquireSlot(slots) {
+		return NewErrorMessage(ErrMCPEndpointUnavailable, "bridge at capacity")
+	}
+	return h.grantBridge(bridge, pattern, slots)
+}
+
+// bridgeResponse returns the details of bridge, which matched the requested
+// data type with pattern
+func bridgeResponse(bridge *MCPBridge, pattern string) (*Message, error) {
+	bridge.aclMu.RLock()
+	payload, err := json.Marshal(MCPBridgeResponsePayload{MCPBridge: bridge, MatchedPattern: pattern})
+	bridge.aclMu.RUnlock()
 	if err != nil {
-		return createErrorMessage(ErrInvalidPayload, "failed to marshal bridge details")
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal bridge details")
 	}
 
 	return &Message{
@@ -220,16 +1197,34 @@ func (h *Handler) handleMCPBridgeRequest(msg *Message) (*Message, error) {
 	}, nil
 }
 
-func createErrorMessage(code ErrorCode, message string) (*Message, error) {
-	errPayload := struct {
-		Code    ErrorCode `json:"code"`
-		Message string    `json:"message"`
-	}{
-		Code:    code,
-		Message: message,
+// matchDataType returns the entry of dataTypes that dataType matches. An
+// entry containing * or ? is a path.Match glob, so structured.* matches
+// structured.json; exact entries are preferred over globs.
+func matchDataType(dataTypes []string, dataType string) (string, bool) {
+	for _, dt := range dataTypes {
+		if dt == dataType {
+			return dt, true
+		}
 	}
+	for _, dt := range dataTypes {
+		if !strings.ContainsAny(dt, "*?") {
+			continue
+		}
+		// Malformed patterns never match
+		if ok, err := path.Match(dt, dataType); err == nil && ok {
+			return dt, true
+		}
+	}
+	return "", false
+}
 
-	payload, err := json.Marshal(errPayload)
+// NewErrorMessage builds an Error message carrying the given code and description
+func NewErrorMessage(code ErrorCode, message string) (*Message, error) {
+	payload, err := json.Marshal(ErrorPayload{
+		Code:    code,
+		Name:    code.String(),
+		Message: message,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create error message: %w", err)
 	}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// Hash returns the SHA-256 digest of the capability's content, the same
+// on every server holding an identical capability. Metadata is hashed in
+// key order; Dependencies in the order given. The Namespace is only hashed
+// when set, so capabilities outside any namespace keep their earlier hashes.
+func (c *Capability) Hash() [32]byte {
+	var buf []byte
+	for _, s := range []string{c.ID, c.Name, c.Type, c.Version} {
+		buf = appendHashString(buf, s)
+	}
+	buf = binary.AppendUvarint(buf, uint64(c.Interaction))
+
+	keys := make([]string, 0, len(c.Metadata))
+	for k := range c.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendHashString(buf, k)
+		buf = appendHashString(buf, c.Metadata[k])
+	}
+
+	if c.MCPEnabled {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendVarint(buf, int64(c.TTL))
+
+	buf = binary.AppendUvarint(buf, uint64(len(c.Dependencies)))
+	for _, dep := range c.Dependencies {
+		buf = appendHashString(buf, dep)
+	}
+
+	if c.Namespace != "" {
+		buf = appendHashString(buf, c.Namespace)
+	}
+	return sha256.Sum256(buf)
+}
+
+// appendHashString appends s prefixed with its length, so adjacent fields
+// cannot run into each other
+func appendHashString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// GetCapabilityByHash returns a copy of the registered capability whose
+// Hash is hash
+func (h *Handler) GetCapabilityByHash(hash [32]byte) (*Capability, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	cap, ok := h.capabilities[h.hashes[hash]]
+	if !ok {
+		return nil, false
+	}
+	return cap.clone(), true
+}
+
+// putCapability adds cap to the registry and the hash index, replacing any
+// capability with the same ID, and tells watchers if anything changed.
+// Callers must hold h.mu for writing.
+func (h *Handler) putCapability(cap *Capability) {
+	hash := cap.Hash()
+	old, replaced := h.capabilities[cap.ID]
+	var oldHash [32]byte
+	if replaced {
+		oldHash = old.Hash()
+		delete(h.hashes, oldHash)
+	}
+	h.capabilities[cap.ID] = cap
+	h.hashes[hash] = cap.ID
+	h.graph.Add(cap)
+
+	switch {
+	case !replaced:
+		h.notifyWatchers(Added, cap)
+	case oldHash != hash:
+		h.notifyWatchers(Updated, cap)
+	}
+}
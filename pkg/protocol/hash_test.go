@@ -0,0 +1,80 @@
+package protocol
+
+import "testing"
+
+func TestCapabilityHash(t *testing.T) {
+	base := &Capability{
+		ID:          "nlp-1",
+		Name:        "Sentiment",
+		Type:        "nlp",
+		Version:     "1.0.0",
+		Interaction: Discover,
+		Metadata:    map[string]string{"lang": "en", "model": "small"},
+	}
+
+	// Map iteration order must not leak into the digest
+	same := base.clone()
+	same.Metadata = map[string]string{"model": "small", "lang": "en"}
+	if base.Hash() != same.Hash() {
+		t.Error("Expected identical capabilities to hash the same")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Capability)
+	}{
+		{"ID", func(c *Capability) { c.ID = "nlp-2" }},
+		{"version", func(c *Capability) { c.Version = "1.0.1" }},
+		{"metadata value", func(c *Capability) { c.Metadata["lang"] = "fr" }},
+		{"metadata key moved", func(c *Capability) { c.Metadata = map[string]string{"lang": "en", "mode": "lsmall"} }},
+		{"fields run together", func(c *Capability) { c.Name, c.Type = "Sentimentnlp", "" }},
+		{"MCP enabled", func(c *Capability) { c.MCPEnabled = true }},
+		{"dependencies", func(c *Capability) { c.Dependencies = []string{"tokenizer"} }},
+		{"namespace", func(c *Capability) { c.Namespace = "nlp" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base.clone()
+			tt.modify(changed)
+			if changed.Hash() == base.Hash() {
+				t.Errorf("Expected a different %s to change the hash", tt.name)
+			}
+		})
+	}
+}
+
+func TestGetCapabilityByHash(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	cap := &Capability{ID: "nlp-1", Type: "nlp", Version: "1.0.0", Interaction: Discover}
+	if err := handler.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	first := cap.Hash()
+
+	got, ok := handler.GetCapabilityByHash(first)
+	if !ok || got.ID != "nlp-1" {
+		t.Fatalf("GetCapabilityByHash() = %v, %v, want nlp-1", got, ok)
+	}
+
+	// Replacing the capability moves it to its new hash
+	updated := &Capability{ID: "nlp-1", Type: "nlp", Version: "1.1.0", Interaction: Discover}
+	if err := handler.RegisterCapability(updated); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if _, ok := handler.GetCapabilityByHash(first); ok {
+		t.Error("Expected the replaced capability's hash to be gone")
+	}
+	if got, ok := handler.GetCapabilityByHash(updated.Hash()); !ok || got.Version != "1.1.0" {
+		t.Errorf("GetCapabilityByHash() = %v, %v, want version 1.1.0", got, ok)
+	}
+
+	if err := handler.DeregisterCapability("nlp-1"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if _, ok := handler.GetCapabilityByHash(updated.Hash()); ok {
+		t.Error("Expected a deregistered capability not to be found by hash")
+	}
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Longest a single endpoint probe may take
+const maxProbeTimeout = 5 * time.Second
+
+// BridgeHealthChecker periodically probes MCP bridge endpoints and deregisters
+// bridges that fail too many consecutive checks
+type BridgeHealthChecker struct {
+	handler     *Handler
+	interval    time.Duration
+	maxFailures int
+	probe       func(ctx context.Context, endpoint string) error
+
+	failures map[string]int
+}
+
+// WithBridgeHealthCheck probes every bridge each interval and removes those that
+// fail maxFailures checks in a row
+func WithBridgeHealthCheck(interval time.Duration, maxFailures int) Option {
+	return func(h *Handler) {
+		h.healthChecker = &BridgeHealthChecker{
+			handler:     h,
+			interval:    interval,
+			maxFailures: maxFailures,
+			probe:       ProbeEndpoint,
+			failures:    make(map[string]int),
+		}
+	}
+}
+
+func (c *BridgeHealthChecker) run(done <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.checkAll()
+		}
+	}
+}
+
+// checkAll probes every registered bridge concurrently
+func (c *BridgeHealthChecker) checkAll() {
+	c.handler.mu.RLock()
+	endpoints := make(map[string]string, len(c.handler.mcpBridges))
+	for id, bridge := range c.handler.mcpBridges {
+		endpoints[id] = bridge.Endpoint
+	}
+	c.handler.mu.RUnlock()
+
+	timeout := c.interval
+	if timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(endpoints))
+	for id, endpoint := range endpoints {
+		wg.Add(1)
+		go func(id, endpoint string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			err := c.probe(ctx, endpoint)
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id, endpoint)
+	}
+	wg.Wait()
+
+	// Forget bridges that were removed by other means
+	for id := range c.failures {
+		if _, ok := results[id]; !ok {
+			delete(c.failures, id)
+		}
+	}
+
+	for id, err := range results {
+		c.handler.RecordBridgeResult(id, err)
+
+		if err == nil {
+			delete(c.failures, id)
+			continue
+		}
+
+		c.failures[id]++
+		if c.failures[id] < c.maxFailures {
+			continue
+		}
+
+		c.handler.logger.Warn("Deregistering unhealthy MCP bridge", "bridge", id, "failures", c.failures[id], "error", err)
+		delete(c.failures, id)
+		if err := c.handler.DeregisterMCPBridge(id); err != nil {
+			c.handler.logger.Error("Failed to deregister MCP bridge", "bridge", id, "error", err)
+		}
+	}
+}
+
+// ProbeEndpoint checks that an MCP endpoint is reachable: an HTTP GET for
+// http(s) endpoints and a TCP dial otherwise
+func ProbeEndpoint(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("endpoint returned %s", resp.Status)
+		}
+		return nil
+	default:
+		if u.Port() == "" {
+			return fmt.Errorf("endpoint %s has no port to probe", endpoint)
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
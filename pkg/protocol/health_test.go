@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBroadcaster captures broadcast messages for inspection
+type recordingBroadcaster struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (b *recordingBroadcaster) Broadcast(msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+func (b *recordingBroadcaster) received() []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*Message(nil), b.messages...)
+}
+
+func TestBridgeHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	// Reserve a port and release it so dials are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	deadAddr := listener.Addr().String()
+	listener.Close()
+
+	handler := NewHandler(WithBridgeHealthCheck(20*time.Millisecond, 2))
+	defer handler.Close()
+
+	broadcaster := &recordingBroadcaster{}
+	handler.SetBroadcaster(broadcaster)
+
+	for _, bridge := range []*MCPBridge{
+		{ID: "healthy", Endpoint: healthy.URL, Protocol: "MCP/1.0", Metadata: mcp10Metadata()},
+		{ID: "dead", Endpoint: "mcp://" + deadAddr + "/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata()},
+	} {
+		if err := handler.RegisterMCPBridge(bridge); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", bridge.ID, err)
+		}
+	}
+
+	// Wait for the dead bridge to be announced
+	deadline := time.Now().Add(2 * time.Second)
+	for len(broadcaster.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	messages := broadcaster.received()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 broadcast, got %d", len(messages))
+	}
+	if messages[0].Type != MCPBridgeDown {
+		t.Errorf("Expected MCPBridgeDown, got %v", messages[0].Type)
+	}
+
+	var down MCPBridge
+	if err := json.Unmarshal(messages[0].Payload, &down); err != nil {
+		t.Fatalf("Failed to unmarshal bridge: %v", err)
+	}
+	if down.ID != "dead" {
+		t.Errorf("Expected dead bridge to be removed, got %s", down.ID)
+	}
+
+	handler.mu.RLock()
+	_, healthyExists := handler.mcpBridges["healthy"]
+	_, deadExists := handler.mcpBridges["dead"]
+	handler.mu.RUnlock()
+
+	if !healthyExists {
+		t.Error("Expected healthy bridge to remain registered")
+	}
+	if deadExists {
+		t.Error("Expected dead bridge to be deregistered")
+	}
+}
+
+func TestDeregisterUnknownBridge(t *testing.T) {
+	handler := NewHandler()
+
+	if err := handler.DeregisterMCPBridge("missing"); !errors.Is(err, ErrMCPEndpointUnavailable) {
+		t.Errorf("Expected ErrMCPEndpointUnavailable, got %v", err)
+	}
+}
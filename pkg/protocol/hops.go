@@ -0,0 +1,56 @@
+package protocol
+
+import "fmt"
+
+// DefaultMaxHops is how many forwarding nodes a message may pass through
+// before it is taken to be looping
+const DefaultMaxHops uint8 = 8
+
+// WithMaxHops sets how many forwarding nodes a message may pass through.
+// Messages whose HopCount exceeds it are rejected with ErrInvalidPayload.
+func WithMaxHops(n uint8) Option {
+	return func(h *Handler) {
+		h.maxHops = n
+	}
+}
+
+// Forwarded returns a copy of m for sending on to the next node, with its
+// hop count incremented and the version raised to carry it. The count
+// saturates rather than wrapping back to zero.
+func (m *Message) Forwarded() *Message {
+	cp := m.Clone()
+	if cp.HopCount < 255 {
+		cp.HopCount++
+	}
+	if cp.Version < V2 {
+		cp.Version = V2
+	}
+	return cp
+}
+
+// encodeRoute writes each node ID of route behind a one byte length
+func encodeRoute(route []string) ([]byte, error) {
+	var value []byte
+	for _, id := range route {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("route node ID %q must be 1 to 255 bytes", id)
+		}
+		value = append(value, byte(len(id)))
+		value = append(value, id...)
+	}
+	return value, nil
+}
+
+// decodeRoute reads a route written by encodeRoute
+func decodeRoute(value []byte) ([]string, error) {
+	var route []string
+	for len(value) > 0 {
+		size := int(value[0])
+		if size == 0 || len(value) < 1+size {
+			return nil, fmt.Errorf("%w: truncated route", ErrInvalidPayload)
+		}
+		route = append(route, string(value[1:1+size]))
+		value = value[1+size:]
+	}
+	return route, nil
+}
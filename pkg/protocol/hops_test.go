@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwarded(t *testing.T) {
+	msg := &Message{Version: V1, Type: Query, Payload: []byte(`{}`), Timestamp: time.Now()}
+
+	forwarded := msg.Forwarded()
+	if forwarded.HopCount != 1 || forwarded.Version != V2 {
+		t.Errorf("Forwarded() = hops %d version %v, want 1 and V2", forwarded.HopCount, forwarded.Version)
+	}
+	if msg.HopCount != 0 || msg.Version != V1 {
+		t.Error("Forwarded() changed the original message")
+	}
+
+	data, err := forwarded.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if decoded.HopCount != 1 {
+		t.Errorf("Decoded HopCount = %d, want 1", decoded.HopCount)
+	}
+
+	if _, err := (&Message{Version: V1, Type: Query, Timestamp: time.Now(), HopCount: 1}).Serialize(); err == nil {
+		t.Error("Expected error serializing a hop count as V1")
+	}
+
+	saturated := &Message{Version: V2, Type: Query, Timestamp: time.Now(), HopCount: 255}
+	if got := saturated.Forwarded().HopCount; got != 255 {
+		t.Errorf("Forwarded() of a saturated count = %d, want 255", got)
+	}
+}
+
+func TestHandlerMaxHops(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		hops    uint8
+		wantErr bool
+	}{
+		{"direct", nil, 0, false},
+		{"at default limit", nil, DefaultMaxHops, false},
+		{"past default limit", nil, DefaultMaxHops + 1, true},
+		{"past custom limit", []Option{WithMaxHops(2)}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(tt.opts...)
+			defer handler.Close()
+
+			msg := &Message{Version: V2, Type: Hello, Timestamp: time.Now(), HopCount: tt.hops}
+			response, err := handler.HandleMessage(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if !tt.wantErr {
+				if response.Type == Error {
+					t.Errorf("HandleMessage() = %s, want a response", response.Payload)
+				}
+				return
+			}
+			var payload ErrorPayload
+			if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil ||
+				payload.Code != ErrInvalidPayload || payload.Message != "max hop count exceeded" {
+				t.Errorf("HandleMessage() = %s, want max hop count exceeded", response.Payload)
+			}
+		})
+	}
+}
+
+func TestSignatureIgnoresHopCount(t *testing.T) {
+	secret := []byte("hop-secret")
+	msg := &Message{Version: V2, Type: Query, Payload: []byte(`{}`), Timestamp: time.Now()}
+	if err := msg.Sign(secret); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := msg.Forwarded().Verify(secret); err != nil {
+		t.Errorf("Verify() of a forwarded message error = %v", err)
+	}
+}
+
+func TestRouteEncoding(t *testing.T) {
+	msg := &Message{Version: V2, Type: Query, Payload: []byte(`{}`), Timestamp: time.Now(), Route: []string{"relay-a", "relay-b", "c"}}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if !slices.Equal(decoded.Route, msg.Route) {
+		t.Errorf("Decoded Route = %v, want %v", decoded.Route, msg.Route)
+	}
+
+	// Relays pop the route, so clones must not share it
+	clone := msg.Clone()
+	clone.Route[0] = "changed"
+	if msg.Route[0] != "relay-a" {
+		t.Error("Clone() shares Route with the original")
+	}
+
+	// Popping the route must not break the signature
+	key := []byte("shared-secret")
+	if err := msg.Sign(key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	popped := msg.Forwarded()
+	popped.Route = popped.Route[1:]
+	if err := popped.Verify(key); err != nil {
+		t.Errorf("Verify() after popping the route error = %v", err)
+	}
+
+	for _, route := range [][]string{{""}, {strings.Repeat("x", 256)}} {
+		if _, err := (&Message{Version: V2, Type: Query, Timestamp: time.Now(), Route: route}).Serialize(); err == nil {
+			t.Errorf("Expected error serializing route %q", route)
+		}
+	}
+	if _, err := (&Message{Version: V1, Type: Query, Timestamp: time.Now(), Route: []string{"a"}}).Serialize(); err == nil {
+		t.Error("Expected error serializing a route as V1")
+	}
+	if _, err := decodeRoute([]byte{5, 'a'}); err == nil {
+		t.Error("Expected error decoding a truncated route")
+	}
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// WithKeyring lets peers prove the identities held in keyring during the
+// handshake, by agreeing FeatureIdentity with those that offer it. Proven
+// identities are attached to the session context for access control.
+func WithKeyring(keyring *security.Keyring) Option {
+	return func(h *Handler) {
+		h.keyring = keyring
+	}
+}
+
+// VerifyHandshakeProof checks the second Handshake a peer sends after
+// HandleHandshake challenged it, proving it is peerID. It returns the
+// proven identity and a Response, or a nil identity and the Error to send.
+func (h *Handler) VerifyHandshakeProof(peerID string, challenge []byte, msg *Message) (*security.PeerIdentity, *Message, error) {
+	if msg.Type != Handshake {
+		response, err := NewErrorMessage(ErrInvalidMessageType, "identity proof required")
+		return nil, response, err
+	}
+
+	var proof HandshakePayload
+	if err := msg.DecodePayload(&proof); err != nil {
+		response, err := NewErrorMessage(ErrInvalidPayload, "invalid identity proof format")
+		return nil, response, err
+	}
+	if proof.PeerID != "" && proof.PeerID != peerID {
+		response, err := NewErrorMessage(ErrInvalidCredentials, "identity proof names another peer")
+		return nil, response, err
+	}
+
+	identity, err := h.keyring.Verify(peerID, challenge, proof.Proof)
+	if err != nil {
+		h.logger.Warn("Rejected identity proof", "peer_id", peerID, "error", err)
+		response, err := NewErrorMessage(ErrInvalidCredentials, "identity not proven")
+		return nil, response, err
+	}
+
+	return identity, &Message{
+		Version:   msg.Version,
+		Type:      Response,
+		Timestamp: time.Now(),
+	}, nil
+}
@@ -0,0 +1,209 @@
+package protocol
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func handshakeMessage(t *testing.T, payload HandshakePayload) *Message {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to marshal handshake: %v", err)
+	}
+	return &Message{Version: V1, Type: Handshake, Payload: data, Timestamp: time.Now()}
+}
+
+func TestHandshakeIdentityChallenge(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	keyring, err := security.NewKeyring(&security.PeerIdentity{ID: "agent-1", PublicKey: pub})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	offer := HandshakePayload{
+		MinVersion: MinSupportedVersion,
+		MaxVersion: MaxSupportedVersion,
+		Features:   []string{FeatureIdentity},
+		PeerID:     "agent-1",
+	}
+
+	tests := []struct {
+		name          string
+		opts          []Option
+		offer         HandshakePayload
+		wantChallenge bool
+		wantErr       ErrorCode
+	}{
+		{"keyring and feature", []Option{WithKeyring(keyring)}, offer, true, 0},
+		{"no keyring", nil, offer, false, 0},
+		{"feature not offered", []Option{WithKeyring(keyring)}, HandshakePayload{MinVersion: V1, MaxVersion: V1, PeerID: "agent-1"}, false, 0},
+		{"no peer ID", []Option{WithKeyring(keyring)}, HandshakePayload{MinVersion: V1, MaxVersion: V1, Features: []string{FeatureIdentity}}, false, ErrInvalidPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(tt.opts...)
+			defer handler.Close()
+
+			response, err := handler.HandleHandshake(handshakeMessage(t, tt.offer))
+			if err != nil {
+				t.Fatalf("HandleHandshake() error = %v", err)
+			}
+			if tt.wantErr != 0 {
+				var payload ErrorPayload
+				if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != tt.wantErr {
+					t.Fatalf("HandleHandshake() = %s, want error %v", response.Payload, tt.wantErr)
+				}
+				return
+			}
+
+			var agreed HandshakePayload
+			if err := json.Unmarshal(response.Payload, &agreed); err != nil {
+				t.Fatalf("Failed to decode handshake response: %v", err)
+			}
+			if got := len(agreed.Challenge) == security.ChallengeSize; got != tt.wantChallenge {
+				t.Errorf("Challenge = %x, want challenge %v", agreed.Challenge, tt.wantChallenge)
+			}
+			if got := slices.Contains(agreed.Features, FeatureIdentity); got != tt.wantChallenge {
+				t.Errorf("Features = %v, want identity agreed %v", agreed.Features, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestVerifyHandshakeProof(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	keyring, err := security.NewKeyring(&security.PeerIdentity{ID: "agent-1", PublicKey: pub, Roles: []string{"reader"}})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	handler := NewHandler(WithKeyring(keyring))
+	defer handler.Close()
+
+	challenge, _ := security.NewChallenge()
+	proof := security.SignChallenge(key, "agent-1", challenge)
+
+	tests := []struct {
+		name    string
+		msg     *Message
+		wantErr ErrorCode
+	}{
+		{"valid proof", handshakeMessage(t, HandshakePayload{PeerID: "agent-1", Proof: proof}), 0},
+		{"bad signature", handshakeMessage(t, HandshakePayload{PeerID: "agent-1", Proof: proof[1:]}), ErrInvalidCredentials},
+		{"other peer", handshakeMessage(t, HandshakePayload{PeerID: "agent-2", Proof: proof}), ErrInvalidCredentials},
+		{"not a handshake", &Message{Version: V1, Type: Query, Timestamp: time.Now()}, ErrInvalidMessageType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, response, err := handler.VerifyHandshakeProof("agent-1", challenge, tt.msg)
+			if err != nil {
+				t.Fatalf("VerifyHandshakeProof() error = %v", err)
+			}
+
+			if tt.wantErr == 0 {
+				if identity == nil || identity.ID != "agent-1" || !identity.HasRole("reader") {
+					t.Errorf("VerifyHandshakeProof() identity = %+v, want agent-1", identity)
+				}
+				if response.Type != Response {
+					t.Errorf("VerifyHandshakeProof() response type = %v, want Response", response.Type)
+				}
+				return
+			}
+
+			if identity != nil {
+				t.Errorf("VerifyHandshakeProof() identity = %+v, want nil", identity)
+			}
+			var payload ErrorPayload
+			if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != tt.wantErr {
+				t.Errorf("VerifyHandshakeProof() = %s, want error %v", response.Payload, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMCPBridgeRoleACL(t *testing.T) {
+	bridge := &MCPBridge{
+		ID:             "restricted",
+		AllowedClients: []string{"role:reader", "agent-9"},
+	}
+
+	proven := func(id string, roles ...string) context.Context {
+		return security.ContextWithPeerIdentity(context.Background(), &security.PeerIdentity{ID: id, Roles: roles})
+	}
+
+	keyring, err := security.NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withKeyring := NewHandler(WithKeyring(keyring))
+	withoutKeyring := NewHandler()
+
+	tests := []struct {
+		name    string
+		handler *Handler
+		ctx     context.Context
+		allowed bool
+	}{
+		{"proven role", withKeyring, proven("agent-1", "reader"), true},
+		{"proven without role", withKeyring, proven("agent-1", "writer"), false},
+		{"proven listed ID", withKeyring, proven("agent-9"), true},
+		{"claimed role name", withKeyring, ContextWithPeerID(context.Background(), "role:reader"), false},
+		{"proven ID overrides claimed", withKeyring, security.ContextWithPeerIdentity(ContextWithPeerID(context.Background(), "agent-9"), &security.PeerIdentity{ID: "agent-1"}), false},
+		{"claimed ID with keyring", withKeyring, ContextWithPeerID(context.Background(), "agent-9"), false},
+		{"claimed ID without keyring", withoutKeyring, ContextWithPeerID(context.Background(), "agent-9"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peerID, _ := tt.handler.trustedPeerID(tt.ctx)
+			if got := bridge.allows(tt.ctx, peerID); got != tt.allowed {
+				t.Errorf("allows() = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestOwnerIDWithKeyring(t *testing.T) {
+	keyring, err := security.NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(WithKeyring(keyring))
+	defer handler.Close()
+
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	owner := security.ContextWithPeerIdentity(ContextWithPeerAddr(context.Background(), addr), &security.PeerIdentity{ID: "agent-1"})
+	if err := handler.registerCapability(&Capability{ID: "owned", Version: "1.0.0"}, handler.registrantOf(owner)); err != nil {
+		t.Fatalf("registerCapability() error = %v", err)
+	}
+
+	// Claiming the owner's ID without proving it does not grant ownership
+	impostor := ContextWithPeerID(ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}), "agent-1")
+	if got := handler.ownerID(impostor); got == handler.ownerID(owner) {
+		t.Errorf("ownerID() of an unproven claim = %q, want it to differ from the proven owner", got)
+	}
+	response, err := handler.HandleMessage(impostor, streamMessage(t, Unregister, UnregisterPayload{CapabilityID: "owned"}))
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	var payload ErrorPayload
+	if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for an impostor, got %v: %s", response.Type, response.Payload)
+	}
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// messageJSON is the JSON form of a Message. Byte fields are base64, as
+// encoding/json writes them, and V2 fields are left out when unset.
+type messageJSON struct {
+	Version          string           `json:"version"`
+	Type             MessageType      `json:"type"`
+	Payload          []byte           `json:"payload,omitempty"`
+	Timestamp        string           `json:"timestamp"`
+	Compressed       bool             `json:"compressed,omitempty"`
+	CompressionCodec CompressionCodec `json:"compression_codec,omitempty"`
+	Signature        []byte           `json:"signature,omitempty"`
+	Nonce            []byte           `json:"nonce,omitempty"`
+	Priority         Priority         `json:"priority,omitempty"`
+	Encoding         Encoding         `json:"encoding,omitempty"`
+	CodecID          uint8            `json:"codec_id,omitempty"`
+	TraceContext     []byte           `json:"trace_context,omitempty"`
+	CorrelationID    []byte           `json:"correlation_id,omitempty"`
+	HopCount         uint8            `json:"hop_count,omitempty"`
+	Route            []string         `json:"route,omitempty"`
+}
+
+// MarshalJSON encodes m for carrying over JSON transports such as HTTP,
+// with Version and Type as their names, Payload in base64 and Timestamp in
+// RFC 3339. PayloadSize is implied by Payload and not written.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(messageJSON{
+		Version:          m.Version.String(),
+		Type:             m.Type,
+		Payload:          m.Payload,
+		Timestamp:        m.Timestamp.Format(time.RFC3339Nano),
+		Compressed:       m.Compressed,
+		CompressionCodec: m.CompressionCodec,
+		Signature:        m.Signature,
+		Nonce:            optionalID(m.Nonce),
+		Priority:         m.Priority,
+		Encoding:         m.Encoding,
+		CodecID:          m.CodecID,
+		TraceContext:     m.TraceContext,
+		CorrelationID:    optionalID(m.CorrelationID),
+		HopCount:         m.HopCount,
+		Route:            m.Route,
+	})
+}
+
+// UnmarshalJSON decodes a message written by MarshalJSON
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw messageJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	n, err := parseEnum(raw.Version, "Version", uint64(MaxSupportedVersion), math.MaxUint8, func(n uint64) string {
+		return Version(n).String()
+	})
+	if err != nil {
+		return err
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, raw.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	msg := Message{
+		Version:          Version(n),
+		Type:             raw.Type,
+		PayloadSize:      uint32(len(raw.Payload)),
+		Payload:          raw.Payload,
+		Timestamp:        timestamp,
+		Compressed:       raw.Compressed,
+		CompressionCodec: raw.CompressionCodec,
+		Signature:        raw.Signature,
+		Priority:         raw.Priority,
+		Encoding:         raw.Encoding,
+		CodecID:          raw.CodecID,
+		TraceContext:     raw.TraceContext,
+		HopCount:         raw.HopCount,
+		Route:            raw.Route,
+	}
+	if err := readID(&msg.Nonce, raw.Nonce, "nonce"); err != nil {
+		return err
+	}
+	if err := readID(&msg.CorrelationID, raw.CorrelationID, "correlation ID"); err != nil {
+		return err
+	}
+	*m = msg
+	return nil
+}
+
+// optionalID returns id as a slice, or nil for the zero ID so it is omitted
+func optionalID(id [16]byte) []byte {
+	if id == ([16]byte{}) {
+		return nil
+	}
+	return id[:]
+}
+
+// readID copies a decoded 16 byte ID into dst, leaving it zero when absent
+func readID(dst *[16]byte, src []byte, name string) error {
+	if src == nil {
+		return nil
+	}
+	if len(src) != len(dst) {
+		return fmt.Errorf("%s must be %d bytes, got %d", name, len(dst), len(src))
+	}
+	copy(dst[:], src)
+	return nil
+}
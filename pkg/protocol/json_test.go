@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageJSON(t *testing.T) {
+	msg := &Message{
+		Version:       V2,
+		Type:          Query,
+		Payload:       []byte(`{"capability_type":"nlp"}`),
+		PayloadSize:   25,
+		Timestamp:     time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		Priority:      PriorityHigh,
+		Nonce:         [16]byte{1, 2, 3},
+		CorrelationID: [16]byte{9},
+		HopCount:      2,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("json.Unmarshal() into a map error = %v", err)
+	}
+	if fields["version"] != "V2" || fields["type"] != "Query" || fields["timestamp"] != "2024-05-01T12:30:00.123456789Z" {
+		t.Errorf("Unexpected JSON encoding %s", data)
+	}
+	if fields["payload"] != "eyJjYXBhYmlsaXR5X3R5cGUiOiJubHAifQ==" {
+		t.Errorf("Expected a base64 payload, got %v", fields["payload"])
+	}
+	if _, ok := fields["signature"]; ok {
+		t.Errorf("Expected unset fields to be omitted, got %s", data)
+	}
+
+	var got Message
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(&got, msg) {
+		t.Errorf("Round trip = %+v, want %+v", got, msg)
+	}
+}
+
+func TestMessageJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"unknown version", `{"version":"V9","type":"Query","timestamp":"2024-05-01T12:30:00Z"}`},
+		{"numeric version", `{"version":1,"type":"Query","timestamp":"2024-05-01T12:30:00Z"}`},
+		{"unknown type", `{"version":"V1","type":"Gossip","timestamp":"2024-05-01T12:30:00Z"}`},
+		{"bad timestamp", `{"version":"V1","type":"Query","timestamp":"yesterday"}`},
+		{"short nonce", `{"version":"V2","type":"Query","timestamp":"2024-05-01T12:30:00Z","nonce":"AQI="}`},
+		{"bad payload", `{"version":"V1","type":"Query","timestamp":"2024-05-01T12:30:00Z","payload":"!!"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			if err := json.NewDecoder(strings.NewReader(tt.json)).Decode(&msg); err == nil {
+				t.Errorf("Expected an error decoding %s", tt.json)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default clock-skew tolerance before an unrenewed bridge lease is reclaimed
+const defaultLeaseRenewalGrace = 5 * time.Second
+
+// BridgeLeasePayload tells a bridge owner when its lease runs out
+type BridgeLeasePayload struct {
+	BridgeID    string    `json:"bridge_id"`
+	LeaseExpiry time.Time `json:"lease_expiry"`
+}
+
+// BridgeRenewPayload asks to extend the lease of a bridge
+type BridgeRenewPayload struct {
+	BridgeID string `json:"bridge_id"`
+}
+
+// WithLeaseRenewalGrace keeps a bridge registered for d past its lease expiry,
+// tolerating clock skew and slow renewals
+func WithLeaseRenewalGrace(d time.Duration) Option {
+	return func(h *Handler) {
+		h.leaseGrace = d
+	}
+}
+
+// BridgeLeaseExpiry returns when the lease of bridge id runs out. It reports
+// false for unknown bridges and bridges registered without a lease.
+func (h *Handler) BridgeLeaseExpiry(id string) (time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	expiry, ok := h.leases[id]
+	return expiry, ok
+}
+
+// RenewMCPBridge extends the lease of bridge id by its Lease and returns the
+// new expiry
+func (h *Handler) RenewMCPBridge(id string) (time.Time, error) {
+	return h.renewMCPBridge(id, "")
+}
+
+// renewMCPBridge extends the lease of bridge id on behalf of requester, who
+// must be the peer that advertised it
+func (h *Handler) renewMCPBridge(id, requester string) (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bridge, exists := h.mcpBridges[id]
+	if !exists {
+		return time.Time{}, fmt.Errorf("%w: bridge %s not found", ErrMCPEndpointUnavailable, id)
+	}
+	if h.bridgeOwners[id] != requester {
+		return time.Time{}, fmt.Errorf("%w: bridge registered by another peer", ErrForbidden)
+	}
+	if bridge.Lease <= 0 {
+		return time.Time{}, fmt.Errorf("%w: bridge %s has no lease", ErrInvalidPayload, id)
+	}
+
+	h.scheduleLease(bridge)
+	return h.leases[id], nil
+}
+
+// scheduleLease starts or extends the lease of bridge.
+// Callers must hold h.mu for writing.
+func (h *Handler) scheduleLease(bridge *MCPBridge) {
+	if bridge.Lease <= 0 {
+		delete(h.leases, bridge.ID)
+		return
+	}
+	h.leases[bridge.ID] = time.Now().Add(bridge.Lease)
+	h.wakeExpiry()
+}
+
+// handleMCPBridgeRenew extends a bridge lease for the peer that advertised it
+func (h *Handler) handleMCPBridgeRenew(ctx context.Context, msg *Message) (*Message, error) {
+	var request BridgeRenewPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid bridge renew format")
+	}
+
+	expiry, err := h.renewMCPBridge(request.BridgeID, h.ownerID(ctx))
+	if err != nil {
+		var code ErrorCode
+		if !errors.As(err, &code) {
+			code = ErrMCPEndpointUnavailable
+		}
+		return NewErrorMessage(code, err.Error())
+	}
+	return newLeaseResponse(request.BridgeID, expiry)
+}
+
+// newLeaseResponse builds the MCPBridgeResponse carrying a lease expiry
+func newLeaseResponse(id string, expiry time.Time) (*Message, error) {
+	payload, err := json.Marshal(BridgeLeasePayload{BridgeID: id, LeaseExpiry: expiry})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lease: %w", err)
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      MCPBridgeResponse,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
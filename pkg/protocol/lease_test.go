@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// hasBridge reports whether id is still registered with handler
+func hasBridge(handler *Handler, id string) bool {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+
+	_, ok := handler.mcpBridges[id]
+	return ok
+}
+
+func TestBridgeLeaseExpires(t *testing.T) {
+	handler := NewHandler(WithLeaseRenewalGrace(0))
+	defer handler.Close()
+
+	broadcaster := &recordingBroadcaster{}
+	handler.SetBroadcaster(broadcaster)
+
+	leased := &MCPBridge{ID: "leased", Endpoint: "mcp://leased/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), Lease: 50 * time.Millisecond}
+	if err := handler.RegisterMCPBridge(leased); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+	permanent := &MCPBridge{ID: "permanent", Endpoint: "mcp://permanent/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata()}
+	if err := handler.RegisterMCPBridge(permanent); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	if _, ok := handler.BridgeLeaseExpiry("permanent"); ok {
+		t.Error("Expected no lease for a bridge registered without one")
+	}
+
+	// Wait for the lapsed bridge to be announced
+	deadline := time.Now().Add(time.Second)
+	for len(broadcaster.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	messages := broadcaster.received()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 broadcast, got %d", len(messages))
+	}
+	if messages[0].Type != MCPBridgeDown {
+		t.Errorf("Expected MCPBridgeDown, got %v", messages[0].Type)
+	}
+
+	if hasBridge(handler, "leased") {
+		t.Error("Expected lapsed bridge to be removed")
+	}
+	if !hasBridge(handler, "permanent") {
+		t.Error("Expected bridge without a lease to remain")
+	}
+	if _, err := handler.RenewMCPBridge("leased"); !errors.Is(err, ErrMCPEndpointUnavailable) {
+		t.Errorf("RenewMCPBridge() after expiry error = %v, want ErrMCPEndpointUnavailable", err)
+	}
+}
+
+func TestBridgeLeaseRenewal(t *testing.T) {
+	handler := NewHandler(WithLeaseRenewalGrace(0))
+	defer handler.Close()
+
+	bridge := &MCPBridge{ID: "renewed", Endpoint: "mcp://renewed/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), Lease: 80 * time.Millisecond}
+	if err := handler.RegisterMCPBridge(bridge); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+	first, _ := handler.BridgeLeaseExpiry("renewed")
+
+	// Renew before the first lease runs out
+	time.Sleep(50 * time.Millisecond)
+	expiry, err := handler.RenewMCPBridge("renewed")
+	if err != nil {
+		t.Fatalf("RenewMCPBridge() error = %v", err)
+	}
+	if !expiry.After(first) {
+		t.Errorf("Expected renewed expiry %v to be after %v", expiry, first)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if !hasBridge(handler, "renewed") {
+		t.Error("Expected renewed bridge to outlive its first lease")
+	}
+}
+
+func TestLeaseRenewalGrace(t *testing.T) {
+	handler := NewHandler(WithLeaseRenewalGrace(100 * time.Millisecond))
+	defer handler.Close()
+
+	bridge := &MCPBridge{ID: "skewed", Endpoint: "mcp://skewed/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), Lease: 20 * time.Millisecond}
+	if err := handler.RegisterMCPBridge(bridge); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	// Past the lease but inside the grace period a late renewal still lands
+	time.Sleep(50 * time.Millisecond)
+	if _, err := handler.RenewMCPBridge("skewed"); err != nil {
+		t.Errorf("RenewMCPBridge() within grace error = %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if hasBridge(handler, "skewed") {
+		t.Error("Expected bridge to be removed once lease and grace elapsed")
+	}
+}
+
+func TestHandleMCPBridgeRenew(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	owner := ContextWithPeerID(context.Background(), "bridge-owner")
+	other := ContextWithPeerID(context.Background(), "someone-else")
+
+	send := func(ctx context.Context, msgType MessageType, v interface{}) *Message {
+		t.Helper()
+
+		payload, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+		response, err := handler.HandleMessage(ctx, &Message{
+			Version:   V1,
+			Type:      msgType,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	bridge := MCPBridge{ID: "owned", Endpoint: "mcp://owned/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), Lease: time.Minute}
+	response := send(owner, MCPBridgeAdvertise, &bridge)
+	if response.Type != MCPBridgeResponse {
+		t.Fatalf("Expected MCPBridgeResponse to advertise, got %v", response.Type)
+	}
+	var lease BridgeLeasePayload
+	if err := json.Unmarshal(response.Payload, &lease); err != nil {
+		t.Fatalf("Failed to unmarshal lease: %v", err)
+	}
+	if lease.BridgeID != "owned" || time.Until(lease.LeaseExpiry) <= 0 {
+		t.Errorf("Unexpected lease %+v", lease)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		bridgeID string
+		wantCode ErrorCode
+	}{
+		{"owner", owner, "owned", 0},
+		{"other peer", other, "owned", ErrForbidden},
+		{"unknown bridge", owner, "missing", ErrMCPEndpointUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := send(tt.ctx, MCPBridgeRenew, BridgeRenewPayload{BridgeID: tt.bridgeID})
+			if tt.wantCode == 0 {
+				if response.Type != MCPBridgeResponse {
+					t.Fatalf("Expected MCPBridgeResponse, got %v", response.Type)
+				}
+				var renewed BridgeLeasePayload
+				if err := json.Unmarshal(response.Payload, &renewed); err != nil {
+					t.Fatalf("Failed to unmarshal lease: %v", err)
+				}
+				if renewed.LeaseExpiry.Before(lease.LeaseExpiry) {
+					t.Errorf("Expected renewal to extend the lease, got %v before %v", renewed.LeaseExpiry, lease.LeaseExpiry)
+				}
+				return
+			}
+
+			var errPayload ErrorPayload
+			if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+				t.Fatalf("Failed to unmarshal error: %v", err)
+			}
+			if response.Type != Error || errPayload.Code != tt.wantCode {
+				t.Errorf("Expected %v, got %v %v", tt.wantCode, response.Type, errPayload.Code)
+			}
+		})
+	}
+}
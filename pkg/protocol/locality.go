@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"context"
+	"sort"
+)
+
+// Location is where an MCP bridge runs
+type Location struct {
+	Region string `json:"region,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+}
+
+// score rates how near l is to a requester preferring region and zone: 2 in
+// the region, 1 in the zone alone and 0 otherwise
+func (l Location) score(region, zone string) int {
+	switch {
+	case region != "" && l.Region == region:
+		return 2
+	case zone != "" && l.Zone == zone:
+		return 1
+	}
+	return 0
+}
+
+// selectMCPBridge answers a request naming no bridge with the nearest one
+// that supports its data type, that the requester may use and whose circuit
+// is closed. Of equally near bridges the most recently updated wins.
+func (h *Handler) selectMCPBridge(ctx context.Context, request *MCPBridgeRequestPayload) (*Message, error) {
+	type candidate struct {
+		bridge  *MCPBridge
+		pattern string
+		breaker *circuitBreaker
+		slots   chan struct{}
+		score   int
+	}
+
+	peerID, _ := h.trustedPeerID(ctx)
+	h.mu.RLock()
+	var candidates []candidate
+	for id, bridge := range h.mcpBridges {
+		pattern, ok := matchDataType(bridge.DataTypes, request.DataType)
+		if !ok || !bridge.allows(ctx, peerID) {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			bridge:  bridge,
+			pattern: pattern,
+			breaker: h.breakers[id],
+			slots:   h.bridgeSlots[id],
+			score:   bridge.Location.score(request.PreferredRegion, request.PreferredZone),
+		})
+	}
+	h.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return NewErrorMessage(ErrMCPEndpointUnavailable, "no bridge available for data type")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if !a.bridge.LastUpdated.Equal(b.bridge.LastUpdated) {
+			return a.bridge.LastUpdated.After(b.bridge.LastUpdated)
+		}
+		return a.bridge.ID < b.bridge.ID
+	})
+
+	// Skip bridges failing fast while their circuit is open or at capacity
+	reason := "bridge circuit open"
+	for _, c := range candidates {
+		if c.breaker != nil && !c.breaker.allow() {
+			continue
+		}
+		if !acquireSlot(c.slots) {
+			reason = "bridge at capacity"
+			continue
+		}
+		return h.grantBridge(c.bridge, c.pattern, c.slots)
+	}
+	return NewErrorMessage(ErrMCPEndpointUnavailable, reason)
+}
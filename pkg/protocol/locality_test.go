@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSelectMCPBridgeByLocation(t *testing.T) {
+	now := time.Now()
+	bridges := []*MCPBridge{
+		{ID: "eu-old", Location: Location{Region: "eu-west", Zone: "eu-west-1a"}, LastUpdated: now.Add(-time.Hour)},
+		{ID: "eu-new", Location: Location{Region: "eu-west", Zone: "eu-west-1b"}, LastUpdated: now},
+		{ID: "us", Location: Location{Region: "us-east", Zone: "us-east-1a"}, LastUpdated: now},
+		{ID: "nowhere", LastUpdated: now.Add(time.Minute)},
+		{ID: "other-data", Location: Location{Region: "ap-south"}, LastUpdated: now},
+	}
+
+	handler := NewHandler()
+	defer handler.Close()
+	for _, b := range bridges {
+		b.Endpoint = "mcp://" + b.ID + "/v1"
+		b.Protocol = "MCP/1.0"
+		b.Metadata = map[string]string{"auth_type": "none", "data_format": "json"}
+		b.DataTypes = []string{"structured.json"}
+		if b.ID == "other-data" {
+			b.DataTypes = []string{"images"}
+		}
+		if err := handler.RegisterMCPBridge(b); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", b.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request MCPBridgeRequestPayload
+		want    string
+		wantErr ErrorCode
+	}{
+		{"region match, newest wins", MCPBridgeRequestPayload{DataType: "structured.json", PreferredRegion: "eu-west"}, "eu-new", 0},
+		{"region beats zone", MCPBridgeRequestPayload{DataType: "structured.json", PreferredRegion: "us-east", PreferredZone: "eu-west-1a"}, "us", 0},
+		{"zone match", MCPBridgeRequestPayload{DataType: "structured.json", PreferredZone: "eu-west-1a"}, "eu-old", 0},
+		{"no preference, newest wins", MCPBridgeRequestPayload{DataType: "structured.json"}, "nowhere", 0},
+		{"only bridge for data type", MCPBridgeRequestPayload{DataType: "images", PreferredRegion: "eu-west"}, "other-data", 0},
+		{"named bridge ignores location", MCPBridgeRequestPayload{BridgeID: "us", DataType: "structured.json", PreferredRegion: "eu-west"}, "us", 0},
+		{"unsupported data type", MCPBridgeRequestPayload{DataType: "audio"}, "", ErrMCPEndpointUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(context.Background(), streamMessage(t, MCPBridgeRequest, tt.request))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if tt.wantErr != 0 {
+				var payload ErrorPayload
+				if response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != tt.wantErr {
+					t.Errorf("HandleMessage() = %s, want %v", response.Payload, tt.wantErr)
+				}
+				return
+			}
+
+			var payload MCPBridgeResponsePayload
+			if response.Type != MCPBridgeResponse || json.Unmarshal(response.Payload, &payload) != nil {
+				t.Fatalf("HandleMessage() = %v: %s, want MCPBridgeResponse", response.Type, response.Payload)
+			}
+			if payload.ID != tt.want {
+				t.Errorf("Selected bridge %s, want %s", payload.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectMCPBridgeSkipsOpenCircuit(t *testing.T) {
+	handler := NewHandler(WithCircuitBreaker(CircuitBreakerConfig{MaxFailures: 1, Window: time.Hour, ResetTimeout: time.Hour}))
+	defer handler.Close()
+
+	for _, b := range []*MCPBridge{
+		{ID: "near", Location: Location{Region: "eu-west"}},
+		{ID: "far", Location: Location{Region: "us-east"}},
+	} {
+		b.Endpoint = "mcp://" + b.ID + "/v1"
+		b.Protocol = "MCP/1.0"
+		b.Metadata = map[string]string{"auth_type": "none", "data_format": "json"}
+		b.DataTypes = []string{"structured.json"}
+		if err := handler.RegisterMCPBridge(b); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", b.ID, err)
+		}
+	}
+	handler.RecordBridgeResult("near", context.DeadlineExceeded)
+
+	request := MCPBridgeRequestPayload{DataType: "structured.json", PreferredRegion: "eu-west"}
+	response, err := handler.HandleMessage(context.Background(), streamMessage(t, MCPBridgeRequest, request))
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	var payload MCPBridgeResponsePayload
+	if json.Unmarshal(response.Payload, &payload) != nil || payload.ID != "far" {
+		t.Errorf("HandleMessage() = %s, want the far bridge while near's circuit is open", response.Payload)
+	}
+}
@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metadata keys each known MCP protocol version requires of the bridges
+// that declare it. Versions not listed require none.
+var (
+	mcpVersionsMu sync.RWMutex
+	mcpVersions   = map[string][]string{
+		"MCP/1.0": {"auth_type", "data_format"},
+	}
+)
+
+// RegisterMCPVersion declares that bridges speaking ver, such as "MCP/1.1",
+// must set every key in requiredKeys in their Metadata. It replaces any
+// keys registered for ver before.
+func RegisterMCPVersion(ver string, requiredKeys []string) {
+	mcpVersionsMu.Lock()
+	defer mcpVersionsMu.Unlock()
+
+	mcpVersions[ver] = append([]string(nil), requiredKeys...)
+}
+
+// checkMCPVersion fails with ErrMCPProtocolMismatch, naming the missing
+// keys, when bridge lacks metadata its protocol version requires
+func checkMCPVersion(bridge *MCPBridge) error {
+	mcpVersionsMu.RLock()
+	required := mcpVersions[bridge.Protocol]
+	mcpVersionsMu.RUnlock()
+
+	var missing []string
+	for _, key := range required {
+		if _, ok := bridge.Metadata[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("%w: %s requires metadata %s", ErrMCPProtocolMismatch, bridge.Protocol, strings.Join(missing, ", "))
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mcp10Metadata returns the metadata every MCP/1.0 bridge must carry
+func mcp10Metadata() map[string]string {
+	return map[string]string{"auth_type": "none", "data_format": "json"}
+}
+
+func TestMCPVersionRequiredMetadata(t *testing.T) {
+	RegisterMCPVersion("MCP/9.1", []string{"region", "auth_type"})
+	defer func() {
+		mcpVersionsMu.Lock()
+		delete(mcpVersions, "MCP/9.1")
+		mcpVersionsMu.Unlock()
+	}()
+
+	tests := []struct {
+		name     string
+		protocol string
+		metadata map[string]string
+		missing  string // Empty when registration succeeds
+	}{
+		{"MCP/1.0 complete", "MCP/1.0", mcp10Metadata(), ""},
+		{"MCP/1.0 missing one", "MCP/1.0", map[string]string{"auth_type": "token"}, "data_format"},
+		{"MCP/1.0 missing all", "MCP/1.0", nil, "auth_type, data_format"},
+		{"registered version", "MCP/9.1", map[string]string{"region": "eu", "auth_type": "none"}, ""},
+		{"registered version missing", "MCP/9.1", map[string]string{"region": "eu"}, "auth_type"},
+		{"unknown version", "MCP/7.3", nil, ""},
+		{"no protocol", "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler()
+			defer handler.Close()
+
+			err := handler.RegisterMCPBridge(&MCPBridge{
+				ID:       "bridge",
+				Endpoint: "mcp://bridge/v1",
+				Protocol: tt.protocol,
+				Metadata: tt.metadata,
+			})
+			if tt.missing == "" {
+				if err != nil {
+					t.Errorf("RegisterMCPBridge() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMCPProtocolMismatch) {
+				t.Fatalf("RegisterMCPBridge() error = %v, want %v", err, ErrMCPProtocolMismatch)
+			}
+			if !strings.HasSuffix(err.Error(), "metadata "+tt.missing) {
+				t.Errorf("RegisterMCPBridge() error = %v, want it to name %s", err, tt.missing)
+			}
+		})
+	}
+}
+
+func TestMCPBridgeAdvertiseProtocolMismatch(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	payload, _ := json.Marshal(&MCPBridge{ID: "bridge", Endpoint: "mcp://bridge/v1", Protocol: "MCP/1.0"})
+	response, err := handler.HandleMessage(context.Background(), &Message{
+		Version:   V1,
+		Type:      MCPBridgeAdvertise,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	var errPayload ErrorPayload
+	if response.Type != Error || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != ErrMCPProtocolMismatch {
+		t.Errorf("Got %v %s, want ErrMCPProtocolMismatch", response.Type, response.Payload)
+	}
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// HandlerFunc handles a single message and returns the response, if any
+type HandlerFunc func(ctx context.Context, msg *Message) (*Message, error)
+
+// Middleware intercepts a message before it reaches the type-specific handlers.
+// It may inspect or rewrite msg, short-circuit with its own response, or call next.
+type Middleware func(ctx context.Context, msg *Message, next func(ctx context.Context, msg *Message) (*Message, error)) (*Message, error)
+
+// LoggingMiddleware logs each message with its peer, size and latency.
+// Failures are logged at error level, everything else at debug level.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		start := time.Now()
+		response, err := next(ctx, msg)
+
+		peer := "local"
+		if addr, ok := PeerAddrFromContext(ctx); ok {
+			peer = addr.String()
+		}
+		if err != nil {
+			logger.Error("Failed to handle message", "type", msg.Type, "peer", peer, "error", err)
+		} else {
+			logger.Debug("Handled message", "type", msg.Type, "peer", peer,
+				"payload_size", len(msg.Payload), "latency", time.Since(start))
+		}
+		return response, err
+	}
+}
+
+// AuthMiddleware rejects messages for which validator returns an error.
+// The peer receives an Error message with ErrInvalidCredentials.
+func AuthMiddleware(validator func(msg *Message) error) Middleware {
+	return func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		if err := validator(msg); err != nil {
+			return NewErrorMessage(ErrInvalidCredentials, err.Error())
+		}
+		return next(ctx, msg)
+	}
+}
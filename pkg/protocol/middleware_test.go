@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+			calls = append(calls, name+" before")
+			response, err := next(ctx, msg)
+			calls = append(calls, name+" after")
+			return response, err
+		}
+	}
+	handler.Use(record("outer"), record("inner"))
+
+	msg := &Message{Version: V1, Type: Hello, Timestamp: time.Now()}
+	if _, err := handler.HandleMessage(context.Background(), msg); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	want := []string{"outer before", "inner before", "inner after", "outer after"}
+	if len(calls) != len(want) {
+		t.Fatalf("Expected calls %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("Call %d: expected %q, got %q", i, want[i], calls[i])
+		}
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	dispatched := false
+	handler := NewHandler()
+	defer handler.Close()
+
+	canned := &Message{Version: V1, Type: Response, Payload: []byte(`"cached"`)}
+	handler.Use(func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		return canned, nil
+	}, func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		dispatched = true
+		return next(ctx, msg)
+	})
+
+	response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: Hello, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response != canned {
+		t.Errorf("Expected middleware response, got %v", response)
+	}
+	if dispatched {
+		t.Error("Expected dispatch to be skipped")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	handler.Use(AuthMiddleware(func(msg *Message) error {
+		if msg.Type == Register {
+			return errors.New("registration disabled")
+		}
+		return nil
+	}))
+
+	tests := []struct {
+		name      string
+		msgType   MessageType
+		wantError bool
+	}{
+		{"allowed", Hello, false},
+		{"rejected", Register, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Version: V1, Type: tt.msgType, Payload: []byte(`{}`), Timestamp: time.Now()}
+			response, err := handler.HandleMessage(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			rejected := response != nil && response.Type == Error
+			if rejected != tt.wantError {
+				t.Fatalf("Expected rejected = %v, got response %v", tt.wantError, response)
+			}
+			if !rejected {
+				return
+			}
+
+			var payload ErrorPayload
+			if err := json.Unmarshal(response.Payload, &payload); err != nil {
+				t.Fatalf("Failed to unmarshal error payload: %v", err)
+			}
+			if payload.Code != ErrInvalidCredentials {
+				t.Errorf("Expected ErrInvalidCredentials, got %v", payload.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"sort"
+	"strings"
+)
+
+// NamespacedHandler registers and lists the capabilities of one namespace
+// of a Handler. Capabilities registered through it have their IDs prefixed
+// with the namespace and a slash, so namespaces cannot collide.
+type NamespacedHandler struct {
+	h  *Handler
+	ns string
+}
+
+// Namespace returns a view of h limited to the namespace ns
+func (h *Handler) Namespace(ns string) *NamespacedHandler {
+	return &NamespacedHandler{h: h, ns: ns}
+}
+
+// Name returns the namespace n is limited to
+func (n *NamespacedHandler) Name() string {
+	return n.ns
+}
+
+// ID returns the registry ID of the capability n knows as id
+func (n *NamespacedHandler) ID(id string) string {
+	if strings.HasPrefix(id, n.ns+"/") {
+		return id
+	}
+	return n.ns + "/" + id
+}
+
+// RegisterCapability registers a copy of cap in the namespace, with its ID
+// prefixed and its Namespace set. cap itself is left unchanged. Dependencies
+// are full registry IDs and are not prefixed.
+func (n *NamespacedHandler) RegisterCapability(cap *Capability) error {
+	cp := cap.clone()
+	cp.ID = n.ID(cap.ID)
+	cp.Namespace = n.ns
+	return n.h.RegisterCapability(cp)
+}
+
+// DeregisterCapability removes the capability n knows as id
+func (n *NamespacedHandler) DeregisterCapability(id string) error {
+	return n.h.DeregisterCapability(n.ID(id))
+}
+
+// ListCapabilities returns copies of the capabilities in the namespace,
+// ordered by ID
+func (n *NamespacedHandler) ListCapabilities() []*Capability {
+	n.h.mu.RLock()
+	defer n.h.mu.RUnlock()
+
+	caps := make([]*Capability, 0)
+	for _, cap := range n.h.capabilities {
+		if cap.Namespace == n.ns {
+			caps = append(caps, cap.clone())
+		}
+	}
+	sort.Slice(caps, func(i, j int) bool { return caps[i].ID < caps[j].ID })
+	return caps
+}
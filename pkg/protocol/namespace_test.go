@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNamespacedHandler(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	finance := handler.Namespace("finance")
+	nlp := handler.Namespace("nlp")
+
+	cap := &Capability{ID: "summarize", Type: "DISCOVER"}
+	if err := finance.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := nlp.RegisterCapability(cap); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := nlp.RegisterCapability(&Capability{ID: "nlp/translate", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.RegisterCapability(&Capability{ID: "global", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	if cap.ID != "summarize" || cap.Namespace != "" {
+		t.Errorf("RegisterCapability() changed the caller's capability to %+v", cap)
+	}
+	if n := handler.CapabilityCount(); n != 4 {
+		t.Fatalf("Expected the same ID in two namespaces not to collide, got %d capabilities", n)
+	}
+
+	ids := func(caps []*Capability) []string {
+		out := make([]string, len(caps))
+		for i, c := range caps {
+			out[i] = c.ID
+		}
+		return out
+	}
+	if got := ids(nlp.ListCapabilities()); len(got) != 2 || got[0] != "nlp/summarize" || got[1] != "nlp/translate" {
+		t.Errorf("nlp ListCapabilities() = %v, want [nlp/summarize nlp/translate]", got)
+	}
+	if got := finance.ListCapabilities(); len(got) != 1 || got[0].ID != "finance/summarize" || got[0].Namespace != "finance" {
+		t.Errorf("finance ListCapabilities() = %v, want finance/summarize", got)
+	}
+
+	if err := finance.DeregisterCapability("summarize"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if got := finance.ListCapabilities(); len(got) != 0 {
+		t.Errorf("Expected an empty finance namespace, got %v", ids(got))
+	}
+}
+
+func TestQueryNamespace(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	handler.Namespace("finance").RegisterCapability(&Capability{ID: "a", Type: "DISCOVER"})
+	handler.Namespace("nlp").RegisterCapability(&Capability{ID: "a", Type: "DISCOVER"})
+	handler.RegisterCapability(&Capability{ID: "a", Type: "DISCOVER"})
+
+	tests := []struct {
+		namespace string
+		want      int
+	}{
+		{"", 3},
+		{"finance", 1},
+		{"nlp", 1},
+		{"legal", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			payload, _ := json.Marshal(QueryPayload{CapabilityType: "DISCOVER", Namespace: tt.namespace})
+			response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: Query, Payload: payload, Timestamp: time.Now()})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			var caps []*Capability
+			if err := json.Unmarshal(response.Payload, &caps); err != nil {
+				t.Fatalf("Failed to decode query response %s: %v", response.Payload, err)
+			}
+			if len(caps) != tt.want {
+				t.Errorf("Query returned %d capabilities, want %d", len(caps), tt.want)
+			}
+			for _, c := range caps {
+				if tt.namespace != "" && c.Namespace != tt.namespace {
+					t.Errorf("Query returned %s from namespace %q", c.ID, c.Namespace)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// NegotiateFunc decides the parameters of an interaction. offered holds the
+// matched capability's metadata and required the initiator's parameters. It
+// returns the agreed parameters, or an error if the two cannot be reconciled.
+type NegotiateFunc func(offered, required map[string]interface{}) (agreed map[string]interface{}, err error)
+
+// NegotiatePayload is the body of a Register message that opens a negotiation.
+// The embedded capability selects the counterpart by Type, or by ID if set, and
+// must have Interaction set to Negotiate.
+type NegotiatePayload struct {
+	Capability
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// NegotiateResult is returned when a negotiation reaches agreement
+type NegotiateResult struct {
+	CapabilityID string                 `json:"capability_id"`
+	Parameters   map[string]interface{} `json:"parameters"`
+}
+
+// WithNegotiateFunc sets the function that settles Negotiate interactions.
+// Without one, negotiation requests are refused.
+func WithNegotiateFunc(fn NegotiateFunc) Option {
+	return func(h *Handler) {
+		h.negotiate = fn
+	}
+}
+
+// isNegotiation reports whether a Register payload opens a negotiation rather
+// than registering a negotiable capability
+func (p *NegotiatePayload) isNegotiation() bool {
+	return p.Interaction == Negotiate && p.Parameters != nil
+}
+
+// handleNegotiate offers the initiator's parameters to each matching negotiable
+// capability in ID order and returns the first agreement
+func (h *Handler) handleNegotiate(request *NegotiatePayload) (*Message, error) {
+	if h.negotiate == nil {
+		return NewErrorMessage(ErrCapabilityUnavailable, "negotiation not supported")
+	}
+
+	h.mu.RLock()
+	var candidates []*Capability
+	for _, cap := range h.capabilities {
+		if cap.Interaction != Negotiate || cap.Type != request.Type {
+			continue
+		}
+		if request.ID != "" && cap.ID != request.ID {
+			continue
+		}
+		candidates = append(candidates, cap)
+	}
+	h.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return NewErrorMessage(ErrCapabilityNotFound, "no negotiable capability matches")
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+
+	var lastErr error
+	for _, cap := range candidates {
+		offered := make(map[string]interface{}, len(cap.Metadata))
+		for k, v := range cap.Metadata {
+			offered[k] = v
+		}
+
+		agreed, err := h.negotiate(offered, request.Parameters)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		payload, err := json.Marshal(NegotiateResult{CapabilityID: cap.ID, Parameters: agreed})
+		if err != nil {
+			return NewErrorMessage(ErrInvalidPayload, "failed to marshal negotiation result")
+		}
+		return &Message{
+			Version:   V1,
+			Type:      Response,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		}, nil
+	}
+
+	return NewErrorMessage(ErrCapabilityUnavailable, fmt.Sprintf("no agreement reached: %v", lastErr))
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// minTokens agrees on the smaller of the offered and required token limits
+func minTokens(offered, required map[string]interface{}) (map[string]interface{}, error) {
+	var limit float64
+	if _, err := fmt.Sscan(fmt.Sprint(offered["max_tokens"]), &limit); err != nil {
+		return nil, errors.New("capability has no token limit")
+	}
+	want, ok := required["max_tokens"].(float64)
+	if !ok {
+		return nil, errors.New("max_tokens required")
+	}
+	if want > limit {
+		want = limit
+	}
+	return map[string]interface{}{"max_tokens": want}, nil
+}
+
+func TestNegotiate(t *testing.T) {
+	handler := NewHandler(WithNegotiateFunc(minTokens))
+	defer handler.Close()
+
+	for _, cap := range []*Capability{
+		{ID: "gen-small", Type: "GENERATE", Interaction: Negotiate, Metadata: map[string]string{"max_tokens": "512"}},
+		{ID: "gen-fixed", Type: "GENERATE", Interaction: Delegate, Metadata: map[string]string{"max_tokens": "8192"}},
+		{ID: "embed", Type: "EMBED", Interaction: Negotiate},
+	} {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	negotiate := func(request NegotiatePayload) *Message {
+		t.Helper()
+
+		payload, _ := json.Marshal(request)
+		response, err := handler.HandleMessage(context.Background(), &Message{
+			Version:   V1,
+			Type:      Register,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	tests := []struct {
+		name     string
+		request  NegotiatePayload
+		wantCode ErrorCode
+		wantCap  string
+		want     float64
+	}{
+		{
+			name:    "agreement caps the limit",
+			request: NegotiatePayload{Capability: Capability{Type: "GENERATE", Interaction: Negotiate}, Parameters: map[string]interface{}{"max_tokens": 1024}},
+			wantCap: "gen-small",
+			want:    512,
+		},
+		{
+			name:     "no agreement",
+			request:  NegotiatePayload{Capability: Capability{Type: "EMBED", Interaction: Negotiate}, Parameters: map[string]interface{}{"max_tokens": 1}},
+			wantCode: ErrCapabilityUnavailable,
+		},
+		{
+			name:     "no negotiable capability",
+			request:  NegotiatePayload{Capability: Capability{Type: "TRANSLATE", Interaction: Negotiate}, Parameters: map[string]interface{}{}},
+			wantCode: ErrCapabilityNotFound,
+		},
+		{
+			name:     "id must match",
+			request:  NegotiatePayload{Capability: Capability{ID: "gen-fixed", Type: "GENERATE", Interaction: Negotiate}, Parameters: map[string]interface{}{}},
+			wantCode: ErrCapabilityNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := negotiate(tt.request)
+
+			if tt.wantCode != 0 {
+				if response.Type != Error {
+					t.Fatalf("Expected Error, got %v", response.Type)
+				}
+				var payload ErrorPayload
+				json.Unmarshal(response.Payload, &payload)
+				if payload.Code != tt.wantCode {
+					t.Errorf("Expected %v, got %v", tt.wantCode, payload.Code)
+				}
+				return
+			}
+
+			if response.Type != Response {
+				t.Fatalf("Expected Response, got %v: %s", response.Type, response.Payload)
+			}
+			var result NegotiateResult
+			if err := json.Unmarshal(response.Payload, &result); err != nil {
+				t.Fatalf("Failed to unmarshal result: %v", err)
+			}
+			if result.CapabilityID != tt.wantCap || result.Parameters["max_tokens"] != tt.want {
+				t.Errorf("Expected %s with max_tokens %v, got %+v", tt.wantCap, tt.want, result)
+			}
+		})
+	}
+
+	// Registering a negotiable capability without parameters is an ordinary registration
+	negotiate(NegotiatePayload{Capability: Capability{ID: "gen-large", Type: "GENERATE", Interaction: Negotiate}})
+	if err := handler.ValidateCapabilityPayload("gen-large", nil); err != nil {
+		t.Errorf("Expected gen-large to be registered, got %v", err)
+	}
+}
+
+func TestNegotiateWithoutFunc(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	payload, _ := json.Marshal(NegotiatePayload{
+		Capability: Capability{Type: "GENERATE", Interaction: Negotiate},
+		Parameters: map[string]interface{}{},
+	})
+	response, err := handler.HandleMessage(context.Background(), &Message{
+		Version:   V1,
+		Type:      Register,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Error {
+		t.Errorf("Expected negotiation to be refused, got %v", response.Type)
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// paginated reports whether q asks for a page of results
+func (q *QueryPayload) paginated() bool {
+	return q.Offset > 0 || q.Limit > 0
+}
+
+// paginate returns the page of matches starting at offset, holding at most
+// limit capabilities, or all the rest if limit is zero
+func paginate(matches []*Capability, offset, limit int) []*Capability {
+	if offset >= len(matches) {
+		return []*Capability{}
+	}
+	page := matches[offset:]
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+	return page
+}
+
+// DecodeQueryResponse decodes the Response to a Query, whether paginated or
+// a bare list from a query without Offset or Limit
+func DecodeQueryResponse(msg *Message) (*QueryResponse, error) {
+	payload := bytes.TrimSpace(msg.Payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var caps []*Capability
+		if err := json.Unmarshal(payload, &caps); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal query response: %w", err)
+		}
+		return &QueryResponse{Capabilities: caps, Total: len(caps)}, nil
+	}
+
+	var response QueryResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query response: %w", err)
+	}
+	return &response, nil
+}
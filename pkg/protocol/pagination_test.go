@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryPagination(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	// Registered out of order; pages follow ID order
+	for _, id := range []string{"cap-d", "cap-b", "cap-e", "cap-a", "cap-c"} {
+		if err := handler.RegisterCapability(&Capability{ID: id, Type: "DISCOVER", Interaction: Discover}); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		want    []string
+		wantErr bool
+	}{
+		{"unpaginated", 0, 0, []string{"cap-a", "cap-b", "cap-c", "cap-d", "cap-e"}, false},
+		{"first page", 0, 2, []string{"cap-a", "cap-b"}, false},
+		{"middle page", 2, 2, []string{"cap-c", "cap-d"}, false},
+		{"short last page", 4, 2, []string{"cap-e"}, false},
+		{"limit zero returns the rest", 3, 0, []string{"cap-d", "cap-e"}, false},
+		{"limit past total", 0, 100, []string{"cap-a", "cap-b", "cap-c", "cap-d", "cap-e"}, false},
+		{"offset at total", 5, 1, []string{}, false},
+		{"offset past total", 10, 0, []string{}, false},
+		{"negative offset", -1, 0, nil, true},
+		{"negative limit", 0, -1, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(&QueryPayload{CapabilityType: "DISCOVER", Offset: tt.offset, Limit: tt.limit})
+			response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: Query, Payload: payload, Timestamp: time.Now()})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if tt.wantErr {
+				var errPayload ErrorPayload
+				if response.Type != Error || json.Unmarshal(response.Payload, &errPayload) != nil || errPayload.Code != ErrInvalidPayload {
+					t.Errorf("HandleMessage() = %s, want %v", response.Payload, ErrInvalidPayload)
+				}
+				return
+			}
+
+			// Only paginated queries are answered with a QueryResponse
+			if paginated := response.Payload[0] == '{'; paginated != (tt.offset > 0 || tt.limit > 0) {
+				t.Errorf("Response payload %s, want paginated %v", response.Payload, tt.offset > 0 || tt.limit > 0)
+			}
+
+			page, err := DecodeQueryResponse(response)
+			if err != nil {
+				t.Fatalf("DecodeQueryResponse() error = %v", err)
+			}
+			if page.Total != 5 {
+				t.Errorf("Total = %d, want 5", page.Total)
+			}
+			ids := make([]string, 0, len(page.Capabilities))
+			for _, cap := range page.Capabilities {
+				ids = append(ids, cap.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Capabilities = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package protocol
+
+//go:generate protoc -I ../../proto --go_out=../../proto --go_opt=paths=source_relative arn/capability/v1/capability.proto arn/bridge/v1/bridge.proto arn/message/v1/message.proto
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	bridgev1 "github.com/heathweaver/arn-protocol/proto/arn/bridge/v1"
+	capabilityv1 "github.com/heathweaver/arn-protocol/proto/arn/capability/v1"
+)
+
+// marshalProto encodes v as protobuf. Generated messages are encoded as is;
+// Capability and MCPBridge go through their definitions under proto/.
+func marshalProto(v interface{}) ([]byte, error) {
+	var pm proto.Message
+	switch v := v.(type) {
+	case proto.Message:
+		pm = v
+	case *Capability:
+		pm = capabilityToProto(v)
+	case *NegotiatePayload:
+		pm = capabilityToProto(&v.Capability)
+	case *MCPBridge:
+		pm = bridgeToProto(v)
+	default:
+		return nil, fmt.Errorf("no protobuf mapping for %T", v)
+	}
+	return proto.Marshal(pm)
+}
+
+// unmarshalProto decodes data into v, the counterpart of marshalProto
+func unmarshalProto(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	case *Capability:
+		var pc capabilityv1.Capability
+		if err := proto.Unmarshal(data, &pc); err != nil {
+			return err
+		}
+		*v = *capabilityFromProto(&pc)
+	case *NegotiatePayload:
+		// Negotiation parameters have no protobuf form, only the capability is carried
+		var pc capabilityv1.Capability
+		if err := proto.Unmarshal(data, &pc); err != nil {
+			return err
+		}
+		v.Capability = *capabilityFromProto(&pc)
+	case *MCPBridge:
+		var pb bridgev1.MCPBridge
+		if err := proto.Unmarshal(data, &pb); err != nil {
+			return err
+		}
+		bridgeFromProto(&pb, v)
+	default:
+		return fmt.Errorf("no protobuf mapping for %T", v)
+	}
+	return nil
+}
+
+// ToProto converts c to the Capability message defined under proto/. The
+// result shares c's metadata and dependencies.
+func (c *Capability) ToProto() *capabilityv1.Capability {
+	return capabilityToProto(c)
+}
+
+// CapabilityFromProto converts p to a Capability, failing if p names an
+// interaction type this package does not know or breaks Capability's
+// validation rules, such as lacking an ID
+func CapabilityFromProto(p *capabilityv1.Capability) (*Capability, error) {
+	if p == nil {
+		return nil, fmt.Errorf("nil capability")
+	}
+	if _, err := interactionFromProto(p.GetInteraction()); err != nil {
+		return nil, err
+	}
+
+	c := capabilityFromProto(p)
+	if err := Validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// interactionToProto maps i to its protobuf enum value. Unknown types keep
+// their number, which proto3's open enums carry unchanged.
+func interactionToProto(i InteractionType) capabilityv1.InteractionType {
+	switch i {
+	case 0:
+		return capabilityv1.InteractionType_INTERACTION_TYPE_UNSPECIFIED
+	case Discover:
+		return capabilityv1.InteractionType_INTERACTION_TYPE_DISCOVER
+	case Negotiate:
+		return capabilityv1.InteractionType_INTERACTION_TYPE_NEGOTIATE
+	case Stream:
+		return capabilityv1.InteractionType_INTERACTION_TYPE_STREAM
+	case Delegate:
+		return capabilityv1.InteractionType_INTERACTION_TYPE_DELEGATE
+	default:
+		return capabilityv1.InteractionType(i)
+	}
+}
+
+// interactionFromProto maps a protobuf enum value back to an InteractionType
+func interactionFromProto(pi capabilityv1.InteractionType) (InteractionType, error) {
+	switch pi {
+	case capabilityv1.InteractionType_INTERACTION_TYPE_UNSPECIFIED:
+		return 0, nil
+	case capabilityv1.InteractionType_INTERACTION_TYPE_DISCOVER:
+		return Discover, nil
+	case capabilityv1.InteractionType_INTERACTION_TYPE_NEGOTIATE:
+		return Negotiate, nil
+	case capabilityv1.InteractionType_INTERACTION_TYPE_STREAM:
+		return Stream, nil
+	case capabilityv1.InteractionType_INTERACTION_TYPE_DELEGATE:
+		return Delegate, nil
+	default:
+		return InteractionType(pi), fmt.Errorf("unknown interaction type %d", int32(pi))
+	}
+}
+
+func capabilityToProto(c *Capability) *capabilityv1.Capability {
+	pc := &capabilityv1.Capability{
+		Id:           c.ID,
+		Name:         c.Name,
+		Type:         c.Type,
+		Version:      c.Version,
+		Interaction:  interactionToProto(c.Interaction),
+		Metadata:     c.Metadata,
+		McpEnabled:   c.MCPEnabled,
+		Dependencies: c.Dependencies,
+	}
+	if c.TTL != 0 {
+		pc.Ttl = durationpb.New(c.TTL)
+	}
+	return pc
+}
+
+// capabilityFromProto converts pc without validating it, so payloads decode
+// the same way whatever encoding they arrived in
+func capabilityFromProto(pc *capabilityv1.Capability) *Capability {
+	// Unknown interaction types keep their number, as they would in JSON
+	interaction, _ := interactionFromProto(pc.GetInteraction())
+	c := &Capability{
+		ID:           pc.GetId(),
+		Name:         pc.GetName(),
+		Type:         pc.GetType(),
+		Version:      pc.GetVersion(),
+		Interaction:  interaction,
+		Metadata:     pc.GetMetadata(),
+		MCPEnabled:   pc.GetMcpEnabled(),
+		Dependencies: pc.GetDependencies(),
+	}
+	if pc.Ttl != nil {
+		c.TTL = pc.Ttl.AsDuration()
+	}
+	return c
+}
+
+func bridgeToProto(b *MCPBridge) *bridgev1.MCPBridge {
+	b.aclMu.RLock()
+	allowed := append([]string(nil), b.AllowedClients...)
+	b.aclMu.RUnlock()
+
+	pb := &bridgev1.MCPBridge{
+		Id:             b.ID,
+		Endpoint:       b.Endpoint,
+		Protocol:       b.Protocol,
+		DataTypes:      b.DataTypes,
+		Metadata:       b.Metadata,
+		AllowedClients: allowed,
+	}
+	if !b.LastUpdated.IsZero() {
+		pb.LastUpdated = timestamppb.New(b.LastUpdated)
+	}
+	if b.Lease != 0 {
+		pb.Lease = durationpb.New(b.Lease)
+	}
+	return pb
+}
+
+// bridgeFromProto fills b in place, since MCPBridge holds a lock and cannot be copied
+func bridgeFromProto(pb *bridgev1.MCPBridge, b *MCPBridge) {
+	b.ID = pb.GetId()
+	b.Endpoint = pb.GetEndpoint()
+	b.Protocol = pb.GetProtocol()
+	b.DataTypes = pb.GetDataTypes()
+	b.Metadata = pb.GetMetadata()
+	b.LastUpdated = time.Time{}
+	if pb.LastUpdated != nil {
+		b.LastUpdated = pb.LastUpdated.AsTime()
+	}
+	b.Lease = 0
+	if pb.Lease != nil {
+		b.Lease = pb.Lease.AsDuration()
+	}
+
+	b.aclMu.Lock()
+	b.AllowedClients = pb.GetAllowedClients()
+	b.aclMu.Unlock()
+}
@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	capabilityv1 "github.com/heathweaver/arn-protocol/proto/arn/capability/v1"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	cap := &Capability{
+		ID:          "pb-cap",
+		Name:        "Protobuf capability",
+		Type:        "STREAM",
+		Version:     "1.2.0",
+		Interaction: Stream,
+		Metadata:    map[string]string{"region": "eu"},
+		MCPEnabled:  true,
+		TTL:         90 * time.Second,
+
+		Dependencies: []string{"base-cap"},
+	}
+
+	msg := &Message{Type: Register, Timestamp: time.Now()}
+	if err := msg.SetPayload(cap, EncodingProtobuf); err != nil {
+		t.Fatalf("SetPayload() error = %v", err)
+	}
+	var got Capability
+	if err := msg.DecodePayload(&got); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if !reflect.DeepEqual(&got, cap) {
+		t.Errorf("Expected %+v, got %+v", cap, got)
+	}
+
+	// Generated messages are carried as is
+	var pc capabilityv1.Capability
+	if err := msg.DecodePayload(&pc); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if pc.GetInteraction() != capabilityv1.InteractionType_INTERACTION_TYPE_STREAM {
+		t.Errorf("Expected INTERACTION_TYPE_STREAM, got %v", pc.GetInteraction())
+	}
+
+	bridge := &MCPBridge{
+		ID:             "pb-bridge",
+		Endpoint:       "mcp://pb/v1",
+		Protocol:       "MCP/1.0",
+		DataTypes:      []string{"records"},
+		Metadata:       map[string]string{"owner": "ops"},
+		LastUpdated:    time.Unix(1700000000, 0).UTC(),
+		AllowedClients: []string{"agent-1"},
+	}
+	if err := msg.SetPayload(bridge, EncodingProtobuf); err != nil {
+		t.Fatalf("SetPayload() error = %v", err)
+	}
+	var gotBridge MCPBridge
+	if err := msg.DecodePayload(&gotBridge); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	if gotBridge.ID != bridge.ID || !gotBridge.LastUpdated.Equal(bridge.LastUpdated) ||
+		!reflect.DeepEqual(gotBridge.AllowedClients, bridge.AllowedClients) {
+		t.Errorf("Expected %+v, got %+v", bridge, &gotBridge)
+	}
+
+	// Types without a protobuf definition are rejected
+	if err := msg.SetPayload(&QueryPayload{CapabilityType: "DISCOVER"}, EncodingProtobuf); err == nil {
+		t.Error("Expected error encoding QueryPayload as protobuf")
+	}
+}
+
+func TestHandlerProtobufPayloads(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	send := func(typ MessageType, v interface{}) *Message {
+		t.Helper()
+
+		msg := &Message{Version: V2, Type: typ, Timestamp: time.Now()}
+		if err := msg.SetPayload(v, EncodingProtobuf); err != nil {
+			t.Fatalf("SetPayload() error = %v", err)
+		}
+		response, err := handler.HandleMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		if response.Type == Error {
+			t.Fatalf("%v failed: %s", typ, response.Payload)
+		}
+		return response
+	}
+
+	send(Register, &Capability{ID: "pb-cap", Type: "DISCOVER", Version: "1.0.0"})
+	send(MCPBridgeAdvertise, &MCPBridge{ID: "pb-bridge", Endpoint: "mcp://pb/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata()})
+
+	if handler.CapabilityCount() != 1 {
+		t.Errorf("Expected 1 capability, got %d", handler.CapabilityCount())
+	}
+	if bridges := handler.ListMCPBridges(); len(bridges) != 1 || bridges[0].Endpoint != "mcp://pb/v1" {
+		t.Errorf("Expected pb-bridge to be registered, got %+v", bridges)
+	}
+}
+
+func BenchmarkPayloadEncoding(b *testing.B) {
+	cap := &Capability{
+		ID:          "bench-cap",
+		Name:        "Benchmark capability",
+		Type:        "DISCOVER",
+		Version:     "1.0.0",
+		Interaction: Discover,
+		Metadata:    map[string]string{"region": "eu-west-1", "model": "large", "owner": "platform"},
+		MCPEnabled:  true,
+		TTL:         time.Minute,
+	}
+
+	for _, enc := range []Encoding{EncodingJSON, EncodingGob, EncodingProtobuf} {
+		name := map[Encoding]string{EncodingJSON: "json", EncodingGob: "gob", EncodingProtobuf: "protobuf"}[enc]
+
+		b.Run(fmt.Sprintf("%s/marshal", name), func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := encodePayload(enc, cap)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/payload")
+		})
+
+		b.Run(fmt.Sprintf("%s/unmarshal", name), func(b *testing.B) {
+			data, err := encodePayload(enc, cap)
+			if err != nil {
+				b.Fatal(err)
+			}
+			msg := &Message{Payload: data, Encoding: enc}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var got Capability
+				if err := msg.DecodePayload(&got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCapabilityFromProto(t *testing.T) {
+	valid := func() *capabilityv1.Capability {
+		return &capabilityv1.Capability{
+			Id:          "pb-cap",
+			Version:     "1.0.0",
+			Interaction: capabilityv1.InteractionType_INTERACTION_TYPE_DELEGATE,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(p *capabilityv1.Capability)
+		wantErr bool
+	}{
+		{"valid", func(p *capabilityv1.Capability) {}, false},
+		{"unspecified interaction", func(p *capabilityv1.Capability) { p.Interaction = 0 }, false},
+		{"missing ID", func(p *capabilityv1.Capability) { p.Id = "" }, true},
+		{"bad version", func(p *capabilityv1.Capability) { p.Version = "one" }, true},
+		{"unknown interaction", func(p *capabilityv1.Capability) { p.Interaction = 42 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.edit(p)
+
+			c, err := CapabilityFromProto(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CapabilityFromProto() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.ToProto().GetInteraction() != p.GetInteraction() {
+				t.Errorf("Interaction %v did not survive the round trip, got %v", p.GetInteraction(), c.Interaction)
+			}
+		})
+	}
+
+	if _, err := CapabilityFromProto(nil); err == nil {
+		t.Error("Expected a nil capability to fail")
+	}
+}
+
+func FuzzCapabilityProtoRoundTrip(f *testing.F) {
+	f.Add("pb-cap", "Protobuf capability", "STREAM", "1.2.0", uint8(Stream), "region", "eu", true, int64(90*time.Second), "base-cap")
+	f.Add("x", "", "", "", uint8(0), "", "", false, int64(0), "")
+	f.Add("", "unnamed", "DISCOVER", "2.0.0-rc.1", uint8(Delegate+1), "k", "", false, int64(-time.Second), "dep")
+
+	f.Fuzz(func(t *testing.T, id, name, capType, version string, interaction uint8, key, value string, mcp bool, ttl int64, dep string) {
+		c := &Capability{
+			ID:          id,
+			Name:        name,
+			Type:        capType,
+			Version:     version,
+			Interaction: InteractionType(interaction),
+			MCPEnabled:  mcp,
+			TTL:         time.Duration(ttl),
+		}
+		if key != "" {
+			c.Metadata = map[string]string{key: value}
+		}
+		if dep != "" {
+			c.Dependencies = []string{dep}
+		}
+
+		// Go through the wire so the fuzzer exercises the encoding too
+		data, err := proto.Marshal(c.ToProto())
+		if err != nil {
+			return // proto3 strings must be valid UTF-8
+		}
+		var p capabilityv1.Capability
+		if err := proto.Unmarshal(data, &p); err != nil {
+			t.Fatalf("proto.Unmarshal() error = %v", err)
+		}
+
+		got, err := CapabilityFromProto(&p)
+		wantErr := Validate(c) != nil || interaction > uint8(Delegate)
+		if (err != nil) != wantErr {
+			t.Fatalf("CapabilityFromProto() error = %v, wantErr %v", err, wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(got, c) {
+			t.Errorf("Round trip changed %+v into %+v", c, got)
+		}
+	})
+}
@@ -1,10 +1,18 @@
 package protocol
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
 )
 
 func TestMessageSerialization(t *testing.T) {
@@ -68,7 +76,7 @@ func TestMessageSerialization(t *testing.T) {
 }
 
 func TestCapabilityRegistration(t *testing.T) {
-	handler := NewHandler(nil, nil)
+	handler := NewHandler()
 
 	cap := &Capability{
 		ID:          "test-cap",
@@ -132,9 +140,9 @@ func TestCapabilityRegistration(t *testing.T) {
 
 func TestMCPBridgeIntegration(t *testing.T) {
 	bridgeReceived := make(chan *MCPBridge, 1)
-	handler := NewHandler(nil, func(bridge *MCPBridge) error {
-		bridgeReceived <- bridge
-		return nil
+	handler := NewHandler()
+	handler.Events().Subscribe(events.BridgeAdvertised, func(event interface{}) {
+		bridgeReceived <- event.(*MCPBridge)
 	})
 
 	bridge := &MCPBridge{
@@ -145,7 +153,9 @@ func TestMCPBridgeIntegration(t *testing.T) {
 			"test_data",
 		},
 		Metadata: map[string]string{
-			"provider": "TestProvider",
+			"provider":    "TestProvider",
+			"auth_type":   "none",
+			"data_format": "json",
 		},
 		LastUpdated: time.Now(),
 	}
@@ -216,3 +226,980 @@ func TestMCPBridgeIntegration(t *testing.T) {
 		t.Errorf("Expected bridge ID %s, got %s", bridge.ID, responseBridge.ID)
 	}
 }
+
+func TestMCPBridgeDataTypeGlob(t *testing.T) {
+	handler := NewHandler()
+	if err := handler.RegisterMCPBridge(&MCPBridge{
+		ID:        "glob-bridge",
+		Endpoint:  "mcp://glob/v1",
+		Protocol:  "MCP/1.0",
+		Metadata:  map[string]string{"auth_type": "none", "data_format": "json"},
+		DataTypes: []string{"structured.*", "image.png", "log.?", "raw[1]", "bad[*", "image.*"},
+	}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	tests := []struct {
+		dataType    string
+		wantPattern string // Empty when the request is refused
+	}{
+		{"structured.json", "structured.*"},
+		{"structured.csv", "structured.*"},
+		{"image.png", "image.png"}, // Exact entries win over earlier globs
+		{"image.jpeg", "image.*"},
+		{"log.1", "log.?"},
+		{"log.10", ""},
+		{"structured", ""},
+		{"structured.nested/path", ""},
+		{"raw[1]", "raw[1]"}, // Without * or ? an entry is literal
+		{"bad[x", ""},        // Malformed globs never match
+		{"unstructured.json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dataType, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]string{"bridge_id": "glob-bridge", "data_type": tt.dataType})
+			response, err := handler.HandleMessage(context.Background(), &Message{
+				Version:   V1,
+				Type:      MCPBridgeRequest,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if tt.wantPattern == "" {
+				if response.Type != Error {
+					t.Errorf("Expected Error for %s, got %v", tt.dataType, response.Type)
+				}
+				return
+			}
+			if response.Type != MCPBridgeResponse {
+				t.Fatalf("Expected MCPBridgeResponse, got %v: %s", response.Type, response.Payload)
+			}
+
+			var got MCPBridgeResponsePayload
+			if err := json.Unmarshal(response.Payload, &got); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if got.MatchedPattern != tt.wantPattern {
+				t.Errorf("MatchedPattern = %q, want %q", got.MatchedPattern, tt.wantPattern)
+			}
+			if got.MCPBridge == nil || got.ID != "glob-bridge" {
+				t.Errorf("Expected the bridge details in the response, got %+v", got.MCPBridge)
+			}
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	handler := NewHandler()
+
+	tests := []struct {
+		name         string
+		offer        HandshakePayload
+		wantType     MessageType
+		wantVersion  Version
+		wantFeatures []string
+	}{
+		{
+			name: "full overlap",
+			offer: HandshakePayload{
+				MinVersion: V1,
+				MaxVersion: V2,
+				Features:   []string{FeatureCompression, FeatureStreaming},
+			},
+			wantType:     Handshake,
+			wantVersion:  V2,
+			wantFeatures: []string{FeatureCompression, FeatureStreaming},
+		},
+		{
+			name: "v1 only peer",
+			offer: HandshakePayload{
+				MinVersion: V1,
+				MaxVersion: V1,
+				Features:   []string{FeatureAuth, "telepathy"},
+			},
+			wantType:     Handshake,
+			wantVersion:  V1,
+			wantFeatures: []string{FeatureAuth},
+		},
+		{
+			name: "no common version",
+			offer: HandshakePayload{
+				MinVersion: 5,
+				MaxVersion: 7,
+			},
+			wantType: Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(tt.offer)
+			msg := &Message{
+				Version:   V1,
+				Type:      Handshake,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			}
+
+			response, err := handler.HandleMessage(context.Background(), msg)
+			if err != nil {
+				t.Errorf("HandleMessage() error = %v", err)
+				return
+			}
+
+			if response.Type != tt.wantType {
+				t.Errorf("Expected response type %v, got %v", tt.wantType, response.Type)
+				return
+			}
+
+			if tt.wantType != Handshake {
+				return
+			}
+
+			var agreed HandshakePayload
+			if err := json.Unmarshal(response.Payload, &agreed); err != nil {
+				t.Errorf("Failed to unmarshal handshake: %v", err)
+				return
+			}
+
+			if agreed.MaxVersion != tt.wantVersion || response.Version != tt.wantVersion {
+				t.Errorf("Expected version %v, got %v", tt.wantVersion, agreed.MaxVersion)
+			}
+
+			if len(agreed.Features) != len(tt.wantFeatures) {
+				t.Errorf("Expected features %v, got %v", tt.wantFeatures, agreed.Features)
+				return
+			}
+			for i := range agreed.Features {
+				if agreed.Features[i] != tt.wantFeatures[i] {
+					t.Errorf("Expected features %v, got %v", tt.wantFeatures, agreed.Features)
+				}
+			}
+		})
+	}
+}
+
+func TestDeserializeInvalidVersion(t *testing.T) {
+	msg := Message{
+		Version:   Version(9),
+		Type:      Hello,
+		Timestamp: time.Now(),
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	if _, err := Deserialize(data); !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("Expected ErrInvalidVersion, got %v", err)
+	}
+}
+
+func TestCompressedSerialization(t *testing.T) {
+	// Repetitive payload larger than a UDP datagram
+	payload := bytes.Repeat([]byte(`{"capability":"stream","data":"abcdefgh"}`), 4096)
+
+	tests := []struct {
+		name  string
+		codec CompressionCodec
+	}{
+		{name: "gzip", codec: CompressionGzip},
+		{name: "zstd", codec: CompressionZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{
+				Version:          V2,
+				Type:             AIStreamData,
+				Payload:          payload,
+				Timestamp:        time.Now(),
+				Compressed:       true,
+				CompressionCodec: tt.codec,
+			}
+
+			data, err := msg.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+
+			if len(data) >= len(payload) {
+				t.Errorf("Expected compressed frame smaller than %d bytes, got %d", len(payload), len(data))
+			}
+
+			got, err := Deserialize(data)
+			if err != nil {
+				t.Fatalf("Deserialize() error = %v", err)
+			}
+
+			if !got.Compressed || got.CompressionCodec != tt.codec {
+				t.Errorf("Expected codec %v, got compressed=%v codec=%v", tt.codec, got.Compressed, got.CompressionCodec)
+			}
+
+			if !bytes.Equal(got.Payload, payload) {
+				t.Errorf("Payload mismatch after round trip: got %d bytes, want %d", len(got.Payload), len(payload))
+			}
+		})
+	}
+}
+
+func TestCompressionRequiresV2(t *testing.T) {
+	msg := Message{
+		Version:    V1,
+		Type:       Hello,
+		Payload:    []byte("test payload"),
+		Timestamp:  time.Now(),
+		Compressed: true,
+	}
+
+	if _, err := msg.Serialize(); err == nil {
+		t.Error("Expected error compressing a V1 message")
+	}
+}
+
+func TestMessageSignature(t *testing.T) {
+	key := []byte("shared-secret")
+
+	msg := &Message{
+		Version:    V2,
+		Type:       Register,
+		Payload:    []byte(`{"id":"signed-cap"}`),
+		Timestamp:  time.Now(),
+		Compressed: true,
+	}
+	if err := msg.Sign(key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// Signature survives the wire round trip
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if err := got.Verify(key); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	// Wrong key
+	if err := got.Verify([]byte("other-secret")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for wrong key, got %v", err)
+	}
+
+	// Tampered payload
+	got.Payload[2] ^= 0xff
+	if err := got.Verify(key); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for tampered payload, got %v", err)
+	}
+
+	// V1 messages cannot be signed
+	v1 := &Message{Version: V1, Type: Hello, Timestamp: time.Now()}
+	if err := v1.Sign(key); err == nil {
+		t.Error("Expected error signing a V1 message")
+	}
+}
+
+func TestHandlerSharedSecret(t *testing.T) {
+	key := []byte("shared-secret")
+	handler := NewHandler()
+	handler.SetSharedSecret(key)
+
+	// Unsigned messages are rejected
+	msg := &Message{Version: V2, Type: Hello, Timestamp: time.Now()}
+	response, err := handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	if response.Type != Error {
+		t.Fatalf("Expected Error response, got %v", response.Type)
+	}
+	var payload ErrorPayload
+	if err := json.Unmarshal(response.Payload, &payload); err != nil {
+		t.Fatalf("Failed to unmarshal error: %v", err)
+	}
+	if payload.Code != ErrInvalidCredentials {
+		t.Errorf("Expected code %d, got %d", ErrInvalidCredentials, payload.Code)
+	}
+
+	// Signed messages are answered with signed responses
+	if err := msg.Sign(key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	response, err = handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Hello {
+		t.Errorf("Expected Hello response, got %v", response.Type)
+	}
+	if err := response.Verify(key); err != nil {
+		t.Errorf("Response Verify() error = %v", err)
+	}
+}
+
+func TestQueryVersionRange(t *testing.T) {
+	handler := NewHandler()
+
+	for _, cap := range []*Capability{
+		{ID: "search-v1", Type: "DISCOVER", Version: "1.0"},
+		{ID: "search-v1.5", Type: "DISCOVER", Version: "v1.5.2"},
+		{ID: "search-v2", Type: "DISCOVER", Version: "2.0.0"},
+		{ID: "search-beta", Type: "DISCOVER", Version: "2.1.0-beta"},
+		{ID: "search-unversioned", Type: "DISCOVER"},
+	} {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		min, max string
+		wantType MessageType
+		wantIDs  []string
+	}{
+		{name: "no bounds", wantType: Response, wantIDs: []string{"search-v1", "search-v1.5", "search-v2", "search-beta", "search-unversioned"}},
+		{name: "lower bound", min: "1.5", wantType: Response, wantIDs: []string{"search-v1.5", "search-v2", "search-beta"}},
+		{name: "upper bound", max: "1.9.9", wantType: Response, wantIDs: []string{"search-v1", "search-v1.5"}},
+		{name: "exact", min: "2.0.0", max: "2.0.0", wantType: Response, wantIDs: []string{"search-v2"}},
+		{name: "prerelease below release", min: "2.1.0", wantType: Response, wantIDs: []string{}},
+		{name: "malformed bound", min: "latest", wantType: Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(QueryPayload{
+				CapabilityType: "DISCOVER",
+				MinVersion:     tt.min,
+				MaxVersion:     tt.max,
+			})
+
+			response, err := handler.HandleMessage(context.Background(), &Message{
+				Version:   V1,
+				Type:      Query,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if response.Type != tt.wantType {
+				t.Fatalf("Expected response type %v, got %v", tt.wantType, response.Type)
+			}
+			if tt.wantType == Error {
+				var errPayload ErrorPayload
+				json.Unmarshal(response.Payload, &errPayload)
+				if errPayload.Code != ErrInvalidCapabilityFormat {
+					t.Errorf("Expected code %d, got %d", ErrInvalidCapabilityFormat, errPayload.Code)
+				}
+				return
+			}
+
+			var matches []*Capability
+			if err := json.Unmarshal(response.Payload, &matches); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			got := make(map[string]bool)
+			for _, m := range matches {
+				got[m.ID] = true
+			}
+			if len(got) != len(tt.wantIDs) {
+				t.Errorf("Expected %v, got %v", tt.wantIDs, got)
+			}
+			for _, id := range tt.wantIDs {
+				if !got[id] {
+					t.Errorf("Expected %s in results %v", id, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterMalformedVersion(t *testing.T) {
+	handler := NewHandler()
+
+	err := handler.RegisterCapability(&Capability{ID: "bad", Type: "DISCOVER", Version: "one point oh"})
+	if !errors.Is(err, ErrInvalidCapabilityFormat) {
+		t.Errorf("Expected ErrInvalidCapabilityFormat, got %v", err)
+	}
+}
+
+func TestQueryByInteraction(t *testing.T) {
+	handler := NewHandler()
+
+	for _, cap := range []*Capability{
+		{ID: "nlp-discover", Type: "NLP", Interaction: Discover},
+		{ID: "nlp-negotiate", Type: "NLP", Interaction: Negotiate},
+		{ID: "nlp-stream", Type: "NLP", Interaction: Stream},
+		{ID: "nlp-stream-mcp", Type: "NLP", Interaction: Stream, MCPEnabled: true},
+		{ID: "nlp-delegate", Type: "NLP", Interaction: Delegate},
+		{ID: "vision-stream", Type: "VISION", Interaction: Stream},
+	} {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   QueryPayload
+		wantIDs []string
+	}{
+		{name: "any interaction", query: QueryPayload{CapabilityType: "NLP"}, wantIDs: []string{"nlp-discover", "nlp-negotiate", "nlp-stream", "nlp-stream-mcp", "nlp-delegate"}},
+		{name: "discover", query: QueryPayload{CapabilityType: "NLP", Interaction: Discover}, wantIDs: []string{"nlp-discover"}},
+		{name: "negotiate", query: QueryPayload{CapabilityType: "NLP", Interaction: Negotiate}, wantIDs: []string{"nlp-negotiate"}},
+		{name: "stream", query: QueryPayload{CapabilityType: "NLP", Interaction: Stream}, wantIDs: []string{"nlp-stream", "nlp-stream-mcp"}},
+		{name: "delegate", query: QueryPayload{CapabilityType: "NLP", Interaction: Delegate}, wantIDs: []string{"nlp-delegate"}},
+		{name: "stream with mcp", query: QueryPayload{CapabilityType: "NLP", Interaction: Stream, MCPEnabled: true}, wantIDs: []string{"nlp-stream-mcp"}},
+		{name: "other type", query: QueryPayload{CapabilityType: "VISION", Interaction: Stream}, wantIDs: []string{"vision-stream"}},
+		{name: "no match", query: QueryPayload{CapabilityType: "VISION", Interaction: Delegate}, wantIDs: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(tt.query)
+			response, err := handler.HandleMessage(context.Background(), &Message{
+				Version:   V1,
+				Type:      Query,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			var matches []*Capability
+			if err := json.Unmarshal(response.Payload, &matches); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			got := make(map[string]bool)
+			for _, m := range matches {
+				got[m.ID] = true
+			}
+			if len(got) != len(tt.wantIDs) {
+				t.Errorf("Expected %v, got %v", tt.wantIDs, got)
+			}
+			for _, id := range tt.wantIDs {
+				if !got[id] {
+					t.Errorf("Expected %s in results %v", id, got)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMetadataFilter(t *testing.T) {
+	handler := NewHandler()
+
+	for _, cap := range []*Capability{
+		{ID: "nlp-eu", Type: "NLP", Metadata: map[string]string{"region": "eu-west-1", "model": "Large-v2"}},
+		{ID: "nlp-us", Type: "NLP", Metadata: map[string]string{"region": "us-east-1", "model": "large-v1"}},
+		{ID: "nlp-eu-small", Type: "NLP", Metadata: map[string]string{"region": "EU-central-1", "model": "small"}},
+		{ID: "nlp-bare", Type: "NLP"},
+		{ID: "vision-eu", Type: "VISION", Metadata: map[string]string{"region": "eu-west-1"}},
+	} {
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filter  map[string]string
+		wantIDs []string
+	}{
+		{name: "no filter", filter: nil, wantIDs: []string{"nlp-eu", "nlp-us", "nlp-eu-small", "nlp-bare"}},
+		{name: "substring", filter: map[string]string{"region": "eu-"}, wantIDs: []string{"nlp-eu", "nlp-eu-small"}},
+		{name: "case-insensitive", filter: map[string]string{"model": "LARGE"}, wantIDs: []string{"nlp-eu", "nlp-us"}},
+		{name: "every pair", filter: map[string]string{"region": "eu", "model": "large"}, wantIDs: []string{"nlp-eu"}},
+		{name: "exact value", filter: map[string]string{"region": "us-east-1"}, wantIDs: []string{"nlp-us"}},
+		{name: "empty value needs the key", filter: map[string]string{"model": ""}, wantIDs: []string{"nlp-eu", "nlp-us", "nlp-eu-small"}},
+		{name: "missing key", filter: map[string]string{"owner": "platform"}, wantIDs: []string{}},
+		{name: "no match", filter: map[string]string{"region": "ap-"}, wantIDs: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(QueryPayload{CapabilityType: "NLP", MetadataFilter: tt.filter})
+			response, err := handler.HandleMessage(context.Background(), &Message{
+				Version:   V1,
+				Type:      Query,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			var matches []*Capability
+			if err := json.Unmarshal(response.Payload, &matches); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			got := make(map[string]bool)
+			for _, m := range matches {
+				got[m.ID] = true
+			}
+			if len(got) != len(tt.wantIDs) {
+				t.Errorf("Expected %v, got %v", tt.wantIDs, got)
+			}
+			for _, id := range tt.wantIDs {
+				if !got[id] {
+					t.Errorf("Expected %s in results %v", id, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageReceivedGetsClone(t *testing.T) {
+	handler := NewHandler()
+
+	// A subscriber scribbling over the message must not change what is handled
+	handler.Events().Subscribe(events.MessageReceived, func(event interface{}) {
+		msg := event.(*Message)
+		for i := range msg.Payload {
+			msg.Payload[i] = ' '
+		}
+	})
+
+	payload, _ := json.Marshal(&Capability{ID: "cloned", Type: "NLP"})
+	msg := &Message{Version: V1, Type: Register, Payload: payload, Timestamp: time.Now()}
+	response, err := handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Response {
+		t.Fatalf("Expected Response, got %v: %s", response.Type, response.Payload)
+	}
+	if handler.CapabilityCount() != 1 {
+		t.Errorf("Expected the capability to be registered, got %d", handler.CapabilityCount())
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("Expected the caller's payload to be untouched, got %s", msg.Payload)
+	}
+}
+
+func TestHandlerLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	handler := NewHandler(WithLogger(logger))
+	defer handler.Close()
+
+	peer := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4242}
+	ctx := ContextWithPeerAddr(context.Background(), peer)
+
+	msg := &Message{
+		Version:   V1,
+		Type:      Hello,
+		Payload:   []byte("hello"),
+		Timestamp: time.Now(),
+	}
+	if _, err := handler.HandleMessage(ctx, msg); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse log entry %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "DEBUG" {
+		t.Errorf("Expected DEBUG level, got %v", entry["level"])
+	}
+	if entry["peer"] != peer.String() {
+		t.Errorf("Expected peer %s, got %v", peer, entry["peer"])
+	}
+	if entry["payload_size"] != float64(len(msg.Payload)) {
+		t.Errorf("Expected payload_size %d, got %v", len(msg.Payload), entry["payload_size"])
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Error("Expected latency to be logged")
+	}
+
+	// Failures are logged at error level
+	handler.Use(func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		return nil, errors.New("rejected")
+	})
+	buf.Reset()
+	if _, err := handler.HandleMessage(ctx, &Message{Version: V1, Type: MessageType(255)}); err == nil {
+		t.Fatal("Expected error from middleware")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"level":"ERROR"`)) {
+		t.Errorf("Expected ERROR log entry, got %q", buf.String())
+	}
+}
+
+func TestPrioritySerialization(t *testing.T) {
+	msg := &Message{
+		Version:   V2,
+		Type:      Query,
+		Priority:  PriorityHigh,
+		Timestamp: time.Now(),
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if decoded.Priority != PriorityHigh {
+		t.Errorf("Expected PriorityHigh, got %v", decoded.Priority)
+	}
+
+	msg.Version = V1
+	if _, err := msg.Serialize(); err == nil {
+		t.Error("Expected error serializing a priority at V1")
+	}
+
+	// Errors are critical whatever the sender asked for
+	errMsg := &Message{Type: Error}
+	if p := errMsg.EffectivePriority(); p != PriorityCritical {
+		t.Errorf("Expected Error to be critical, got %v", p)
+	}
+}
+
+func TestAICapabilityAdvertise(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	broadcaster := &recordingBroadcaster{}
+	handler.SetBroadcaster(broadcaster)
+
+	advertise := func(cap *Capability) *Message {
+		t.Helper()
+
+		payload, _ := json.Marshal(cap)
+		response, err := handler.HandleMessage(context.Background(), &Message{
+			Version:   V1,
+			Type:      AICapabilityAdvertise,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	cap := &Capability{ID: "advertised", Type: "DISCOVER", Version: "1.0.0"}
+	if response := advertise(cap); response.Type != Response {
+		t.Fatalf("Expected Response, got %v", response.Type)
+	}
+
+	pushed := broadcaster.received()
+	if len(pushed) != 1 || pushed[0].Type != AICapabilityAdvertise {
+		t.Fatalf("Expected one AICapabilityAdvertise broadcast, got %v", pushed)
+	}
+	var got Capability
+	if err := json.Unmarshal(pushed[0].Payload, &got); err != nil {
+		t.Fatalf("Failed to unmarshal broadcast: %v", err)
+	}
+	if got.ID != cap.ID {
+		t.Errorf("Expected broadcast of %s, got %s", cap.ID, got.ID)
+	}
+
+	// An unchanged re-advertisement is not pushed again
+	advertise(cap)
+	if n := len(broadcaster.received()); n != 1 {
+		t.Errorf("Expected unchanged advertisement to be absorbed, got %d broadcasts", n)
+	}
+
+	// A changed one is
+	advertise(&Capability{ID: "advertised", Type: "DISCOVER", Version: "1.1.0"})
+	if n := len(broadcaster.received()); n != 2 {
+		t.Errorf("Expected changed advertisement to be broadcast, got %d broadcasts", n)
+	}
+}
+
+func TestAICapabilityRequest(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	if err := handler.RegisterCapability(&Capability{ID: "known", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		wantType MessageType
+	}{
+		{"known capability", "known", Response},
+		{"unknown capability", "missing", Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(CapabilityRequestPayload{CapabilityID: tt.id})
+			response, err := handler.HandleMessage(context.Background(), &Message{
+				Version:   V1,
+				Type:      AICapabilityRequest,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if response.Type != tt.wantType {
+				t.Fatalf("Expected %v, got %v", tt.wantType, response.Type)
+			}
+
+			if tt.wantType == Error {
+				var errPayload ErrorPayload
+				json.Unmarshal(response.Payload, &errPayload)
+				if errPayload.Code != ErrCapabilityNotFound {
+					t.Errorf("Expected ErrCapabilityNotFound, got %v", errPayload.Code)
+				}
+				return
+			}
+
+			var cap Capability
+			if err := json.Unmarshal(response.Payload, &cap); err != nil {
+				t.Fatalf("Failed to unmarshal capability: %v", err)
+			}
+			if cap.ID != tt.id {
+				t.Errorf("Expected capability %s, got %s", tt.id, cap.ID)
+			}
+		})
+	}
+}
+
+func TestDeregisterCapability(t *testing.T) {
+	var removed []string
+	handler := NewHandler(WithCapabilityRemoved(func(cap *Capability) {
+		removed = append(removed, cap.ID)
+	}))
+	defer handler.Close()
+
+	if err := handler.RegisterCapability(&Capability{ID: "local", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.DeregisterCapability("local"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+	if handler.CapabilityCount() != 0 {
+		t.Errorf("Expected no capabilities, got %d", handler.CapabilityCount())
+	}
+	if len(removed) != 1 || removed[0] != "local" {
+		t.Errorf("Expected removal callback for local, got %v", removed)
+	}
+
+	if err := handler.DeregisterCapability("local"); !errors.Is(err, ErrCapabilityNotFound) {
+		t.Errorf("Expected ErrCapabilityNotFound, got %v", err)
+	}
+}
+
+func TestUnregisterMessage(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	peer := func(ip string) context.Context {
+		return ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000})
+	}
+	send := func(ctx context.Context, msgType MessageType, v interface{}) *Message {
+		t.Helper()
+
+		payload, _ := json.Marshal(v)
+		response, err := handler.HandleMessage(ctx, &Message{
+			Version:   V1,
+			Type:      msgType,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	owner := peer("192.0.2.1")
+	if response := send(owner, Register, &Capability{ID: "owned", Type: "DISCOVER"}); response.Type != Response {
+		t.Fatalf("Register failed: %s", response.Payload)
+	}
+	if err := handler.RegisterCapability(&Capability{ID: "local", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		id       string
+		wantCode ErrorCode
+	}{
+		{"other peer", peer("192.0.2.2"), "owned", ErrForbidden},
+		{"local capability", owner, "local", ErrForbidden},
+		{"unknown capability", owner, "missing", ErrCapabilityNotFound},
+		{"owner", owner, "owned", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := send(tt.ctx, Unregister, &UnregisterPayload{CapabilityID: tt.id})
+
+			if tt.wantCode == 0 {
+				if response.Type != Response {
+					t.Fatalf("Expected Response, got %v: %s", response.Type, response.Payload)
+				}
+				return
+			}
+
+			var payload ErrorPayload
+			json.Unmarshal(response.Payload, &payload)
+			if response.Type != Error || payload.Code != tt.wantCode {
+				t.Errorf("Expected %v, got %v %+v", tt.wantCode, response.Type, payload)
+			}
+		})
+	}
+
+	if handler.CapabilityCount() != 1 {
+		t.Errorf("Expected only the local capability to remain, got %d", handler.CapabilityCount())
+	}
+}
+
+func TestDeregisterPeerCapabilities(t *testing.T) {
+	var removed []string
+	handler := NewHandler(WithCapabilityRemoved(func(cap *Capability) {
+		removed = append(removed, cap.ID)
+	}))
+	defer handler.Close()
+
+	peer := func(id string) context.Context {
+		ctx := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000})
+		return ContextWithPeerID(ctx, id)
+	}
+	register := func(ctx context.Context, cap *Capability) {
+		t.Helper()
+
+		payload, _ := json.Marshal(cap)
+		response, err := handler.HandleMessage(ctx, &Message{Version: V1, Type: Register, Payload: payload, Timestamp: time.Now()})
+		if err != nil || response.Type != Response {
+			t.Fatalf("Register %s failed: %v %v", cap.ID, response, err)
+		}
+	}
+
+	register(peer("agent-1"), &Capability{ID: "b-cap", Type: "DISCOVER"})
+	register(peer("agent-1"), &Capability{ID: "a-cap", Type: "DISCOVER"})
+	register(peer("agent-1"), &Capability{ID: "waiting", Type: "DISCOVER", Dependencies: []string{"missing"}})
+	register(peer("agent-2"), &Capability{ID: "other", Type: "DISCOVER"})
+	if err := handler.RegisterCapability(&Capability{ID: "local", Type: "DISCOVER"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	if got := handler.DeregisterPeerCapabilities(peer("agent-1")); !reflect.DeepEqual(got, []string{"a-cap", "b-cap", "waiting"}) {
+		t.Errorf("DeregisterPeerCapabilities() = %v, want [a-cap b-cap waiting]", got)
+	}
+	sort.Strings(removed)
+	if !reflect.DeepEqual(removed, []string{"a-cap", "b-cap"}) {
+		t.Errorf("Expected removal callbacks for a-cap and b-cap, got %v", removed)
+	}
+	if handler.CapabilityCount() != 2 {
+		t.Errorf("Expected other and local to remain, got %d capabilities", handler.CapabilityCount())
+	}
+
+	// Local calls own nothing
+	if got := handler.DeregisterPeerCapabilities(context.Background()); got != nil {
+		t.Errorf("DeregisterPeerCapabilities() without a peer = %v, want nil", got)
+	}
+}
+
+func TestPing(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	ping := &Message{Version: V2, Type: Ping, CorrelationID: [16]byte{7}, Timestamp: time.Now()}
+	response, err := handler.HandleMessage(context.Background(), ping)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Pong || response.CorrelationID != ping.CorrelationID {
+		t.Errorf("Expected a Pong echoing the correlation ID, got %v %x", response.Type, response.CorrelationID)
+	}
+}
+
+func TestListCapabilitiesAndBridges(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	for _, id := range []string{"b-cap", "a-cap"} {
+		if err := handler.RegisterCapability(&Capability{ID: id, Type: "DISCOVER", Metadata: map[string]string{"k": "v"}}); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+	if err := handler.RegisterMCPBridge(&MCPBridge{ID: "bridge", Endpoint: "mcp://data/v1", DataTypes: []string{"records"}}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	caps := handler.ListCapabilities()
+	bridges := handler.ListMCPBridges()
+
+	if len(caps) != 2 || caps[0].ID != "a-cap" || caps[1].ID != "b-cap" {
+		t.Fatalf("Expected [a-cap b-cap], got %v", caps)
+	}
+	if len(bridges) != 1 || bridges[0].ID != "bridge" {
+		t.Fatalf("Expected [bridge], got %v", bridges)
+	}
+
+	// Registry changes after listing leave the returned slices alone
+	handler.RegisterCapability(&Capability{ID: "c-cap", Type: "DISCOVER"})
+	handler.DeregisterCapability("a-cap")
+	handler.DeregisterMCPBridge("bridge")
+
+	if len(caps) != 2 || caps[0].ID != "a-cap" {
+		t.Errorf("Expected listed capabilities to be unchanged, got %v", caps)
+	}
+	if len(bridges) != 1 || bridges[0].ID != "bridge" {
+		t.Errorf("Expected listed bridges to be unchanged, got %v", bridges)
+	}
+
+	// Changes to the returned values leave the registry alone
+	listed := handler.ListCapabilities()
+	listed[0].Metadata["k"] = "changed"
+	listed[0].Type = "CHANGED"
+	if again := handler.ListCapabilities(); again[0].Type != "DISCOVER" || again[0].Metadata["k"] != "v" {
+		t.Errorf("Expected registry to be unaffected by edits to listed capabilities, got %+v", again[0])
+	}
+}
+
+func TestHandlerEvents(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	var topics []string
+	for _, topic := range []string{events.CapabilityRegistered, events.BridgeAdvertised, events.MessageReceived} {
+		topic := topic
+		handler.Events().Subscribe(topic, func(event interface{}) {
+			topics = append(topics, topic)
+		})
+	}
+
+	register := &Message{Version: V1, Type: Register, Timestamp: time.Now()}
+	if err := register.SetPayload(&Capability{ID: "evented", Type: "DISCOVER", Version: "1.0.0"}, EncodingJSON); err != nil {
+		t.Fatalf("SetPayload() error = %v", err)
+	}
+	if _, err := handler.HandleMessage(context.Background(), register); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if err := handler.RegisterMCPBridge(&MCPBridge{ID: "evented-bridge", Endpoint: "mcp://e/v1"}); err != nil {
+		t.Fatalf("RegisterMCPBridge() error = %v", err)
+	}
+
+	want := []string{events.MessageReceived, events.CapabilityRegistered, events.BridgeAdvertised}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("Expected %v, got %v", want, topics)
+	}
+}
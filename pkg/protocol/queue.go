@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueClosed is returned by a MessageQueue once it is closed and, for
+// Dequeue, drained
+var ErrQueueClosed = errors.New("message queue closed")
+
+// MessageQueue is a bounded FIFO of messages backed by a ring buffer. It
+// sits between a goroutine reading messages off the wire and the code
+// consuming them, and is safe for concurrent use.
+type MessageQueue struct {
+	mu     sync.Mutex
+	buf    []*Message
+	head   int // Index of the oldest message
+	count  int
+	closed bool
+
+	// Closed and replaced whenever a message is added or removed or the
+	// queue closes, waking everyone blocked on the queue
+	changed chan struct{}
+}
+
+// NewMessageQueue creates a queue holding up to capacity messages. A
+// capacity below 1 is treated as 1.
+func NewMessageQueue(capacity int) *MessageQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MessageQueue{
+		buf:     make([]*Message, capacity),
+		changed: make(chan struct{}),
+	}
+}
+
+// Enqueue adds msg to the back of the queue, blocking while it is full. It
+// fails with ctx's error if ctx is done first, or ErrQueueClosed.
+func (q *MessageQueue) Enqueue(ctx context.Context, msg *Message) error {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrQueueClosed
+		}
+		if q.count < len(q.buf) {
+			q.put(msg)
+			q.mu.Unlock()
+			return nil
+		}
+		wait := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// TryEnqueue adds msg without blocking and reports whether there was room.
+// It reports false once the queue is closed.
+func (q *MessageQueue) TryEnqueue(msg *Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.count == len(q.buf) {
+		return false
+	}
+	q.put(msg)
+	return true
+}
+
+// Dequeue removes the oldest message, blocking until there is one. Messages
+// queued before Close are still handed out; after that it fails with
+// ErrQueueClosed, or with ctx's error if ctx is done first.
+func (q *MessageQueue) Dequeue(ctx context.Context) (*Message, error) {
+	for {
+		q.mu.Lock()
+		if q.count > 0 {
+			msg := q.buf[q.head]
+			q.buf[q.head] = nil
+			q.head = (q.head + 1) % len(q.buf)
+			q.count--
+			q.wake()
+			q.mu.Unlock()
+			return msg, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil, ErrQueueClosed
+		}
+		wait := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// Len returns the number of queued messages
+func (q *MessageQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Cap returns the most messages the queue holds
+func (q *MessageQueue) Cap() int {
+	return len(q.buf)
+}
+
+// Close stops the queue accepting messages and wakes every blocked caller.
+// Closing an already closed queue does nothing.
+func (q *MessageQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.wake()
+	}
+}
+
+// put appends msg. Callers must hold q.mu and have checked there is room.
+func (q *MessageQueue) put(msg *Message) {
+	q.buf[(q.head+q.count)%len(q.buf)] = msg
+	q.count++
+	q.wake()
+}
+
+// wake releases every caller waiting for the queue to change. Callers must
+// hold q.mu.
+func (q *MessageQueue) wake() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
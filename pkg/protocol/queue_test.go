@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageQueueFIFO(t *testing.T) {
+	q := NewMessageQueue(3)
+	if q.Cap() != 3 {
+		t.Fatalf("Cap() = %d, want 3", q.Cap())
+	}
+
+	// Cycle through the ring several times so head wraps around
+	ctx := context.Background()
+	next := 0
+	for round := 0; round < 4; round++ {
+		for q.TryEnqueue(&Message{Type: Query, Payload: []byte{byte(next + q.Len())}}) {
+		}
+		if q.Len() != 3 {
+			t.Fatalf("Len() = %d on a full queue, want 3", q.Len())
+		}
+
+		for i := 0; i < 2; i++ {
+			msg, err := q.Dequeue(ctx)
+			if err != nil {
+				t.Fatalf("Dequeue() error = %v", err)
+			}
+			if got := int(msg.Payload[0]); got != next {
+				t.Fatalf("Dequeue() returned message %d, want %d", got, next)
+			}
+			next++
+		}
+	}
+
+	if q := NewMessageQueue(0); q.Cap() != 1 {
+		t.Errorf("NewMessageQueue(0).Cap() = %d, want 1", q.Cap())
+	}
+}
+
+func TestMessageQueueBlocking(t *testing.T) {
+	q := NewMessageQueue(1)
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, &Message{Type: Query}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if q.TryEnqueue(&Message{Type: Query}) {
+		t.Fatal("TryEnqueue() succeeded on a full queue")
+	}
+
+	// A full queue holds Enqueue until a message is taken
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- q.Enqueue(ctx, &Message{Type: Response})
+	}()
+	select {
+	case err := <-enqueued:
+		t.Fatalf("Enqueue() returned %v on a full queue", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if msg, err := q.Dequeue(ctx); err != nil || msg.Type != Query {
+		t.Fatalf("Dequeue() = %v, %v, want the Query", msg, err)
+	}
+	if err := <-enqueued; err != nil {
+		t.Fatalf("Blocked Enqueue() error = %v", err)
+	}
+
+	// An empty queue holds Dequeue until a message arrives
+	if msg, err := q.Dequeue(ctx); err != nil || msg.Type != Response {
+		t.Fatalf("Dequeue() = %v, %v, want the Response", msg, err)
+	}
+	dequeued := make(chan *Message, 1)
+	go func() {
+		msg, _ := q.Dequeue(ctx)
+		dequeued <- msg
+	}()
+	select {
+	case msg := <-dequeued:
+		t.Fatalf("Dequeue() returned %v on an empty queue", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if !q.TryEnqueue(&Message{Type: Hello}) {
+		t.Fatal("TryEnqueue() failed on an empty queue")
+	}
+	if msg := <-dequeued; msg == nil || msg.Type != Hello {
+		t.Errorf("Blocked Dequeue() = %v, want the Hello", msg)
+	}
+}
+
+func TestMessageQueueContext(t *testing.T) {
+	q := NewMessageQueue(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Dequeue() on an empty queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	q.TryEnqueue(&Message{Type: Query})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Enqueue(ctx, &Message{Type: Query}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Enqueue() on a full queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if q.Len() != 1 {
+		t.Errorf("Len() = %d after a cancelled Enqueue, want 1", q.Len())
+	}
+}
+
+func TestMessageQueueClose(t *testing.T) {
+	q := NewMessageQueue(2)
+	ctx := context.Background()
+
+	// Close wakes a blocked Enqueue
+	q.TryEnqueue(&Message{Type: Query})
+	q.TryEnqueue(&Message{Type: Query})
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- q.Enqueue(ctx, &Message{Type: Query})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	q.Close()
+
+	select {
+	case err := <-enqueued:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("Blocked Enqueue() error = %v, want %v", err, ErrQueueClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to wake a blocked Enqueue")
+	}
+	if q.TryEnqueue(&Message{Type: Query}) {
+		t.Error("TryEnqueue() succeeded on a closed queue")
+	}
+
+	// Queued messages are still handed out, then Dequeue reports the close
+	for i := 0; i < 2; i++ {
+		if _, err := q.Dequeue(ctx); err != nil {
+			t.Fatalf("Dequeue() of a queued message error = %v", err)
+		}
+	}
+	if _, err := q.Dequeue(ctx); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Dequeue() on a drained queue error = %v, want %v", err, ErrQueueClosed)
+	}
+
+	// Close also wakes a blocked Dequeue
+	empty := NewMessageQueue(1)
+	dequeued := make(chan error, 1)
+	go func() {
+		_, err := empty.Dequeue(ctx)
+		dequeued <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	empty.Close()
+	select {
+	case err := <-dequeued:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("Blocked Dequeue() error = %v, want %v", err, ErrQueueClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to wake a blocked Dequeue")
+	}
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// RequireRole restricts messages of type t to peers whose proven identity
+// holds one of roles, such as security.RoleAgent for Register. Identities
+// with security.RoleAdmin pass every requirement. Others, including peers
+// that proved no identity, get an ErrForbidden Error. Calling RequireRole
+// without roles lifts the restriction.
+func (h *Handler) RequireRole(t MessageType, roles ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(roles) == 0 {
+		delete(h.roles, t)
+		return
+	}
+	if h.roles == nil {
+		h.roles = make(map[MessageType][]string)
+	}
+	h.roles[t] = slices.Clone(roles)
+}
+
+// RequiredRoles returns the roles set for t by RequireRole, or nil if any
+// peer may send it
+func (h *Handler) RequiredRoles(t MessageType) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return slices.Clone(h.roles[t])
+}
+
+// checkRole reports why the peer behind ctx may not send a message of type
+// t, if it may not
+func (h *Handler) checkRole(ctx context.Context, t MessageType) error {
+	h.mu.RLock()
+	roles := h.roles[t]
+	h.mu.RUnlock()
+
+	if len(roles) == 0 {
+		return nil
+	}
+	identity, ok := security.PeerIdentityFromContext(ctx)
+	if ok {
+		if identity.HasRole(security.RoleAdmin) {
+			return nil
+		}
+		for _, role := range roles {
+			if identity.HasRole(role) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%v requires role %s", t, strings.Join(roles, " or "))
+}
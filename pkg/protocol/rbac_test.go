@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func TestRequireRole(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+	handler.RequireRole(Register, security.RoleAgent)
+	handler.RequireRole(MCPBridgeAdvertise, security.RoleAgent)
+	handler.RequireRole(Query, security.RoleAgent, security.RoleObserver)
+
+	proven := func(roles ...string) context.Context {
+		return security.ContextWithPeerIdentity(context.Background(), &security.PeerIdentity{ID: "peer", Roles: roles})
+	}
+	register := func() *Message {
+		return streamMessage(t, Register, Capability{ID: "cap", Type: "DISCOVER", Version: "1.0.0"})
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		msg       *Message
+		forbidden bool
+	}{
+		{"agent registers", proven(security.RoleAgent), register(), false},
+		{"admin registers", proven(security.RoleAdmin), register(), false},
+		{"observer cannot register", proven(security.RoleObserver), register(), true},
+		{"unproven peer cannot register", ContextWithPeerID(context.Background(), "peer"), register(), true},
+		{"claimed role is not proof", ContextWithPeerID(context.Background(), security.RoleAgent), register(), true},
+		{"observer cannot advertise bridges", proven(security.RoleObserver), streamMessage(t, MCPBridgeAdvertise, MCPBridge{ID: "b"}), true},
+		{"observer queries", proven(security.RoleObserver), streamMessage(t, Query, QueryPayload{CapabilityType: "DISCOVER"}), false},
+		{"role without access", proven("billing"), streamMessage(t, Query, QueryPayload{CapabilityType: "DISCOVER"}), true},
+		{"unrestricted type", context.Background(), streamMessage(t, Hello, nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(tt.ctx, tt.msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			var payload ErrorPayload
+			forbidden := response != nil && response.Type == Error &&
+				json.Unmarshal(response.Payload, &payload) == nil && payload.Code == ErrForbidden
+			if forbidden != tt.forbidden {
+				t.Errorf("HandleMessage() = %v %s, forbidden %v", response.Type, response.Payload, tt.forbidden)
+			}
+		})
+	}
+
+	if got := handler.RequiredRoles(Query); !slices.Equal(got, []string{security.RoleAgent, security.RoleObserver}) {
+		t.Errorf("RequiredRoles(Query) = %v", got)
+	}
+
+	// Requiring no roles lifts the restriction
+	handler.RequireRole(Register)
+	if roles := handler.RequiredRoles(Register); roles != nil {
+		t.Errorf("RequiredRoles(Register) = %v, want nil", roles)
+	}
+	response, err := handler.HandleMessage(context.Background(), streamMessage(t, Register, Capability{ID: "open", Type: "DISCOVER", Version: "1.0.0"}))
+	if err != nil || response.Type != Response {
+		t.Errorf("HandleMessage() after lifting = %v %v, want Response", response, err)
+	}
+}
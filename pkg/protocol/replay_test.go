@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNonceSerialization(t *testing.T) {
+	msg := &Message{
+		Version:   V2,
+		Type:      Hello,
+		Timestamp: time.Now(),
+	}
+	if err := msg.GenerateNonce(); err != nil {
+		t.Fatalf("GenerateNonce() error = %v", err)
+	}
+	if !msg.HasNonce() {
+		t.Fatal("Expected nonce to be set")
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if decoded.Nonce != msg.Nonce {
+		t.Errorf("Nonce mismatch: got %x, want %x", decoded.Nonce, msg.Nonce)
+	}
+
+	// Nonces need the V2 trailer
+	msg.Version = V1
+	if _, err := msg.Serialize(); err == nil {
+		t.Error("Expected error serializing a nonce at V1")
+	}
+}
+
+func TestReplayRejected(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	alice := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000})
+	aliceAgain := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2000})
+	bob := ContextWithPeerAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1000})
+
+	msg := &Message{
+		Version:   V2,
+		Type:      Hello,
+		Timestamp: time.Now(),
+	}
+	if err := msg.GenerateNonce(); err != nil {
+		t.Fatalf("GenerateNonce() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		wantError bool
+	}{
+		{"first delivery", alice, false},
+		{"replay", alice, true},
+		{"replay over new connection", aliceAgain, true},
+		{"same nonce from another sender", bob, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(tt.ctx, msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if got := response.Type == Error; got != tt.wantError {
+				t.Fatalf("Expected error response = %v, got %v", tt.wantError, response.Type)
+			}
+			if tt.wantError {
+				var errPayload ErrorPayload
+				if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+					t.Fatalf("Failed to unmarshal error: %v", err)
+				}
+				if errPayload.Code != ErrInvalidPayload {
+					t.Errorf("Expected ErrInvalidPayload, got %v", errPayload.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	handler := NewHandler(WithReplayWindow(time.Minute))
+	defer handler.Close()
+
+	msg := &Message{
+		Version:   V2,
+		Type:      Hello,
+		Timestamp: time.Now().Add(-2 * time.Minute),
+	}
+	if err := msg.GenerateNonce(); err != nil {
+		t.Fatalf("GenerateNonce() error = %v", err)
+	}
+
+	response, err := handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Error {
+		t.Errorf("Expected stale message to be rejected, got %v", response.Type)
+	}
+
+	// Messages without a nonce are not subject to replay checks
+	msg.Nonce = [16]byte{}
+	response, err = handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Hello {
+		t.Errorf("Expected Hello response, got %v", response.Type)
+	}
+}
+
+func TestNonceCacheEviction(t *testing.T) {
+	cache := newNonceCache(time.Minute, 2)
+	now := time.Now()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if cache.seen(key, now) {
+			t.Fatalf("Expected %s to be new", key)
+		}
+	}
+
+	// "a" was least recently seen and has been evicted
+	if cache.seen("a", now) {
+		t.Error("Expected evicted nonce to be treated as new")
+	}
+	if !cache.seen("c", now) {
+		t.Error("Expected recent nonce to be remembered")
+	}
+
+	// Entries older than the window no longer count as seen
+	if cache.seen("c", now.Add(2*time.Minute)) {
+		t.Error("Expected nonce outside the window to be treated as new")
+	}
+
+	if front := cache.entries.Front().Value.(*nonceEntry); front.key != "c" {
+		t.Error("Expected most recently seen nonce at the front")
+	}
+}
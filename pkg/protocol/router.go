@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+)
+
+// Router dispatches messages to the HandlerFunc registered for their type,
+// much like http.ServeMux does for paths. The zero value is ready to use.
+type Router struct {
+	mu         sync.RWMutex
+	routes     map[MessageType]HandlerFunc
+	middleware []Middleware
+}
+
+// HandleFunc registers fn for messages of type t, replacing any handler
+// already registered for it
+func (r *Router) HandleFunc(t MessageType, fn HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.routes == nil {
+		r.routes = make(map[MessageType]HandlerFunc)
+	}
+	r.routes[t] = fn
+}
+
+// Use appends middleware to the chain. The first middleware added runs outermost.
+// In a Handler the chain runs after signature and replay checks, so it only sees
+// authentic messages.
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middleware = append(r.middleware, mw...)
+}
+
+// ServeMessage runs msg through the middleware and the handler registered
+// for its type. Types without a handler get no response.
+func (r *Router) ServeMessage(ctx context.Context, msg *Message) (*Message, error) {
+	r.mu.RLock()
+	mws := r.middleware
+	r.mu.RUnlock()
+
+	next := r.route
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, inner := mws[i], next
+		next = func(ctx context.Context, msg *Message) (*Message, error) {
+			return mw(ctx, msg, inner)
+		}
+	}
+	return next(ctx, msg)
+}
+
+// route calls the handler for msg's type, looked up after middleware has run
+// in case it rewrote the message
+func (r *Router) route(ctx context.Context, msg *Message) (*Message, error) {
+	r.mu.RLock()
+	fn, ok := r.routes[msg.Type]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+	return fn(ctx, msg)
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	var router Router
+
+	var calls []string
+	router.Use(func(ctx context.Context, msg *Message, next func(context.Context, *Message) (*Message, error)) (*Message, error) {
+		calls = append(calls, "middleware")
+		return next(ctx, msg)
+	})
+	router.HandleFunc(Hello, func(ctx context.Context, msg *Message) (*Message, error) {
+		calls = append(calls, "hello")
+		return &Message{Version: V1, Type: Hello, Timestamp: time.Now()}, nil
+	})
+
+	response, err := router.ServeMessage(context.Background(), &Message{Version: V1, Type: Hello})
+	if err != nil {
+		t.Fatalf("ServeMessage() error = %v", err)
+	}
+	if response == nil || response.Type != Hello {
+		t.Errorf("Expected Hello response, got %v", response)
+	}
+
+	// Unrouted types still pass through middleware but get no response
+	response, err = router.ServeMessage(context.Background(), &Message{Version: V1, Type: Query})
+	if err != nil || response != nil {
+		t.Errorf("Expected no response for unrouted type, got %v, %v", response, err)
+	}
+
+	want := []string{"middleware", "hello", "middleware"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v", want, calls)
+	}
+}
+
+func TestHandlerCustomRoutes(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	// A message type the protocol does not define
+	const Echo MessageType = 200
+	handler.HandleFunc(Echo, func(ctx context.Context, msg *Message) (*Message, error) {
+		return &Message{Version: V1, Type: Response, Payload: msg.Payload, Timestamp: time.Now()}, nil
+	})
+
+	response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: Echo, Payload: []byte(`"ping"`), Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response == nil || string(response.Payload) != `"ping"` {
+		t.Errorf("Expected echoed payload, got %v", response)
+	}
+
+	// Built-in routes can be replaced
+	handler.HandleFunc(Hello, func(ctx context.Context, msg *Message) (*Message, error) {
+		return &Message{Version: V1, Type: Hello, Payload: []byte(`"custom"`), Timestamp: time.Now()}, nil
+	})
+	response, err = handler.HandleMessage(context.Background(), &Message{Version: V1, Type: Hello, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if string(response.Payload) != `"custom"` {
+		t.Errorf("Expected custom Hello response, got %s", response.Payload)
+	}
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaMetadataKey is the Capability.Metadata key that holds a JSON Schema
+// document describing the payloads the capability accepts
+const SchemaMetadataKey = "schema"
+
+// compileSchema compiles the schema in cap's metadata, returning nil if it has none
+func compileSchema(cap *Capability) (*jsonschema.Schema, error) {
+	doc, ok := cap.Metadata[SchemaMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+
+	url := "arn://capabilities/" + cap.ID
+	compiler := jsonschema.NewCompiler()
+	// Schemas arrive from remote peers, so never let them pull in local files or URLs
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not allowed: %s", s)
+	}
+
+	if err := compiler.AddResource(url, strings.NewReader(doc)); err != nil {
+		return nil, fmt.Errorf("%w: malformed schema for capability %s: %v", ErrInvalidCapabilityFormat, cap.ID, err)
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid schema for capability %s: %v", ErrInvalidCapabilityFormat, cap.ID, err)
+	}
+	return schema, nil
+}
+
+// ValidateCapabilityPayload checks a JSON payload against the schema registered
+// with the capability. Capabilities without a schema accept any payload.
+func (h *Handler) ValidateCapabilityPayload(capID string, payload []byte) error {
+	h.mu.RLock()
+	_, ok := h.capabilities[capID]
+	schema := h.schemas[capID]
+	h.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrCapabilityNotFound, capID)
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return fmt.Errorf("%w: payload is not valid JSON: %v", ErrInvalidPayload, err)
+	}
+	if err := schema.Validate(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"prompt": {"type": "string", "minLength": 1},
+		"max_tokens": {"type": "integer", "minimum": 1}
+	},
+	"required": ["prompt"]
+}`
+
+func TestRegisterCapabilitySchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{"valid schema", testSchema, false},
+		{"malformed json", `{"type": `, true},
+		{"invalid keyword value", `{"type": "banana"}`, true},
+		{"external reference", `{"$ref": "file:///etc/passwd"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler()
+			defer handler.Close()
+
+			err := handler.RegisterCapability(&Capability{
+				ID:       "generate",
+				Type:     "DELEGATE",
+				Metadata: map[string]string{SchemaMetadataKey: tt.schema},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RegisterCapability() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCapabilityFormat) {
+				t.Errorf("Expected ErrInvalidCapabilityFormat, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCapabilityPayload(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	if err := handler.RegisterCapability(&Capability{
+		ID:       "generate",
+		Type:     "DELEGATE",
+		Metadata: map[string]string{SchemaMetadataKey: testSchema},
+	}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := handler.RegisterCapability(&Capability{ID: "freeform", Type: "DELEGATE"}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		capID   string
+		payload string
+		wantErr error
+	}{
+		{"valid payload", "generate", `{"prompt": "hi", "max_tokens": 10}`, nil},
+		{"missing required", "generate", `{"max_tokens": 10}`, ErrInvalidPayload},
+		{"wrong type", "generate", `{"prompt": "hi", "max_tokens": "ten"}`, ErrInvalidPayload},
+		{"not json", "generate", `prompt=hi`, ErrInvalidPayload},
+		{"no schema", "freeform", `anything goes`, nil},
+		{"unknown capability", "missing", `{}`, ErrCapabilityNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handler.ValidateCapabilityPayload(tt.capID, []byte(tt.payload))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("ValidateCapabilityPayload() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// canonicalVersion normalises a capability version such as "1.2" or "v1.2.3-beta"
+// to the "vMAJOR.MINOR.PATCH" form understood by the semver package
+func canonicalVersion(v string) (string, error) {
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	if !semver.IsValid(v) {
+		return "", fmt.Errorf("%w: malformed version %q", ErrInvalidCapabilityFormat, strings.TrimPrefix(v, "v"))
+	}
+	return semver.Canonical(v), nil
+}
+
+// CompareVersions compares two SemVer strings, returning -1, 0 or +1
+func CompareVersions(a, b string) (int, error) {
+	ca, err := canonicalVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	cb, err := canonicalVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return semver.Compare(ca, cb), nil
+}
+
+// versionInRange reports whether v lies within [min, max]; empty bounds are open
+func versionInRange(v, min, max string) (bool, error) {
+	if min != "" {
+		cmp, err := CompareVersions(v, min)
+		if err != nil || cmp < 0 {
+			return false, err
+		}
+	}
+	if max != "" {
+		cmp, err := CompareVersions(v, max)
+		if err != nil || cmp > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
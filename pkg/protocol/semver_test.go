@@ -0,0 +1,30 @@
+package protocol
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "1.0", b: "1.0.0", want: 0},
+		{a: "v1.2.3", b: "1.2.4", want: -1},
+		{a: "2.0", b: "1.99.99", want: 1},
+		{a: "1.0.0-alpha", b: "1.0.0", want: -1},
+		{a: "1.0.0+build.5", b: "1.0.0", want: 0},
+		{a: "1.x", b: "1.0", wantErr: true},
+		{a: "1.0", b: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := CompareVersions(tt.a, tt.b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CompareVersions(%q, %q) error = %v, wantErr %v", tt.a, tt.b, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Sign computes an HMAC-SHA256 over the message with key and stores it in Signature.
+// Only V2 messages can carry a signature.
+func (m *Message) Sign(key []byte) error {
+	if m.Version < V2 {
+		return fmt.Errorf("signatures require protocol version %d or later", V2)
+	}
+
+	mac, err := m.mac(key)
+	if err != nil {
+		return err
+	}
+	m.Signature = mac
+	return nil
+}
+
+// Verify checks Signature against key and returns ErrInvalidCredentials on mismatch
+func (m *Message) Verify(key []byte) error {
+	if len(m.Signature) == 0 {
+		return fmt.Errorf("%w: message is not signed", ErrInvalidCredentials)
+	}
+
+	mac, err := m.mac(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, m.Signature) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+// mac hashes the uncompressed, unsigned wire form so the signature
+// survives re-encoding by intermediaries. The hop count and route are left
+// out as forwarding nodes change them.
+func (m *Message) mac(key []byte) ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	unsigned.Compressed = false
+	unsigned.HopCount = 0
+	unsigned.Route = nil
+
+	data, err := unsigned.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message for signing: %w", err)
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,173 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/heathweaver/arn-protocol/pkg/events"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// snapshotMagic opens every snapshot so foreign data is rejected early
+var snapshotMagic = [4]byte{'A', 'R', 'N', 'S'}
+
+// Snapshot format version, bumped when snapshotState changes incompatibly
+const snapshotVersion = 1
+
+// ErrInvalidSnapshot is returned by Restore for data Snapshot did not produce
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// snapshotState is the gob-encoded body of a snapshot
+type snapshotState struct {
+	Capabilities map[string]*Capability
+	Bridges      map[string]*MCPBridge
+
+	// Peers allowed to unregister capabilities and renew bridge leases
+	Owners       map[string]string
+	BridgeOwners map[string]string
+}
+
+// WithMCPBridgeRegistered registers a callback invoked when an MCP bridge is
+// registered or restored from a snapshot
+func WithMCPBridgeRegistered(fn func(*MCPBridge)) Option {
+	return func(h *Handler) {
+		h.onMCPBridge = fn
+	}
+}
+
+// Snapshot serializes every registered capability and bridge, with the peers
+// that own them, so another handler can take over with Restore. The blob is
+// a magic number and format version followed by the gob-encoded registry.
+func (h *Handler) Snapshot() ([]byte, error) {
+	state := snapshotState{
+		Owners:       make(map[string]string),
+		BridgeOwners: make(map[string]string),
+	}
+
+	h.mu.RLock()
+	state.Capabilities = make(map[string]*Capability, len(h.capabilities))
+	for id, cap := range h.capabilities {
+		state.Capabilities[id] = cap.clone()
+	}
+	state.Bridges = make(map[string]*MCPBridge, len(h.mcpBridges))
+	for id, bridge := range h.mcpBridges {
+		state.Bridges[id] = bridge.snapshot()
+	}
+	for id, owner := range h.owners {
+		state.Owners[id] = owner
+	}
+	for id, owner := range h.bridgeOwners {
+		state.BridgeOwners[id] = owner
+	}
+	h.mu.RUnlock()
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	buf.WriteByte(snapshotVersion)
+	if err := gob.NewEncoder(&buf).Encode(&state); err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore merges a snapshot taken with Snapshot into the registry. Entries
+// already registered under the same ID are replaced. Nothing is merged if
+// any entry in the snapshot is invalid.
+func (h *Handler) Restore(data []byte) error {
+	header := len(snapshotMagic) + 1
+	if len(data) < header || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic[:]) {
+		return fmt.Errorf("%w: missing header", ErrInvalidSnapshot)
+	}
+	if v := data[len(snapshotMagic)]; v != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, v)
+	}
+
+	var state snapshotState
+	if err := gob.NewDecoder(bytes.NewReader(data[header:])).Decode(&state); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+
+	// Validate everything up front so a bad entry leaves the registry untouched
+	for id, cap := range state.Capabilities {
+		if cap == nil || cap.ID != id {
+			return fmt.Errorf("%w: capability %q does not match its key", ErrInvalidSnapshot, id)
+		}
+		if err := Validate(cap); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCapabilityFormat, err)
+		}
+		if err := validateDependencies(cap); err != nil {
+			return err
+		}
+	}
+	for id, bridge := range state.Bridges {
+		if bridge == nil || bridge.ID != id {
+			return fmt.Errorf("%w: bridge %q does not match its key", ErrInvalidSnapshot, id)
+		}
+		if err := Validate(bridge); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+		}
+	}
+	schemas := make(map[string]*jsonschema.Schema, len(state.Capabilities))
+	for id, cap := range state.Capabilities {
+		schema, err := compileSchema(cap)
+		if err != nil {
+			return err
+		}
+		schemas[id] = schema
+	}
+
+	h.mu.Lock()
+	for id, cap := range state.Capabilities {
+		delete(h.pending, id)
+		h.putCapability(cap)
+		setOwner(h.owners, id, state.Owners[id])
+		if schemas[id] != nil {
+			h.schemas[id] = schemas[id]
+		} else {
+			delete(h.schemas, id)
+		}
+		h.scheduleExpiry(cap)
+		h.signalReady(id)
+	}
+	for id, bridge := range state.Bridges {
+		h.mcpBridges[id] = bridge
+		h.setBridgeSlots(bridge)
+		setOwner(h.bridgeOwners, id, state.BridgeOwners[id])
+		h.scheduleLease(bridge)
+		if _, ok := h.breakers[id]; !ok {
+			h.breakers[id] = newCircuitBreaker(h.breakerConfig)
+		}
+	}
+	h.metrics.SetBridgeCount(len(h.mcpBridges))
+	callback := h.onMCPBridge
+	h.mu.Unlock()
+
+	h.persist()
+
+	// Published outside the lock so subscribers may call back into the handler
+	for _, cap := range state.Capabilities {
+		h.events.Publish(events.CapabilityRegistered, cap.clone())
+	}
+	for _, bridge := range state.Bridges {
+		h.events.Publish(events.BridgeAdvertised, bridge)
+		if callback != nil {
+			callback(bridge)
+		}
+	}
+
+	// Capabilities held back may have been waiting on restored ones
+	h.resolvePending()
+	return nil
+}
+
+// setOwner records owner for id in owners, clearing it for local entries.
+// Callers must hold h.mu for writing.
+func setOwner(owners map[string]string, id, owner string) {
+	if owner != "" {
+		owners[id] = owner
+	} else {
+		delete(owners, id)
+	}
+}
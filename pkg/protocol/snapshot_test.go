@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	source := NewHandler()
+	defer source.Close()
+
+	caps := []*Capability{
+		{ID: "search", Name: "Search", Type: "DISCOVER", Version: "1.2.0", Metadata: map[string]string{"region": "eu"}},
+		{ID: "summarize", Type: "GENERATE", Version: "2.0.0", MCPEnabled: true, Dependencies: []string{"search"}},
+		{ID: "translate", Type: "GENERATE", Interaction: Stream, TTL: time.Hour},
+	}
+	for _, cap := range caps {
+		if err := source.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+		}
+	}
+	bridges := []*MCPBridge{
+		{ID: "records", Endpoint: "mcp://records/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), DataTypes: []string{"records"}},
+		{ID: "docs", Endpoint: "mcp://docs/v1", Protocol: "MCP/1.1", AllowedClients: []string{"agent-1"}, Lease: time.Minute},
+	}
+	for _, bridge := range bridges {
+		if err := source.RegisterMCPBridge(bridge); err != nil {
+			t.Fatalf("RegisterMCPBridge(%s) error = %v", bridge.ID, err)
+		}
+	}
+
+	// A bridge advertised by a peer keeps its owner across the migration
+	owner := ContextWithPeerID(context.Background(), "bridge-owner")
+	if err := source.registerMCPBridge(&MCPBridge{ID: "remote", Endpoint: "mcp://remote/v1", Protocol: "MCP/1.0", Metadata: mcp10Metadata(), Lease: time.Minute}, source.ownerID(owner)); err != nil {
+		t.Fatalf("registerMCPBridge() error = %v", err)
+	}
+
+	data, err := source.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	var restored []string
+	target := NewHandler(WithMCPBridgeRegistered(func(bridge *MCPBridge) {
+		mu.Lock()
+		defer mu.Unlock()
+		restored = append(restored, bridge.ID)
+	}))
+	defer target.Close()
+
+	local := &Capability{ID: "local", Type: "DISCOVER"}
+	if err := target.RegisterCapability(local); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+	if err := target.Restore(data); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	// Restore merges, so the target's own capability survives
+	want := append(source.ListCapabilities(), local)
+	got := target.ListCapabilities()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d capabilities, got %d", len(want), len(got))
+	}
+	byID := make(map[string]*Capability, len(got))
+	for _, cap := range got {
+		byID[cap.ID] = cap
+	}
+	for _, cap := range want {
+		if !reflect.DeepEqual(byID[cap.ID], cap) {
+			t.Errorf("Capability %s = %+v, want %+v", cap.ID, byID[cap.ID], cap)
+		}
+	}
+
+	wantBridges := source.ListMCPBridges()
+	gotBridges := target.ListMCPBridges()
+	if len(gotBridges) != len(wantBridges) {
+		t.Fatalf("Expected %d bridges, got %d", len(wantBridges), len(gotBridges))
+	}
+	for i, bridge := range wantBridges {
+		if !reflect.DeepEqual(gotBridges[i], bridge) {
+			t.Errorf("Bridge %s = %+v, want %+v", bridge.ID, gotBridges[i], bridge)
+		}
+	}
+
+	mu.Lock()
+	if len(restored) != len(wantBridges) {
+		t.Errorf("Expected onMCPBridge for %d bridges, got %v", len(wantBridges), restored)
+	}
+	mu.Unlock()
+
+	if _, ok := target.BridgeLeaseExpiry("docs"); !ok {
+		t.Error("Expected restored bridge to keep its lease")
+	}
+	if _, err := target.renewMCPBridge("remote", target.ownerID(owner)); err != nil {
+		t.Errorf("Expected owner to renew restored bridge, got %v", err)
+	}
+}
+
+func TestRestoreRejectsInvalidSnapshot(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	valid, err := handler.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	badVersion := append([]byte(nil), valid...)
+	badVersion[len(snapshotMagic)] = snapshotVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", []byte("JSON{}")},
+		{"unsupported version", badVersion},
+		{"truncated body", valid[:len(snapshotMagic)+2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := handler.Restore(tt.data); !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("Restore() error = %v, want ErrInvalidSnapshot", err)
+			}
+		})
+	}
+}
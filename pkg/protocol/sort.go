@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"golang.org/x/mod/semver"
+)
+
+// SortKey selects the order SortCapabilities puts capabilities in
+type SortKey uint8
+
+const (
+	// ByID orders capabilities by ID, the default for query responses
+	ByID SortKey = iota
+	// ByName orders capabilities by Name
+	ByName
+	// ByVersion orders capabilities by SemVer Version, oldest first.
+	// Capabilities without a valid version come last.
+	ByVersion
+	// ByRegistrationTime orders capabilities by RegisteredAt, earliest first
+	ByRegistrationTime
+)
+
+// String returns the constant name of k, such as "ByVersion"
+func (k SortKey) String() string {
+	switch k {
+	case ByID:
+		return "ByID"
+	case ByName:
+		return "ByName"
+	case ByVersion:
+		return "ByVersion"
+	case ByRegistrationTime:
+		return "ByRegistrationTime"
+	default:
+		return fmt.Sprintf("SortKey(%d)", uint8(k))
+	}
+}
+
+// SortCapabilities sorts caps by the given key. Capabilities that compare
+// equal are ordered by ID, so the result does not depend on the order caps
+// came in.
+func SortCapabilities(caps []*Capability, by SortKey) {
+	slices.SortFunc(caps, func(a, b *Capability) int {
+		var c int
+		switch by {
+		case ByName:
+			c = cmp.Compare(a.Name, b.Name)
+		case ByVersion:
+			c = compareCapabilityVersions(a.Version, b.Version)
+		case ByRegistrationTime:
+			c = a.RegisteredAt.Compare(b.RegisteredAt)
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// compareCapabilityVersions compares two SemVer strings, ordering invalid
+// ones after every valid one
+func compareCapabilityVersions(a, b string) int {
+	ca, aerr := canonicalVersion(a)
+	cb, berr := canonicalVersion(b)
+	switch {
+	case aerr != nil && berr != nil:
+		return 0
+	case aerr != nil:
+		return 1
+	case berr != nil:
+		return -1
+	}
+	return semver.Compare(ca, cb)
+}
+
+// SetDefaultSort sets the order of the capabilities in query responses,
+// ByID unless changed
+func (h *Handler) SetDefaultSort(key SortKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.defaultSort = key
+}
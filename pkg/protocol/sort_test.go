@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSortCapabilities(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	caps := []*Capability{
+		{ID: "c", Name: "alpha", Version: "1.10.0", RegisteredAt: base.Add(2 * time.Minute)},
+		{ID: "a", Name: "gamma", Version: "1.2.0", RegisteredAt: base.Add(3 * time.Minute)},
+		{ID: "d", Name: "beta", Version: "", RegisteredAt: base},
+		{ID: "b", Name: "alpha", Version: "2.0.0-beta", RegisteredAt: base.Add(time.Minute)},
+		{ID: "e", Name: "beta", Version: "1.2", RegisteredAt: base},
+	}
+
+	tests := []struct {
+		by   SortKey
+		want []string
+	}{
+		{ByID, []string{"a", "b", "c", "d", "e"}},
+		{ByName, []string{"b", "c", "d", "e", "a"}},
+		{ByVersion, []string{"a", "e", "c", "b", "d"}},
+		{ByRegistrationTime, []string{"d", "e", "b", "c", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by.String(), func(t *testing.T) {
+			sorted := slices.Clone(caps)
+			SortCapabilities(sorted, tt.by)
+			if got := capabilityIDs(sorted); !slices.Equal(got, tt.want) {
+				t.Errorf("SortCapabilities(%v) = %v, want %v", tt.by, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDefaultSort(t *testing.T) {
+	caps := []Capability{
+		{ID: "zeta", Name: "first", Type: "DISCOVER", Version: "3.0.0"},
+		{ID: "alpha", Name: "second", Type: "DISCOVER", Version: "1.0.0"},
+		{ID: "mu", Name: "third", Type: "DISCOVER", Version: "2.0.0"},
+		{ID: "beta", Name: "fourth", Type: "DISCOVER", Version: "1.5.0"},
+		{ID: "omega", Name: "fifth", Type: "DISCOVER", Version: "0.1.0"},
+	}
+
+	tests := []struct {
+		by   SortKey
+		want []string
+	}{
+		{ByID, []string{"alpha", "beta", "mu", "omega", "zeta"}},
+		{ByName, []string{"omega", "zeta", "beta", "alpha", "mu"}},
+		{ByVersion, []string{"omega", "alpha", "beta", "mu", "zeta"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by.String(), func(t *testing.T) {
+			// Fresh handlers filled in a different order give the same response
+			for run := range 20 {
+				handler := NewHandler()
+				handler.SetDefaultSort(tt.by)
+				for _, i := range rand.Perm(len(caps)) {
+					cap := caps[i]
+					if err := handler.RegisterCapability(&cap); err != nil {
+						t.Fatalf("RegisterCapability(%s) error = %v", cap.ID, err)
+					}
+				}
+
+				response, err := handler.HandleMessage(t.Context(), streamMessage(t, Query, QueryPayload{CapabilityType: "DISCOVER"}))
+				handler.Close()
+				if err != nil {
+					t.Fatalf("HandleMessage() error = %v", err)
+				}
+				var got []*Capability
+				if err := json.Unmarshal(response.Payload, &got); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if ids := capabilityIDs(got); !slices.Equal(ids, tt.want) {
+					t.Fatalf("Run %d returned %v, want %v", run, ids, tt.want)
+				}
+			}
+		})
+	}
+
+	// Registration order is kept, and replacing a capability keeps its time
+	handler := NewHandler()
+	defer handler.Close()
+	handler.SetDefaultSort(ByRegistrationTime)
+	for _, id := range []string{"late", "early", "middle"} {
+		if err := handler.RegisterCapability(&Capability{ID: id, Type: "DISCOVER"}); err != nil {
+			t.Fatalf("RegisterCapability(%s) error = %v", id, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := handler.RegisterCapability(&Capability{ID: "late", Type: "DISCOVER", Name: "updated"}); err != nil {
+		t.Fatalf("RegisterCapability(late) error = %v", err)
+	}
+	response, err := handler.HandleMessage(t.Context(), streamMessage(t, Query, QueryPayload{CapabilityType: "DISCOVER"}))
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	var got []*Capability
+	if err := json.Unmarshal(response.Payload, &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if ids := capabilityIDs(got); !slices.Equal(ids, []string{"late", "early", "middle"}) {
+		t.Errorf("ByRegistrationTime returned %v, want [late early middle]", ids)
+	}
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import "errors"
+
+// Store saves and restores the handler's registry across restarts.
+// See pkg/persistence for implementations.
+type Store interface {
+	Save(capabilities []*Capability, bridges []*MCPBridge) error
+	Load() ([]*Capability, []*MCPBridge, error)
+}
+
+// WithStore restores capabilities and bridges from store when the handler is
+// created and saves them back in the background after every change
+func WithStore(store Store) Option {
+	return func(h *Handler) {
+		h.store = store
+	}
+}
+
+// restore loads the saved registry. Bridges are published to
+// events.BridgeAdvertised as if they had just been registered.
+func (h *Handler) restore() {
+	capabilities, bridges, err := h.store.Load()
+	if err != nil {
+		h.logger.Error("Failed to load saved registry", "error", err)
+		return
+	}
+
+	for _, cap := range capabilities {
+		if err := h.storeCapability(cap, registrant{}); err != nil && !errors.Is(err, errDependenciesPending) {
+			h.logger.Warn("Skipping saved capability", "capability", cap.ID, "error", err)
+		}
+	}
+
+	// A capability may be saved before the ones it depends on
+	for ready := h.takeReadyPending(); len(ready) > 0; ready = h.takeReadyPending() {
+		for _, p := range ready {
+			if err := h.storeCapability(p.cap, p.by); err != nil {
+				h.logger.Warn("Skipping saved capability", "capability", p.cap.ID, "error", err)
+			}
+		}
+	}
+	for _, bridge := range bridges {
+		if err := h.RegisterMCPBridge(bridge); err != nil {
+			h.logger.Warn("Skipping saved MCP bridge", "bridge", bridge.ID, "error", err)
+		}
+	}
+}
+
+// persist schedules a save of the registry. Saves are coalesced, so a burst of
+// changes results in a single write of the latest state.
+func (h *Handler) persist() {
+	if h.store == nil {
+		return
+	}
+
+	select {
+	case h.saveWake <- struct{}{}:
+	default:
+	}
+}
+
+// saveLoop writes the registry whenever persist is called, with a final
+// write on Close if a change is still pending
+func (h *Handler) saveLoop() {
+	defer close(h.saved)
+
+	for {
+		select {
+		case <-h.done:
+			select {
+			case <-h.saveWake:
+				h.save()
+			default:
+			}
+			return
+		case <-h.saveWake:
+			h.save()
+		}
+	}
+}
+
+func (h *Handler) save() {
+	h.mu.RLock()
+	capabilities := make([]*Capability, 0, len(h.capabilities))
+	for _, cap := range h.capabilities {
+		capabilities = append(capabilities, cap)
+	}
+	bridges := make([]*MCPBridge, 0, len(h.mcpBridges))
+	for _, bridge := range h.mcpBridges {
+		bridges = append(bridges, bridge.snapshot())
+	}
+	h.mu.RUnlock()
+
+	if err := h.store.Save(capabilities, bridges); err != nil {
+		h.logger.Error("Failed to save registry", "error", err)
+	}
+}
+
+// snapshot copies the bridge so it can be read while its ACL keeps changing
+func (b *MCPBridge) snapshot() *MCPBridge {
+	b.aclMu.RLock()
+	defer b.aclMu.RUnlock()
+
+	var metadata map[string]string
+	if b.Metadata != nil {
+		metadata = make(map[string]string, len(b.Metadata))
+		for k, v := range b.Metadata {
+			metadata[k] = v
+		}
+	}
+
+	return &MCPBridge{
+		ID:             b.ID,
+		Endpoint:       b.Endpoint,
+		Protocol:       b.Protocol,
+		DataTypes:      append([]string(nil), b.DataTypes...),
+		Metadata:       metadata,
+		LastUpdated:    b.LastUpdated,
+		Location:       b.Location,
+		AllowedClients: append([]string(nil), b.AllowedClients...),
+		Lease:          b.Lease,
+
+		MaxConcurrentRequests: b.MaxConcurrentRequests,
+	}
+}
@@ -0,0 +1,372 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of data chunks buffered per stream before senders are turned
+	// away, unless AIStreamStart asks for another QueueDepth
+	streamBufferSize = 64
+
+	// Largest QueueDepth a sender may ask for
+	maxStreamQueueDepth = 4096
+
+	// Codec assumed when AIStreamStart names none
+	defaultStreamCodec = "raw"
+)
+
+// Codecs AIStreamStart accepts
+var (
+	streamCodecsMu sync.RWMutex
+	streamCodecs   = map[string]bool{
+		defaultStreamCodec: true,
+		"json":             true,
+		"protobuf":         true,
+	}
+)
+
+// RegisterStreamCodec lets streams be opened with codec, the name senders
+// put in StreamStartPayload.Codec
+func RegisterStreamCodec(codec string) {
+	streamCodecsMu.Lock()
+	defer streamCodecsMu.Unlock()
+
+	streamCodecs[codec] = true
+}
+
+func streamCodecRegistered(codec string) bool {
+	streamCodecsMu.RLock()
+	defer streamCodecsMu.RUnlock()
+
+	return streamCodecs[codec]
+}
+
+// StreamSession is an open AI-to-AI stream
+type StreamSession struct {
+	ID              string
+	StartedAt       time.Time
+	Codec           string
+	MaxBandwidthBps int64 // zero when the sender set no limit
+
+	queue   *MessageQueue // AIStreamData chunks waiting for the consumer
+	mu      sync.Mutex
+	closed  bool
+	credits uint32 // chunks the sender has been granted but not yet sent
+
+	subscribe sync.Once
+	data      chan []byte // fed from queue once SubscribeStream is called
+}
+
+// StreamStartPayload is the body of an AIStreamStart message. Every field is
+// optional: the server generates a SessionID when none is given, assumes the
+// "raw" codec and buffers 64 chunks.
+type StreamStartPayload struct {
+	SessionID       string `json:"session_id,omitempty"`
+	Codec           string `json:"codec,omitempty"`
+	MaxBandwidthBps int64  `json:"max_bandwidth_bps,omitempty"`
+	QueueDepth      int    `json:"queue_depth,omitempty"`
+}
+
+// StreamStartResponse answers AIStreamStart. When Accepted, SessionID keys
+// the AIStreamData that follows and Credits are the sender's initial
+// credits; otherwise Reason says why the stream was refused.
+type StreamStartResponse struct {
+	SessionID string `json:"session_id"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	Credits   uint32 `json:"credits,omitempty"`
+}
+
+// StreamSessionPayload identifies a stream in AIStreamEnd requests
+type StreamSessionPayload struct {
+	SessionID string `json:"session_id"`
+}
+
+// StreamCreditPayload is the body of an AIStreamCredit message. The receiver
+// grants Credits more AIStreamData messages; a sender asking for credits sends zero.
+type StreamCreditPayload struct {
+	SessionID string `json:"session_id"`
+	Credits   uint32 `json:"credits"`
+}
+
+// StreamDataPayload is the body of an AIStreamData message
+type StreamDataPayload struct {
+	SessionID string `json:"session_id"`
+	Data      []byte `json:"data"`
+}
+
+// push queues a chunk, spending one of the sender's credits. It fails when the
+// sender has no credits left, which means it ignored flow control.
+func (s *StreamSession) push(chunk *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("stream closed")
+	}
+	if s.credits == 0 {
+		return fmt.Errorf("no stream credits")
+	}
+
+	if !s.queue.TryEnqueue(chunk) {
+		return fmt.Errorf("stream buffer full")
+	}
+	s.credits--
+	return nil
+}
+
+// grant hands out credits for buffer space that is neither filled nor
+// already promised to the sender, returning how many were added
+func (s *StreamSession) grant() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0
+	}
+
+	free := s.queue.Cap() - s.queue.Len() - int(s.credits)
+	if free <= 0 {
+		return 0
+	}
+	s.credits += uint32(free)
+	return uint32(free)
+}
+
+// close ends the stream; consumers drain what is queued, then see it closed
+func (s *StreamSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.queue.Close()
+	}
+}
+
+// subscription returns the channel SubscribeStream hands out, starting the
+// goroutine that feeds it from the queue on first use
+func (s *StreamSession) subscription() <-chan []byte {
+	s.subscribe.Do(func() {
+		s.data = make(chan []byte)
+		go s.feed()
+	})
+	return s.data
+}
+
+// feed moves chunks from the queue to the subscription channel until the
+// stream ends and the queue is drained
+func (s *StreamSession) feed() {
+	defer close(s.data)
+
+	for {
+		chunk, err := s.queue.Dequeue(context.Background())
+		if err != nil {
+			return
+		}
+		s.data <- chunk.Payload
+	}
+}
+
+// StreamQueue returns the queue holding a session's data as AIStreamData
+// messages, each with a chunk as its Payload. Dequeue fails with
+// ErrQueueClosed once the sender has ended the stream and the queue is
+// drained. Consume a stream through either StreamQueue or SubscribeStream.
+func (h *Handler) StreamQueue(sessionID string) (*MessageQueue, error) {
+	session, err := h.stream(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return session.queue, nil
+}
+
+// SubscribeStream returns a channel carrying a session's data, fed from its
+// queue. The channel is closed when the sender ends the stream.
+func (h *Handler) SubscribeStream(sessionID string) (<-chan []byte, error) {
+	session, err := h.stream(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return session.subscription(), nil
+}
+
+// stream looks up an open session
+func (h *Handler) stream(sessionID string) (*StreamSession, error) {
+	h.mu.RLock()
+	session, exists := h.streams[sessionID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: stream %s not found", ErrCapabilityNotFound, sessionID)
+	}
+	return session, nil
+}
+
+func (h *Handler) handleAIStreamStart(msg *Message) (*Message, error) {
+	var request StreamStartPayload
+	if len(msg.Payload) > 0 {
+		if err := msg.DecodePayload(&request); err != nil {
+			return NewErrorMessage(ErrInvalidPayload, "invalid stream start format")
+		}
+	}
+
+	if request.Codec == "" {
+		request.Codec = defaultStreamCodec
+	}
+	if request.QueueDepth == 0 {
+		request.QueueDepth = streamBufferSize
+	}
+	if reason := checkStreamStart(&request); reason != "" {
+		return streamStartResponse(StreamStartResponse{SessionID: request.SessionID, Reason: reason})
+	}
+
+	id := request.SessionID
+	if id == "" {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return nil, err
+		}
+	}
+
+	session := &StreamSession{
+		ID:              id,
+		StartedAt:       time.Now(),
+		Codec:           request.Codec,
+		MaxBandwidthBps: request.MaxBandwidthBps,
+		queue:           NewMessageQueue(request.QueueDepth),
+	}
+
+	h.mu.Lock()
+	if _, exists := h.streams[id]; exists {
+		h.mu.Unlock()
+		return streamStartResponse(StreamStartResponse{SessionID: id, Reason: "session ID already in use"})
+	}
+	h.streams[id] = session
+	h.mu.Unlock()
+
+	return streamStartResponse(StreamStartResponse{SessionID: id, Accepted: true, Credits: session.grant()})
+}
+
+// checkStreamStart returns why a stream asking for request must be refused,
+// or "" if it can be opened
+func checkStreamStart(request *StreamStartPayload) string {
+	switch {
+	case !streamCodecRegistered(request.Codec):
+		return fmt.Sprintf("unsupported codec %q", request.Codec)
+	case request.MaxBandwidthBps < 0:
+		return "max bandwidth must not be negative"
+	case request.QueueDepth < 0 || request.QueueDepth > maxStreamQueueDepth:
+		return fmt.Sprintf("queue depth must be between 1 and %d", maxStreamQueueDepth)
+	}
+	return ""
+}
+
+func streamStartResponse(response StreamStartResponse) (*Message, error) {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal stream session")
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (h *Handler) handleAIStreamData(msg *Message) (*Message, error) {
+	var data StreamDataPayload
+	if err := msg.DecodePayload(&data); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid stream data format")
+	}
+
+	h.mu.RLock()
+	session, exists := h.streams[data.SessionID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return NewErrorMessage(ErrCapabilityNotFound, "stream not found")
+	}
+
+	chunk := &Message{Version: msg.Version, Type: AIStreamData, Payload: data.Data, Timestamp: msg.Timestamp}
+	if err := session.push(chunk); err != nil {
+		return NewErrorMessage(ErrCapabilityUnavailable, err.Error())
+	}
+
+	// Pass on whatever space the subscriber has freed since the last grant
+	return streamCreditMessage(session)
+}
+
+// handleAIStreamCredit answers a sender waiting for credits with any that
+// have become available, possibly none
+func (h *Handler) handleAIStreamCredit(msg *Message) (*Message, error) {
+	var request StreamCreditPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid stream credit format")
+	}
+
+	h.mu.RLock()
+	session, exists := h.streams[request.SessionID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return NewErrorMessage(ErrCapabilityNotFound, "stream not found")
+	}
+	return streamCreditMessage(session)
+}
+
+func streamCreditMessage(session *StreamSession) (*Message, error) {
+	payload, err := json.Marshal(StreamCreditPayload{SessionID: session.ID, Credits: session.grant()})
+	if err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "failed to marshal stream credits")
+	}
+
+	return &Message{
+		Version:   V1,
+		Type:      AIStreamCredit,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (h *Handler) handleAIStreamEnd(msg *Message) (*Message, error) {
+	var end StreamSessionPayload
+	if err := msg.DecodePayload(&end); err != nil {
+		return NewErrorMessage(ErrInvalidPayload, "invalid stream end format")
+	}
+
+	h.mu.Lock()
+	session, exists := h.streams[end.SessionID]
+	delete(h.streams, end.SessionID)
+	h.mu.Unlock()
+
+	if !exists {
+		return NewErrorMessage(ErrCapabilityNotFound, "stream not found")
+	}
+	session.close()
+
+	return &Message{
+		Version:   V1,
+		Type:      Response,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// newSessionID returns a random RFC 4122 version 4 UUID
+func newSessionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
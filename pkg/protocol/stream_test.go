@@ -0,0 +1,288 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func startStream(t *testing.T, handler *Handler) string {
+	t.Helper()
+
+	response, err := handler.HandleMessage(context.Background(), &Message{
+		Version:   V1,
+		Type:      AIStreamStart,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Response {
+		t.Fatalf("Expected Response, got %v", response.Type)
+	}
+
+	var session StreamStartResponse
+	if err := json.Unmarshal(response.Payload, &session); err != nil {
+		t.Fatalf("Failed to unmarshal session: %v", err)
+	}
+	if !session.Accepted {
+		t.Fatalf("Stream refused: %s", session.Reason)
+	}
+	return session.SessionID
+}
+
+func streamMessage(t *testing.T, msgType MessageType, payload any) *Message {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to marshal payload: %v", err)
+	}
+	return &Message{
+		Version:   V1,
+		Type:      msgType,
+		Payload:   data,
+		Timestamp: time.Now(),
+	}
+}
+
+func TestStreamLifecycle(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	sessionID := startStream(t, handler)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(sessionID) {
+		t.Errorf("Expected UUID session ID, got %q", sessionID)
+	}
+
+	data, err := handler.SubscribeStream(sessionID)
+	if err != nil {
+		t.Fatalf("SubscribeStream() error = %v", err)
+	}
+
+	chunks := []string{"first", "second", "third"}
+	for _, chunk := range chunks {
+		msg := streamMessage(t, AIStreamData, StreamDataPayload{SessionID: sessionID, Data: []byte(chunk)})
+		response, err := handler.HandleMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		if response.Type != AIStreamCredit {
+			t.Fatalf("Expected AIStreamCredit, got %v", response.Type)
+		}
+	}
+
+	response, err := handler.HandleMessage(context.Background(), streamMessage(t, AIStreamEnd, StreamSessionPayload{SessionID: sessionID}))
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Response {
+		t.Fatalf("Expected Response, got %v", response.Type)
+	}
+
+	var received []string
+	for chunk := range data {
+		received = append(received, string(chunk))
+	}
+	if len(received) != len(chunks) {
+		t.Fatalf("Expected %d chunks, got %d", len(chunks), len(received))
+	}
+	for i, chunk := range chunks {
+		if received[i] != chunk {
+			t.Errorf("Chunk %d: expected %q, got %q", i, chunk, received[i])
+		}
+	}
+
+	// The session is gone once ended
+	if _, err := handler.SubscribeStream(sessionID); err == nil {
+		t.Error("Expected error subscribing to ended stream")
+	}
+}
+
+func TestStreamUnknownSession(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	if _, err := handler.SubscribeStream("missing"); err == nil {
+		t.Error("Expected error subscribing to unknown stream")
+	}
+
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"data", streamMessage(t, AIStreamData, StreamDataPayload{SessionID: "missing", Data: []byte("x")})},
+		{"end", streamMessage(t, AIStreamEnd, StreamSessionPayload{SessionID: "missing"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(context.Background(), tt.msg)
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			var errPayload ErrorPayload
+			if err := json.Unmarshal(response.Payload, &errPayload); err != nil {
+				t.Fatalf("Failed to unmarshal error: %v", err)
+			}
+			if response.Type != Error || errPayload.Code != ErrCapabilityNotFound {
+				t.Errorf("Expected ErrCapabilityNotFound, got %v %v", response.Type, errPayload.Code)
+			}
+		})
+	}
+}
+
+func TestStreamBufferFull(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	sessionID := startStream(t, handler)
+
+	// Nobody drains the stream, so the buffer eventually overflows
+	for i := 0; i < streamBufferSize; i++ {
+		msg := streamMessage(t, AIStreamData, StreamDataPayload{SessionID: sessionID, Data: []byte("x")})
+		if response, _ := handler.HandleMessage(context.Background(), msg); response.Type != AIStreamCredit {
+			t.Fatalf("Chunk %d: expected AIStreamCredit, got %v", i, response.Type)
+		}
+	}
+
+	msg := streamMessage(t, AIStreamData, StreamDataPayload{SessionID: sessionID, Data: []byte("x")})
+	response, err := handler.HandleMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if response.Type != Error {
+		t.Errorf("Expected Error once buffer is full, got %v", response.Type)
+	}
+}
+
+func TestStreamCredits(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: AIStreamStart, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	var session StreamStartResponse
+	if err := json.Unmarshal(response.Payload, &session); err != nil {
+		t.Fatalf("Failed to unmarshal session: %v", err)
+	}
+	if session.Credits != streamBufferSize {
+		t.Fatalf("Expected %d initial credits, got %d", streamBufferSize, session.Credits)
+	}
+
+	grant := func(msg *Message) uint32 {
+		t.Helper()
+
+		response, err := handler.HandleMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		if response.Type != AIStreamCredit {
+			t.Fatalf("Expected AIStreamCredit, got %v: %s", response.Type, response.Payload)
+		}
+		var credit StreamCreditPayload
+		if err := json.Unmarshal(response.Payload, &credit); err != nil {
+			t.Fatalf("Failed to unmarshal credits: %v", err)
+		}
+		return credit.Credits
+	}
+
+	// Spending credits without the subscriber reading earns nothing back
+	for i := 0; i < 10; i++ {
+		if got := grant(streamMessage(t, AIStreamData, StreamDataPayload{SessionID: session.SessionID, Data: []byte("x")})); got != 0 {
+			t.Fatalf("Chunk %d: expected no new credits, got %d", i, got)
+		}
+	}
+
+	// Reading frees buffer space, which the next grant hands back
+	queue, err := handler.StreamQueue(session.SessionID)
+	if err != nil {
+		t.Fatalf("StreamQueue() error = %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := queue.Dequeue(context.Background()); err != nil {
+			t.Fatalf("Dequeue() error = %v", err)
+		}
+	}
+	if got := grant(streamMessage(t, AIStreamCredit, StreamCreditPayload{SessionID: session.SessionID})); got != 4 {
+		t.Errorf("Expected 4 credits after reading 4 chunks, got %d", got)
+	}
+	if got := grant(streamMessage(t, AIStreamCredit, StreamCreditPayload{SessionID: session.SessionID})); got != 0 {
+		t.Errorf("Expected credits to be granted only once, got %d", got)
+	}
+}
+
+func TestStreamStartNegotiation(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	RegisterStreamCodec("opus")
+
+	tests := []struct {
+		name        string
+		start       StreamStartPayload
+		wantID      string
+		wantAccept  bool
+		wantCredits uint32
+	}{
+		{"defaults", StreamStartPayload{}, "", true, streamBufferSize},
+		{"chosen ID", StreamStartPayload{SessionID: "session-1", Codec: "json"}, "session-1", true, streamBufferSize},
+		{"ID in use", StreamStartPayload{SessionID: "session-1"}, "session-1", false, 0},
+		{"registered codec", StreamStartPayload{Codec: "opus", QueueDepth: 8, MaxBandwidthBps: 1 << 20}, "", true, 8},
+		{"unknown codec", StreamStartPayload{Codec: "mp3"}, "", false, 0},
+		{"negative bandwidth", StreamStartPayload{MaxBandwidthBps: -1}, "", false, 0},
+		{"queue too deep", StreamStartPayload{QueueDepth: maxStreamQueueDepth + 1}, "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleMessage(context.Background(), streamMessage(t, AIStreamStart, tt.start))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			var got StreamStartResponse
+			if response.Type != Response || json.Unmarshal(response.Payload, &got) != nil {
+				t.Fatalf("Expected a stream start response, got %v: %s", response.Type, response.Payload)
+			}
+
+			if got.Accepted != tt.wantAccept {
+				t.Fatalf("Accepted = %v (%s), want %v", got.Accepted, got.Reason, tt.wantAccept)
+			}
+			if !got.Accepted && got.Reason == "" {
+				t.Error("Expected a refusal to give a reason")
+			}
+			if tt.wantID != "" && got.SessionID != tt.wantID {
+				t.Errorf("SessionID = %q, want %q", got.SessionID, tt.wantID)
+			}
+			if got.Credits != tt.wantCredits {
+				t.Errorf("Credits = %d, want %d", got.Credits, tt.wantCredits)
+			}
+			if !got.Accepted {
+				return
+			}
+
+			session, err := handler.stream(got.SessionID)
+			if err != nil {
+				t.Fatalf("Accepted stream %q not open: %v", got.SessionID, err)
+			}
+			wantCodec := tt.start.Codec
+			if wantCodec == "" {
+				wantCodec = defaultStreamCodec
+			}
+			if session.Codec != wantCodec || session.MaxBandwidthBps != tt.start.MaxBandwidthBps {
+				t.Errorf("Session = %+v, want codec %s and bandwidth %d", session, wantCodec, tt.start.MaxBandwidthBps)
+			}
+		})
+	}
+
+	response, err := handler.HandleMessage(context.Background(), &Message{Version: V1, Type: AIStreamStart, Payload: []byte("[]"), Timestamp: time.Now()})
+	if err != nil || response.Type != Error {
+		t.Errorf("Expected a malformed start to fail, got %v, %v", response, err)
+	}
+}
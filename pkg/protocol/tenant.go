@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+// Longest tenant ID a peer may give, matching the limit on Capability.Namespace
+const maxTenantIDLength = 64
+
+// validateTenantID rejects tenant IDs that cannot name a namespace
+func validateTenantID(id string) error {
+	if len(id) > maxTenantIDLength {
+		return fmt.Errorf("tenant ID longer than %d bytes", maxTenantIDLength)
+	}
+	if strings.Contains(id, "/") {
+		return fmt.Errorf("tenant ID %q contains a slash", id)
+	}
+	return nil
+}
+
+// tenantNamespace returns the namespace of the tenant behind ctx, or nil if
+// the peer joined no tenant
+func (h *Handler) tenantNamespace(ctx context.Context) *NamespacedHandler {
+	tenant, ok := TenantIDFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return h.Namespace(tenant)
+}
+
+// SessionTenant returns the tenant a session joins given the handshake offer
+// and the identity the peer proved, nil if it proved none. With a keyring
+// the tenant is the one granted to the identity, and asking for any other is
+// refused. Without one no tenant can be proven and the requested one is used.
+func (h *Handler) SessionTenant(offer *HandshakePayload, identity *security.PeerIdentity) (string, error) {
+	if h.keyring == nil {
+		return offer.TenantID, nil
+	}
+	if identity == nil {
+		if offer.TenantID != "" {
+			return "", fmt.Errorf("tenant %q requires a proven identity", offer.TenantID)
+		}
+		return "", nil
+	}
+	if offer.TenantID != "" && offer.TenantID != identity.Tenant {
+		return "", fmt.Errorf("tenant %q not granted to %s", offer.TenantID, identity.ID)
+	}
+	if err := validateTenantID(identity.Tenant); err != nil {
+		return "", err
+	}
+	return identity.Tenant, nil
+}
+
+// placeCapability moves cap into the namespace of the tenant behind ctx and
+// returns that tenant, nil for none. Peers outside tenants may not register
+// into a tenant's namespace.
+func (h *Handler) placeCapability(ctx context.Context, cap *Capability) (*NamespacedHandler, error) {
+	tenant := h.tenantNamespace(ctx)
+	if tenant != nil {
+		tenant.adopt(cap)
+		h.mu.Lock()
+		h.tenants[tenant.Name()] = true
+		h.mu.Unlock()
+		return tenant, nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.inTenant(cap) {
+		return nil, fmt.Errorf("capability %s is in a tenant's namespace", cap.ID)
+	}
+	return nil, nil
+}
+
+// isTenant reports whether ns belongs to a tenant: a tenant session has
+// registered in it, or the keyring grants it to an identity.
+// Callers must hold h.mu.
+func (h *Handler) isTenant(ns string) bool {
+	if ns == "" {
+		return false
+	}
+	return h.tenants[ns] || (h.keyring != nil && h.keyring.HasTenant(ns))
+}
+
+// inTenant reports whether cap is in a tenant's namespace, or has an ID
+// that would collide with one. Callers must hold h.mu.
+func (h *Handler) inTenant(cap *Capability) bool {
+	if h.isTenant(cap.Namespace) {
+		return true
+	}
+	prefix, _, ok := strings.Cut(cap.ID, "/")
+	return ok && h.isTenant(prefix)
+}
+
+// visibleTo reports whether a peer in tenant, nil for none, may see cap.
+// Tenants see only their own namespace and other peers no tenant's.
+// Callers must hold h.mu.
+func (h *Handler) visibleTo(tenant *NamespacedHandler, cap *Capability) bool {
+	if tenant != nil {
+		return cap.Namespace == tenant.Name()
+	}
+	return !h.inTenant(cap)
+}
+
+// adopt moves cap into the namespace, prefixing its ID and dependencies so a
+// tenant can neither collide with nor depend on another tenant's capabilities
+func (n *NamespacedHandler) adopt(cap *Capability) {
+	cap.ID = n.ID(cap.ID)
+	cap.Namespace = n.ns
+	for i, dep := range cap.Dependencies {
+		cap.Dependencies[i] = n.ID(dep)
+	}
+}
@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/heathweaver/arn-protocol/pkg/security"
+)
+
+func TestTenantPartition(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	acme := ContextWithTenantID(ContextWithPeerID(context.Background(), "acme-agent"), "acme")
+	globex := ContextWithTenantID(ContextWithPeerID(context.Background(), "globex-agent"), "globex")
+
+	// Both tenants register search-v1 without colliding
+	for _, ctx := range []context.Context{acme, globex} {
+		msg := streamMessage(t, Register, &Capability{ID: "search-v1", Type: "DISCOVER", Interaction: Discover})
+		if response, err := handler.HandleMessage(ctx, msg); err != nil || response.Type != Response {
+			t.Fatalf("Register = %v, %v, want a Response", response, err)
+		}
+	}
+
+	queryIDs := func(ctx context.Context) []string {
+		t.Helper()
+		response, err := handler.HandleMessage(ctx, streamMessage(t, Query, &QueryPayload{CapabilityType: "DISCOVER", Namespace: "globex"}))
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		page, err := DecodeQueryResponse(response)
+		if err != nil {
+			t.Fatalf("DecodeQueryResponse() error = %v", err)
+		}
+		return capabilityIDs(page.Capabilities)
+	}
+
+	// A tenant's queries stay in its namespace, whichever it asks for
+	if got := queryIDs(acme); !reflect.DeepEqual(got, []string{"acme/search-v1"}) {
+		t.Errorf("acme query = %v, want [acme/search-v1]", got)
+	}
+	// Peers outside tenants see no tenant's capabilities, even by namespace
+	if got := queryIDs(context.Background()); len(got) != 0 {
+		t.Errorf("Untenanted query = %v, want none", got)
+	}
+
+	requestCap := func(ctx context.Context, id string) *Message {
+		t.Helper()
+		response, err := handler.HandleMessage(ctx, streamMessage(t, AICapabilityRequest, &CapabilityRequestPayload{CapabilityID: id}))
+		if err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+		return response
+	}
+
+	var cap Capability
+	if response := requestCap(globex, "search-v1"); response.Type != Response || json.Unmarshal(response.Payload, &cap) != nil || cap.ID != "globex/search-v1" {
+		t.Errorf("globex request for search-v1 = %s, want globex/search-v1", response.Payload)
+	}
+	// A tenant cannot reach into another namespace by its full ID
+	if response := requestCap(acme, "globex/search-v1"); response.Type != Error {
+		t.Errorf("acme request for globex/search-v1 = %v, want an Error", response.Type)
+	}
+	if response := requestCap(context.Background(), "globex/search-v1"); response.Type != Error {
+		t.Errorf("Untenanted request for globex/search-v1 = %v, want an Error", response.Type)
+	}
+
+	// Nor can a peer outside tenants register into one
+	for _, cap := range []*Capability{
+		{ID: "globex/planted", Type: "DISCOVER"},
+		{ID: "planted", Type: "DISCOVER", Namespace: "globex"},
+	} {
+		response, err := handler.HandleMessage(context.Background(), streamMessage(t, Register, cap))
+		var payload ErrorPayload
+		if err != nil || response.Type != Error || json.Unmarshal(response.Payload, &payload) != nil || payload.Code != ErrForbidden {
+			t.Errorf("Untenanted Register of %s/%s = %v, want ErrForbidden", cap.Namespace, cap.ID, response)
+		}
+	}
+
+	msg := streamMessage(t, Unregister, &UnregisterPayload{CapabilityID: "search-v1"})
+	if response, err := handler.HandleMessage(acme, msg); err != nil || response.Type != Response {
+		t.Fatalf("Unregister = %v, %v, want a Response", response, err)
+	}
+	if got := capabilityIDs(handler.ListCapabilities()); !reflect.DeepEqual(got, []string{"globex/search-v1"}) {
+		t.Errorf("Capabilities after acme unregistered = %v, want [globex/search-v1]", got)
+	}
+}
+
+func TestHandshakeTenantID(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	tests := []struct {
+		name    string
+		tenant  string
+		wantErr bool
+	}{
+		{"no tenant", "", false},
+		{"tenant", "acme", false},
+		{"slash", "acme/eu", true},
+		{"too long", string(make([]byte, maxTenantIDLength+1)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.HandleHandshake(handshakeMessage(t, HandshakePayload{MinVersion: V1, MaxVersion: V1, TenantID: tt.tenant}))
+			if err != nil {
+				t.Fatalf("HandleHandshake() error = %v", err)
+			}
+			if got := response.Type == Error; got != tt.wantErr {
+				t.Errorf("HandleHandshake() = %v: %s, want error %v", response.Type, response.Payload, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionTenant(t *testing.T) {
+	keyring, err := security.NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := &security.PeerIdentity{ID: "acme-agent", Tenant: "acme"}
+	untenanted := &security.PeerIdentity{ID: "ops"}
+
+	tests := []struct {
+		name     string
+		handler  *Handler
+		offer    HandshakePayload
+		identity *security.PeerIdentity
+		want     string
+		wantErr  bool
+	}{
+		{"declared without keyring", NewHandler(), HandshakePayload{TenantID: "acme"}, nil, "acme", false},
+		{"declared but unproven", NewHandler(WithKeyring(keyring)), HandshakePayload{TenantID: "acme"}, nil, "", true},
+		{"unproven without tenant", NewHandler(WithKeyring(keyring)), HandshakePayload{}, nil, "", false},
+		{"granted", NewHandler(WithKeyring(keyring)), HandshakePayload{TenantID: "acme"}, acme, "acme", false},
+		{"granted but not asked", NewHandler(WithKeyring(keyring)), HandshakePayload{}, acme, "acme", false},
+		{"not granted", NewHandler(WithKeyring(keyring)), HandshakePayload{TenantID: "globex"}, acme, "", true},
+		{"no tenant granted", NewHandler(WithKeyring(keyring)), HandshakePayload{TenantID: "acme"}, untenanted, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.handler.Close()
+			got, err := tt.handler.SessionTenant(&tt.offer, tt.identity)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("SessionTenant() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer spans are created with
+const tracerName = "github.com/heathweaver/arn-protocol/pkg/protocol"
+
+// WithOTelTracerProvider creates handler spans with tp instead of the global
+// provider from otel.GetTracerProvider
+func WithOTelTracerProvider(tp trace.TracerProvider) Option {
+	return func(h *Handler) {
+		h.tracerProvider = tp
+	}
+}
+
+// InjectTraceContext stores the span context of ctx in msg.TraceContext using
+// the global propagator, so the receiver can continue the trace. The message
+// is upgraded to V2 when there is anything to carry.
+func InjectTraceContext(ctx context.Context, msg *Message) error {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("failed to encode trace context: %w", err)
+	}
+	if msg.Version < V2 {
+		msg.Version = V2
+	}
+	msg.TraceContext = data
+	return nil
+}
+
+// ExtractTraceContext returns ctx carrying the span context propagated in
+// msg.TraceContext. Messages without a valid trace context leave ctx as is.
+func ExtractTraceContext(ctx context.Context, msg *Message) context.Context {
+	if len(msg.TraceContext) == 0 {
+		return ctx
+	}
+
+	var carrier propagation.MapCarrier
+	if err := json.Unmarshal(msg.TraceContext, &carrier); err != nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// startSpan starts the span covering the handling of msg as a child of the
+// sender's span, if it propagated one
+func (h *Handler) startSpan(ctx context.Context, msg *Message) (context.Context, trace.Span) {
+	tp := h.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName).Start(ExtractTraceContext(ctx, msg), fmt.Sprintf("arn.message.%d", msg.Type),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.Int("arn.message.type", int(msg.Type)),
+			attribute.Int("arn.message.version", int(msg.Version)),
+		),
+	)
+}
+
+// endSpan records the outcome of handling and ends span
+func endSpan(span trace.Span, response *Message, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case response != nil && response.Type == Error:
+		span.SetStatus(codes.Error, "error response")
+	}
+	span.End()
+}
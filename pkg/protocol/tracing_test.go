@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+
+	handler := NewHandler(WithOTelTracerProvider(tp))
+	defer handler.Close()
+
+	// The sender's span
+	ctx, parent := tp.Tracer("sender").Start(context.Background(), "query")
+	msg := &Message{
+		Version:   V1,
+		Type:      Query,
+		Payload:   []byte(`{"capability_type":"DISCOVER"}`),
+		Timestamp: time.Now(),
+	}
+	if err := InjectTraceContext(ctx, msg); err != nil {
+		t.Fatalf("InjectTraceContext() error = %v", err)
+	}
+	parent.End()
+	if msg.Version != V2 || len(msg.TraceContext) == 0 {
+		t.Fatalf("InjectTraceContext() left version %d, trace context %q", msg.Version, msg.TraceContext)
+	}
+
+	// Across the wire
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	received, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+
+	if _, err := handler.HandleMessage(context.Background(), received); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected sender and handler spans, got %d", len(spans))
+	}
+	child := spans[1]
+	if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Handler span parent = %s, want %s", child.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if child.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("Handler span trace = %s, want %s", child.SpanContext().TraceID(), parent.SpanContext().TraceID())
+	}
+	if !child.Parent().IsRemote() {
+		t.Error("Handler span parent should be remote")
+	}
+}
+
+func TestTraceSpanRecordsErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+
+	handler := NewHandler(WithOTelTracerProvider(tp))
+	defer handler.Close()
+
+	// Malformed queries are answered with an Error message
+	if _, err := handler.HandleMessage(context.Background(), &Message{
+		Version:   V1,
+		Type:      Query,
+		Payload:   []byte("not json"),
+		Timestamp: time.Now(),
+	}); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("Span status = %v, want %v", spans[0].Status().Code, codes.Error)
+	}
+	if spans[0].Parent().IsValid() {
+		t.Error("Span without propagated context should be a root span")
+	}
+}
+
+func TestInjectTraceContextWithoutSpan(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	msg := &Message{Version: V1, Type: Hello, Timestamp: time.Now()}
+	if err := InjectTraceContext(context.Background(), msg); err != nil {
+		t.Fatalf("InjectTraceContext() error = %v", err)
+	}
+	if msg.Version != V1 || msg.TraceContext != nil {
+		t.Errorf("InjectTraceContext() changed a message with no span to propagate")
+	}
+
+	// Garbage trace contexts are ignored
+	ctx := ExtractTraceContext(context.Background(), &Message{TraceContext: []byte("garbage")})
+	if ctx != context.Background() {
+		t.Error("ExtractTraceContext() changed the context for an invalid trace context")
+	}
+}
@@ -2,7 +2,14 @@ package protocol
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,8 +18,42 @@ type Version uint8
 
 const (
 	V1 Version = 1
+	V2 Version = 2
 )
 
+// String returns the constant name of v, such as "V2"
+func (v Version) String() string {
+	switch v {
+	case V1:
+		return "V1"
+	case V2:
+		return "V2"
+	default:
+		return fmt.Sprintf("Version(%d)", uint8(v))
+	}
+}
+
+// Range of protocol versions this implementation can speak
+const (
+	MinSupportedVersion = V1
+	MaxSupportedVersion = V2
+)
+
+// Feature flags negotiated during the handshake
+const (
+	FeatureCompression = "compression"
+	FeatureAuth        = "auth"
+	FeatureStreaming   = "streaming"
+
+	// FeatureIdentity asks the server to challenge the peer to prove the
+	// identity named by PeerID. It is only agreed by handlers with a keyring,
+	// and only offered by peers holding a key, so it is not in SupportedFeatures.
+	FeatureIdentity = "identity"
+)
+
+// SupportedFeatures lists the features offered during a handshake
+var SupportedFeatures = []string{FeatureCompression, FeatureAuth, FeatureStreaming}
+
 // MessageType represents different types of ARN messages
 type MessageType uint8
 
@@ -33,11 +74,104 @@ const (
 	AIStreamEnd
 
 	// MCP bridge messages
-	MCPBridgeAdvertise  // Advertise MCP data source
-	MCPBridgeRequest    // Request access to MCP data
-	MCPBridgeResponse   // Response with MCP endpoint details
+	MCPBridgeAdvertise // Advertise MCP data source
+	MCPBridgeRequest   // Request access to MCP data
+	MCPBridgeResponse  // Response with MCP endpoint details
+	MCPBridgeDown      // Bridge failed health checks and was removed
+
+	// Registry maintenance
+	Unregister // Remove a capability the sender registered
+
+	// Stream flow control
+	AIStreamCredit // Grants a stream sender more AIStreamData messages
+
+	// Batching
+	BatchMessage  // Several messages sent in one frame, see Batch
+	BatchResponse // Responses to the messages of a BatchMessage, in order
+
+	// Bridge leases
+	MCPBridgeRenew // Extend the lease of an MCP bridge the sender advertised
+
+	// Heartbeats
+	Ping // Asks the peer to show it is still there
+	Pong // Answers a Ping, echoing its correlation ID
+
+	// Bridge discovery
+	MCPBridgeQuery // Find MCP bridges by protocol, data type, region or age
 )
 
+// String returns the constant name of t, such as "MCPBridgeAdvertise"
+func (t MessageType) String() string {
+	switch t {
+	case Hello:
+		return "Hello"
+	case Register:
+		return "Register"
+	case Query:
+		return "Query"
+	case Response:
+		return "Response"
+	case Handshake:
+		return "Handshake"
+	case Error:
+		return "Error"
+	case AICapabilityAdvertise:
+		return "AICapabilityAdvertise"
+	case AICapabilityRequest:
+		return "AICapabilityRequest"
+	case AIStreamStart:
+		return "AIStreamStart"
+	case AIStreamData:
+		return "AIStreamData"
+	case AIStreamEnd:
+		return "AIStreamEnd"
+	case MCPBridgeAdvertise:
+		return "MCPBridgeAdvertise"
+	case MCPBridgeRequest:
+		return "MCPBridgeRequest"
+	case MCPBridgeResponse:
+		return "MCPBridgeResponse"
+	case MCPBridgeDown:
+		return "MCPBridgeDown"
+	case Unregister:
+		return "Unregister"
+	case AIStreamCredit:
+		return "AIStreamCredit"
+	case BatchMessage:
+		return "BatchMessage"
+	case BatchResponse:
+		return "BatchResponse"
+	case MCPBridgeRenew:
+		return "MCPBridgeRenew"
+	case Ping:
+		return "Ping"
+	case Pong:
+		return "Pong"
+	case MCPBridgeQuery:
+		return "MCPBridgeQuery"
+	default:
+		return fmt.Sprintf("MessageType(%d)", uint8(t))
+	}
+}
+
+// MarshalText encodes t as its constant name
+func (t MessageType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText accepts anything MarshalText produces, including the
+// "MessageType(N)" form of unknown types
+func (t *MessageType) UnmarshalText(text []byte) error {
+	n, err := parseEnum(string(text), "MessageType", uint64(MCPBridgeQuery), math.MaxUint8, func(n uint64) string {
+		return MessageType(n).String()
+	})
+	if err != nil {
+		return err
+	}
+	*t = MessageType(n)
+	return nil
+}
+
 // ErrorCode represents standardized error codes
 type ErrorCode uint16
 
@@ -46,23 +180,155 @@ const (
 	ErrInvalidVersion ErrorCode = 100 + iota
 	ErrInvalidMessageType
 	ErrInvalidPayload
+)
 
+const (
 	// 2xx: Authentication/Authorization errors
-	ErrUnauthorized = 200 + iota
+	ErrUnauthorized ErrorCode = 200 + iota
 	ErrForbidden
 	ErrInvalidCredentials
+)
 
+const (
 	// 3xx: Capability errors
-	ErrCapabilityNotFound = 300 + iota
+	ErrCapabilityNotFound ErrorCode = 300 + iota
 	ErrCapabilityUnavailable
 	ErrInvalidCapabilityFormat
+)
 
+const (
 	// 4xx: MCP bridge errors
-	ErrMCPEndpointUnavailable = 400 + iota
+	ErrMCPEndpointUnavailable ErrorCode = 400 + iota
 	ErrMCPProtocolMismatch
 	ErrMCPAuthenticationFailed
 )
 
+// Error implements the error interface so codes can be returned and matched with errors.Is
+func (e ErrorCode) Error() string {
+	switch e {
+	case ErrInvalidVersion:
+		return "invalid version"
+	case ErrInvalidMessageType:
+		return "invalid message type"
+	case ErrInvalidPayload:
+		return "invalid payload"
+	case ErrUnauthorized:
+		return "unauthorized"
+	case ErrForbidden:
+		return "forbidden"
+	case ErrInvalidCredentials:
+		return "invalid credentials"
+	case ErrCapabilityNotFound:
+		return "capability not found"
+	case ErrCapabilityUnavailable:
+		return "capability unavailable"
+	case ErrInvalidCapabilityFormat:
+		return "invalid capability format"
+	case ErrMCPEndpointUnavailable:
+		return "mcp endpoint unavailable"
+	case ErrMCPProtocolMismatch:
+		return "mcp protocol mismatch"
+	case ErrMCPAuthenticationFailed:
+		return "mcp authentication failed"
+	default:
+		return fmt.Sprintf("error code %d", uint16(e))
+	}
+}
+
+// String returns the constant name of e, such as "ErrMCPProtocolMismatch".
+// Error gives the human-readable description instead.
+func (e ErrorCode) String() string {
+	switch e {
+	case ErrInvalidVersion:
+		return "ErrInvalidVersion"
+	case ErrInvalidMessageType:
+		return "ErrInvalidMessageType"
+	case ErrInvalidPayload:
+		return "ErrInvalidPayload"
+	case ErrUnauthorized:
+		return "ErrUnauthorized"
+	case ErrForbidden:
+		return "ErrForbidden"
+	case ErrInvalidCredentials:
+		return "ErrInvalidCredentials"
+	case ErrCapabilityNotFound:
+		return "ErrCapabilityNotFound"
+	case ErrCapabilityUnavailable:
+		return "ErrCapabilityUnavailable"
+	case ErrInvalidCapabilityFormat:
+		return "ErrInvalidCapabilityFormat"
+	case ErrMCPEndpointUnavailable:
+		return "ErrMCPEndpointUnavailable"
+	case ErrMCPProtocolMismatch:
+		return "ErrMCPProtocolMismatch"
+	case ErrMCPAuthenticationFailed:
+		return "ErrMCPAuthenticationFailed"
+	default:
+		return fmt.Sprintf("ErrorCode(%d)", uint16(e))
+	}
+}
+
+// MarshalText encodes e as its constant name
+func (e ErrorCode) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText accepts anything MarshalText produces, including the
+// "ErrorCode(N)" form of unknown codes
+func (e *ErrorCode) UnmarshalText(text []byte) error {
+	n, err := parseEnum(string(text), "ErrorCode", uint64(ErrMCPAuthenticationFailed), math.MaxUint16, func(n uint64) string {
+		return ErrorCode(n).String()
+	})
+	if err != nil {
+		return err
+	}
+	*e = ErrorCode(n)
+	return nil
+}
+
+// MarshalJSON keeps error codes numeric on the wire, which is what every
+// peer decodes. The name travels separately in ErrorPayload.Name.
+func (e ErrorCode) MarshalJSON() ([]byte, error) {
+	return strconv.AppendUint(nil, uint64(e), 10), nil
+}
+
+// UnmarshalJSON accepts the numbers MarshalJSON writes as well as the names
+// written by MarshalText
+func (e *ErrorCode) UnmarshalJSON(data []byte) error {
+	var n uint16
+	if err := json.Unmarshal(data, &n); err == nil {
+		*e = ErrorCode(n)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid error code %s", data)
+	}
+	return e.UnmarshalText([]byte(text))
+}
+
+// parseEnum maps text back to the value up to last whose name is text, or to
+// the number inside a "<kind>(N)" fallback name, which may be up to max
+func parseEnum(text, kind string, last, max uint64, name func(uint64) string) (uint64, error) {
+	if inner, ok := strings.CutPrefix(text, kind+"("); ok {
+		if digits, ok := strings.CutSuffix(inner, ")"); ok {
+			n, err := strconv.ParseUint(digits, 10, 64)
+			if err == nil && n <= max {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid %s %q", kind, text)
+	}
+
+	for n := uint64(0); n <= last; n++ {
+		if name(n) == text {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q", kind, text)
+}
+
 // InteractionType represents different ways AIs can interact
 type InteractionType uint8
 
@@ -74,55 +340,367 @@ const (
 	Delegate
 )
 
+// String returns the constant name of i, such as "Discover"
+func (i InteractionType) String() string {
+	switch i {
+	case Discover:
+		return "Discover"
+	case Negotiate:
+		return "Negotiate"
+	case Stream:
+		return "Stream"
+	case Delegate:
+		return "Delegate"
+	default:
+		return fmt.Sprintf("InteractionType(%d)", uint8(i))
+	}
+}
+
+// MarshalJSON encodes i by name. Values without a name, including the
+// zero value, stay numbers so that they survive a round trip unchanged.
+func (i InteractionType) MarshalJSON() ([]byte, error) {
+	if i < Discover || i > Delegate {
+		return strconv.AppendUint(nil, uint64(i), 10), nil
+	}
+	return strconv.AppendQuote(nil, i.String()), nil
+}
+
+// UnmarshalJSON accepts the names MarshalJSON produces as well as the
+// numbers earlier releases sent
+func (i *InteractionType) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n uint8
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid InteractionType %s", data)
+		}
+		*i = InteractionType(n)
+		return nil
+	}
+
+	n, err := parseEnum(name, "InteractionType", uint64(Delegate), math.MaxUint8, func(n uint64) string {
+		return InteractionType(n).String()
+	})
+	if err != nil {
+		return err
+	}
+	*i = InteractionType(n)
+	return nil
+}
+
 // Capability represents an AI's capability or a data source's capability
 type Capability struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
+	ID          string            `json:"id" arn:"required,max=128"`
+	Name        string            `json:"name" arn:"max=256"`
 	Type        string            `json:"type"`
-	Version     string            `json:"version"`
+	Version     string            `json:"version" arn:"semver"`
 	Interaction InteractionType   `json:"interaction"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
-	MCPEnabled  bool             `json:"mcp_enabled,omitempty"` // Whether this capability can interact via MCP
+	MCPEnabled  bool              `json:"mcp_enabled,omitempty"` // Whether this capability can interact via MCP
+	TTL         time.Duration     `json:"ttl,omitempty"`         // Lifetime after registration, zero uses the handler default
+
+	// Dependencies are IDs of capabilities that must be registered first
+	Dependencies []string `json:"dependencies,omitempty"`
+
+	// Namespace partitions the registry, such as "finance" or "nlp". Empty
+	// is the default namespace. See Handler.Namespace.
+	Namespace string `json:"namespace,omitempty" arn:"max=64"`
+
+	// RegisteredAt is when the handler first stored a capability with this
+	// ID; replacing it keeps the time. Values sent by peers are ignored.
+	RegisteredAt time.Time `json:"registered_at,omitzero"`
 }
 
+// clone returns a copy of c that shares no maps with it
+func (c *Capability) clone() *Capability {
+	cp := *c
+	if c.Metadata != nil {
+		cp.Metadata = make(map[string]string, len(c.Metadata))
+		for k, v := range c.Metadata {
+			cp.Metadata[k] = v
+		}
+	}
+	if c.Dependencies != nil {
+		cp.Dependencies = append([]string(nil), c.Dependencies...)
+	}
+	return &cp
+}
+
+// QueryPayload is the body of a Query message
+type QueryPayload struct {
+	CapabilityType string `json:"capability_type"`
+	MCPEnabled     bool   `json:"mcp_enabled,omitempty"`
+	MinVersion     string `json:"min_version,omitempty"` // Inclusive SemVer lower bound
+	MaxVersion     string `json:"max_version,omitempty"` // Inclusive SemVer upper bound
+
+	// Interaction restricts results to one interaction pattern, zero matches any
+	Interaction InteractionType `json:"interaction,omitempty"`
+
+	// MetadataFilter keeps capabilities whose metadata has every key, with a
+	// value containing the filter value, ignoring case
+	MetadataFilter map[string]string `json:"metadata_filter,omitempty"`
+
+	// Namespace restricts results to one namespace, empty matches any
+	Namespace string `json:"namespace,omitempty"`
+
+	// Offset skips the first matches and Limit, if above zero, caps how many
+	// are returned, with matches ordered by ID. Setting either makes the
+	// response a QueryResponse rather than a bare list.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+// QueryResponse is the body of the Response to a paginated Query. Total
+// counts every match, including those outside the page.
+type QueryResponse struct {
+	Capabilities []*Capability `json:"capabilities"`
+	Total        int           `json:"total"`
+}
+
+// CapabilityRequestPayload is the body of an AICapabilityRequest message
+type CapabilityRequestPayload struct {
+	CapabilityID string `json:"capability_id"`
+}
+
+// UnregisterPayload is the body of an Unregister message
+type UnregisterPayload struct {
+	CapabilityID string `json:"capability_id"`
+}
+
+// HandshakePayload carries the version range and features a peer supports
+type HandshakePayload struct {
+	MinVersion Version  `json:"min_version"`
+	MaxVersion Version  `json:"max_version"`
+	Features   []string `json:"features,omitempty"`
+
+	// PeerID optionally names the connecting peer for access control
+	PeerID string `json:"peer_id,omitempty"`
+
+	// TenantID optionally places the session in a tenant. Its capabilities
+	// are registered in the namespace of that name and its queries only see
+	// that namespace, see Handler.Namespace. With a keyring the tenant must
+	// be granted to the proven identity, see Handler.SessionTenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Challenge is sent by the server when FeatureIdentity is agreed. The
+	// peer answers with a second Handshake carrying Proof, its signature of
+	// the challenge, see security.SignChallenge.
+	Challenge []byte `json:"challenge,omitempty"`
+	Proof     []byte `json:"proof,omitempty"`
+}
+
+// Priority orders queued messages; higher values are handled first
+type Priority uint8
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityCritical
+)
+
 // Message represents the base ARN message format
 type Message struct {
-	Version     Version
-	Type        MessageType
+	Version     Version     `arn:"required"`
+	Type        MessageType `arn:"required"`
 	PayloadSize uint32
 	Payload     []byte
 	Timestamp   time.Time
+
+	// V2 only: compress Payload on the wire with CompressionCodec
+	Compressed       bool
+	CompressionCodec CompressionCodec
+
+	// V2 only: HMAC-SHA256 over the message, see Sign and Verify
+	Signature []byte
+
+	// V2 only: random value used once per message to detect replays.
+	// The zero value means the message carries no nonce.
+	Nonce [16]byte
+
+	// V2 only: scheduling hint for receivers with a backlog
+	Priority Priority
+
+	// V2 only: how Payload is serialized, see SetPayload and DecodePayload
+	Encoding Encoding
+
+	// V2 only: ID of a registered Codec that serialized Payload, see
+	// SetPayloadCodec. Zero means Encoding applies.
+	CodecID uint8
+
+	// V2 only: propagated OpenTelemetry span context, see InjectTraceContext
+	TraceContext []byte
+
+	// V2 only: random request ID echoed in the response, so several requests
+	// can be in flight on one connection. The zero value means none.
+	CorrelationID [16]byte
+
+	// V2 only: number of nodes that have forwarded the message. Each
+	// forwarding node increments it so routing loops can be detected.
+	HopCount uint8
+
+	// V2 only: IDs of the relay nodes the message is to visit, in order.
+	// Each relay pops its own ID from the head and forwards the message to
+	// the node named next.
+	Route []string
+
+	// V2 only: CRC-32 (IEEE) of the frame up to the checksum, as received.
+	// Deserialize sets and verifies it; Serialize always writes a fresh one.
+	Checksum uint32
+}
+
+// Clone returns a deep copy of m, so the copy can be changed or handed to
+// other goroutines without affecting m
+func (m *Message) Clone() *Message {
+	cp := *m
+	cp.Payload = cloneBytes(m.Payload)
+	cp.Signature = cloneBytes(m.Signature)
+	cp.TraceContext = cloneBytes(m.TraceContext)
+	cp.Route = slices.Clone(m.Route)
+	return &cp
+}
+
+// cloneBytes copies b, keeping nil and empty slices apart
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// EffectivePriority returns the priority msg is scheduled with. Errors and
+// bridge failures are always critical, whatever the sender asked for.
+func (m *Message) EffectivePriority() Priority {
+	switch m.Type {
+	case Error, MCPBridgeDown:
+		return PriorityCritical
+	}
+	return m.Priority
 }
 
+// V2 flags byte layout
+const (
+	flagCompressed = 1 << 0
+	codecShift     = 1
+	codecMask      = 0x3 << codecShift
+	flagSigned     = 1 << 3
+	encodingShift  = 4
+	encodingMask   = 0x3 << encodingShift
+	flagChecksum   = 1 << 6
+)
+
+// Size of the CRC-32 that ends V2 frames with flagChecksum set
+const checksumSize = 4
+
+// V2 extension identifiers. Each extension is encoded as id(1) + length(2) + value
+// and readers skip identifiers they do not recognise.
+const (
+	extSignature uint8 = 1
+	extNonce     uint8 = 2
+	extPriority  uint8 = 3
+	extCodec     uint8 = 4
+	extTrace     uint8 = 5
+	extCorrelate uint8 = 6
+	extHops      uint8 = 7
+	extRoute     uint8 = 8
+)
+
+// Size of the V2 trailer that follows the timestamp: flags(1) + extension length(2)
+const v2TrailerSize = 1 + 2
+
 // Serialize converts a Message to its wire format
 func (m *Message) Serialize() ([]byte, error) {
-	if len(m.Payload) > 1<<32-1 {
+	if m.Version < V2 && m.usesV2Fields() {
+		return nil, fmt.Errorf("compression, signatures, nonces, priorities, binary encodings, codecs, trace contexts, correlation IDs, hop counts and routes require protocol version %d or later", V2)
+	}
+
+	payload := m.Payload
+	if m.Compressed {
+		compressed, err := compress(m.CompressionCodec, payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = compressed
+	}
+
+	if len(payload) > 1<<32-1 {
 		return nil, fmt.Errorf("payload too large")
 	}
 
-	// Calculate total size: version(1) + type(1) + size(4) + payload + timestamp(8)
-	totalSize := 1 + 1 + 4 + len(m.Payload) + 8
-	buffer := make([]byte, totalSize)
+	// Layout: version(1) + type(1) + size(4 in V1, 1-5 from V2) + payload + timestamp(8)
+	buffer := make([]byte, 0, 1+1+maxVarintSize+len(payload)+8)
 
 	// Write version and type
-	buffer[0] = byte(m.Version)
-	buffer[1] = byte(m.Type)
+	buffer = append(buffer, byte(m.Version), byte(m.Type))
 
 	// Write payload size
-	binary.BigEndian.PutUint32(buffer[2:6], uint32(len(m.Payload)))
+	buffer = appendPayloadSize(buffer, m.Version, uint32(len(payload)))
 
 	// Write payload
-	copy(buffer[6:6+len(m.Payload)], m.Payload)
+	buffer = append(buffer, payload...)
 
 	// Write timestamp
-	binary.BigEndian.PutUint64(buffer[6+len(m.Payload):], uint64(m.Timestamp.UnixNano()))
+	buffer = binary.BigEndian.AppendUint64(buffer, uint64(m.Timestamp.UnixNano()))
+
+	if m.Version < V2 {
+		return buffer, nil
+	}
+
+	// Write V2 flags, extensions and checksum
+	flags := byte(flagChecksum)
+	if m.Compressed {
+		flags |= flagCompressed
+		flags |= byte(m.CompressionCodec) << codecShift & codecMask
+	}
+	flags |= byte(m.Encoding) << encodingShift & encodingMask
+
+	var ext []byte
+	if len(m.Signature) > 0 {
+		flags |= flagSigned
+		ext = appendExtension(ext, extSignature, m.Signature)
+	}
+	if m.HasNonce() {
+		ext = appendExtension(ext, extNonce, m.Nonce[:])
+	}
+	if m.Priority != PriorityNormal {
+		ext = appendExtension(ext, extPriority, []byte{byte(m.Priority)})
+	}
+	if m.CodecID != 0 {
+		ext = appendExtension(ext, extCodec, []byte{m.CodecID})
+	}
+	if len(m.TraceContext) > 0 {
+		ext = appendExtension(ext, extTrace, m.TraceContext)
+	}
+	if m.HasCorrelationID() {
+		ext = appendExtension(ext, extCorrelate, m.CorrelationID[:])
+	}
+	if m.HopCount != 0 {
+		ext = appendExtension(ext, extHops, []byte{m.HopCount})
+	}
+	if len(m.Route) > 0 {
+		route, err := encodeRoute(m.Route)
+		if err != nil {
+			return nil, err
+		}
+		ext = appendExtension(ext, extRoute, route)
+	}
+
+	if len(ext) > 1<<16-1 {
+		return nil, fmt.Errorf("extensions too large")
+	}
 
-	return buffer, nil
+	buffer = append(buffer, flags, 0, 0)
+	binary.BigEndian.PutUint16(buffer[len(buffer)-2:], uint16(len(ext)))
+	buffer = append(buffer, ext...)
+	return binary.BigEndian.AppendUint32(buffer, crc32.ChecksumIEEE(buffer)), nil
 }
 
 // Deserialize converts wire format back to a Message
 func Deserialize(data []byte) (*Message, error) {
-	if len(data) < 14 { // Minimum size: version(1) + type(1) + size(4) + timestamp(8)
+	if len(data) < 11 { // Minimum size: version(1) + type(1) + size(1) + timestamp(8)
 		return nil, fmt.Errorf("message too short")
 	}
 
@@ -131,22 +709,308 @@ func Deserialize(data []byte) (*Message, error) {
 		Type:    MessageType(data[1]),
 	}
 
+	if msg.Version < MinSupportedVersion || msg.Version > MaxSupportedVersion {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidVersion, msg.Version)
+	}
+
 	// Read payload size
-	msg.PayloadSize = binary.BigEndian.Uint32(data[2:6])
+	size, n, err := payloadSize(msg.Version, data[2:])
+	if err != nil {
+		return nil, err
+	}
+	msg.PayloadSize = size
+	header := 2 + uint64(n)
 
 	// Validate total message size
-	expectedSize := 6 + msg.PayloadSize + 8
-	if uint32(len(data)) != expectedSize {
+	expectedSize := header + uint64(msg.PayloadSize) + 8
+	if msg.Version >= V2 {
+		if uint64(len(data)) < expectedSize+v2TrailerSize {
+			return nil, fmt.Errorf("invalid message size")
+		}
+		flags := data[expectedSize]
+		extSize := binary.BigEndian.Uint16(data[expectedSize+1:])
+		expectedSize += v2TrailerSize + uint64(extSize)
+
+		if flags&flagChecksum != 0 {
+			if uint64(len(data)) != expectedSize+checksumSize {
+				return nil, fmt.Errorf("invalid message size")
+			}
+			msg.Checksum = binary.BigEndian.Uint32(data[expectedSize:])
+			if err := verifyChecksum(data[:expectedSize], msg.Checksum); err != nil {
+				return nil, err
+			}
+			data = data[:expectedSize]
+		}
+	}
+	if uint64(len(data)) != expectedSize {
 		return nil, fmt.Errorf("invalid message size")
 	}
 
 	// Read payload
 	msg.Payload = make([]byte, msg.PayloadSize)
-	copy(msg.Payload, data[6:6+msg.PayloadSize])
+	copy(msg.Payload, data[header:header+uint64(msg.PayloadSize)])
 
 	// Read timestamp
-	nsec := binary.BigEndian.Uint64(data[6+msg.PayloadSize:])
+	offset := header + uint64(msg.PayloadSize)
+	nsec := binary.BigEndian.Uint64(data[offset : offset+8])
 	msg.Timestamp = time.Unix(0, int64(nsec))
 
+	if msg.Version < V2 {
+		return msg, nil
+	}
+
+	// Read V2 flags and extensions
+	if err := msg.readTrailer(data[offset+8], data[offset+8+v2TrailerSize:]); err != nil {
+		return nil, err
+	}
 	return msg, nil
 }
+
+// DeserializeFrom reads exactly one message from r, leaving anything after it
+// unread. It issues several small reads, so r should be buffered, such as a
+// *bufio.Reader around a connection.
+func DeserializeFrom(r io.Reader) (*Message, error) {
+	// Read version and type
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	msg := &Message{
+		Version: Version(header[0]),
+		Type:    MessageType(header[1]),
+	}
+
+	// Reject unknown versions before reading a size in their encoding
+	if msg.Version < MinSupportedVersion || msg.Version > MaxSupportedVersion {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidVersion, msg.Version)
+	}
+
+	// V2 frames may end with a checksum of everything read before it
+	frame := r
+	crc := crc32.NewIEEE()
+	if msg.Version >= V2 {
+		crc.Write(header[:])
+		r = io.TeeReader(r, crc)
+	}
+
+	size, err := readPayloadSize(msg.Version, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	msg.PayloadSize = size
+
+	msg.Payload = make([]byte, msg.PayloadSize)
+	if _, err := io.ReadFull(r, msg.Payload); err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", truncated(err))
+	}
+
+	var timestamp [8]byte
+	if _, err := io.ReadFull(r, timestamp[:]); err != nil {
+		return nil, fmt.Errorf("failed to read timestamp: %w", truncated(err))
+	}
+	msg.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(timestamp[:])))
+
+	if msg.Version < V2 {
+		return msg, nil
+	}
+
+	// V2 frames end with a flags byte and a length-prefixed extension block
+	var trailer [v2TrailerSize]byte
+	if _, err := io.ReadFull(r, trailer[:]); err != nil {
+		return nil, fmt.Errorf("failed to read trailer: %w", truncated(err))
+	}
+	ext := make([]byte, binary.BigEndian.Uint16(trailer[1:3]))
+	if _, err := io.ReadFull(r, ext); err != nil {
+		return nil, fmt.Errorf("failed to read extensions: %w", truncated(err))
+	}
+
+	if trailer[0]&flagChecksum != 0 {
+		var checksum [checksumSize]byte
+		if _, err := io.ReadFull(frame, checksum[:]); err != nil {
+			return nil, fmt.Errorf("failed to read checksum: %w", truncated(err))
+		}
+		msg.Checksum = binary.BigEndian.Uint32(checksum[:])
+		if crc.Sum32() != msg.Checksum {
+			return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidPayload)
+		}
+	}
+
+	if err := msg.readTrailer(trailer[0], ext); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Longest LEB128 encoding of a 32-bit payload size
+const maxVarintSize = 5
+
+// appendPayloadSize encodes size the way version v frames it: four bytes big
+// endian in V1 and unsigned LEB128 from V2, which fits payloads under 128
+// bytes in a single byte
+func appendPayloadSize(buf []byte, v Version, size uint32) []byte {
+	if v < V2 {
+		return binary.BigEndian.AppendUint32(buf, size)
+	}
+	return binary.AppendUvarint(buf, uint64(size))
+}
+
+// payloadSize decodes the payload size at the start of data and returns it
+// with the number of bytes it took
+func payloadSize(v Version, data []byte) (uint32, int, error) {
+	if v < V2 {
+		if len(data) < 4 {
+			return 0, 0, fmt.Errorf("message too short")
+		}
+		return binary.BigEndian.Uint32(data), 4, nil
+	}
+
+	size, n := binary.Uvarint(data)
+	if n == 0 {
+		return 0, 0, fmt.Errorf("message too short")
+	}
+	if n < 0 || size > 1<<32-1 {
+		return 0, 0, fmt.Errorf("%w: payload size overflows 32 bits", ErrInvalidPayload)
+	}
+	return uint32(size), n, nil
+}
+
+// readPayloadSize reads a payload size encoded for version v from r
+func readPayloadSize(v Version, r io.Reader) (uint32, error) {
+	if v < V2 {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return 0, truncated(err)
+		}
+		return binary.BigEndian.Uint32(size[:]), nil
+	}
+
+	var size uint64
+	var b [1]byte
+	for i := 0; i < maxVarintSize; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, truncated(err)
+		}
+		size |= uint64(b[0]&0x7f) << (7 * i)
+		if b[0] < 0x80 {
+			if size > 1<<32-1 {
+				break
+			}
+			return uint32(size), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: payload size overflows 32 bits", ErrInvalidPayload)
+}
+
+// verifyChecksum checks the CRC-32 of a frame against the one it carried
+func verifyChecksum(frame []byte, checksum uint32) error {
+	if crc32.ChecksumIEEE(frame) != checksum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// truncated reports an EOF part way through a frame as an unexpected one
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readTrailer applies the V2 flags byte and extension block to m, whose
+// Payload still holds the bytes as sent
+func (m *Message) readTrailer(flags byte, ext []byte) error {
+	m.Encoding = Encoding(flags & encodingMask >> encodingShift)
+	if err := m.readExtensions(ext); err != nil {
+		return err
+	}
+
+	if flags&flagSigned != 0 && len(m.Signature) == 0 {
+		return fmt.Errorf("%w: signed flag set without signature", ErrInvalidPayload)
+	}
+
+	// Undo compression
+	if flags&flagCompressed != 0 {
+		m.Compressed = true
+		m.CompressionCodec = CompressionCodec(flags & codecMask >> codecShift)
+
+		payload, err := decompress(m.CompressionCodec, m.Payload)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		m.Payload = payload
+		m.PayloadSize = uint32(len(payload))
+	}
+	return nil
+}
+
+// usesV2Fields reports whether m sets anything only the V2 trailer can carry
+func (m *Message) usesV2Fields() bool {
+	return m.Compressed || len(m.Signature) > 0 || m.HasNonce() || m.Priority != PriorityNormal ||
+		m.Encoding != EncodingJSON || m.CodecID != 0 || len(m.TraceContext) > 0 ||
+		m.HasCorrelationID() || m.HopCount != 0 || len(m.Route) > 0
+}
+
+// appendExtension encodes a single V2 extension onto ext
+func appendExtension(ext []byte, id uint8, value []byte) []byte {
+	ext = append(ext, id, 0, 0)
+	binary.BigEndian.PutUint16(ext[len(ext)-2:], uint16(len(value)))
+	return append(ext, value...)
+}
+
+// readExtensions decodes the V2 extension block into msg
+func (m *Message) readExtensions(ext []byte) error {
+	for len(ext) > 0 {
+		if len(ext) < 3 {
+			return fmt.Errorf("%w: truncated extension header", ErrInvalidPayload)
+		}
+
+		id := ext[0]
+		size := int(binary.BigEndian.Uint16(ext[1:3]))
+		if len(ext) < 3+size {
+			return fmt.Errorf("%w: truncated extension %d", ErrInvalidPayload, id)
+		}
+		value := ext[3 : 3+size]
+		ext = ext[3+size:]
+
+		switch id {
+		case extSignature:
+			m.Signature = append([]byte(nil), value...)
+		case extNonce:
+			if size != len(m.Nonce) {
+				return fmt.Errorf("%w: nonce must be %d bytes", ErrInvalidPayload, len(m.Nonce))
+			}
+			copy(m.Nonce[:], value)
+		case extPriority:
+			if size != 1 {
+				return fmt.Errorf("%w: priority must be 1 byte", ErrInvalidPayload)
+			}
+			m.Priority = Priority(value[0])
+		case extCodec:
+			if size != 1 {
+				return fmt.Errorf("%w: codec ID must be 1 byte", ErrInvalidPayload)
+			}
+			m.CodecID = value[0]
+		case extTrace:
+			m.TraceContext = append([]byte(nil), value...)
+		case extCorrelate:
+			if size != len(m.CorrelationID) {
+				return fmt.Errorf("%w: correlation ID must be %d bytes", ErrInvalidPayload, len(m.CorrelationID))
+			}
+			copy(m.CorrelationID[:], value)
+		case extHops:
+			if size != 1 {
+				return fmt.Errorf("%w: hop count must be 1 byte", ErrInvalidPayload)
+			}
+			m.HopCount = value[0]
+		case extRoute:
+			route, err := decodeRoute(value)
+			if err != nil {
+				return err
+			}
+			m.Route = route
+		}
+	}
+	return nil
+}
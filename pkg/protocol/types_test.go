@@ -0,0 +1,424 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageTypeString(t *testing.T) {
+	tests := []struct {
+		typ  MessageType
+		want string
+	}{
+		{Hello, "Hello"},
+		{MCPBridgeAdvertise, "MCPBridgeAdvertise"},
+		{MCPBridgeRenew, "MCPBridgeRenew"},
+		{Pong, "Pong"},
+		{MCPBridgeQuery, "MCPBridgeQuery"},
+		{MessageType(0), "MessageType(0)"},
+		{MessageType(200), "MessageType(200)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.typ.String(); got != tt.want {
+			t.Errorf("MessageType(%d).String() = %q, want %q", uint8(tt.typ), got, tt.want)
+		}
+	}
+
+	if got := fmt.Sprintf("%v", AIStreamData); got != "AIStreamData" {
+		t.Errorf("Expected %%v to print the name, got %q", got)
+	}
+}
+
+func TestErrorCodeString(t *testing.T) {
+	tests := []struct {
+		code ErrorCode
+		want string
+	}{
+		{ErrInvalidVersion, "ErrInvalidVersion"},
+		{ErrMCPProtocolMismatch, "ErrMCPProtocolMismatch"},
+		{ErrorCode(999), "ErrorCode(999)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.want {
+			t.Errorf("ErrorCode(%d).String() = %q, want %q", uint16(tt.code), got, tt.want)
+		}
+	}
+}
+
+func TestEnumTextRoundTrip(t *testing.T) {
+	for typ := MessageType(0); typ <= MCPBridgeQuery+1; typ++ {
+		text, err := typ.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText() error = %v", err)
+		}
+		var got MessageType
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatalf("UnmarshalText(%s) error = %v", text, err)
+		}
+		if got != typ {
+			t.Errorf("MessageType round trip of %s = %d, want %d", text, got, typ)
+		}
+	}
+
+	for _, code := range []ErrorCode{ErrInvalidPayload, ErrForbidden, ErrInvalidCapabilityFormat, ErrMCPAuthenticationFailed, 999} {
+		data, err := json.Marshal(code)
+		if err != nil {
+			t.Fatalf("json.Marshal(%d) error = %v", code, err)
+		}
+		var got ErrorCode
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error = %v", data, err)
+		}
+		if got != code {
+			t.Errorf("ErrorCode round trip of %s = %d, want %d", data, got, code)
+		}
+	}
+}
+
+func TestEnumUnmarshalText(t *testing.T) {
+	var typ MessageType
+	for _, text := range []string{"", "Bogus", "MessageType(256)", "MessageType(x)"} {
+		if err := typ.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("UnmarshalText(%q) expected error, got %v", text, typ)
+		}
+	}
+
+	// Error payloads from peers that send numeric codes still decode
+	var payload ErrorPayload
+	if err := json.Unmarshal([]byte(`{"code":201,"message":"no"}`), &payload); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if payload.Code != ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v", payload.Code.String())
+	}
+
+	// So do those from peers that sent names
+	if err := json.Unmarshal([]byte(`{"code":"ErrForbidden","message":"no"}`), &payload); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if payload.Code != ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v", payload.Code.String())
+	}
+
+	// The code stays numeric on the wire, with the name beside it
+	msg, err := NewErrorMessage(ErrForbidden, "")
+	if err != nil {
+		t.Fatalf("NewErrorMessage() error = %v", err)
+	}
+	if want := `{"code":201,"name":"ErrForbidden","message":""}`; string(msg.Payload) != want {
+		t.Errorf("NewErrorMessage() payload = %s, want %s", msg.Payload, want)
+	}
+}
+
+func TestInteractionTypeJSON(t *testing.T) {
+	for _, interaction := range []InteractionType{Discover, Negotiate, Stream, Delegate} {
+		c := Capability{ID: "cap-" + interaction.String(), Version: "1.0.0", Interaction: interaction}
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		if want := fmt.Sprintf(`"interaction":"%s"`, interaction); !bytes.Contains(data, []byte(want)) {
+			t.Errorf("json.Marshal() = %s, want it to contain %s", data, want)
+		}
+
+		var got Capability
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error = %v", data, err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("Capability round trip = %+v, want %+v", got, c)
+		}
+	}
+
+	tests := []struct {
+		json    string
+		want    InteractionType
+		wantErr bool
+	}{
+		{`"Stream"`, Stream, false},
+		{`3`, Stream, false},
+		{`0`, 0, false},
+		{`"InteractionType(9)"`, 9, false},
+		{`"stream"`, 0, true},
+		{`256`, 0, true},
+		{`-1`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var got InteractionType
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("json.Unmarshal(%s) error = %v, wantErr %v", tt.json, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+
+	// Values without a name stay numbers
+	for _, interaction := range []InteractionType{0, 9} {
+		data, err := json.Marshal(interaction)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		if want := fmt.Sprint(uint8(interaction)); string(data) != want {
+			t.Errorf("json.Marshal(%v) = %s, want %s", interaction, data, want)
+		}
+	}
+}
+
+func TestDeserializeFrom(t *testing.T) {
+	messages := []*Message{
+		{Version: V1, Type: Hello, Payload: []byte(`{"hello":"world"}`), Timestamp: time.Unix(0, 1700000000000000000)},
+		{Version: V2, Type: Query, Payload: bytes.Repeat([]byte("compress me "), 64), Timestamp: time.Unix(0, 1700000000000000001),
+			Compressed: true, CompressionCodec: CompressionZstd, Priority: PriorityHigh},
+		{Version: V2, Type: Response, Payload: []byte{}, Timestamp: time.Unix(0, 1700000000000000002), CorrelationID: [16]byte{1, 2, 3}, HopCount: 3},
+	}
+
+	// Several frames back to back on one stream
+	var stream bytes.Buffer
+	for _, msg := range messages {
+		data, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		stream.Write(data)
+	}
+
+	r := bufio.NewReader(&stream)
+	for i, want := range messages {
+		got, err := DeserializeFrom(r)
+		if err != nil {
+			t.Fatalf("DeserializeFrom() message %d error = %v", i, err)
+		}
+
+		data, _ := want.Serialize()
+		fromBytes, err := Deserialize(data)
+		if err != nil {
+			t.Fatalf("Deserialize() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, fromBytes) {
+			t.Errorf("Message %d = %+v, want %+v", i, got, fromBytes)
+		}
+	}
+
+	if _, err := DeserializeFrom(r); !errors.Is(err, io.EOF) {
+		t.Errorf("DeserializeFrom() at end of stream error = %v, want io.EOF", err)
+	}
+}
+
+func TestDeserializeFromInvalid(t *testing.T) {
+	msg := &Message{Version: V2, Type: Query, Payload: []byte("payload"), Timestamp: time.Now(), Priority: PriorityHigh}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	badVersion := append([]byte{9}, data[1:]...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"truncated header", data[:3], io.ErrUnexpectedEOF},
+		{"truncated payload", data[:10], io.ErrUnexpectedEOF},
+		{"truncated extensions", data[:len(data)-1], io.ErrUnexpectedEOF},
+		{"unsupported version", badVersion, ErrInvalidVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeserializeFrom(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DeserializeFrom() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	msg := &Message{Version: V2, Type: Query, Payload: []byte(`{"capability_type":"nlp"}`), Timestamp: time.Now(), Priority: PriorityHigh}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	decoded, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if want := crc32.ChecksumIEEE(data[:len(data)-checksumSize]); decoded.Checksum != want {
+		t.Errorf("Checksum = %08x, want %08x", decoded.Checksum, want)
+	}
+
+	// Flipping any single byte before the checksum is caught, whichever way the frame is read
+	for i := 2; i < len(data)-checksumSize; i++ {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0x01
+		if _, err := Deserialize(corrupt); err == nil {
+			t.Errorf("Deserialize() accepted a frame with byte %d corrupted", i)
+		}
+		if _, err := DeserializeFrom(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("DeserializeFrom() accepted a frame with byte %d corrupted", i)
+		}
+	}
+
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if _, err := Deserialize(corrupt); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("Deserialize() of a corrupted payload error = %v, want %v", err, ErrInvalidPayload)
+	}
+
+	// V2 frames from senders that predate the checksum still decode
+	flags := 2 + 1 + len(msg.Payload) + 8 // version, type, one byte size, payload, timestamp
+	legacy := bytes.Clone(data[:len(data)-checksumSize])
+	legacy[flags] &^= flagChecksum
+	if got, err := Deserialize(legacy); err != nil || got.Checksum != 0 || got.Priority != PriorityHigh {
+		t.Errorf("Deserialize() of a frame without checksum = %+v, %v", got, err)
+	}
+	if _, err := DeserializeFrom(bytes.NewReader(legacy)); err != nil {
+		t.Errorf("DeserializeFrom() of a frame without checksum error = %v", err)
+	}
+
+	// V1 frames carry none
+	v1 := &Message{Version: V1, Type: Query, Payload: msg.Payload, Timestamp: msg.Timestamp}
+	if data, err := v1.Serialize(); err != nil || len(data) != 2+4+len(msg.Payload)+8 {
+		t.Errorf("V1 Serialize() = %d bytes, %v, want no checksum", len(data), err)
+	}
+}
+
+func TestPayloadSizeEncoding(t *testing.T) {
+	tests := []struct {
+		size     int
+		v2Header int // Bytes the V2 payload size takes
+	}{
+		{0, 1},
+		{127, 1},
+		{128, 2},
+		{16383, 2},
+		{16384, 3},
+		{1 << 21, 4},
+	}
+
+	for _, tt := range tests {
+		payload := bytes.Repeat([]byte("x"), tt.size)
+		for _, v := range []Version{V1, V2} {
+			msg := &Message{Version: v, Type: Query, Payload: payload, Timestamp: time.Unix(0, 1700000000000000000)}
+			data, err := msg.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+
+			sizeLen := 4
+			trailer := 0
+			if v >= V2 {
+				sizeLen = tt.v2Header
+				trailer = v2TrailerSize + checksumSize
+			}
+			if want := 2 + sizeLen + tt.size + 8 + trailer; len(data) != want {
+				t.Errorf("V%d frame for %d bytes is %d bytes, want %d", v, tt.size, len(data), want)
+			}
+
+			got, err := Deserialize(data)
+			if err != nil {
+				t.Fatalf("Deserialize() V%d %d bytes error = %v", v, tt.size, err)
+			}
+			if int(got.PayloadSize) != tt.size || !bytes.Equal(got.Payload, payload) {
+				t.Errorf("V%d round trip of %d bytes returned %d bytes", v, tt.size, got.PayloadSize)
+			}
+
+			fromStream, err := DeserializeFrom(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DeserializeFrom() V%d %d bytes error = %v", v, tt.size, err)
+			}
+			if !bytes.Equal(fromStream.Payload, payload) {
+				t.Errorf("V%d stream round trip of %d bytes returned %d bytes", v, tt.size, fromStream.PayloadSize)
+			}
+		}
+	}
+}
+
+func TestPayloadSizeOverflow(t *testing.T) {
+	// Six continuation bytes cannot be a 32-bit size
+	data := append([]byte{byte(V2), byte(Query)}, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01)
+	data = append(data, make([]byte, 16)...)
+
+	if _, err := Deserialize(data); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("Deserialize() error = %v, want %v", err, ErrInvalidPayload)
+	}
+	if _, err := DeserializeFrom(bytes.NewReader(data)); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("DeserializeFrom() error = %v, want %v", err, ErrInvalidPayload)
+	}
+}
+
+func BenchmarkWireSize(b *testing.B) {
+	for _, size := range []int{50, 50 << 10} {
+		payload := bytes.Repeat([]byte("x"), size)
+		for _, v := range []Version{V1, V2} {
+			b.Run(fmt.Sprintf("%dB/V%d", size, v), func(b *testing.B) {
+				msg := &Message{Version: v, Type: Query, Payload: payload, Timestamp: time.Now()}
+				var wire int
+				for i := 0; i < b.N; i++ {
+					data, err := msg.Serialize()
+					if err != nil {
+						b.Fatal(err)
+					}
+					wire = len(data)
+				}
+				b.ReportMetric(float64(wire), "bytes/frame")
+				b.ReportMetric(float64(wire-size), "bytes/overhead")
+			})
+		}
+	}
+}
+
+func TestMessageClone(t *testing.T) {
+	original := &Message{
+		Version:       V2,
+		Type:          Query,
+		Payload:       []byte(`{"capability_type":"NLP"}`),
+		PayloadSize:   25,
+		Timestamp:     time.Unix(0, 1700000000000000000),
+		Signature:     []byte{1, 2, 3},
+		Nonce:         [16]byte{4},
+		Priority:      PriorityHigh,
+		TraceContext:  []byte("00-trace"),
+		CorrelationID: [16]byte{5},
+	}
+	want := &Message{}
+	*want = *original
+	want.Payload = append([]byte(nil), original.Payload...)
+	want.Signature = append([]byte(nil), original.Signature...)
+	want.TraceContext = append([]byte(nil), original.TraceContext...)
+
+	clone := original.Clone()
+	if !reflect.DeepEqual(clone, original) {
+		t.Fatalf("Clone() = %+v, want %+v", clone, original)
+	}
+
+	clone.Payload[0] = 'X'
+	clone.Signature[0] = 9
+	clone.TraceContext[0] = 'X'
+	clone.Nonce[0] = 9
+	clone.CorrelationID[0] = 9
+	clone.Type = Response
+	if !reflect.DeepEqual(original, want) {
+		t.Errorf("Mutating the clone changed the original: %+v", original)
+	}
+
+	// Absent slices stay absent and empty ones stay empty
+	bare := (&Message{Payload: []byte{}}).Clone()
+	if bare.Payload == nil || bare.Signature != nil || bare.TraceContext != nil {
+		t.Errorf("Clone() = %+v, want empty Payload and nil Signature and TraceContext", bare)
+	}
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError reports a field that broke one of the rules in its arn tag
+type ValidationError struct {
+	Field string // Type and field name, such as "Capability.ID"
+	Rule  string // The rule that failed, such as "max=128"
+	Err   error  // Underlying cause, if any
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s fails %s: %v", e.Field, e.Rule, e.Err)
+	}
+	return fmt.Sprintf("%s fails %s", e.Field, e.Rule)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// MCP protocol versions look like "MCP/1.0"
+var mcpProtocolPattern = regexp.MustCompile(`^MCP/\d+\.\d+$`)
+
+// Validate checks every field of the struct v, or the struct it points to,
+// against the comma separated rules in its `arn` tag:
+//
+//	required     the field is not its zero value
+//	max=N        a string has at most N characters, a slice or map at most N entries
+//	semver       a non-empty string is a SemVer version
+//	url          a non-empty string is an absolute URL with a host
+//	mcpprotocol  a non-empty string names an MCP version such as "MCP/1.0"
+//
+// The first failure is returned as a *ValidationError.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return fmt.Errorf("cannot validate nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot validate %T, not a struct", v)
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("arn")
+		if !ok {
+			continue
+		}
+
+		field := rt.Name() + "." + rt.Field(i).Name
+		for _, rule := range strings.Split(tag, ",") {
+			if err := checkRule(rv.Field(i), rule); err != nil {
+				if verr, ok := err.(*ValidationError); ok {
+					verr.Field = field
+					return verr
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRule applies one tag rule to a field value
+func checkRule(fv reflect.Value, rule string) error {
+	name, arg, _ := strings.Cut(rule, "=")
+	fail := func(err error) error {
+		return &ValidationError{Rule: rule, Err: err}
+	}
+
+	switch name {
+	case "required":
+		if fv.IsZero() {
+			return fail(nil)
+		}
+	case "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid arn rule %q: %w", rule, err)
+		}
+		var n int
+		switch fv.Kind() {
+		case reflect.String:
+			n = utf8.RuneCountInString(fv.String())
+		case reflect.Slice, reflect.Map, reflect.Array:
+			n = fv.Len()
+		default:
+			return fmt.Errorf("arn rule %q does not apply to %s", rule, fv.Kind())
+		}
+		if n > limit {
+			return fail(fmt.Errorf("length %d", n))
+		}
+	case "semver":
+		if s := fv.String(); s != "" {
+			if _, err := canonicalVersion(s); err != nil {
+				return fail(fmt.Errorf("malformed version %q", s))
+			}
+		}
+	case "url":
+		if s := fv.String(); s != "" {
+			u, err := url.Parse(s)
+			if err != nil {
+				return fail(err)
+			}
+			if u.Scheme == "" || u.Host == "" {
+				return fail(fmt.Errorf("%q is not an absolute URL", s))
+			}
+		}
+	case "mcpprotocol":
+		if s := fv.String(); s != "" && !mcpProtocolPattern.MatchString(s) {
+			return fail(fmt.Errorf("%q does not match %s", s, mcpProtocolPattern))
+		}
+	default:
+		return fmt.Errorf("unknown arn rule %q", rule)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		v         interface{}
+		wantField string
+	}{
+		{"valid capability", &Capability{ID: "cap", Version: "1.2.0"}, ""},
+		{"capability without version", Capability{ID: "cap"}, ""},
+		{"missing capability ID", &Capability{Name: "No ID"}, "Capability.ID"},
+		{"long capability ID", &Capability{ID: strings.Repeat("x", 129)}, "Capability.ID"},
+		{"multibyte ID within limit", &Capability{ID: strings.Repeat("é", 128)}, ""},
+		{"long name", &Capability{ID: "cap", Name: strings.Repeat("n", 257)}, "Capability.Name"},
+		{"malformed version", &Capability{ID: "cap", Version: "one point oh"}, "Capability.Version"},
+		{"valid bridge", &MCPBridge{ID: "b", Endpoint: "mcp://data/v1", Protocol: "MCP/1.0"}, ""},
+		{"bridge without protocol", &MCPBridge{ID: "b", Endpoint: "https://data.example/mcp"}, ""},
+		{"missing endpoint", &MCPBridge{ID: "b"}, "MCPBridge.Endpoint"},
+		{"relative endpoint", &MCPBridge{ID: "b", Endpoint: "/data/v1"}, "MCPBridge.Endpoint"},
+		{"unparseable endpoint", &MCPBridge{ID: "b", Endpoint: "mcp://%zz"}, "MCPBridge.Endpoint"},
+		{"bad protocol", &MCPBridge{ID: "b", Endpoint: "mcp://data", Protocol: "MCP 1"}, "MCPBridge.Protocol"},
+		{"protocol with patch", &MCPBridge{ID: "b", Endpoint: "mcp://data", Protocol: "MCP/1.0.1"}, "MCPBridge.Protocol"},
+		{"valid message", &Message{Version: V1, Type: Hello}, ""},
+		{"message without type", &Message{Version: V1}, "Message.Type"},
+		{"message without version", &Message{Type: Hello}, "Message.Version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.v)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			if verr.Field != tt.wantField {
+				t.Errorf("Validate() failed on %s, want %s", verr.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateNonStruct(t *testing.T) {
+	var cap *Capability
+	for _, v := range []interface{}{cap, "capability", 42} {
+		if err := Validate(v); err == nil {
+			t.Errorf("Validate(%#v) accepted a non-struct", v)
+		}
+	}
+}
+
+func TestValidateUnknownRule(t *testing.T) {
+	type tagged struct {
+		Name string `arn:"shiny"`
+	}
+	if err := Validate(tagged{Name: "x"}); err == nil || !strings.Contains(err.Error(), "unknown arn rule") {
+		t.Errorf("Validate() error = %v, want unknown rule", err)
+	}
+}
+
+func TestRegisterValidates(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	err := handler.RegisterCapability(&Capability{ID: strings.Repeat("x", 200)})
+	var verr *ValidationError
+	if !errors.Is(err, ErrInvalidCapabilityFormat) || !errors.As(err, &verr) {
+		t.Errorf("RegisterCapability() error = %v, want ErrInvalidCapabilityFormat and *ValidationError", err)
+	}
+
+	err = handler.RegisterMCPBridge(&MCPBridge{ID: "b", Endpoint: "mcp://data/v1", Protocol: "HTTP/1.1"})
+	if !errors.Is(err, ErrInvalidPayload) || !errors.As(err, &verr) || verr.Field != "MCPBridge.Protocol" {
+		t.Errorf("RegisterMCPBridge() error = %v, want invalid MCPBridge.Protocol", err)
+	}
+	if len(handler.ListMCPBridges()) != 0 {
+		t.Error("Invalid bridge was registered")
+	}
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import "fmt"
+
+// EventType says how a capability changed
+type EventType uint8
+
+const (
+	Added   EventType = iota + 1 // Registered under a new ID
+	Updated                      // Replaced by a capability with different content
+	Removed                      // Deregistered or expired
+)
+
+// String returns the constant name of t, such as "Added"
+func (t EventType) String() string {
+	switch t {
+	case Added:
+		return "Added"
+	case Updated:
+		return "Updated"
+	case Removed:
+		return "Removed"
+	default:
+		return fmt.Sprintf("EventType(%d)", uint8(t))
+	}
+}
+
+// CapabilityEvent reports a change to a registered capability. Capability
+// is a copy; for Removed it is the capability as it was last registered.
+type CapabilityEvent struct {
+	Type       EventType
+	Capability *Capability
+}
+
+// Events a watcher may fall behind by before further events are dropped
+const watchBufferSize = 64
+
+type capabilityWatcher struct {
+	filter func(*Capability) bool
+	events chan CapabilityEvent
+}
+
+// WatchCapabilities delivers an event on the returned channel whenever a
+// capability matching filter is added, updated or removed. A nil filter
+// matches every capability; filter runs with the registry locked, so it
+// must not call back into the handler. Events are dropped rather than wait
+// for a watcher that falls behind. Calling stop ends the watch and closes
+// the channel; it may be called more than once.
+func (h *Handler) WatchCapabilities(filter func(*Capability) bool) (<-chan CapabilityEvent, func()) {
+	w := &capabilityWatcher{
+		filter: filter,
+		events: make(chan CapabilityEvent, watchBufferSize),
+	}
+
+	h.mu.Lock()
+	h.nextWatcher++
+	id := h.nextWatcher
+	h.watchers[id] = w
+	h.mu.Unlock()
+
+	stop := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.watchers[id]; ok {
+			delete(h.watchers, id)
+			close(w.events)
+		}
+	}
+	return w.events, stop
+}
+
+// notifyWatchers sends an event about cap to every watcher it matches.
+// Callers must hold h.mu for writing.
+func (h *Handler) notifyWatchers(t EventType, cap *Capability) {
+	for _, w := range h.watchers {
+		if w.filter != nil && !w.filter(cap) {
+			continue
+		}
+		select {
+		case w.events <- CapabilityEvent{Type: t, Capability: cap.clone()}:
+		default:
+			h.logger.Warn("Dropped capability event for a slow watcher", "capability", cap.ID, "event", t)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan CapabilityEvent) CapabilityEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Watch channel closed unexpectedly")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a capability event")
+		return CapabilityEvent{}
+	}
+}
+
+func TestWatchCapabilities(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	events, stop := handler.WatchCapabilities(func(c *Capability) bool { return c.Type == "nlp" })
+	defer stop()
+
+	register := func(cap *Capability) {
+		t.Helper()
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+
+	register(&Capability{ID: "vision-1", Type: "vision", Version: "1.0.0", Interaction: Discover})
+	register(&Capability{ID: "nlp-1", Type: "nlp", Version: "1.0.0", Interaction: Discover})
+	register(&Capability{ID: "nlp-1", Type: "nlp", Version: "1.0.0", Interaction: Discover}) // unchanged
+	register(&Capability{ID: "nlp-1", Type: "nlp", Version: "1.1.0", Interaction: Discover})
+	if err := handler.DeregisterCapability("nlp-1"); err != nil {
+		t.Fatalf("DeregisterCapability() error = %v", err)
+	}
+
+	want := []struct {
+		typ     EventType
+		version string
+	}{
+		{Added, "1.0.0"},
+		{Updated, "1.1.0"},
+		{Removed, "1.1.0"},
+	}
+	for _, w := range want {
+		event := nextEvent(t, events)
+		if event.Type != w.typ || event.Capability.ID != "nlp-1" || event.Capability.Version != w.version {
+			t.Errorf("Got %v %s@%s, want %v nlp-1@%s", event.Type, event.Capability.ID, event.Capability.Version, w.typ, w.version)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected event %v for %s", event.Type, event.Capability.ID)
+	default:
+	}
+}
+
+func TestWatchCapabilitiesStop(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	events, stop := handler.WatchCapabilities(nil)
+	stop()
+	stop()
+
+	if _, ok := <-events; ok {
+		t.Fatal("Expected stop to close the channel")
+	}
+	// Changes after stop must not reach, or panic on, the closed channel
+	if err := handler.RegisterCapability(&Capability{ID: "nlp-1", Type: "nlp", Version: "1.0.0", Interaction: Discover}); err != nil {
+		t.Fatalf("RegisterCapability() error = %v", err)
+	}
+}
+
+func TestWatchCapabilitiesSlowWatcher(t *testing.T) {
+	handler := NewHandler()
+	defer handler.Close()
+
+	events, stop := handler.WatchCapabilities(nil)
+	defer stop()
+
+	// Registration never waits on a watcher that is not reading
+	for i := 0; i < watchBufferSize+10; i++ {
+		cap := &Capability{ID: fmt.Sprintf("cap-%d", i), Type: "nlp", Version: "1.0.0", Interaction: Discover}
+		if err := handler.RegisterCapability(cap); err != nil {
+			t.Fatalf("RegisterCapability() error = %v", err)
+		}
+	}
+	if len(events) != watchBufferSize {
+		t.Errorf("Expected the watcher to hold %d events, got %d", watchBufferSize, len(events))
+	}
+}
+
+func TestEventTypeString(t *testing.T) {
+	if Added.String() != "Added" || Removed.String() != "Removed" || EventType(9).String() != "EventType(9)" {
+		t.Errorf("Unexpected names %q, %q, %q", Added, Removed, EventType(9))
+	}
+}
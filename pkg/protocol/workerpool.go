@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWorkerPoolClosed is returned by Submit once the pool is closed
+var ErrWorkerPoolClosed = errors.New("worker pool closed")
+
+// Jobs each worker may have waiting before Submit blocks
+const workerQueueDepth = 16
+
+// Result is the outcome of a message handled by a WorkerPool
+type Result struct {
+	Request  *Message
+	Response *Message
+	Err      error
+}
+
+type workerJob struct {
+	ctx   context.Context
+	msg   *Message
+	reply chan<- Result
+}
+
+// WorkerPool handles messages on a fixed number of goroutines, so a slow
+// message does not hold up those behind it. Results are delivered on the
+// channel each message was submitted with, in the order they complete. It is
+// safe for concurrent use.
+type WorkerPool struct {
+	handle HandlerFunc
+	jobs   chan workerJob
+	size   int
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool starts size workers handling messages with handle. A size
+// below 1 is treated as 1.
+func NewWorkerPool(size int, handle HandlerFunc) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &WorkerPool{
+		handle: handle,
+		jobs:   make(chan workerJob, size*workerQueueDepth),
+		size:   size,
+	}
+
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Size returns the number of workers
+func (p *WorkerPool) Size() int {
+	return p.size
+}
+
+// Submit queues msg for a worker, blocking while the queue is full. The
+// result is sent on reply, which must be drained for the worker to move on.
+func (p *WorkerPool) Submit(ctx context.Context, msg *Message, reply chan<- Result) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrWorkerPoolClosed
+	}
+	select {
+	case p.jobs <- workerJob{ctx: ctx, msg: msg, reply: reply}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages and waits for the workers to finish those
+// already queued. It is safe to call more than once.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		response, err := p.handle(job.ctx, job.msg)
+		job.reply <- Result{Request: job.msg, Response: response, Err: err}
+	}
+}
+
+// WithWorkerPool handles messages passed to Dispatch on n worker goroutines
+// rather than the caller's, so slow messages do not hold up a connection
+func WithWorkerPool(n int) Option {
+	return func(h *Handler) {
+		h.workerCount = n
+	}
+}
+
+// Workers returns the size of the handler's worker pool, or zero if it has none
+func (h *Handler) Workers() int {
+	if h.workerPool == nil {
+		return 0
+	}
+	return h.workerPool.Size()
+}
+
+// Dispatch handles msg as HandleMessage does and sends the result on reply.
+// With a worker pool the message is queued and Dispatch returns once a
+// worker has it; without one it is handled before Dispatch returns, so
+// reply must have room or be drained by another goroutine.
+func (h *Handler) Dispatch(ctx context.Context, msg *Message, reply chan<- Result) error {
+	if h.workerPool != nil {
+		return h.workerPool.Submit(ctx, msg, reply)
+	}
+
+	response, err := h.HandleMessage(ctx, msg)
+	reply <- Result{Request: msg, Response: response, Err: err}
+	return nil
+}
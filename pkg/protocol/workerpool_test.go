@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	pool := NewWorkerPool(4, func(ctx context.Context, msg *Message) (*Message, error) {
+		started <- struct{}{}
+		<-release
+		return &Message{Version: V1, Type: Response, Payload: msg.Payload, Timestamp: time.Now()}, nil
+	})
+	defer pool.Close()
+
+	if pool.Size() != 4 {
+		t.Fatalf("Size() = %d, want 4", pool.Size())
+	}
+
+	// Every worker takes a message before any of them finishes
+	reply := make(chan Result, 4)
+	for i := 0; i < 4; i++ {
+		if err := pool.Submit(context.Background(), &Message{Type: Query, Payload: []byte{byte(i)}}, reply); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d of 4 messages were handled concurrently", i)
+		}
+	}
+	close(release)
+
+	seen := make(map[byte]bool)
+	for i := 0; i < 4; i++ {
+		result := <-reply
+		if result.Err != nil || result.Response.Payload[0] != result.Request.Payload[0] {
+			t.Errorf("Result = %+v, want the response to its request", result)
+		}
+		seen[result.Request.Payload[0]] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("Got results for %d distinct messages, want 4", len(seen))
+	}
+
+	if NewWorkerPool(0, nil).Size() != 1 {
+		t.Error("Expected a size below 1 to start one worker")
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	handled := make(chan struct{}, 8)
+	pool := NewWorkerPool(1, func(ctx context.Context, msg *Message) (*Message, error) {
+		handled <- struct{}{}
+		return nil, nil
+	})
+
+	// Queued messages are still handled by Close
+	reply := make(chan Result, 8)
+	for i := 0; i < 8; i++ {
+		if err := pool.Submit(context.Background(), &Message{Type: Query}, reply); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	pool.Close()
+	pool.Close()
+	if len(handled) != 8 || len(reply) != 8 {
+		t.Errorf("Close() left %d handled and %d results, want 8", len(handled), len(reply))
+	}
+
+	if err := pool.Submit(context.Background(), &Message{Type: Query}, reply); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Errorf("Submit() after Close error = %v, want %v", err, ErrWorkerPoolClosed)
+	}
+}
+
+func TestHandlerDispatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		workers int
+	}{
+		{"inline", nil, 0},
+		{"worker pool", []Option{WithWorkerPool(2)}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(tt.opts...)
+			defer handler.Close()
+
+			if handler.Workers() != tt.workers {
+				t.Errorf("Workers() = %d, want %d", handler.Workers(), tt.workers)
+			}
+
+			reply := make(chan Result, 1)
+			msg := &Message{Version: V1, Type: Hello, Timestamp: time.Now()}
+			if err := handler.Dispatch(context.Background(), msg, reply); err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			select {
+			case result := <-reply:
+				if result.Err != nil || result.Request != msg || result.Response == nil || result.Response.Type == Error {
+					t.Errorf("Dispatch() result = %+v, want the reply to the Hello", result)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected a result from Dispatch")
+			}
+		})
+	}
+}
+
+// benchmarkWorkerPool measures throughput of messages that each wait on
+// something slow, such as a store or an upstream, for a fixed time
+func benchmarkWorkerPool(b *testing.B, workers int) {
+	pool := NewWorkerPool(workers, func(ctx context.Context, msg *Message) (*Message, error) {
+		time.Sleep(100 * time.Microsecond)
+		return msg, nil
+	})
+	defer pool.Close()
+
+	reply := make(chan Result, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			<-reply
+		}
+	}()
+
+	ctx := context.Background()
+	msg := &Message{Version: V1, Type: Query, Timestamp: time.Now()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pool.Submit(ctx, msg, reply); err != nil {
+			b.Fatalf("Submit() error = %v", err)
+		}
+	}
+	<-done
+}
+
+func BenchmarkWorkerPool(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkWorkerPool(b, workers)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package routing resolves the relay nodes named in a message's Route
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownNode is returned when a route names a node the registry does
+// not hold
+var ErrUnknownNode = errors.New("unknown node")
+
+// NodeRegistry maps relay node IDs to the addresses they listen on. It is
+// safe for concurrent use.
+type NodeRegistry struct {
+	mu    sync.RWMutex
+	nodes map[string]string
+}
+
+// NewNodeRegistry creates an empty registry
+func NewNodeRegistry() *NodeRegistry {
+	return &NodeRegistry{nodes: make(map[string]string)}
+}
+
+// Register records that the node named id listens on addr, replacing any
+// address registered for it before
+func (r *NodeRegistry) Register(id, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[id] = addr
+}
+
+// Remove forgets the node named id
+func (r *NodeRegistry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, id)
+}
+
+// Resolve returns the address of the node named id
+func (r *NodeRegistry) Resolve(id string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	addr, ok := r.nodes[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+	return addr, nil
+}
@@ -0,0 +1,40 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNodeRegistry(t *testing.T) {
+	registry := NewNodeRegistry()
+	registry.Register("relay-a", "10.0.0.1:8080")
+	registry.Register("relay-b", "10.0.0.2:8080")
+	registry.Register("relay-a", "10.0.0.3:8080")
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr error
+	}{
+		{"relay-a", "10.0.0.3:8080", nil},
+		{"relay-b", "10.0.0.2:8080", nil},
+		{"relay-c", "", ErrUnknownNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := registry.Resolve(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	registry.Remove("relay-b")
+	if _, err := registry.Resolve("relay-b"); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("Resolve() of a removed node error = %v, want %v", err, ErrUnknownNode)
+	}
+}
@@ -0,0 +1,24 @@
+package security
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	cert, err := GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	if cert.Leaf == nil {
+		t.Fatal("Expected parsed leaf certificate")
+	}
+
+	// The certificate must verify against itself for localhost
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots}); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
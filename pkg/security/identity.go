@@ -0,0 +1,179 @@
+package security
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Size of the random challenge a server sends a peer to sign
+const ChallengeSize = 32
+
+// Prefix binding handshake proofs to this protocol, so a signature made for
+// anything else can never pass as one
+const proofContext = "ARN handshake proof v1"
+
+var (
+	// ErrUnknownPeer is returned when a peer claims an identity the keyring
+	// does not hold
+	ErrUnknownPeer = errors.New("unknown peer identity")
+
+	// ErrInvalidProof is returned when a handshake proof does not verify
+	ErrInvalidProof = errors.New("invalid identity proof")
+)
+
+// Roles a keyring can grant an identity. Handler.RequireRole restricts
+// message types to them; RoleAdmin satisfies every requirement.
+const (
+	RoleAdmin    = "admin"    // manages the node
+	RoleAgent    = "agent"    // registers capabilities and advertises bridges
+	RoleObserver = "observer" // queries the registry
+)
+
+// PeerIdentity is a peer that proved it holds the private key for
+// PublicKey, an Ed25519 key. Roles and Tenant are granted by the server,
+// never taken from the peer.
+type PeerIdentity struct {
+	ID        string
+	PublicKey []byte
+	Roles     []string
+
+	// Tenant places the identity's sessions in that tenant, empty for none
+	Tenant string
+}
+
+// HasRole reports whether the identity was granted role
+func (p *PeerIdentity) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type peerIdentityKey struct{}
+
+// ContextWithPeerIdentity returns a copy of ctx carrying a verified identity
+func ContextWithPeerIdentity(ctx context.Context, identity *PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityKey{}, identity)
+}
+
+// PeerIdentityFromContext returns the verified identity stored in ctx, if any
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	identity, ok := ctx.Value(peerIdentityKey{}).(*PeerIdentity)
+	return identity, ok && identity != nil
+}
+
+// NewChallenge returns a random challenge for a peer to sign
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// SignChallenge proves that the holder of key is the peer named id
+func SignChallenge(key ed25519.PrivateKey, id string, challenge []byte) []byte {
+	return ed25519.Sign(key, proofMessage(id, challenge))
+}
+
+// VerifyChallenge checks that proof was made by SignChallenge with the
+// private half of publicKey, for id and challenge
+func VerifyChallenge(publicKey []byte, id string, challenge, proof []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: public key must be %d bytes", ErrInvalidProof, ed25519.PublicKeySize)
+	}
+	if !ed25519.Verify(publicKey, proofMessage(id, challenge), proof) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// proofMessage is what a peer signs: the proof context, its ID and the
+// challenge, separated so no two inputs sign the same bytes
+func proofMessage(id string, challenge []byte) []byte {
+	msg := make([]byte, 0, len(proofContext)+1+len(id)+1+len(challenge))
+	msg = append(msg, proofContext...)
+	msg = append(msg, 0)
+	msg = append(msg, id...)
+	msg = append(msg, 0)
+	return append(msg, challenge...)
+}
+
+// Keyring holds the identities peers may prove during the handshake. It is
+// safe for concurrent use. Identities must not be changed once added.
+type Keyring struct {
+	mu    sync.RWMutex
+	peers map[string]*PeerIdentity
+}
+
+// NewKeyring creates a keyring holding identities
+func NewKeyring(identities ...*PeerIdentity) (*Keyring, error) {
+	k := &Keyring{peers: make(map[string]*PeerIdentity)}
+	for _, identity := range identities {
+		if err := k.Add(identity); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Add lets a peer prove identity, replacing any identity with the same ID
+func (k *Keyring) Add(identity *PeerIdentity) error {
+	if identity.ID == "" {
+		return fmt.Errorf("identity has no ID")
+	}
+	if len(identity.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("identity %s: public key must be %d bytes", identity.ID, ed25519.PublicKeySize)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.peers[identity.ID] = identity
+	return nil
+}
+
+// Remove stops a peer proving the identity named id
+func (k *Keyring) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.peers, id)
+}
+
+// Lookup returns the identity named id
+func (k *Keyring) Lookup(id string) (*PeerIdentity, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	identity, ok := k.peers[id]
+	return identity, ok
+}
+
+// HasTenant reports whether any identity is granted tenant
+func (k *Keyring) HasTenant(tenant string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, identity := range k.peers {
+		if identity.Tenant == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks a proof from the peer claiming to be id and returns its
+// identity if the proof holds
+func (k *Keyring) Verify(id string, challenge, proof []byte) (*PeerIdentity, error) {
+	identity, ok := k.Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, id)
+	}
+	if err := VerifyChallenge(identity.PublicKey, id, challenge, proof); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
@@ -0,0 +1,106 @@
+package security
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestChallengeProof(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge() error = %v", err)
+	}
+	if len(challenge) != ChallengeSize {
+		t.Fatalf("NewChallenge() returned %d bytes, want %d", len(challenge), ChallengeSize)
+	}
+	proof := SignChallenge(key, "agent-1", challenge)
+
+	otherChallenge, _ := NewChallenge()
+	tests := []struct {
+		name      string
+		publicKey []byte
+		id        string
+		challenge []byte
+		wantErr   bool
+	}{
+		{"valid", pub, "agent-1", challenge, false},
+		{"other ID", pub, "agent-2", challenge, true},
+		{"other challenge", pub, "agent-1", otherChallenge, true},
+		{"other key", otherPub, "agent-1", challenge, true},
+		{"short key", pub[:16], "agent-1", challenge, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyChallenge(tt.publicKey, tt.id, tt.challenge, proof)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("VerifyChallenge() error = %v, want %v", err, ErrInvalidProof)
+			}
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	keyring, err := NewKeyring(&PeerIdentity{ID: "agent-1", PublicKey: pub, Roles: []string{"reader"}})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	if err := keyring.Add(&PeerIdentity{ID: "bad", PublicKey: []byte("short")}); err == nil {
+		t.Error("Expected Add to reject a malformed public key")
+	}
+	if err := keyring.Add(&PeerIdentity{PublicKey: pub}); err == nil {
+		t.Error("Expected Add to reject an identity without an ID")
+	}
+
+	challenge, _ := NewChallenge()
+	identity, err := keyring.Verify("agent-1", challenge, SignChallenge(key, "agent-1", challenge))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if identity.ID != "agent-1" || !identity.HasRole("reader") || identity.HasRole("admin") {
+		t.Errorf("Verify() = %+v, want agent-1 with only the reader role", identity)
+	}
+
+	if _, err := keyring.Verify("agent-2", challenge, SignChallenge(key, "agent-2", challenge)); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("Verify() of an unknown peer error = %v, want %v", err, ErrUnknownPeer)
+	}
+
+	keyring.Remove("agent-1")
+	if _, ok := keyring.Lookup("agent-1"); ok {
+		t.Error("Expected the removed identity to be gone")
+	}
+}
+
+func TestPeerIdentityContext(t *testing.T) {
+	if _, ok := PeerIdentityFromContext(context.Background()); ok {
+		t.Error("Expected no identity in an empty context")
+	}
+	if _, ok := PeerIdentityFromContext(ContextWithPeerIdentity(context.Background(), nil)); ok {
+		t.Error("Expected a nil identity not to count")
+	}
+
+	identity := &PeerIdentity{ID: "agent-1"}
+	got, ok := PeerIdentityFromContext(ContextWithPeerIdentity(context.Background(), identity))
+	if !ok || got != identity {
+		t.Errorf("PeerIdentityFromContext() = %v, %v, want the stored identity", got, ok)
+	}
+}